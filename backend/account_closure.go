@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"containerized-go-app/notification"
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AccountClosure = store.AccountClosure
+
+const (
+	closureConfirmTTL = 24 * time.Hour
+	closureInterval   = time.Hour
+)
+
+var (
+	// closureGracePeriod is how long a confirmed closure can be undone
+	// before the login is deleted.
+	closureGracePeriod = 14 * 24 * time.Hour
+	// recordRetentionYears is how long a closed account's patient record
+	// is kept before it is anonymized.
+	recordRetentionYears = 7
+	// closureConfirmURL is the frontend page that confirms the closure
+	// with the token query parameter.
+	closureConfirmURL = "http://localhost:3000/close-account"
+)
+
+var errAccountClosing = errors.New("account is being closed")
+
+type closureConfirmRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+func hashClosureToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkNotClosing returns errAccountClosing once the patient has confirmed
+// closing their account, so nothing new is booked for it.
+func (s *Server) checkNotClosing(ctx context.Context, patientID string) error {
+	closure, err := s.store.Closures.Get(ctx, patientID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if closure.ConfirmedAt != nil {
+		return errAccountClosing
+	}
+	return nil
+}
+
+// RequestAccountClosure emails the patient a link to confirm closing their
+// account. Asking again before confirming sends a new link.
+func (s *Server) RequestAccountClosure(c *gin.Context) {
+	ctx := c.Request.Context()
+	patientID := c.Param("id")
+	if !s.notifier.Enabled() {
+		abortWithError(c, http.StatusServiceUnavailable, "Account closure can't be confirmed by email")
+		return
+	}
+
+	existing, err := s.store.Closures.Get(ctx, patientID)
+	if err == nil && existing.ConfirmedAt != nil {
+		abortWithCode(c, http.StatusConflict, "closure_confirmed", "The account is already being closed")
+		return
+	} else if err != nil && !errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving account closure")
+		return
+	}
+	user, err := s.store.Users.FindByProfile(ctx, RolePatient, patientID)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving account")
+		return
+	}
+	if user.Email == "" {
+		abortWithCode(c, http.StatusConflict, "email_required", "An email address is needed to confirm closing the account")
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error requesting account closure")
+		return
+	}
+	token := hex.EncodeToString(secret)
+	now := time.Now().UTC()
+	closure := AccountClosure{
+		PatientID:   patientID,
+		Username:    user.Username,
+		RequestedAt: now,
+		ConfirmHash: hashClosureToken(token),
+		ConfirmBy:   now.Add(closureConfirmTTL),
+	}
+	if err := s.store.Closures.Put(ctx, closure); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error requesting account closure")
+		return
+	}
+
+	event := notification.Event{
+		Kind:          notification.AccountClosure,
+		PatientID:     patientID,
+		PatientEmail:  user.Email,
+		Link:          closureConfirmURL + "?token=" + url.QueryEscape(token),
+		LinkExpiresAt: &closure.ConfirmBy,
+	}
+	if patient, err := s.store.Patients.Get(ctx, patientID); err == nil {
+		event.PatientName = patient.PName
+		event.Consents = patient.ActiveConsents(now)
+	}
+	s.notifier.Notify(event)
+	c.JSON(http.StatusAccepted, closure)
+}
+
+// ConfirmAccountClosure starts the grace period with the emailed token and
+// cancels the patient's future appointments.
+func (s *Server) ConfirmAccountClosure(c *gin.Context) {
+	ctx := c.Request.Context()
+	patientID := c.Param("id")
+	var req closureConfirmRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	now := time.Now().UTC()
+	closure, err := s.store.Closures.Get(ctx, patientID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving account closure")
+		return
+	}
+	if err != nil || closure.ConfirmedAt != nil || !now.Before(closure.ConfirmBy) ||
+		subtle.ConstantTimeCompare([]byte(hashClosureToken(req.Token)), []byte(closure.ConfirmHash)) != 1 {
+		abortWithCode(c, http.StatusBadRequest, "invalid_token", "The confirmation link is invalid or has expired")
+		return
+	}
+
+	cancelled, err := s.cancelFutureAppointments(ctx, patientID, now)
+	closure.CancelledAppointments = cancelled
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error cancelling appointments")
+		return
+	}
+	closesAt := now.Add(closureGracePeriod)
+	closure.ConfirmHash = ""
+	closure.ConfirmedAt, closure.ClosesAt = &now, &closesAt
+	if err := s.store.Closures.Put(ctx, closure); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error confirming account closure")
+		return
+	}
+	c.JSON(http.StatusOK, closure)
+}
+
+// cancelFutureAppointments cancels the patient's scheduled appointments
+// starting after now at their request, returning the IDs cancelled.
+func (s *Server) cancelFutureAppointments(ctx context.Context, patientID string, now time.Time) ([]string, error) {
+	filter := store.AppointmentFilter{PatientID: patientID, Statuses: []string{AppointmentScheduled}, From: now}
+	appointments, _, err := s.store.Appointments.List(ctx, filter, store.Page{})
+	if err != nil {
+		return nil, err
+	}
+	var cancelled []string
+	for _, appointment := range appointments {
+		err := s.store.Appointments.Cancel(ctx, patientID, appointment.ID, "patient_request")
+		if errors.Is(err, store.ErrNotFound) {
+			// Cancelled or completed since it was listed
+			continue
+		} else if err != nil {
+			return cancelled, err
+		}
+		cancelled = append(cancelled, appointment.ID)
+		appointment.Status, appointment.CancellationReason = AppointmentCancelled, "patient_request"
+		s.notifyAppointment(notification.Cancelled, appointment)
+		s.uncountReferral(context.WithoutCancel(ctx), appointment)
+		if err := s.releaseSlot(context.WithoutCancel(ctx), appointment.DoctorID, appointment.StartTime, appointment.ID); err != nil {
+			return cancelled, err
+		}
+	}
+	return cancelled, nil
+}
+
+func (s *Server) GetAccountClosure(c *gin.Context) {
+	closure, err := s.store.Closures.Get(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, "The account isn't being closed")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving account closure")
+		return
+	}
+	c.JSON(http.StatusOK, closure)
+}
+
+// UndoAccountClosure keeps the account open. It works until the grace
+// period ends; appointments cancelled on confirmation stay cancelled.
+func (s *Server) UndoAccountClosure(c *gin.Context) {
+	ctx := c.Request.Context()
+	closure, err := s.store.Closures.Get(ctx, c.Param("id"))
+	if errors.Is(err, store.ErrNotFound) || err == nil && closure.ClosedAt != nil {
+		abortWithError(c, http.StatusNotFound, "The account isn't being closed")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving account closure")
+		return
+	}
+	if err := s.store.Closures.Delete(ctx, closure.PatientID); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error undoing account closure")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "The account will stay open", "cancelledAppointments": closure.CancelledAppointments})
+}
+
+// runAccountClosures closes accounts whose grace period is over and
+// anonymizes patients past the retention window, on start and then every
+// closureInterval.
+func (s *Server) runAccountClosures(ctx context.Context) {
+	ticker := time.NewTicker(closureInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.processAccountClosures(ctx, time.Now().UTC()); err != nil {
+			log.Println("Processing account closures failed: ", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) processAccountClosures(ctx context.Context, now time.Time) error {
+	closing, err := s.store.Closures.Closing(ctx, now)
+	if err != nil {
+		return err
+	}
+	for _, closure := range closing {
+		if err := s.closeAccount(ctx, closure, now); err != nil {
+			return err
+		}
+	}
+
+	closed, err := s.store.Closures.Closed(ctx, now.AddDate(-recordRetentionYears, 0, 0))
+	if err != nil {
+		return err
+	}
+	for _, closure := range closed {
+		if err := s.store.Patients.Anonymize(ctx, closure.PatientID); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		s.patientIndex.put(Patient{ID: closure.PatientID})
+		closure.Username, closure.AnonymizedAt = "", &now
+		if err := s.store.Closures.Put(ctx, closure); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeAccount signs the patient out, deletes the login and revokes the
+// delegations the patient granted and those granted to their username, so
+// whoever signs up with it next inherits no access. The patient record
+// stays for the retention window.
+func (s *Server) closeAccount(ctx context.Context, closure AccountClosure, now time.Time) error {
+	if _, err := s.store.Sessions.RevokeAll(ctx, closure.Username, "", now); err != nil {
+		return err
+	}
+	granted, err := s.store.Delegations.ForPatient(ctx, closure.PatientID)
+	if err != nil {
+		return err
+	}
+	received, err := s.store.Delegations.ForDelegate(ctx, closure.Username)
+	if err != nil {
+		return err
+	}
+	for _, delegation := range append(granted, received...) {
+		if delegation.RevokedAt != nil {
+			continue
+		}
+		if err := s.store.Delegations.Revoke(ctx, delegation.PatientID, delegation.ID, now); err != nil {
+			return err
+		}
+	}
+	if err := s.store.Users.Delete(ctx, closure.Username); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	closure.ClosedAt = &now
+	return s.store.Closures.Put(ctx, closure)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"containerized-go-app/notification"
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAccountClosure(t *testing.T) {
+	f := newBookingFixture(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sent := make(captureSender, 10)
+	f.notifier = notification.New(10, sent)
+	go f.notifier.Run(ctx)
+	user := f.alice
+	user.Email = "alice@example.com"
+	if err := f.store.Users.Create(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	doctor := User{Username: "grey", Role: RoleDoctor, ProfileID: f.doctor.ID}
+	path := "/api/patients/p-alice/closure"
+
+	// request emails a confirmation link and returns its token
+	request := func() string {
+		t.Helper()
+		decode[AccountClosure](t, f.do(t, http.MethodPost, path, f.alice, nil), http.StatusAccepted)
+		for {
+			select {
+			case event := <-sent:
+				if event.Kind != notification.AccountClosure {
+					continue
+				}
+				link, err := url.Parse(event.Link)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return link.Query().Get("token")
+			case <-time.After(time.Second):
+				t.Fatal("no confirmation email sent")
+			}
+		}
+	}
+
+	booked := decode[bookingResponse](t, f.book(t, f.alice, f.first), http.StatusOK).Appointment
+	decode[apiError](t, f.do(t, http.MethodPost, path, doctor, nil), http.StatusForbidden)
+	token := request()
+	decode[apiError](t, f.do(t, http.MethodPost, path+"/confirm", f.alice, gin.H{"token": "guess"}), http.StatusBadRequest)
+	closure := decode[AccountClosure](t, f.do(t, http.MethodPost, path+"/confirm", f.alice, gin.H{"token": token}), http.StatusOK)
+	if len(closure.CancelledAppointments) != 1 || closure.CancelledAppointments[0] != booked.ID || closure.ClosesAt == nil {
+		t.Errorf("closure = %+v, want %s cancelled and a grace period", closure, booked.ID)
+	}
+	decode[apiError](t, f.do(t, http.MethodPost, path+"/confirm", f.alice, gin.H{"token": token}), http.StatusBadRequest)
+	if resp := decode[apiError](t, f.book(t, f.alice, f.second), http.StatusConflict); resp.Code != "account_closing" {
+		t.Errorf("booking while closing: code = %q, want account_closing", resp.Code)
+	}
+
+	// Undoing keeps the account, and booking works again
+	decode[struct{}](t, f.do(t, http.MethodDelete, path, f.alice, nil), http.StatusOK)
+	decode[apiError](t, f.do(t, http.MethodGet, path, f.alice, nil), http.StatusNotFound)
+	decode[bookingResponse](t, f.book(t, f.alice, f.first), http.StatusOK)
+
+	// After the grace period the login goes, and after the retention
+	// window the patient record is anonymized
+	proxy := Delegation{ID: "dl-bob", PatientID: f.bob.ProfileID, Delegate: "alice", Scopes: []string{"book"}, GrantedAt: time.Now().UTC()}
+	if err := f.store.Delegations.Create(ctx, proxy); err != nil {
+		t.Fatal(err)
+	}
+	token = request()
+	decode[AccountClosure](t, f.do(t, http.MethodPost, path+"/confirm", f.alice, gin.H{"token": token}), http.StatusOK)
+	closedAt := time.Now().UTC().Add(closureGracePeriod + time.Hour)
+	if err := f.processAccountClosures(ctx, closedAt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.store.Users.FindByUsername(ctx, "alice"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("finding the closed account: err = %v, want ErrNotFound", err)
+	}
+	decode[apiError](t, f.do(t, http.MethodDelete, path, f.alice, nil), http.StatusNotFound)
+	if received, err := f.store.Delegations.ForDelegate(ctx, "alice"); err != nil || len(received) != 1 || received[0].RevokedAt == nil {
+		t.Errorf("delegations to alice = %+v, %v; want bob's revoked so a new alice can't use it", received, err)
+	}
+
+	if err := f.processAccountClosures(ctx, closedAt.AddDate(recordRetentionYears, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	patient, err := f.store.Patients.Get(ctx, "p-alice")
+	if err != nil || patient.PName != "" {
+		t.Errorf("patient = %+v (err %v), want it anonymized", patient, err)
+	}
+	if closure := decode[AccountClosure](t, f.do(t, http.MethodGet, path, doctor, nil), http.StatusOK); closure.AnonymizedAt == nil || closure.Username != "" {
+		t.Errorf("closure = %+v, want it anonymized without the username", closure)
+	}
+}
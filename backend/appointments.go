@@ -0,0 +1,504 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"time"
+
+	"containerized-go-app/notification"
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	AppointmentScheduled = store.AppointmentScheduled
+	AppointmentCompleted = store.AppointmentCompleted
+	AppointmentCancelled = store.AppointmentCancelled
+	AppointmentNoShow    = store.AppointmentNoShow
+)
+
+type Appointment = store.Appointment
+
+type appointmentList struct {
+	XMLName      xml.Name      `xml:"appointments"`
+	Appointments []Appointment `xml:"appointment"`
+}
+
+// appointmentRequest is the body accepted when booking or updating.
+// StartTime must be one of the doctor's slots; EndTime may be omitted and
+// defaults to the end of that slot.
+type appointmentRequest struct {
+	DoctorID  string    `json:"doctorId" binding:"required,notblank"`
+	StartTime time.Time `json:"startTime" binding:"required"`
+	EndTime   time.Time `json:"endTime" binding:"omitempty,gtfield=StartTime"`
+	Notes     string    `json:"notes" binding:"max=2000"`
+	// Status may only be changed by doctors and admins, e.g. to record
+	// a completed visit or a no-show.
+	Status string `json:"status" binding:"omitempty,oneof=scheduled completed cancelled no-show"`
+	// CancellationReason is required when Status is cancelled.
+	CancellationReason string `json:"cancellationReason" binding:"required_if=Status cancelled"`
+}
+
+var (
+	errPatientNotFound     = errors.New("patient not found")
+	errDoctorNotFound      = errors.New("doctor not found")
+	errAppointmentNotFound = errors.New("appointment not found")
+	errInvalidTimeRange    = errors.New("endTime must be after startTime")
+	errAppointmentClosed   = errors.New("appointment is no longer scheduled")
+)
+
+func isValidAppointmentStatus(status string) bool {
+	switch status {
+	case AppointmentScheduled, AppointmentCompleted, AppointmentCancelled, AppointmentNoShow:
+		return true
+	}
+	return false
+}
+
+func (s *Server) GetPatientAppointments(c *gin.Context) {
+	ctx := c.Request.Context()
+	patientID := c.Param("id")
+
+	if _, err := s.findPatient(ctx, patientID); err != nil {
+		abortWithError(c, http.StatusNotFound, "Patient not found")
+		return
+	}
+
+	filter, q, err := parseAppointmentQuery(c)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.PatientID = patientID
+
+	appointments, total, err := s.store.Appointments.List(ctx, filter, q.page())
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error fetching appointments")
+		return
+	}
+
+	setTotalCount(c, total)
+	addAppointmentDates(appointments)
+	render(c, http.StatusOK, appointments, appointmentList{Appointments: appointments})
+}
+
+func (s *Server) GetDoctorAppointments(c *gin.Context) {
+	ctx := c.Request.Context()
+	doctorID := c.Param("id")
+
+	if exists, err := s.store.Doctors.Exists(ctx, doctorID); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error fetching doctor data")
+		return
+	} else if !exists {
+		abortWithError(c, http.StatusNotFound, "Doctor not found")
+		return
+	}
+
+	filter, q, err := parseAppointmentQuery(c)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.DoctorID = doctorID
+
+	appointments, total, err := s.store.Appointments.List(ctx, filter, q.page())
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error fetching appointments")
+		return
+	}
+
+	setTotalCount(c, total)
+	addAppointmentDates(appointments)
+	render(c, http.StatusOK, appointments, appointmentList{Appointments: appointments})
+}
+
+func (s *Server) BookAppointment(c *gin.Context) {
+	ctx := c.Request.Context()
+	patientID := c.Param("id")
+
+	var req appointmentRequest
+	if !bindJSON(c, &req) || !s.checkTriageOrAbort(c, patientID) {
+		return
+	}
+
+	appointment := Appointment{
+		PatientID: patientID,
+		DoctorID:  req.DoctorID,
+		StartTime: req.StartTime,
+		EndTime:   req.EndTime,
+		Notes:     req.Notes,
+	}
+	patient, err := s.createAppointment(ctx, &appointment)
+	if err != nil {
+		writeAppointmentError(c, err, "Error booking appointment")
+		return
+	}
+
+	appointment.SecondaryDate = secondaryDate(appointment.StartTime)
+	c.JSON(http.StatusOK, bookedBody(c, appointment, patient))
+}
+
+// bookedBody is the response to a booking. Staff booking for the patient
+// also get their tags and notes, which patients and their delegates never
+// see.
+func bookedBody(c *gin.Context, appointment Appointment, patient Patient) gin.H {
+	body := gin.H{
+		"message":     "Appointment booked successfully",
+		"appointment": appointment,
+	}
+	if user, _ := currentUser(c); user.Role == RoleDoctor || user.Role == RoleAdmin {
+		body["tags"] = patient.Tags
+		body["notes"] = patient.Notes
+	}
+	return body
+}
+
+func (s *Server) UpdateAppointment(c *gin.Context) {
+	ctx := c.Request.Context()
+	patientID := c.Param("id")
+	appointmentID := c.Param("appointmentID")
+
+	var req appointmentRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if req.Status != "" {
+		if user, _ := currentUser(c); user.Role == RolePatient {
+			abortWithError(c, http.StatusForbidden, "Patients cannot change appointment status")
+			return
+		}
+	}
+
+	existing, err := s.findAppointment(ctx, patientID, appointmentID)
+	if err != nil {
+		writeAppointmentError(c, err, "Error updating appointment")
+		return
+	}
+	// Completed, no-show and cancelled visits are over: changing their
+	// status would notify the patient and release the slot again
+	if existing.Status != AppointmentScheduled {
+		writeAppointmentError(c, errAppointmentClosed, "Error updating appointment")
+		return
+	}
+	moved := req.DoctorID != existing.DoctorID || !req.StartTime.Equal(existing.StartTime)
+	if moved && !s.checkTriageOrAbort(c, patientID) {
+		return
+	}
+	if req.Status == AppointmentCancelled {
+		if !s.checkCancellationReasonOrAbort(c, req.CancellationReason, "cancellationReason") {
+			return
+		}
+		if s.handOverQueueAppointment(c, existing) {
+			return
+		}
+	}
+
+	updated := existing
+	updated.DoctorID = req.DoctorID
+	updated.StartTime = req.StartTime.UTC()
+	updated.EndTime = req.EndTime.UTC()
+	updated.Notes = req.Notes
+	if req.Status != "" {
+		updated.Status = req.Status
+	}
+	if req.Status == AppointmentCancelled {
+		updated.CancellationReason = req.CancellationReason
+	}
+	if err := s.rescheduleAppointment(ctx, existing, &updated); err != nil {
+		writeAppointmentError(c, err, "Error updating appointment")
+		return
+	}
+
+	updated.SecondaryDate = secondaryDate(updated.StartTime)
+	c.JSON(http.StatusOK, gin.H{"message": "Appointment updated successfully", "appointment": updated})
+}
+
+// CancelAppointment cancels with the catalog reason in ?reason=.
+func (s *Server) CancelAppointment(c *gin.Context) {
+	ctx := c.Request.Context()
+	patientID := c.Param("id")
+	appointmentID := c.Param("appointmentID")
+	reason := c.Query("reason")
+	if !s.checkCancellationReasonOrAbort(c, reason, "reason") {
+		return
+	}
+
+	existing, err := s.findAppointment(ctx, patientID, appointmentID)
+	if err != nil {
+		writeAppointmentError(c, err, "Error canceling appointment")
+		return
+	}
+	// Only scheduled appointments can be cancelled, so the patient hears
+	// of each cancellation once
+	if existing.Status != AppointmentScheduled {
+		writeAppointmentError(c, errAppointmentClosed, "Error canceling appointment")
+		return
+	}
+	if s.handOverQueueAppointment(c, existing) {
+		return
+	}
+
+	// Cancelled appointments are kept so they still show up in history.
+	// The store only cancels it while still scheduled, which settles
+	// concurrent cancellations.
+	err = s.store.Appointments.Cancel(ctx, patientID, appointmentID, reason)
+	if errors.Is(err, store.ErrNotFound) {
+		writeAppointmentError(c, errAppointmentClosed, "Error canceling appointment")
+		return
+	} else if err != nil {
+		writeAppointmentError(c, err, "Error canceling appointment")
+		return
+	}
+	existing.Status = AppointmentCancelled
+	existing.CancellationReason = reason
+	s.notifyAppointment(notification.Cancelled, existing)
+	s.uncountReferral(context.WithoutCancel(ctx), existing)
+
+	if err := s.releaseSlot(context.WithoutCancel(ctx), existing.DoctorID, existing.StartTime, existing.ID); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error releasing appointment slot")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Appointment canceled successfully"})
+}
+
+// checkCancellationReasonOrAbort writes a validation error on field unless
+// code is a reason that can be chosen.
+func (s *Server) checkCancellationReasonOrAbort(c *gin.Context, code, field string) bool {
+	err := s.checkCancellationReason(c.Request.Context(), code)
+	if errors.Is(err, errUnknownReason) {
+		abortWithDetails(c, fieldError{Field: field, Message: "must be a cancellation reason from the catalog"})
+		return false
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving cancellation reasons")
+		return false
+	}
+	return true
+}
+
+// writeAppointmentError maps booking errors to a response, falling back to
+// a 500 with fallback as the message.
+func writeAppointmentError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, errPatientNotFound):
+		abortWithError(c, http.StatusNotFound, "Patient not found")
+	case errors.Is(err, errDoctorNotFound):
+		abortWithError(c, http.StatusNotFound, "Doctor not found")
+	case errors.Is(err, errAppointmentNotFound), errors.Is(err, store.ErrNotFound):
+		abortWithError(c, http.StatusNotFound, "Appointment not found")
+	case errors.Is(err, errInvalidTimeRange):
+		abortWithCode(c, http.StatusBadRequest, "invalid_time_range", "endTime must be after startTime")
+	case errors.Is(err, errAppointmentClosed):
+		abortWithCode(c, http.StatusConflict, "appointment_closed", "Appointment is no longer scheduled")
+	case errors.Is(err, errSlotUnavailable):
+		abortWithCode(c, http.StatusBadRequest, "slot_unavailable", "The doctor has no slot at that time")
+	case errors.Is(err, errFollowUpOnly):
+		abortWithCode(c, http.StatusForbidden, "follow_up_only", "That slot is reserved for follow-up visits of the doctor's existing patients")
+	case errors.Is(err, errSlotTaken):
+		abortWithCode(c, http.StatusConflict, "slot_taken", "That slot has already been booked")
+	case errors.Is(err, errAccountClosing):
+		abortWithCode(c, http.StatusConflict, "account_closing", "The patient's account is being closed")
+	default:
+		abortWithError(c, http.StatusInternalServerError, fallback)
+	}
+}
+
+// createAppointment validates and stores a new appointment, filling in its
+// ID, end time and status. The doctor's slot is claimed before the
+// appointment is written, so concurrent bookings for the same slot result
+// in exactly one success and errSlotTaken for the rest.
+func (s *Server) createAppointment(ctx context.Context, appointment *Appointment) (Patient, error) {
+	patient, err := s.findPatient(ctx, appointment.PatientID)
+	if err != nil {
+		return Patient{}, err
+	}
+	if err := s.checkNotClosing(ctx, patient.ID); err != nil {
+		return Patient{}, err
+	}
+	doctor, err := s.findDoctor(ctx, appointment.DoctorID)
+	if err != nil {
+		return Patient{}, err
+	}
+	if err := s.fitToBookableSlot(ctx, doctor, appointment); err != nil {
+		return Patient{}, err
+	}
+
+	appointment.ID = primitive.NewObjectID().Hex()
+	appointment.Status = AppointmentScheduled
+
+	if err := s.claimSlot(ctx, appointment.DoctorID, appointment.StartTime, appointment.ID); err != nil {
+		return Patient{}, err
+	}
+	if err := s.store.Appointments.Create(ctx, *appointment); err != nil {
+		// The request may have run out of time; the claim must still go
+		s.releaseSlot(context.WithoutCancel(ctx), appointment.DoctorID, appointment.StartTime, appointment.ID)
+		return Patient{}, err
+	}
+	s.notifyAppointment(notification.Booked, *appointment)
+	// The booking stands whatever the hooks do
+	patient = s.runHooks(context.WithoutCancel(ctx), hookAppointmentCreated, *appointment, patient)
+	return patient, nil
+}
+
+// rescheduleAppointment saves updated over existing. Moving to another
+// doctor or time passes the same checks as a new booking and claims the
+// new slot before the old one is released, and cancelling the appointment
+// releases its slot.
+func (s *Server) rescheduleAppointment(ctx context.Context, existing Appointment, updated *Appointment) error {
+	moved := updated.DoctorID != existing.DoctorID || !updated.StartTime.Equal(existing.StartTime)
+	holdsSlot := updated.Status != AppointmentCancelled
+	if moved {
+		if err := s.checkNotClosing(ctx, existing.PatientID); err != nil {
+			return err
+		}
+		doctor, err := s.findDoctor(ctx, updated.DoctorID)
+		if err != nil {
+			return err
+		}
+		if err := s.fitToBookableSlot(ctx, doctor, updated); err != nil {
+			return err
+		}
+		if holdsSlot {
+			if err := s.claimSlot(ctx, updated.DoctorID, updated.StartTime, updated.ID); err != nil {
+				return err
+			}
+		}
+	} else {
+		updated.EndTime = existing.EndTime
+	}
+
+	// The new time gets its own reminder. The store only updates it while
+	// still scheduled, which settles a concurrent cancellation.
+	if err := s.store.Appointments.Update(ctx, *updated, moved); err != nil {
+		if moved && holdsSlot {
+			s.releaseSlot(context.WithoutCancel(ctx), updated.DoctorID, updated.StartTime, updated.ID)
+		}
+		if errors.Is(err, store.ErrNotFound) {
+			return errAppointmentClosed
+		}
+		return err
+	}
+
+	switch {
+	case !holdsSlot:
+		s.notifyAppointment(notification.Cancelled, *updated)
+		s.uncountReferral(context.WithoutCancel(ctx), existing)
+	case moved:
+		s.notifyAppointment(notification.Rescheduled, *updated)
+	}
+
+	// The update is saved, so the old slot is released even if the request
+	// has run out of time
+	if moved || !holdsSlot {
+		return s.releaseSlot(context.WithoutCancel(ctx), existing.DoctorID, existing.StartTime, existing.ID)
+	}
+	return nil
+}
+
+// fitToSlot checks the appointment starts on one of the doctor's slots and
+// sets its end time to the end of that slot.
+func fitToSlot(doctor Doctor, appointment *Appointment) (Slot, error) {
+	appointment.StartTime = appointment.StartTime.UTC()
+	if !appointment.EndTime.IsZero() && !appointment.EndTime.After(appointment.StartTime) {
+		return Slot{}, errInvalidTimeRange
+	}
+
+	slot, ok := findSlot(doctor, appointment.StartTime)
+	if !ok || (!appointment.EndTime.IsZero() && !appointment.EndTime.Equal(slot.EndTime)) {
+		return Slot{}, errSlotUnavailable
+	}
+	appointment.EndTime = slot.EndTime
+	return slot, nil
+}
+
+// fitToBookableSlot is fitToSlot that also turns patients away from
+// follow-up slots unless the doctor has seen them before.
+func (s *Server) fitToBookableSlot(ctx context.Context, doctor Doctor, appointment *Appointment) error {
+	slot, err := fitToSlot(doctor, appointment)
+	if err != nil || !slot.FollowUpOnly {
+		return err
+	}
+	seen, err := s.hasVisited(ctx, appointment.PatientID, doctor.ID)
+	if err != nil {
+		return err
+	}
+	if !seen {
+		return errFollowUpOnly
+	}
+	return nil
+}
+
+// hasVisited reports whether the patient has completed an appointment with
+// the doctor, or with any doctor when doctorID is empty, archived ones
+// included.
+func (s *Server) hasVisited(ctx context.Context, patientID, doctorID string) (bool, error) {
+	filter := store.AppointmentFilter{PatientID: patientID, DoctorID: doctorID, Statuses: []string{AppointmentCompleted}, IncludeArchived: true}
+	_, total, err := s.store.Appointments.List(ctx, filter, store.Page{Limit: 1})
+	return total > 0, err
+}
+
+// appointmentHistory returns every appointment matching filter, including
+// archived ones, earliest first. Archived appointments are read-only and
+// not found by findAppointment.
+func (s *Server) appointmentHistory(ctx context.Context, filter store.AppointmentFilter) ([]Appointment, error) {
+	filter.IncludeArchived = true
+	appointments, _, err := s.store.Appointments.List(ctx, filter, store.Page{Sort: "startTime"})
+	return appointments, err
+}
+
+// parseAppointmentQuery reads the paging and the ?status=, ?from= and ?to=
+// filters of an appointment listing, which includes archived appointments.
+// Only startTime orderings are supported, since pages run across the
+// archive and the live appointments in turn.
+func parseAppointmentQuery(c *gin.Context) (store.AppointmentFilter, listQuery, error) {
+	filter := store.AppointmentFilter{IncludeArchived: true}
+	q, err := parseListQuery(c, "startTime", "startTime")
+	if err != nil {
+		return filter, q, err
+	}
+
+	if statuses := c.QueryArray("status"); len(statuses) > 0 {
+		for _, status := range statuses {
+			if !isValidAppointmentStatus(status) {
+				return filter, q, errors.New("status must be one of scheduled, completed, cancelled or no-show")
+			}
+		}
+		filter.Statuses = statuses
+	}
+
+	if filter.From, err = parseTimeQuery(c, "from"); err != nil {
+		return filter, q, err
+	}
+	if filter.To, err = parseTimeQuery(c, "to"); err != nil {
+		return filter, q, err
+	}
+	return filter, q, nil
+}
+
+func (s *Server) findAppointment(ctx context.Context, patientID, appointmentID string) (Appointment, error) {
+	appointment, err := s.store.Appointments.Get(ctx, patientID, appointmentID)
+	if errors.Is(err, store.ErrNotFound) {
+		return Appointment{}, errAppointmentNotFound
+	}
+	return appointment, err
+}
+
+func (s *Server) findPatient(ctx context.Context, patientID string) (Patient, error) {
+	patient, err := s.store.Patients.Get(ctx, patientID)
+	if errors.Is(err, store.ErrNotFound) {
+		return Patient{}, errPatientNotFound
+	}
+	return patient, err
+}
+
+func (s *Server) findDoctor(ctx context.Context, doctorID string) (Doctor, error) {
+	doctor, err := s.store.Doctors.Get(ctx, doctorID)
+	if errors.Is(err, store.ErrNotFound) {
+		return Doctor{}, errDoctorNotFound
+	}
+	return doctor, err
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"containerized-go-app/notification"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bookingFixture is a doctor with two consecutive flat-schedule slots
+// tomorrow and two patients with accounts.
+type bookingFixture struct {
+	*testServer
+	doctor     Doctor
+	first      time.Time
+	second     time.Time
+	alice, bob User
+}
+
+func newBookingFixture(t *testing.T) *bookingFixture {
+	t.Helper()
+	ctx := context.Background()
+	f := &bookingFixture{testServer: newTestServer(t)}
+
+	f.first = time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1).Add(9 * time.Hour)
+	f.second = f.first.Add(slotDuration)
+	f.doctor = Doctor{
+		ID:       "d1",
+		DName:    "Dr. Grey",
+		Schedule: []string{f.first.Format(time.RFC3339), f.second.Format(time.RFC3339)},
+	}
+	if err := f.store.Doctors.Create(ctx, f.doctor); err != nil {
+		t.Fatal(err)
+	}
+
+	f.alice = User{Username: "alice", Role: RolePatient, ProfileID: "p-alice"}
+	f.bob = User{Username: "bob", Role: RolePatient, ProfileID: "p-bob"}
+	for _, user := range []User{f.alice, f.bob} {
+		if err := f.store.Patients.Create(ctx, Patient{ID: user.ProfileID, PName: user.Username}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+func (f *bookingFixture) book(t *testing.T, patient User, start time.Time) *httptest.ResponseRecorder {
+	t.Helper()
+	path := fmt.Sprintf("/api/patients/%s/appointments", patient.ProfileID)
+	return f.do(t, http.MethodPost, path, patient, gin.H{"doctorId": f.doctor.ID, "startTime": start})
+}
+
+func (f *bookingFixture) freeSlots(t *testing.T) []Slot {
+	t.Helper()
+	day := f.first.Format(dateLayout)
+	path := fmt.Sprintf("/api/doctors/%s/slots?from=%s&to=%s", f.doctor.ID, day, day)
+	return decode[[]Slot](t, f.do(t, http.MethodGet, path, User{}, nil), http.StatusOK)
+}
+
+type bookingResponse struct {
+	Appointment Appointment `json:"appointment"`
+}
+
+func TestBookAppointment(t *testing.T) {
+	f := newBookingFixture(t)
+
+	resp := decode[bookingResponse](t, f.book(t, f.alice, f.first), http.StatusOK)
+	a := resp.Appointment
+	if a.ID == "" || a.Status != AppointmentScheduled || a.PatientID != f.alice.ProfileID {
+		t.Errorf("appointment = %+v, want a scheduled appointment for %s", a, f.alice.ProfileID)
+	}
+	if !a.EndTime.Equal(f.first.Add(slotDuration)) {
+		t.Errorf("endTime = %s, want the end of the slot %s", a.EndTime, f.first.Add(slotDuration))
+	}
+
+	free := f.freeSlots(t)
+	if len(free) != 1 || !free[0].StartTime.Equal(f.second) {
+		t.Errorf("free slots after booking = %+v, want only %s", free, f.second)
+	}
+}
+
+func TestBookingHidesStaffFieldsFromPatients(t *testing.T) {
+	f := newBookingFixture(t)
+	ctx := context.Background()
+	if err := f.store.Patients.SetTags(ctx, f.alice.ProfileID, []string{"anxious"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.store.Patients.AddNote(ctx, f.alice.ProfileID, PatientNote{Text: "Prefers mornings", Author: "grey"}); err != nil {
+		t.Fatal(err)
+	}
+
+	own := decode[map[string]interface{}](t, f.book(t, f.alice, f.first), http.StatusOK)
+	if _, ok := own["tags"]; ok {
+		t.Errorf("patient booking response = %v, want no tags", own)
+	}
+	if _, ok := own["notes"]; ok {
+		t.Errorf("patient booking response = %v, want no notes", own)
+	}
+
+	doctor := User{Username: "grey", Role: RoleDoctor, ProfileID: f.doctor.ID}
+	staff := decode[struct {
+		Tags  []string      `json:"tags"`
+		Notes []PatientNote `json:"notes"`
+	}](t, f.do(t, http.MethodPost, "/api/patients/p-alice/appointments", doctor, gin.H{"doctorId": f.doctor.ID, "startTime": f.second}), http.StatusOK)
+	if len(staff.Tags) != 1 || len(staff.Notes) != 1 {
+		t.Errorf("staff booking response = %+v, want alice's tag and note", staff)
+	}
+}
+
+func TestBookAppointmentErrors(t *testing.T) {
+	f := newBookingFixture(t)
+	decode[bookingResponse](t, f.book(t, f.alice, f.first), http.StatusOK)
+
+	tests := []struct {
+		name     string
+		patient  User
+		start    time.Time
+		body     gin.H
+		status   int
+		wantCode string
+	}{
+		{name: "slot taken", patient: f.bob, start: f.first, status: http.StatusConflict, wantCode: "slot_taken"},
+		{name: "not a slot", patient: f.bob, start: f.first.Add(10 * time.Minute), status: http.StatusBadRequest, wantCode: "slot_unavailable"},
+		{
+			name:     "end before start",
+			patient:  f.bob,
+			body:     gin.H{"doctorId": f.doctor.ID, "startTime": f.second, "endTime": f.second.Add(-time.Minute)},
+			status:   http.StatusBadRequest,
+			wantCode: "validation_failed",
+		},
+		{name: "unknown doctor", patient: f.bob, body: gin.H{"doctorId": "nobody", "startTime": f.second}, status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := tt.body
+			if body == nil {
+				body = gin.H{"doctorId": f.doctor.ID, "startTime": tt.start}
+			}
+			path := fmt.Sprintf("/api/patients/%s/appointments", tt.patient.ProfileID)
+			resp := decode[apiError](t, f.do(t, http.MethodPost, path, tt.patient, body), tt.status)
+			if tt.wantCode != "" && resp.Code != tt.wantCode {
+				t.Errorf("code = %q, want %q", resp.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestBookAppointmentForAnotherPatient(t *testing.T) {
+	f := newBookingFixture(t)
+
+	path := fmt.Sprintf("/api/patients/%s/appointments", f.bob.ProfileID)
+	rec := f.do(t, http.MethodPost, path, f.alice, gin.H{"doctorId": f.doctor.ID, "startTime": f.first})
+	decode[apiError](t, rec, http.StatusForbidden)
+
+	rec = f.do(t, http.MethodPost, path, User{}, gin.H{"doctorId": f.doctor.ID, "startTime": f.first})
+	decode[apiError](t, rec, http.StatusUnauthorized)
+}
+
+func TestConcurrentBookingsClaimSlotOnce(t *testing.T) {
+	f := newBookingFixture(t)
+	const patients = 20
+
+	codes := make(chan int, patients)
+	var wg sync.WaitGroup
+	for i := 0; i < patients; i++ {
+		patient := User{Username: fmt.Sprintf("p%d", i), Role: RolePatient, ProfileID: fmt.Sprintf("p%d", i)}
+		if err := f.store.Patients.Create(context.Background(), Patient{ID: patient.ProfileID}); err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- f.book(t, patient, f.first).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	booked := 0
+	for code := range codes {
+		switch code {
+		case http.StatusOK:
+			booked++
+		case http.StatusConflict:
+		default:
+			t.Errorf("unexpected status %d", code)
+		}
+	}
+	if booked != 1 {
+		t.Errorf("%d bookings succeeded for one slot, want 1", booked)
+	}
+}
+
+func TestCancelAppointmentFreesSlot(t *testing.T) {
+	f := newBookingFixture(t)
+	booked := decode[bookingResponse](t, f.book(t, f.alice, f.first), http.StatusOK).Appointment
+
+	path := fmt.Sprintf("/api/patients/%s/appointments/%s", f.alice.ProfileID, booked.ID)
+	if rec := f.do(t, http.MethodDelete, path+"?reason=patient_request", f.alice, nil); rec.Code != http.StatusOK {
+		t.Fatalf("cancel: status = %d; body %s", rec.Code, rec.Body)
+	}
+	decode[bookingResponse](t, f.book(t, f.bob, f.first), http.StatusOK)
+
+	// The cancelled appointment stays in the patient's history
+	history := decode[[]Appointment](t, f.do(t, http.MethodGet, "/api/patients/p-alice/appointments", f.alice, nil), http.StatusOK)
+	if len(history) != 1 || history[0].Status != AppointmentCancelled {
+		t.Errorf("history = %+v, want the cancelled appointment", history)
+	}
+
+	// and can't be rescheduled
+	update := gin.H{"doctorId": f.doctor.ID, "startTime": f.second}
+	resp := decode[apiError](t, f.do(t, http.MethodPut, path, f.alice, update), http.StatusConflict)
+	if resp.Code != "appointment_closed" {
+		t.Errorf("rescheduling a cancelled appointment: code = %q, want appointment_closed", resp.Code)
+	}
+}
+
+func TestCancelClosedAppointment(t *testing.T) {
+	f := newBookingFixture(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sent := make(captureSender, 10)
+	f.notifier = notification.New(10, sent)
+	go f.notifier.Run(ctx)
+
+	first := decode[bookingResponse](t, f.book(t, f.alice, f.first), http.StatusOK).Appointment
+	second := decode[bookingResponse](t, f.book(t, f.alice, f.second), http.StatusOK).Appointment
+	second.Status = AppointmentCompleted
+	if err := f.store.Appointments.Update(ctx, second, false); err != nil {
+		t.Fatal(err)
+	}
+
+	path := fmt.Sprintf("/api/patients/%s/appointments/", f.alice.ProfileID)
+	decode[struct{}](t, f.do(t, http.MethodDelete, path+first.ID+"?reason=patient_request", f.alice, nil), http.StatusOK)
+	for _, id := range []string{first.ID, second.ID} {
+		resp := decode[apiError](t, f.do(t, http.MethodDelete, path+id+"?reason=patient_request", f.alice, nil), http.StatusConflict)
+		if resp.Code != "appointment_closed" {
+			t.Errorf("cancelling %s again: code = %q, want appointment_closed", id, resp.Code)
+		}
+	}
+	grey := User{Username: "grey", Role: RoleDoctor, ProfileID: "d1"}
+	update := gin.H{"doctorId": f.doctor.ID, "startTime": f.second, "status": AppointmentCancelled, "cancellationReason": "patient_request"}
+	if resp := decode[apiError](t, f.do(t, http.MethodPut, path+second.ID, grey, update), http.StatusConflict); resp.Code != "appointment_closed" {
+		t.Errorf("cancelling the completed appointment with PUT: code = %q, want appointment_closed", resp.Code)
+	}
+	if got, err := f.store.Appointments.Get(ctx, f.alice.ProfileID, second.ID); err != nil || got.Status != AppointmentCompleted {
+		t.Errorf("completed appointment = %+v, %v; want it still completed", got, err)
+	}
+
+	cancelled := 0
+	timeout := time.After(100 * time.Millisecond)
+	for done := false; !done; {
+		select {
+		case event := <-sent:
+			if event.Kind == notification.Cancelled {
+				cancelled++
+			}
+		case <-timeout:
+			done = true
+		}
+	}
+	if cancelled != 1 {
+		t.Errorf("sent %d cancellation notices, want 1", cancelled)
+	}
+}
+
+func TestRescheduleAppointment(t *testing.T) {
+	f := newBookingFixture(t)
+	booked := decode[bookingResponse](t, f.book(t, f.alice, f.first), http.StatusOK).Appointment
+	path := fmt.Sprintf("/api/patients/%s/appointments/%s", f.alice.ProfileID, booked.ID)
+
+	update := gin.H{"doctorId": f.doctor.ID, "startTime": f.second, "notes": "moved"}
+	moved := decode[bookingResponse](t, f.do(t, http.MethodPut, path, f.alice, update), http.StatusOK).Appointment
+	if !moved.StartTime.Equal(f.second) || !moved.EndTime.Equal(f.second.Add(slotDuration)) {
+		t.Errorf("rescheduled to %s-%s, want the slot at %s", moved.StartTime, moved.EndTime, f.second)
+	}
+
+	// The old slot is free again and the new one is taken
+	decode[bookingResponse](t, f.book(t, f.bob, f.first), http.StatusOK)
+	decode[apiError](t, f.book(t, f.bob, f.second), http.StatusConflict)
+
+	// Patients can't record outcomes
+	update["status"] = AppointmentCompleted
+	decode[apiError](t, f.do(t, http.MethodPut, path, f.alice, update), http.StatusForbidden)
+	doctor := User{Username: "grey", Role: RoleDoctor, ProfileID: f.doctor.ID}
+	completed := decode[bookingResponse](t, f.do(t, http.MethodPut, path, doctor, update), http.StatusOK).Appointment
+	if completed.Status != AppointmentCompleted {
+		t.Errorf("status = %q, want completed", completed.Status)
+	}
+}
+
+func TestAppointmentHistoryIncludesArchive(t *testing.T) {
+	f := newBookingFixture(t)
+	ctx := context.Background()
+
+	old := Appointment{
+		ID:        "old",
+		PatientID: f.alice.ProfileID,
+		DoctorID:  f.doctor.ID,
+		StartTime: time.Now().UTC().AddDate(-5, 0, 0),
+		EndTime:   time.Now().UTC().AddDate(-5, 0, 0).Add(slotDuration),
+		Status:    AppointmentCompleted,
+	}
+	if err := f.store.Appointments.Create(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+	decode[bookingResponse](t, f.book(t, f.alice, f.first), http.StatusOK)
+
+	if moved, err := f.archiveAppointments(ctx, time.Now().UTC().AddDate(-3, 0, 0)); err != nil || moved != 1 {
+		t.Fatalf("archiveAppointments = %d, %v; want 1 moved", moved, err)
+	}
+
+	rec := f.do(t, http.MethodGet, "/api/patients/p-alice/appointments?sort=-startTime&limit=1", f.alice, nil)
+	page := decode[[]Appointment](t, rec, http.StatusOK)
+	if total := rec.Header().Get(totalCountHeader); total != "2" {
+		t.Errorf("%s = %q, want 2", totalCountHeader, total)
+	}
+	if len(page) != 1 || page[0].ID == old.ID {
+		t.Errorf("first page newest first = %+v, want the upcoming appointment", page)
+	}
+
+	rec = f.do(t, http.MethodGet, "/api/patients/p-alice/appointments?sort=-startTime&limit=1&page=2", f.alice, nil)
+	page = decode[[]Appointment](t, rec, http.StatusOK)
+	if len(page) != 1 || page[0].ID != old.ID {
+		t.Errorf("second page = %+v, want the archived appointment", page)
+	}
+
+	// Archived appointments are read-only
+	path := fmt.Sprintf("/api/patients/%s/appointments/%s", f.alice.ProfileID, old.ID)
+	decode[apiError](t, f.do(t, http.MethodDelete, path+"?reason=patient_request", f.alice, nil), http.StatusNotFound)
+}
+
+func TestFollowUpOnlySlot(t *testing.T) {
+	f := newBookingFixture(t)
+	doctor := User{Username: "grey", Role: RoleDoctor, ProfileID: f.doctor.ID}
+	followUps := []string{f.second.Format(time.RFC3339)}
+	if rec := f.do(t, http.MethodPut, "/api/doctors/d1/follow-up-slots", doctor, followUps); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d; body %s", rec.Code, rec.Body)
+	}
+	if free := f.freeSlots(t); len(free) != 2 || free[0].FollowUpOnly || !free[1].FollowUpOnly {
+		t.Errorf("free slots = %+v, want only the second marked follow-up", free)
+	}
+
+	resp := decode[apiError](t, f.book(t, f.bob, f.second), http.StatusForbidden)
+	if resp.Code != "follow_up_only" {
+		t.Errorf("new patient code = %q, want follow_up_only", resp.Code)
+	}
+
+	past := time.Now().UTC().AddDate(0, -1, 0)
+	visit := Appointment{ID: "visit", PatientID: f.alice.ProfileID, DoctorID: f.doctor.ID, StartTime: past, EndTime: past.Add(slotDuration), Status: AppointmentCompleted}
+	if err := f.store.Appointments.Create(context.Background(), visit); err != nil {
+		t.Fatal(err)
+	}
+	decode[bookingResponse](t, f.book(t, f.alice, f.second), http.StatusOK)
+}
+
+func TestRescheduleRunsBookingChecks(t *testing.T) {
+	f := newBookingFixture(t)
+	ctx := context.Background()
+	if err := f.store.Doctors.Create(ctx, Doctor{ID: "d2", DName: "Dr. Shepherd", Schedule: f.doctor.Schedule}); err != nil {
+		t.Fatal(err)
+	}
+	grey := User{Username: "grey", Role: RoleDoctor, ProfileID: "d1"}
+	move := func(user User, appointment Appointment, doctorID string, start time.Time) *httptest.ResponseRecorder {
+		t.Helper()
+		path := fmt.Sprintf("/api/patients/%s/appointments/%s", appointment.PatientID, appointment.ID)
+		return f.do(t, http.MethodPut, path, user, gin.H{"doctorId": doctorID, "startTime": start})
+	}
+
+	// A visit that is over can't take a new slot
+	visited := decode[bookingResponse](t, f.book(t, f.alice, f.first), http.StatusOK).Appointment
+	visited.Status = AppointmentCompleted
+	if err := f.store.Appointments.Update(ctx, visited, false); err != nil {
+		t.Fatal(err)
+	}
+	if resp := decode[apiError](t, move(grey, visited, "d1", f.second), http.StatusConflict); resp.Code != "appointment_closed" {
+		t.Errorf("moving a completed visit: code = %q, want appointment_closed", resp.Code)
+	}
+	bobs := decode[bookingResponse](t, f.book(t, f.bob, f.second), http.StatusOK).Appointment
+
+	// Nor can a patient whose account is closing
+	confirmed := time.Now()
+	if err := f.store.Closures.Put(ctx, AccountClosure{PatientID: f.bob.ProfileID, Username: f.bob.Username, ConfirmedAt: &confirmed}); err != nil {
+		t.Fatal(err)
+	}
+	if resp := decode[apiError](t, move(f.bob, bobs, "d2", f.second), http.StatusConflict); resp.Code != "account_closing" {
+		t.Errorf("moving while closing: code = %q, want account_closing", resp.Code)
+	}
+
+	// Or one whose pre-screening sends them to emergency care
+	alices := decode[bookingResponse](t, f.do(t, http.MethodPost, "/api/patients/p-alice/appointments", f.alice, gin.H{"doctorId": "d2", "startTime": f.first}), http.StatusOK).Appointment
+	decode[triageResponse](t, f.do(t, http.MethodPost, "/api/patients/p-alice/triage", f.alice, gin.H{"symptoms": []string{"chest_pain"}, "severity": 4}), http.StatusCreated)
+	if resp := decode[apiError](t, move(f.alice, alices, "d2", f.second), http.StatusForbidden); resp.Code != "emergency_care" {
+		t.Errorf("moving after an emergency pre-screening: code = %q, want emergency_care", resp.Code)
+	}
+	if rec := f.do(t, http.MethodPost, "/api/patients/p-alice/appointments", User{Username: "root", Role: RoleAdmin}, gin.H{"doctorId": "d2", "startTime": f.second}); rec.Code != http.StatusOK {
+		t.Errorf("d2's second slot isn't free after the refused moves: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultArchiveAfterYears = 3
+	archiveInterval          = 24 * time.Hour
+)
+
+// archiveAfterYears reads ARCHIVE_AFTER_YEARS; 0 disables archival.
+func archiveAfterYears() int {
+	value := os.Getenv("ARCHIVE_AFTER_YEARS")
+	if value == "" {
+		return defaultArchiveAfterYears
+	}
+	years, err := strconv.Atoi(value)
+	if err != nil || years < 0 {
+		log.Printf("Ignoring invalid ARCHIVE_AFTER_YEARS=%q", value)
+		return defaultArchiveAfterYears
+	}
+	return years
+}
+
+// runAppointmentArchival moves appointments that ended more than years ago
+// to the archive collection on start and then every archiveInterval.
+func (s *Server) runAppointmentArchival(ctx context.Context, years int) {
+	ticker := time.NewTicker(archiveInterval)
+	defer ticker.Stop()
+
+	for {
+		cutoff := time.Now().UTC().AddDate(-years, 0, 0)
+		if moved, err := s.archiveAppointments(ctx, cutoff); err != nil {
+			log.Println("Appointment archival failed: ", err)
+		} else if moved > 0 {
+			log.Printf("Archived %d appointments that ended before %s", moved, cutoff.Format(dateLayout))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// archiveAppointments moves every appointment that ended before cutoff and
+// returns how many were moved. The store copies each appointment before
+// deleting it, so an interrupted run is safely picked up by the next one.
+func (s *Server) archiveAppointments(ctx context.Context, cutoff time.Time) (int, error) {
+	moved, err := s.store.Appointments.Archive(ctx, cutoff)
+	if err != nil {
+		return moved, err
+	}
+
+	// Claims on slots this old can no longer matter
+	return moved, s.store.Slots.DeleteBefore(ctx, cutoff)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Attendance = store.Attendance
+
+const defaultAttendanceDays = 30
+
+var (
+	// lateStartGrace is how long after their first slot doctors can clock
+	// in before the start counts as late
+	lateStartGrace = 10 * time.Minute
+	// lateStartPush moves the appointments a late doctor has missed to the
+	// day's next free slots
+	lateStartPush = false
+)
+
+// clinicDay returns the clinic-local day at holds and the UTC bounds of it.
+func clinicDay(at time.Time) (day string, from, to time.Time) {
+	local := at.In(calendarLocation)
+	start := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, calendarLocation)
+	return start.Format(dateLayout), start.UTC(), start.AddDate(0, 0, 1).UTC()
+}
+
+// clockIn puts user's day on the clock at now. For doctors the shift
+// starts with their first slot of the day; clocking in more than
+// lateStartGrace after it is a late start, which with lateStartPush moves
+// the appointments already missed.
+func (s *Server) clockIn(ctx context.Context, user AuthUser, now time.Time) (Attendance, error) {
+	day, from, to := clinicDay(now)
+	attendance := Attendance{
+		ID:          store.AttendanceID(user.Username, day),
+		Username:    user.Username,
+		Day:         day,
+		ClockedInAt: now.UTC(),
+	}
+
+	var doctor Doctor
+	if user.Role == RoleDoctor && user.ProfileID != "" {
+		var err error
+		if doctor, err = s.findDoctor(ctx, user.ProfileID); err != nil {
+			return Attendance{}, err
+		}
+		attendance.DoctorID = doctor.ID
+		slots, err := doctorSlots(doctor, from, to)
+		if err != nil {
+			return Attendance{}, err
+		}
+		if len(slots) > 0 {
+			sort.Slice(slots, func(i, j int) bool { return slots[i].StartTime.Before(slots[j].StartTime) })
+			start := slots[0].StartTime
+			attendance.ShiftStart = &start
+			if late := now.Sub(start); late > lateStartGrace {
+				attendance.LateMinutes = int(late / time.Minute)
+			}
+		}
+	}
+	if err := s.store.Attendance.Create(ctx, attendance); err != nil {
+		return Attendance{}, err
+	}
+
+	if attendance.LateMinutes > 0 && lateStartPush {
+		pushed, err := s.pushMissedAppointments(ctx, doctor, from, now, to)
+		if err != nil {
+			log.Printf("Pushing %s's missed appointments failed: %v", doctor.ID, err)
+		}
+		attendance.PushedAppointments = pushed
+		// The clock-in stands even if recording the pushes fails
+		if len(pushed) > 0 {
+			if err := s.store.Attendance.SetPushed(ctx, attendance.ID, pushed); err != nil {
+				log.Printf("Recording pushed appointments of %s failed: %v", attendance.ID, err)
+			}
+		}
+	}
+	return attendance, nil
+}
+
+// pushMissedAppointments moves the doctor's appointments that started in
+// [from, now) to the free slots after now and before to, in order, and
+// returns the IDs of those it moved. The reschedule notifies each patient.
+// Appointments with no slot left for them are left where they are.
+func (s *Server) pushMissedAppointments(ctx context.Context, doctor Doctor, from, now, to time.Time) ([]string, error) {
+	filter := store.AppointmentFilter{DoctorID: doctor.ID, Statuses: []string{AppointmentScheduled}, From: from, To: now}
+	missed, _, err := s.store.Appointments.List(ctx, filter, store.Page{Sort: "startTime"})
+	if err != nil {
+		return nil, err
+	}
+	free, err := s.freeSlots(ctx, doctor, now, to)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(free, func(i, j int) bool { return free[i].StartTime.Before(free[j].StartTime) })
+
+	pushed := []string{}
+	// used marks the free slots taken by a push or by a booking meanwhile;
+	// a follow-up slot one patient can't have may still suit the next
+	used := make([]bool, len(free))
+	for _, existing := range missed {
+		if !existing.StartTime.Before(now) {
+			continue
+		}
+		for i, slot := range free {
+			if used[i] {
+				continue
+			}
+			updated := existing
+			updated.StartTime, updated.EndTime = slot.StartTime, time.Time{}
+			err := s.rescheduleAppointment(ctx, existing, &updated)
+			if errors.Is(err, errFollowUpOnly) {
+				continue
+			}
+			used[i] = true
+			if errors.Is(err, errSlotTaken) {
+				continue
+			} else if err != nil {
+				return pushed, err
+			}
+			pushed = append(pushed, existing.ID)
+			break
+		}
+	}
+	return pushed, nil
+}
+
+// ClockIn starts the signed-in doctor's or admin's day.
+func (s *Server) ClockIn(c *gin.Context) {
+	user, _ := currentUser(c)
+	attendance, err := s.clockIn(c.Request.Context(), user, time.Now())
+	if errors.Is(err, store.ErrDuplicate) {
+		abortWithCode(c, http.StatusConflict, "already_clocked_in", "You have already clocked in today")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error clocking in")
+		return
+	}
+	c.JSON(http.StatusCreated, attendance)
+}
+
+// ClockOut ends the signed-in user's day.
+func (s *Server) ClockOut(c *gin.Context) {
+	ctx := c.Request.Context()
+	user, _ := currentUser(c)
+	now := time.Now().UTC()
+	day, _, _ := clinicDay(now)
+	attendance, err := s.store.Attendance.Get(ctx, store.AttendanceID(user.Username, day))
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithCode(c, http.StatusConflict, "not_clocked_in", "You haven't clocked in today")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error clocking out")
+		return
+	}
+	if attendance.ClockedOutAt != nil {
+		abortWithCode(c, http.StatusConflict, "already_clocked_out", "You have already clocked out today")
+		return
+	}
+	if err := s.store.Attendance.ClockOut(ctx, attendance.ID, now); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error clocking out")
+		return
+	}
+	attendance.ClockedOutAt = &now
+	c.JSON(http.StatusOK, attendance)
+}
+
+// parseAttendanceRange reads the from and to days, which default to the
+// last defaultAttendanceDays days.
+func parseAttendanceRange(c *gin.Context) (from, to string, ok bool) {
+	today, _, _ := clinicDay(time.Now())
+	from, to = c.Query("from"), c.Query("to")
+	if to == "" {
+		to = today
+	}
+	if from == "" {
+		end, err := time.Parse(dateLayout, to)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, "to must be a YYYY-MM-DD date")
+			return "", "", false
+		}
+		from = end.AddDate(0, 0, 1-defaultAttendanceDays).Format(dateLayout)
+	}
+	for _, day := range []string{from, to} {
+		if _, err := time.Parse(dateLayout, day); err != nil {
+			abortWithError(c, http.StatusBadRequest, "from and to must be YYYY-MM-DD dates")
+			return "", "", false
+		}
+	}
+	return from, to, true
+}
+
+// GetAttendance lists the signed-in user's days on the clock.
+func (s *Server) GetAttendance(c *gin.Context) {
+	user, _ := currentUser(c)
+	s.listAttendance(c, user.Username)
+}
+
+// GetStaffAttendance lists everyone's days on the clock, or one person's
+// with ?username=.
+func (s *Server) GetStaffAttendance(c *gin.Context) {
+	s.listAttendance(c, c.Query("username"))
+}
+
+func (s *Server) listAttendance(c *gin.Context, username string) {
+	from, to, ok := parseAttendanceRange(c)
+	if !ok {
+		return
+	}
+	days, err := s.store.Attendance.List(c.Request.Context(), username, from, to)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving attendance")
+		return
+	}
+	c.JSON(http.StatusOK, days)
+}
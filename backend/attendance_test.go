@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"containerized-go-app/store"
+)
+
+func TestLateClockInPushesMissedAppointments(t *testing.T) {
+	f := newBookingFixture(t)
+	ctx := context.Background()
+	lateStartPush = true
+	t.Cleanup(func() { lateStartPush = false })
+
+	booked := decode[bookingResponse](t, f.book(t, f.alice, f.first), http.StatusOK).Appointment
+	doctor := AuthUser{Username: "grey", Role: RoleDoctor, ProfileID: f.doctor.ID}
+
+	attendance, err := f.clockIn(ctx, doctor, f.first.Add(20*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attendance.ShiftStart == nil || !attendance.ShiftStart.Equal(f.first) || attendance.LateMinutes != 20 {
+		t.Errorf("attendance = %+v, want a shift starting %s, 20 minutes late", attendance, f.first)
+	}
+	if len(attendance.PushedAppointments) != 1 || attendance.PushedAppointments[0] != booked.ID {
+		t.Errorf("pushed = %v, want [%s]", attendance.PushedAppointments, booked.ID)
+	}
+	moved, err := f.findAppointment(ctx, f.alice.ProfileID, booked.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !moved.StartTime.Equal(f.second) {
+		t.Errorf("appointment starts %s, want the next free slot %s", moved.StartTime, f.second)
+	}
+
+	if _, err := f.clockIn(ctx, doctor, f.first.Add(time.Hour)); !errors.Is(err, store.ErrDuplicate) {
+		t.Errorf("second clock-in err = %v, want ErrDuplicate", err)
+	}
+}
+
+func TestClockInWithinGraceIsOnTime(t *testing.T) {
+	f := newBookingFixture(t)
+	lateStartPush = true
+	t.Cleanup(func() { lateStartPush = false })
+
+	booked := decode[bookingResponse](t, f.book(t, f.alice, f.first), http.StatusOK).Appointment
+	doctor := AuthUser{Username: "grey", Role: RoleDoctor, ProfileID: f.doctor.ID}
+
+	attendance, err := f.clockIn(context.Background(), doctor, f.first.Add(5*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attendance.LateMinutes != 0 || len(attendance.PushedAppointments) != 0 {
+		t.Errorf("attendance = %+v, want on time with nothing pushed", attendance)
+	}
+	if kept, _ := f.findAppointment(context.Background(), f.alice.ProfileID, booked.ID); !kept.StartTime.Equal(f.first) {
+		t.Errorf("appointment starts %s, want it left at %s", kept.StartTime, f.first)
+	}
+}
+
+func TestClockInAndOut(t *testing.T) {
+	ts := newTestServer(t)
+	admin := User{Username: "root", Role: RoleAdmin}
+
+	in := decode[Attendance](t, ts.do(t, http.MethodPost, "/api/clock-in", admin, nil), http.StatusCreated)
+	if in.Username != admin.Username || in.ShiftStart != nil {
+		t.Errorf("clock-in = %+v, want root's day without a shift", in)
+	}
+	if got := decode[apiError](t, ts.do(t, http.MethodPost, "/api/clock-in", admin, nil), http.StatusConflict); got.Code != "already_clocked_in" {
+		t.Errorf("second clock-in code = %q, want already_clocked_in", got.Code)
+	}
+
+	out := decode[Attendance](t, ts.do(t, http.MethodPost, "/api/clock-out", admin, nil), http.StatusOK)
+	if out.ClockedOutAt == nil {
+		t.Error("clock-out has no clockedOutAt")
+	}
+	if got := decode[apiError](t, ts.do(t, http.MethodPost, "/api/clock-out", admin, nil), http.StatusConflict); got.Code != "already_clocked_out" {
+		t.Errorf("second clock-out code = %q, want already_clocked_out", got.Code)
+	}
+
+	days := decode[[]Attendance](t, ts.do(t, http.MethodGet, "/api/admin/attendance?username=root", admin, nil), http.StatusOK)
+	if len(days) != 1 || days[0].ClockedOutAt == nil {
+		t.Errorf("attendance = %+v, want the one finished day", days)
+	}
+
+	patient := User{Username: "alice", Role: RolePatient, ProfileID: "p-alice"}
+	if rec := ts.do(t, http.MethodPost, "/api/clock-in", patient, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("patient clock-in status = %d, want 403", rec.Code)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AuditEntry = store.AuditEntry
+
+// audit records that the current user took action on patientID. Failing to
+// write the entry is logged rather than failing the request, since the
+// action has already happened.
+func (s *Server) audit(c *gin.Context, action, patientID, detail string) {
+	user, _ := currentUser(c)
+	entry := AuditEntry{
+		ID:        primitive.NewObjectID().Hex(),
+		At:        time.Now().UTC(),
+		Actor:     user.Username,
+		ActorRole: user.Role,
+		Action:    action,
+		PatientID: patientID,
+		Detail:    detail,
+	}
+	if err := s.store.Audit.Append(context.WithoutCancel(c.Request.Context()), entry); err != nil {
+		log.Printf("Writing audit entry %s by %s for patient %s failed: %v", action, user.Username, patientID, err)
+	}
+	s.auditSinks.Publish(entry)
+}
+
+// GetAuditLog lists audit entries, newest first unless ?sort= says
+// otherwise, optionally narrowed by ?patientId= and ?action=.
+func (s *Server) GetAuditLog(c *gin.Context) {
+	q, err := parseListQuery(c, "at", "at")
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if c.Query("sort") == "" {
+		q.Desc = true
+	}
+
+	filter := store.AuditFilter{PatientID: c.Query("patientId"), Action: c.Query("action")}
+	entries, total, err := s.store.Audit.List(c.Request.Context(), filter, q.page())
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving audit log")
+		return
+	}
+	setTotalCount(c, total)
+	c.JSON(http.StatusOK, entries)
+}
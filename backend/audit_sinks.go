@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	auditQueueSize   = 1024
+	auditSinkTimeout = 10 * time.Second
+	// Formats of AUDIT_HTTP_FORMAT
+	auditFormatJSON   = "json"
+	auditFormatSplunk = "splunk"
+)
+
+// AuditSink receives a copy of every audit entry, e.g. for the security
+// team's SIEM. The store stays the record the audit log endpoint reads.
+type AuditSink interface {
+	Write(ctx context.Context, entry AuditEntry) error
+}
+
+// auditSinks fans audit entries out to the sinks in the background, so a
+// slow sink never holds up a request.
+type auditSinks struct {
+	sinks []AuditSink
+	queue chan AuditEntry
+}
+
+func newAuditSinks(queueSize int, sinks ...AuditSink) *auditSinks {
+	return &auditSinks{sinks: sinks, queue: make(chan AuditEntry, queueSize)}
+}
+
+// auditSinksFromEnv sets up the sinks named by the environment:
+//
+//	AUDIT_FILE (an append-only file of JSON lines)
+//	AUDIT_SYSLOG_ADDR ("local", or udp://host:514 or tcp://host:514)
+//	AUDIT_HTTP_URL, AUDIT_HTTP_AUTHORIZATION (sent as the Authorization header),
+//	AUDIT_HTTP_FORMAT (json, or splunk for an HTTP Event Collector)
+//
+// It returns nil when none is set.
+func auditSinksFromEnv() (*auditSinks, error) {
+	var sinks []AuditSink
+	if path := os.Getenv("AUDIT_FILE"); path != "" {
+		sink, err := newFileAuditSink(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AUDIT_FILE: %w", err)
+		}
+		sinks = append(sinks, sink)
+	}
+	if addr := os.Getenv("AUDIT_SYSLOG_ADDR"); addr != "" {
+		sink, err := newSyslogAuditSink(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AUDIT_SYSLOG_ADDR: %w", err)
+		}
+		sinks = append(sinks, sink)
+	}
+	if url := os.Getenv("AUDIT_HTTP_URL"); url != "" {
+		format := os.Getenv("AUDIT_HTTP_FORMAT")
+		switch format {
+		case "":
+			format = auditFormatJSON
+		case auditFormatJSON, auditFormatSplunk:
+		default:
+			return nil, fmt.Errorf("invalid AUDIT_HTTP_FORMAT %q: use %s or %s", format, auditFormatJSON, auditFormatSplunk)
+		}
+		sinks = append(sinks, httpAuditSink{URL: url, Authorization: os.Getenv("AUDIT_HTTP_AUTHORIZATION"), Format: format})
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	return newAuditSinks(auditQueueSize, sinks...), nil
+}
+
+// Publish queues entry for the sinks. It never blocks: when the queue is
+// full the entry is dropped and logged, and stays in the store.
+func (a *auditSinks) Publish(entry AuditEntry) {
+	if a == nil {
+		return
+	}
+	select {
+	case a.queue <- entry:
+	default:
+		log.Printf("Audit sink queue full, dropping %s entry %s", entry.Action, entry.ID)
+	}
+}
+
+// Run writes queued entries to every sink until ctx is cancelled.
+func (a *auditSinks) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case entry := <-a.queue:
+			a.write(ctx, entry)
+		}
+	}
+}
+
+func (a *auditSinks) write(ctx context.Context, entry AuditEntry) {
+	for _, sink := range a.sinks {
+		sinkCtx, cancel := context.WithTimeout(ctx, auditSinkTimeout)
+		if err := sink.Write(sinkCtx, entry); err != nil {
+			log.Printf("Writing audit entry %s to %T failed: %v", entry.ID, sink, err)
+		}
+		cancel()
+	}
+}
+
+// fileAuditSink appends each entry as a line of JSON. The file is opened
+// append-only so earlier entries are never rewritten.
+type fileAuditSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newFileAuditSink(path string) (*fileAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileAuditSink{file: file}, nil
+}
+
+func (f *fileAuditSink) Write(_ context.Context, entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err = f.file.Write(append(line, '\n'))
+	return err
+}
+
+// httpAuditSink POSTs each entry to a collector such as Splunk's HTTP Event
+// Collector or an Elasticsearch ingest endpoint.
+type httpAuditSink struct {
+	URL           string
+	Authorization string
+	Format        string
+	Client        *http.Client
+}
+
+func (h httpAuditSink) Write(ctx context.Context, entry AuditEntry) error {
+	var body interface{} = entry
+	if h.Format == auditFormatSplunk {
+		body = map[string]interface{}{"time": entry.At.Unix(), "sourcetype": "clinic:audit", "event": entry}
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.Authorization != "" {
+		req.Header.Set("Authorization", h.Authorization)
+	}
+
+	httpClient := h.Client
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("audit collector responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// syslogMessage is the line syslog sinks send, as key=value pairs SIEMs
+// parse without configuration.
+func syslogMessage(entry AuditEntry) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "action=%s actor=%s role=%s", entry.Action, entry.Actor, entry.ActorRole)
+	if entry.PatientID != "" {
+		fmt.Fprintf(&b, " patient=%s", entry.PatientID)
+	}
+	if entry.Detail != "" {
+		fmt.Fprintf(&b, " detail=%q", entry.Detail)
+	}
+	fmt.Fprintf(&b, " id=%s", entry.ID)
+	return b.String()
+}
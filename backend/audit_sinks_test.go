@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAuditSinks(t *testing.T) {
+	f := newBookingFixture(t)
+	if err := f.store.Users.Create(context.Background(), f.bob); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	file, err := newFileAuditSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	splunk := make(chan map[string]json.RawMessage, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Splunk hec-token" {
+			t.Errorf("collector got Authorization %q", r.Header.Get("Authorization"))
+		}
+		var event map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Error(err)
+		}
+		splunk <- event
+	}))
+	defer collector.Close()
+	syslogConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syslogConn.Close()
+	syslog, err := newSyslogAuditSink("udp://" + syslogConn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.auditSinks = newAuditSinks(4, file, httpAuditSink{URL: collector.URL, Authorization: "Splunk hec-token", Format: auditFormatSplunk}, syslog)
+	f.do(t, http.MethodPost, "/api/patients/p-alice/delegations", f.alice, gin.H{"username": "bob", "scopes": []string{"view"}})
+	if len(f.auditSinks.queue) != 1 {
+		t.Fatalf("%d entries queued, want the delegation", len(f.auditSinks.queue))
+	}
+	f.auditSinks.write(context.Background(), <-f.auditSinks.queue)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logged AuditEntry
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	if !scanner.Scan() || json.Unmarshal(scanner.Bytes(), &logged) != nil || logged.Action != "delegation.granted" || logged.PatientID != "p-alice" {
+		t.Errorf("audit file = %q, want the delegation entry", data)
+	}
+
+	select {
+	case event := <-splunk:
+		var entry AuditEntry
+		if err := json.Unmarshal(event["event"], &entry); err != nil || entry.ID != logged.ID {
+			t.Errorf("collector event = %s, want entry %s", event["event"], logged.ID)
+		}
+	default:
+		t.Error("collector received nothing")
+	}
+
+	buf := make([]byte, 1024)
+	syslogConn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := syslogConn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message := string(buf[:n]); !strings.Contains(message, "action=delegation.granted actor=alice") || !strings.Contains(message, syslogTag) {
+		t.Errorf("syslog message %q", message)
+	}
+}
@@ -0,0 +1,39 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log/syslog"
+	"net/url"
+)
+
+const syslogTag = "clinic-audit"
+
+// syslogAuditSink sends each entry to syslog with the auth facility.
+type syslogAuditSink struct {
+	writer *syslog.Writer
+}
+
+// newSyslogAuditSink dials addr, which is "local" for the host's syslog or
+// a udp:// or tcp:// URL.
+func newSyslogAuditSink(addr string) (*syslogAuditSink, error) {
+	network, raddr := "", ""
+	if addr != "local" {
+		u, err := url.Parse(addr)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+			return nil, errors.New(`use "local", udp://host:port or tcp://host:port`)
+		}
+		network, raddr = u.Scheme, u.Host
+	}
+	writer, err := syslog.Dial(network, raddr, syslog.LOG_AUTH|syslog.LOG_NOTICE, syslogTag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogAuditSink{writer: writer}, nil
+}
+
+func (s *syslogAuditSink) Write(_ context.Context, entry AuditEntry) error {
+	return s.writer.Notice(syslogMessage(entry))
+}
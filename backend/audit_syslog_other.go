@@ -0,0 +1,9 @@
+//go:build windows || plan9
+
+package main
+
+import "errors"
+
+func newSyslogAuditSink(string) (AuditSink, error) {
+	return nil, errors.New("syslog isn't available on this platform")
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	RolePatient = "patient"
+	RoleDoctor  = "doctor"
+	RoleAdmin   = "admin"
+)
+
+// authUserKey is the gin context key holding the authenticated AuthUser.
+const authUserKey = "authUser"
+
+const defaultTokenTTL = 24 * time.Hour
+
+var (
+	jwtSecret []byte
+	tokenTTL  = defaultTokenTTL
+	// maxSessionsPerUser caps a user's concurrent sessions, signing out the
+	// oldest on a new sign-in; 0 means no limit.
+	maxSessionsPerUser = 0
+)
+
+var (
+	errInvalidToken = errors.New("invalid or expired token")
+	errSessionEnded = errors.New("session has ended")
+)
+
+// AuthUser is the identity carried by a validated token.
+type AuthUser struct {
+	Username  string
+	Role      string
+	ProfileID string
+	// SessionID is the session the token belongs to.
+	SessionID string
+}
+
+type authClaims struct {
+	Role      string `json:"role"`
+	ProfileID string `json:"pid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type loginRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+func isValidRole(role string) bool {
+	return role == RolePatient || role == RoleDoctor || role == RoleAdmin
+}
+
+func (s *Server) Login(c *gin.Context) {
+	ctx := c.Request.Context()
+	var creds loginRequest
+	if !bindJSON(c, &creds) {
+		return
+	}
+
+	user, err := s.store.Users.FindByUsername(ctx, creds.Username)
+	if err != nil || bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(creds.Password)) != nil {
+		abortWithError(c, http.StatusUnauthorized, "Invalid username or password")
+		return
+	}
+
+	s.respondWithToken(c, user)
+}
+
+// respondWithToken signs user in, answering with a new token.
+func (s *Server) respondWithToken(c *gin.Context, user User) {
+	// Accounts created before roles existed are patients
+	if user.Role == "" {
+		user.Role = RolePatient
+	}
+
+	token, expiresAt, err := s.startSession(c.Request.Context(), user, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error issuing token")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":     token,
+		"expiresAt": expiresAt,
+		"role":      user.Role,
+		"profileId": user.ProfileID,
+	})
+}
+
+// startSession records a new session for user, signing out their oldest
+// ones beyond maxSessionsPerUser, and returns a token for it that expires
+// after tokenTTL.
+func (s *Server) startSession(ctx context.Context, user User, clientIP, userAgent string) (string, time.Time, error) {
+	now := time.Now().UTC()
+	session := Session{
+		ID:        primitive.NewObjectID().Hex(),
+		Username:  user.Username,
+		CreatedAt: now,
+		ExpiresAt: now.Add(tokenTTL),
+		ClientIP:  clientIP,
+		UserAgent: userAgent,
+	}
+	if maxSessionsPerUser > 0 {
+		active, err := s.store.Sessions.Active(ctx, user.Username, now)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		for i := 0; i <= len(active)-maxSessionsPerUser; i++ {
+			if err := s.store.Sessions.Revoke(ctx, active[i].ID, now); err != nil {
+				return "", time.Time{}, err
+			}
+		}
+	}
+	if err := s.store.Sessions.Create(ctx, session); err != nil {
+		return "", time.Time{}, err
+	}
+
+	claims := authClaims{
+		Role:      user.Role,
+		ProfileID: user.ProfileID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        session.ID,
+			Subject:   user.Username,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(session.ExpiresAt),
+		},
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+	return signed, session.ExpiresAt, err
+}
+
+func parseToken(tokenString string) (AuthUser, error) {
+	var claims authClaims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(*jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return AuthUser{}, err
+	}
+	if claims.Subject == "" || claims.ID == "" || !isValidRole(claims.Role) {
+		return AuthUser{}, errors.New("token is missing subject, session or role")
+	}
+
+	return AuthUser{Username: claims.Subject, Role: claims.Role, ProfileID: claims.ProfileID, SessionID: claims.ID}, nil
+}
+
+// authenticate parses a bearer token and checks its session is still
+// active. It returns errInvalidToken for a bad token and errSessionEnded
+// when the session was revoked.
+func (s *Server) authenticate(ctx context.Context, tokenString string) (AuthUser, error) {
+	user, err := parseToken(tokenString)
+	if err != nil {
+		return AuthUser{}, errInvalidToken
+	}
+	session, err := s.store.Sessions.Get(ctx, user.SessionID)
+	if errors.Is(err, store.ErrNotFound) || err == nil && (!session.Active(time.Now()) || session.Username != user.Username) {
+		return AuthUser{}, errSessionEnded
+	}
+	return user, err
+}
+
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// AuthRequired rejects requests without a valid bearer token of an active
+// session and stores the authenticated user in the context for later
+// middleware and handlers.
+func (s *Server) AuthRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := bearerToken(c)
+		if token == "" {
+			abortWithError(c, http.StatusUnauthorized, "Missing bearer token")
+			return
+		}
+
+		user, err := s.authenticate(c.Request.Context(), token)
+		if abortOnSessionError(c, err) {
+			return
+		}
+
+		c.Set(authUserKey, user)
+		c.Next()
+	}
+}
+
+// abortOnSessionError reports whether err from authenticate ended the
+// request.
+func abortOnSessionError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, errSessionEnded):
+		abortWithCode(c, http.StatusUnauthorized, "session_ended", "The session was signed out")
+	case errors.Is(err, errInvalidToken):
+		abortWithError(c, http.StatusUnauthorized, "Invalid or expired token")
+	default:
+		abortWithError(c, http.StatusInternalServerError, "Error checking session")
+	}
+	return true
+}
+
+// OptionalAuth stores the authenticated user when a valid token is sent but
+// lets anonymous requests through.
+func (s *Server) OptionalAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := bearerToken(c); token != "" {
+			if user, err := s.authenticate(c.Request.Context(), token); err == nil {
+				c.Set(authUserKey, user)
+			}
+		}
+		c.Next()
+	}
+}
+
+// RequireRole only lets users with one of roles through. It must run after
+// AuthRequired.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := currentUser(c)
+		if !ok || !hasRole(user, roles) {
+			abortWithError(c, http.StatusForbidden, "Insufficient permissions")
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSelf lets users with ownerRole through only when the :id path
+// parameter is their own profile, and users with any of otherRoles through
+// unconditionally. It must run after AuthRequired.
+func RequireSelf(ownerRole string, otherRoles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := currentUser(c)
+		if ok && hasRole(user, otherRoles) {
+			c.Next()
+			return
+		}
+		if !ok || user.Role != ownerRole || user.ProfileID == "" || user.ProfileID != c.Param("id") {
+			abortWithError(c, http.StatusForbidden, "Insufficient permissions")
+			return
+		}
+		c.Next()
+	}
+}
+
+// currentUser returns the user authenticated by AuthRequired or OptionalAuth.
+func currentUser(c *gin.Context) (AuthUser, bool) {
+	value, ok := c.Get(authUserKey)
+	if !ok {
+		return AuthUser{}, false
+	}
+	user, ok := value.(AuthUser)
+	return user, ok
+}
+
+func hasRole(user AuthUser, roles []string) bool {
+	for _, role := range roles {
+		if user.Role == role {
+			return true
+		}
+	}
+	return false
+}
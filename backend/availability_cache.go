@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"containerized-go-app/store"
+)
+
+const (
+	precomputeDays     = 14
+	precomputeInterval = time.Hour
+	dateLayout         = "2006-01-02"
+)
+
+// Cached days are one doctor's free slots for one UTC day. Bookings always
+// re-check the slot claims, so a briefly stale entry only affects what the
+// calendar shows, never whether a slot can be double-booked.
+
+// errNotPrecomputed is returned for days outside the precomputed window.
+var errNotPrecomputed = errors.New("day is not precomputed")
+
+// runAvailabilityPrecompute refreshes every doctor's next precomputeDays of
+// availability on start and then every precomputeInterval.
+func (s *Server) runAvailabilityPrecompute(ctx context.Context) {
+	ticker := time.NewTicker(precomputeInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.precomputeAllAvailability(ctx); err != nil {
+			log.Println("Availability precompute failed: ", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) precomputeAllAvailability(ctx context.Context) error {
+	doctors, err := s.store.Doctors.All(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, doctor := range doctors {
+		if err := s.precomputeDoctorAvailability(ctx, doctor); err != nil {
+			log.Printf("Availability precompute for doctor %s failed: %v", doctor.ID, err)
+		}
+	}
+	return nil
+}
+
+// precomputeDoctorAvailability materializes a doctor's free slots for today
+// and the following days, and drops entries for days that have passed.
+func (s *Server) precomputeDoctorAvailability(ctx context.Context, doctor Doctor) error {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	for i := 0; i < precomputeDays; i++ {
+		if err := s.storeDayAvailability(ctx, doctor, today.AddDate(0, 0, i)); err != nil {
+			return err
+		}
+	}
+	return s.store.Availability.DeleteBefore(ctx, doctor.ID, today.Format(dateLayout))
+}
+
+// refreshDoctorAvailability recomputes a doctor's window after their
+// schedule changed.
+func (s *Server) refreshDoctorAvailability(doctorID string) {
+	ctx := context.Background()
+	doctor, err := s.findDoctor(ctx, doctorID)
+	if err == nil {
+		err = s.precomputeDoctorAvailability(ctx, doctor)
+	}
+	if err != nil {
+		log.Printf("Availability refresh for doctor %s failed: %v", doctorID, err)
+	}
+}
+
+// refreshDayAvailability recomputes the cached day containing t after a
+// slot was claimed or released. Days outside the precomputed window are
+// left alone.
+func (s *Server) refreshDayAvailability(doctorID string, t time.Time) {
+	day := t.UTC().Truncate(24 * time.Hour)
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	if day.Before(today) || !day.Before(today.AddDate(0, 0, precomputeDays)) {
+		return
+	}
+
+	ctx := context.Background()
+	doctor, err := s.findDoctor(ctx, doctorID)
+	if err == nil {
+		err = s.storeDayAvailability(ctx, doctor, day)
+	}
+	if err != nil {
+		log.Printf("Availability refresh for doctor %s failed: %v", doctorID, err)
+	}
+}
+
+// storeDayAvailability computes and stores one day. Writes are ordered by
+// computation time, so a slower, older refresh can't overwrite a newer one.
+func (s *Server) storeDayAvailability(ctx context.Context, doctor Doctor, day time.Time) error {
+	computedAt := time.Now()
+	slots, err := s.freeSlots(ctx, doctor, day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+
+	date := day.Format(dateLayout)
+	return s.store.Availability.Put(ctx, store.CachedAvailability{
+		ID:         doctor.ID + "|" + date,
+		DoctorID:   doctor.ID,
+		Date:       date,
+		Slots:      slots,
+		ComputedAt: computedAt,
+	})
+}
+
+// cachedDayAvailability returns the precomputed slots for a day, or false
+// when the day hasn't been precomputed.
+func (s *Server) cachedDayAvailability(ctx context.Context, doctorID string, day time.Time) ([]Slot, bool, error) {
+	entry, err := s.store.Availability.Get(ctx, doctorID, day.Format(dateLayout))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if entry.Slots == nil {
+		entry.Slots = []Slot{}
+	}
+	return entry.Slots, true, nil
+}
+
+// availableDoctorIDs returns the doctors with at least one free slot on a
+// precomputed day.
+func (s *Server) availableDoctorIDs(ctx context.Context, day time.Time) ([]string, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	if day.Before(today) || !day.Before(today.AddDate(0, 0, precomputeDays)) {
+		return nil, errNotPrecomputed
+	}
+	return s.store.Availability.DoctorsWithSlots(ctx, day.Format(dateLayout))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clinicBranding is how the frontend presents the clinic.
+type clinicBranding struct {
+	Name         string `json:"name"`
+	LogoURL      string `json:"logoUrl,omitempty"`
+	PrimaryColor string `json:"primaryColor,omitempty"`
+}
+
+var (
+	// branding comes from CLINIC_NAME, CLINIC_LOGO_URL and
+	// CLINIC_PRIMARY_COLOR
+	branding = clinicBranding{Name: "Clinic"}
+	// clinicHours are the opening hours in the clinic's time zone, from
+	// CLINIC_HOURS
+	clinicHours = []WeeklyRule{}
+)
+
+var hexColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// parseClinicHours reads CLINIC_HOURS, a JSON list of weekly windows like
+// [{"weekday":"monday","start":"08:00","end":"18:00"}].
+func parseClinicHours(value string) ([]WeeklyRule, error) {
+	var hours []WeeklyRule
+	if err := json.Unmarshal([]byte(value), &hours); err != nil {
+		return nil, errors.New(`must be a JSON list like [{"weekday":"monday","start":"08:00","end":"18:00"}]`)
+	}
+	for _, rule := range hours {
+		if _, ok := weekdays[strings.ToLower(rule.Weekday)]; !ok {
+			return nil, fmt.Errorf("unknown weekday %q", rule.Weekday)
+		}
+		start, err := clockMinutes(rule.Start)
+		if err != nil {
+			return nil, err
+		}
+		end, err := clockMinutes(rule.End)
+		if err != nil {
+			return nil, err
+		}
+		if end <= start {
+			return nil, fmt.Errorf("%s %s-%s ends before it starts", rule.Weekday, rule.Start, rule.End)
+		}
+	}
+	return hours, nil
+}
+
+// featureFlags are the features the frontend should offer user, which
+// depend on their role and on what this deployment has set up.
+func (s *Server) featureFlags(user AuthUser) map[string]bool {
+	staff := user.Role == RoleDoctor || user.Role == RoleAdmin
+	return map[string]bool{
+		"onlineBooking":   true,
+		"triage":          true,
+		"notifications":   s.notifier.Enabled(),
+		"secondaryDate":   secondaryCalendar != "",
+		"patientDelegate": user.Role == RolePatient,
+		"accountClosure":  user.Role == RolePatient,
+		"typeahead":       staff,
+		"timeClock":       staff,
+		"segments":        staff && s.db != nil,
+		"reports":         user.Role == RoleAdmin && s.db != nil,
+		"trafficCapture":  user.Role == RoleAdmin && captureKey != nil,
+	}
+}
+
+// GetBootstrap returns what the frontend's first screen needs in one
+// response: who is signed in, their profile, the features they have, the
+// clinic's branding and hours, and their next appointment.
+func (s *Server) GetBootstrap(c *gin.Context) {
+	ctx := c.Request.Context()
+	user, _ := currentUser(c)
+
+	body := gin.H{
+		"user":     gin.H{"username": user.Username, "role": user.Role, "profileId": user.ProfileID},
+		"features": s.featureFlags(user),
+		"branding": branding,
+		"clinic":   gin.H{"timeZone": calendarLocation.String(), "hours": clinicHours},
+	}
+
+	filter := store.AppointmentFilter{Statuses: []string{AppointmentScheduled}, From: time.Now().UTC()}
+	switch {
+	case user.Role == RolePatient && user.ProfileID != "":
+		patient, err := s.findPatient(ctx, user.ProfileID)
+		if err != nil {
+			writeAppointmentError(c, err, "Error retrieving profile")
+			return
+		}
+		// Tags and notes are for staff, so the patient gets what the
+		// profile endpoint shows them
+		body["profile"] = gin.H{"id": patient.ID, "pname": patient.PName, "profile": patient.PatientProfile, "status": statusOf(patient.PatientProfile)}
+		filter.PatientID = patient.ID
+	case user.Role == RoleDoctor && user.ProfileID != "":
+		doctor, err := s.findDoctor(ctx, user.ProfileID)
+		if err != nil {
+			writeAppointmentError(c, err, "Error retrieving profile")
+			return
+		}
+		body["profile"] = doctor
+		filter.DoctorID = doctor.ID
+	}
+
+	if filter.PatientID != "" || filter.DoctorID != "" {
+		next, _, err := s.store.Appointments.List(ctx, filter, store.Page{Sort: "startTime", Limit: 1})
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "Error retrieving appointments")
+			return
+		}
+		if len(next) > 0 {
+			next[0].SecondaryDate = secondaryDate(next[0].StartTime)
+			body["nextAppointment"] = next[0]
+		}
+	}
+	c.JSON(http.StatusOK, body)
+}
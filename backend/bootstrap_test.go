@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+type bootstrapResponse struct {
+	User struct {
+		Username string `json:"username"`
+		Role     string `json:"role"`
+	} `json:"user"`
+	Features        map[string]bool        `json:"features"`
+	Branding        clinicBranding         `json:"branding"`
+	Profile         map[string]interface{} `json:"profile"`
+	NextAppointment *Appointment           `json:"nextAppointment"`
+}
+
+func TestBootstrap(t *testing.T) {
+	f := newBookingFixture(t)
+	later := decode[bookingResponse](t, f.book(t, f.alice, f.second), http.StatusOK).Appointment
+	next := decode[bookingResponse](t, f.book(t, f.alice, f.first), http.StatusOK).Appointment
+
+	got := decode[bootstrapResponse](t, f.do(t, http.MethodGet, "/api/bootstrap", f.alice, nil), http.StatusOK)
+	if got.User.Username != "alice" || got.User.Role != RolePatient || got.Branding.Name == "" {
+		t.Errorf("bootstrap = %+v, want alice's with the clinic's branding", got)
+	}
+	if got.NextAppointment == nil || got.NextAppointment.ID != next.ID {
+		t.Errorf("next appointment = %+v, want %s rather than %s", got.NextAppointment, next.ID, later.ID)
+	}
+	if got.Profile["id"] != f.alice.ProfileID || got.Profile["tags"] != nil || got.Profile["notes"] != nil {
+		t.Errorf("profile = %v, want alice's without staff fields", got.Profile)
+	}
+	if !got.Features["onlineBooking"] || got.Features["typeahead"] || got.Features["trafficCapture"] {
+		t.Errorf("patient features = %v", got.Features)
+	}
+
+	doctor := User{Username: "grey", Role: RoleDoctor, ProfileID: f.doctor.ID}
+	got = decode[bootstrapResponse](t, f.do(t, http.MethodGet, "/api/bootstrap", doctor, nil), http.StatusOK)
+	if got.Profile["id"] != f.doctor.ID || got.NextAppointment == nil || got.NextAppointment.ID != next.ID {
+		t.Errorf("doctor bootstrap = %+v, want Dr. Grey's profile and next appointment", got)
+	}
+	if !got.Features["typeahead"] || got.Features["accountClosure"] {
+		t.Errorf("doctor features = %v", got.Features)
+	}
+
+	if rec := f.do(t, http.MethodGet, "/api/bootstrap", User{}, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous bootstrap status = %d, want 401", rec.Code)
+	}
+}
+
+func TestParseClinicHours(t *testing.T) {
+	hours, err := parseClinicHours(`[{"weekday":"monday","start":"08:00","end":"18:00"},{"weekday":"Saturday","start":"09:00","end":"13:00"}]`)
+	if err != nil || len(hours) != 2 {
+		t.Fatalf("parseClinicHours = %v, %v", hours, err)
+	}
+	for _, value := range []string{
+		`monday 08:00-18:00`,
+		`[{"weekday":"funday","start":"08:00","end":"18:00"}]`,
+		`[{"weekday":"monday","start":"18:00","end":"08:00"}]`,
+		`[{"weekday":"monday","start":"8am","end":"18:00"}]`,
+	} {
+		if _, err := parseClinicHours(value); err == nil {
+			t.Errorf("parseClinicHours(%s) succeeded, want an error", value)
+		}
+	}
+}
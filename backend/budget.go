@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultRequestBudget = 2 * time.Second
+	// Reports aggregate over long ranges and get more time.
+	reportBudget = 30 * time.Second
+	// cacheBudget caps the availability cache lookup, leaving the rest of
+	// the request's budget for computing the answer when the cache is slow.
+	cacheBudget = 250 * time.Millisecond
+)
+
+type budgetKey struct{}
+
+// requestBudget records what a request spent its deadline on so a timeout
+// can say where the time went.
+type requestBudget struct {
+	start time.Time
+	total time.Duration
+
+	mu    sync.Mutex
+	steps []budgetStep
+}
+
+type budgetStep struct {
+	Name       string  `json:"name" xml:"name,attr"`
+	DurationMS float64 `json:"durationMs" xml:"durationMs,attr"`
+	Failed     bool    `json:"failed,omitempty" xml:"failed,attr,omitempty"`
+}
+
+// budgetReport is the diagnostics part of a 504 response.
+type budgetReport struct {
+	BudgetMS  float64      `json:"budgetMs" xml:"budgetMs"`
+	ElapsedMS float64      `json:"elapsedMs" xml:"elapsedMs"`
+	Steps     []budgetStep `json:"steps" xml:"steps>step"`
+}
+
+// requestBudgetFromEnv reads REQUEST_BUDGET, e.g. "2s".
+func requestBudgetFromEnv() time.Duration {
+	value := os.Getenv("REQUEST_BUDGET")
+	if value == "" {
+		return defaultRequestBudget
+	}
+	budget, err := time.ParseDuration(value)
+	if err != nil || budget <= 0 {
+		log.Printf("Ignoring invalid REQUEST_BUDGET=%q", value)
+		return defaultRequestBudget
+	}
+	return budget
+}
+
+// DeadlineBudget gives every request a deadline of total, or of the
+// override for the longest matching route prefix. Mongo calls made with the
+// request context inherit it, and ErrorEnvelope turns a request that ran
+// out of time into a 504.
+func DeadlineBudget(total time.Duration, overrides map[string]time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, matched := total, ""
+		for prefix, override := range overrides {
+			if strings.HasPrefix(c.FullPath(), prefix) && len(prefix) > len(matched) {
+				limit, matched = override, prefix
+			}
+		}
+
+		b := &requestBudget{start: time.Now(), total: limit}
+		ctx, cancel := context.WithTimeout(context.WithValue(c.Request.Context(), budgetKey{}, b), limit)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+func budgetFrom(ctx context.Context) *requestBudget {
+	b, _ := ctx.Value(budgetKey{}).(*requestBudget)
+	return b
+}
+
+// recordStep notes a finished step against the request's budget, if any.
+func recordStep(ctx context.Context, name string, duration time.Duration, failed bool) {
+	b := budgetFrom(ctx)
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.steps = append(b.steps, budgetStep{Name: name, DurationMS: durationMS(duration), Failed: failed})
+	b.mu.Unlock()
+}
+
+func (b *requestBudget) report() *budgetReport {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return &budgetReport{
+		BudgetMS:  durationMS(b.total),
+		ElapsedMS: durationMS(time.Since(b.start)),
+		Steps:     append([]budgetStep{}, b.steps...),
+	}
+}
+
+// withSubBudget derives a context for one step that gets at most limit of
+// what is left of the request's deadline.
+func withSubBudget(ctx context.Context, limit time.Duration) (context.Context, context.CancelFunc) {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < limit {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, limit)
+}
+
+// deadlineExceededError is the 504 for a request that ran out of budget.
+func deadlineExceededError(ctx context.Context) *apiError {
+	apiErr := &apiError{
+		Status:  http.StatusGatewayTimeout,
+		Code:    "deadline_exceeded",
+		Message: "The request took too long",
+	}
+	if b := budgetFrom(ctx); b != nil {
+		apiErr.Diagnostics = b.report()
+	}
+	return apiErr
+}
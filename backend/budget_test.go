@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDeadlineBudget(t *testing.T) {
+	routes := gin.New()
+	routes.Use(DeadlineBudget(50*time.Millisecond, map[string]time.Duration{
+		"/api/admin":         time.Minute,
+		"/api/admin/reports": time.Hour,
+	}))
+	routes.Use(ErrorEnvelope())
+	remaining := func(c *gin.Context) {
+		deadline, ok := c.Request.Context().Deadline()
+		if !ok {
+			c.JSON(http.StatusOK, time.Duration(0))
+			return
+		}
+		c.JSON(http.StatusOK, time.Until(deadline))
+	}
+	routes.GET("/api/doctors", remaining)
+	routes.GET("/api/admin/diagnostics", remaining)
+	routes.GET("/api/admin/reports/revenue", remaining)
+	routes.GET("/api/slow", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		recordStep(ctx, "find appointments", 5*time.Millisecond, false)
+		<-ctx.Done()
+		recordStep(ctx, "find slots", 45*time.Millisecond, true)
+		c.Error(ctx.Err())
+	})
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	// The longest matching prefix decides the deadline
+	for _, tc := range []struct {
+		path     string
+		min, max time.Duration
+	}{
+		{"/api/doctors", 0, 50 * time.Millisecond},
+		{"/api/admin/diagnostics", 50 * time.Millisecond, time.Minute},
+		{"/api/admin/reports/revenue", time.Minute, time.Hour},
+	} {
+		if left := decode[time.Duration](t, get(tc.path), http.StatusOK); left <= tc.min || left > tc.max {
+			t.Errorf("GET %s: %v left, want within (%v, %v]", tc.path, left, tc.min, tc.max)
+		}
+	}
+
+	// Running out of time is a 504 that says where the time went
+	got := decode[apiError](t, get("/api/slow"), http.StatusGatewayTimeout)
+	if got.Code != "deadline_exceeded" {
+		t.Errorf("code = %q, want deadline_exceeded", got.Code)
+	}
+	if got.Diagnostics == nil {
+		t.Fatal("504 has no diagnostics")
+	}
+	if got.Diagnostics.BudgetMS != 50 || got.Diagnostics.ElapsedMS < 50 {
+		t.Errorf("diagnostics = %+v, want a 50ms budget spent in full", got.Diagnostics)
+	}
+	if steps := got.Diagnostics.Steps; len(steps) != 2 || steps[0].Name != "find appointments" || steps[0].Failed || !steps[1].Failed {
+		t.Errorf("steps = %+v, want the finished lookup then the failed one", steps)
+	}
+}
+
+func TestSubBudgetNeverExtendsTheDeadline(t *testing.T) {
+	parent, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	parentDeadline, _ := parent.Deadline()
+
+	ctx, cancelSub := withSubBudget(parent, time.Hour)
+	defer cancelSub()
+	if deadline, ok := ctx.Deadline(); !ok || !deadline.Equal(parentDeadline) {
+		t.Errorf("sub-budget deadline = %v, want the parent's %v", deadline, parentDeadline)
+	}
+
+	long, cancelLong := context.WithTimeout(context.Background(), time.Hour)
+	defer cancelLong()
+	ctx, cancelSub = withSubBudget(long, 50*time.Millisecond)
+	defer cancelSub()
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > 50*time.Millisecond {
+		t.Errorf("sub-budget deadline = %v, want at most 50ms away", deadline)
+	}
+
+	ctx, cancelSub = withSubBudget(context.Background(), 50*time.Millisecond)
+	defer cancelSub()
+	if _, ok := ctx.Deadline(); !ok {
+		t.Error("sub-budget without a request deadline has no deadline")
+	}
+}
+
+func TestDeadlineExceededWithoutBudget(t *testing.T) {
+	apiErr := deadlineExceededError(context.Background())
+	if apiErr.Status != http.StatusGatewayTimeout || apiErr.Diagnostics != nil {
+		t.Errorf("error = %+v, want a 504 without diagnostics", apiErr)
+	}
+}
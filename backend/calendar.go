@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"containerized-go-app/store"
+)
+
+type CalendarDate = store.CalendarDate
+
+// Secondary calendars shown next to the Gregorian dates.
+const calendarHijri = "hijri"
+
+var (
+	// secondaryCalendar, when set, adds the day in that calendar to slot
+	// and appointment responses.
+	secondaryCalendar string
+	// calendarLocation is where the clinic is, which decides the local day
+	// an appointment falls on.
+	calendarLocation = time.UTC
+)
+
+var hijriMonths = [12]string{
+	"Muharram", "Safar", "Rabi al-Awwal", "Rabi al-Thani", "Jumada al-Ula", "Jumada al-Akhirah",
+	"Rajab", "Shaban", "Ramadan", "Shawwal", "Dhu al-Qadah", "Dhu al-Hijjah",
+}
+
+// toHijri converts the day t falls on to the tabular Islamic calendar. It
+// is arithmetical, so it can be a day off the calendar based on moon
+// sighting in some months.
+func toHijri(t time.Time) (year, month, day int) {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	julianDay := int(midnight.Unix()/86400) + 2440588
+
+	l := julianDay - 1948440 + 10632
+	n := (l - 1) / 10631
+	l = l - 10631*n + 354
+	j := ((10985-l)/5316)*((50*l)/17719) + (l/5670)*((43*l)/15238)
+	l = l - ((30-j)/15)*((17719*j)/50) - (j/16)*((15238*j)/43) + 29
+	month = (24 * l) / 709
+	day = l - (709*month)/24
+	year = 30*n + j - 30
+	return year, month, day
+}
+
+// secondaryDate returns the local day of t in the secondary calendar, or
+// nil when none is configured.
+func secondaryDate(t time.Time) *CalendarDate {
+	if secondaryCalendar != calendarHijri {
+		return nil
+	}
+	year, month, day := toHijri(t.In(calendarLocation))
+	return &CalendarDate{
+		Calendar: calendarHijri,
+		Date:     fmt.Sprintf("%04d-%02d-%02d", year, month, day),
+		Display:  fmt.Sprintf("%d %s %d AH", day, hijriMonths[month-1], year),
+	}
+}
+
+func addAppointmentDates(appointments []Appointment) {
+	for i := range appointments {
+		appointments[i].SecondaryDate = secondaryDate(appointments[i].StartTime)
+	}
+}
+
+func addSlotDates(slots []Slot) {
+	for i := range slots {
+		slots[i].SecondaryDate = secondaryDate(slots[i].StartTime)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestToHijri(t *testing.T) {
+	for _, tc := range []struct {
+		date             string
+		year, month, day int
+	}{
+		{"2023-07-19", 1445, 1, 1},
+		{"2024-03-11", 1445, 9, 1},
+		{"2025-03-01", 1446, 9, 1},
+	} {
+		date, _ := time.Parse(dateLayout, tc.date)
+		if year, month, day := toHijri(date); year != tc.year || month != tc.month || day != tc.day {
+			t.Errorf("toHijri(%s) = %d-%d-%d, want %d-%d-%d", tc.date, year, month, day, tc.year, tc.month, tc.day)
+		}
+	}
+}
+
+func TestSecondaryDateInResponses(t *testing.T) {
+	f := newBookingFixture(t)
+	if slots := f.freeSlots(t); slots[0].SecondaryDate != nil {
+		t.Errorf("secondaryDate = %+v without a secondary calendar configured", slots[0].SecondaryDate)
+	}
+
+	secondaryCalendar, calendarLocation = calendarHijri, time.FixedZone("AST", 3*3600)
+	t.Cleanup(func() { secondaryCalendar, calendarLocation = "", time.UTC })
+
+	year, month, day := toHijri(f.first.In(calendarLocation))
+	slots := f.freeSlots(t)
+	if got := slots[0].SecondaryDate; got == nil || got.Calendar != calendarHijri || got.Display == "" {
+		t.Fatalf("secondaryDate = %+v, want a Hijri date", got)
+	}
+	booked := decode[bookingResponse](t, f.book(t, f.alice, f.first), http.StatusOK).Appointment
+	want := fmt.Sprintf("%04d-%02d-%02d", year, month, day)
+	if booked.SecondaryDate == nil || booked.SecondaryDate.Date != want {
+		t.Errorf("appointment secondaryDate = %+v, want %s", booked.SecondaryDate, want)
+	}
+}
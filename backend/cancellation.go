@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type CancellationReason = store.CancellationReason
+
+var errUnknownReason = errors.New("unknown cancellation reason")
+
+// defaultCancellationReasons are always in the catalog. Admins can relabel
+// or retire them and add their own.
+var defaultCancellationReasons = []CancellationReason{
+	{Code: "patient_request", Label: "Patient request", InitiatedBy: store.InitiatedByPatient},
+	{Code: "illness", Label: "Illness", InitiatedBy: store.InitiatedByPatient},
+	{Code: "clinic_initiated", Label: "Clinic initiated", InitiatedBy: store.InitiatedByClinic},
+	{Code: "weather", Label: "Weather", InitiatedBy: store.InitiatedByExternal},
+}
+
+// cancellationReasons returns the catalog: the defaults overridden by and
+// merged with the stored reasons, by code.
+func (s *Server) cancellationReasons(ctx context.Context) (map[string]CancellationReason, error) {
+	stored, err := s.store.Reasons.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	catalog := make(map[string]CancellationReason, len(defaultCancellationReasons)+len(stored))
+	for _, reason := range defaultCancellationReasons {
+		catalog[reason.Code] = reason
+	}
+	for _, reason := range stored {
+		catalog[reason.Code] = reason
+	}
+	return catalog, nil
+}
+
+// checkCancellationReason returns errUnknownReason unless code is a reason
+// of the catalog that isn't retired.
+func (s *Server) checkCancellationReason(ctx context.Context, code string) error {
+	catalog, err := s.cancellationReasons(ctx)
+	if err != nil {
+		return err
+	}
+	if reason, ok := catalog[code]; !ok || reason.Retired {
+		return errUnknownReason
+	}
+	return nil
+}
+
+// GetCancellationReasons lists the catalog by code; retired reasons are
+// only included with ?all=true.
+func (s *Server) GetCancellationReasons(c *gin.Context) {
+	catalog, err := s.cancellationReasons(c.Request.Context())
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving cancellation reasons")
+		return
+	}
+	reasons := []CancellationReason{}
+	for _, reason := range catalog {
+		if !reason.Retired || c.Query("all") == "true" {
+			reasons = append(reasons, reason)
+		}
+	}
+	sort.Slice(reasons, func(i, j int) bool { return reasons[i].Code < reasons[j].Code })
+	c.JSON(http.StatusOK, reasons)
+}
+
+// PutCancellationReason adds a reason to the catalog or replaces one,
+// including the defaults.
+func (s *Server) PutCancellationReason(c *gin.Context) {
+	var reason CancellationReason
+	if !bindJSON(c, &reason) {
+		return
+	}
+	reason.Code = c.Param("code")
+	if err := s.store.Reasons.Put(c.Request.Context(), reason); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error saving cancellation reason")
+		return
+	}
+	c.JSON(http.StatusOK, reason)
+}
+
+type cancellationRow struct {
+	Reason        string `json:"reason" bson:"reason"`
+	Label         string `json:"label" bson:"-"`
+	InitiatedBy   string `json:"initiatedBy" bson:"-"`
+	Cancellations int    `json:"cancellations" bson:"cancellations"`
+}
+
+func (r cancellationRow) csvRecord() []string {
+	return []string{r.Reason, r.Label, r.InitiatedBy, strconv.Itoa(r.Cancellations)}
+}
+
+// GetCancellationsReport counts the cancelled appointments starting in the
+// range by reason. Cancellations from before reasons were recorded have an
+// empty reason. No-shows aren't cancellations and are in the attendance
+// report.
+func (s *Server) GetCancellationsReport(c *gin.Context) {
+	ctx := c.Request.Context()
+	r, ok := parseReportRange(c)
+	if !ok {
+		return
+	}
+	r.Interval = ""
+
+	pipeline := append(appointmentsInRange(r),
+		bson.D{{Key: "$match", Value: bson.M{"status": AppointmentCancelled}}},
+		bson.D{{Key: "$group", Value: bson.M{"_id": bson.M{"$ifNull": bson.A{"$cancellationReason", ""}}, "cancellations": bson.M{"$sum": 1}}}},
+		bson.D{{Key: "$project", Value: bson.M{"_id": 0, "reason": "$_id", "cancellations": 1}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "cancellations", Value: -1}, {Key: "reason", Value: 1}}}},
+	)
+	rows, err := aggregateReport[cancellationRow](ctx, s.db.Collection("appointments"), pipeline)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error building report")
+		return
+	}
+	catalog, err := s.cancellationReasons(ctx)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving cancellation reasons")
+		return
+	}
+	for i := range rows {
+		if reason, ok := catalog[rows[i].Reason]; ok {
+			rows[i].Label, rows[i].InitiatedBy = reason.Label, reason.InitiatedBy
+		} else {
+			rows[i].Label = "Not recorded"
+		}
+	}
+	writeReport(c, "cancellations", r, []string{"reason", "label", "initiatedBy", "cancellations"}, rows)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCancellationRequiresCatalogReason(t *testing.T) {
+	f := newBookingFixture(t)
+	admin := User{Username: "root", Role: RoleAdmin}
+	booked := decode[bookingResponse](t, f.book(t, f.alice, f.first), http.StatusOK).Appointment
+	path := "/api/patients/p-alice/appointments/" + booked.ID
+
+	decode[apiError](t, f.do(t, http.MethodDelete, path, f.alice, nil), http.StatusBadRequest)
+	decode[apiError](t, f.do(t, http.MethodDelete, path+"?reason=bored", f.alice, nil), http.StatusBadRequest)
+	decode[apiError](t, f.do(t, http.MethodPut, path, f.alice, gin.H{"status": AppointmentCancelled}), http.StatusBadRequest)
+
+	// Retired reasons can no longer be chosen
+	decode[apiError](t, f.do(t, http.MethodPut, "/api/admin/cancellation-reasons/weather", f.alice, gin.H{"label": "Weather", "initiatedBy": "external", "retired": true}), http.StatusForbidden)
+	decode[CancellationReason](t, f.do(t, http.MethodPut, "/api/admin/cancellation-reasons/weather", admin, gin.H{"label": "Weather", "initiatedBy": "external", "retired": true}), http.StatusOK)
+	decode[CancellationReason](t, f.do(t, http.MethodPut, "/api/admin/cancellation-reasons/transport", admin, gin.H{"label": "No transport", "initiatedBy": "patient"}), http.StatusOK)
+	decode[apiError](t, f.do(t, http.MethodDelete, path+"?reason=weather", f.alice, nil), http.StatusBadRequest)
+
+	reasons := decode[[]CancellationReason](t, f.do(t, http.MethodGet, "/api/cancellation-reasons", f.alice, nil), http.StatusOK)
+	if len(reasons) != 4 || reasons[3].Code != "transport" {
+		t.Errorf("reasons = %+v, want the three active defaults and transport", reasons)
+	}
+
+	decode[struct{}](t, f.do(t, http.MethodDelete, path+"?reason=transport", f.alice, nil), http.StatusOK)
+	list := decode[[]Appointment](t, f.do(t, http.MethodGet, "/api/patients/p-alice/appointments", f.alice, nil), http.StatusOK)
+	if len(list) != 1 || list[0].Status != AppointmentCancelled || list[0].CancellationReason != "transport" {
+		t.Errorf("appointments = %+v, want it cancelled for transport", list)
+	}
+}
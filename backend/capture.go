@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type (
+	Capture          = store.Capture
+	CapturedExchange = store.CapturedExchange
+)
+
+const (
+	defaultCaptureMinutes = 60
+	// captureRetention is how long recorded exchanges are kept to debug
+	captureRetention = 7 * 24 * time.Hour
+	// maxCapturedBody caps how much of each body is kept
+	maxCapturedBody = 64 << 10
+	// Active captures are reread this often, so starting or stopping one
+	// reaches every instance without a lookup per request
+	captureRefresh = 15 * time.Second
+	redactedValue  = "[redacted]"
+)
+
+// captureKey is the AES-256 key sealing recorded exchanges. Capture is off
+// while it is unset.
+var captureKey []byte
+
+// Only these headers are recorded; cookies and credentials never are.
+var (
+	capturedRequestHeaders  = []string{"Content-Type", "Accept", "Accept-Language", "User-Agent"}
+	capturedResponseHeaders = []string{"Content-Type", "Retry-After", totalCountHeader}
+)
+
+// patientRoutes are the routes about the patient in :id. Their exchanges
+// are recorded while that patient consents to diagnostics.
+const patientRoutes = "/api/patients/:id"
+
+// captureSafeRoutes carry no patient's records, so they are recorded
+// whoever calls them. Any other route staff, partners or anonymous callers
+// use may hold patients' data and is never recorded for them.
+var captureSafeRoutes = []string{
+	"/healthz",
+	"/api/login",
+	"/api/doctors",
+	"/api/doctors/:id",
+	"/api/doctors/:id/availability",
+	"/api/doctors/:id/slots",
+	"/api/public/next-available",
+}
+
+// sensitiveFields are redacted from JSON bodies and query strings wherever
+// a field name contains one of them.
+var sensitiveFields = []string{"password", "token", "secret"}
+
+type captureRequest struct {
+	Username string `json:"username" binding:"required_without=Route"`
+	Route    string `json:"route" binding:"omitempty,startswith=/"`
+	Reason   string `json:"reason" binding:"required,notblank,max=500"`
+	// Minutes is how long to record for, defaulting to an hour.
+	Minutes int `json:"minutes" binding:"omitempty,min=1,max=1440"`
+}
+
+// capturedTraffic is what a CapturedExchange seals.
+type capturedTraffic struct {
+	Method          string              `json:"method"`
+	URL             string              `json:"url"`
+	Username        string              `json:"username,omitempty"`
+	ClientIP        string              `json:"clientIp"`
+	RequestHeaders  map[string][]string `json:"requestHeaders"`
+	RequestBody     json.RawMessage     `json:"requestBody,omitempty"`
+	Status          int                 `json:"status"`
+	ResponseHeaders map[string][]string `json:"responseHeaders"`
+	ResponseBody    json.RawMessage     `json:"responseBody,omitempty"`
+	DurationMS      int64               `json:"durationMs"`
+}
+
+type capturedExchangeView struct {
+	CapturedExchange
+	Traffic *capturedTraffic `json:"traffic,omitempty"`
+	// Error says why Traffic couldn't be opened, such as a rotated key.
+	Error string `json:"error,omitempty"`
+}
+
+// captureCache holds the active captures between refreshes.
+type captureCache struct {
+	mu       sync.Mutex
+	loadedAt time.Time
+	active   []Capture
+}
+
+func (s *Server) activeCaptures(ctx context.Context, now time.Time) []Capture {
+	s.captures.mu.Lock()
+	defer s.captures.mu.Unlock()
+	if now.Sub(s.captures.loadedAt) >= captureRefresh {
+		active, err := s.store.Captures.Active(ctx, now)
+		if err != nil {
+			log.Println("Loading captures failed: ", err)
+		} else {
+			s.captures.active = active
+		}
+		// Failures back off too rather than hitting the store every request
+		s.captures.loadedAt = now
+	}
+	var active []Capture
+	for _, capture := range s.captures.active {
+		if capture.Active(now) {
+			active = append(active, capture)
+		}
+	}
+	return active
+}
+
+func (s *Server) reloadCaptures() {
+	s.captures.mu.Lock()
+	defer s.captures.mu.Unlock()
+	s.captures.loadedAt = time.Time{}
+}
+
+// captureWriter keeps the first maxCapturedBody bytes of the response.
+type captureWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	w.keep(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	w.keep([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *captureWriter) keep(b []byte) {
+	if room := maxCapturedBody - w.body.Len(); room > 0 {
+		w.body.Write(b[:min(len(b), room)])
+	}
+}
+
+// CaptureTraffic records the requests matching an active capture. Requests
+// about a patient are only recorded while that patient consents to
+// diagnostics; see captureConsented.
+func (s *Server) CaptureTraffic() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(captureKey) == 0 {
+			c.Next()
+			return
+		}
+		start := time.Now()
+		route := c.FullPath()
+		candidates := s.activeCaptures(c.Request.Context(), start)
+		candidates = filterCaptures(candidates, func(capture Capture) bool { return capture.Route == "" || capture.Route == route })
+		if len(candidates) == 0 {
+			c.Next()
+			return
+		}
+
+		var requestBody []byte
+		if c.Request.Body != nil {
+			requestBody, _ = io.ReadAll(io.LimitReader(c.Request.Body, maxCapturedBody))
+			c.Request.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(requestBody), c.Request.Body), c.Request.Body}
+		}
+		writer := &captureWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		user, _ := currentUser(c)
+		matched := filterCaptures(candidates, func(capture Capture) bool { return capture.Username == "" || capture.Username == user.Username })
+		if len(matched) == 0 {
+			return
+		}
+		ctx := context.WithoutCancel(c.Request.Context())
+		if !s.captureConsented(ctx, c, user) {
+			return
+		}
+
+		traffic := capturedTraffic{
+			Method:          c.Request.Method,
+			URL:             redactURL(c.Request.URL),
+			Username:        user.Username,
+			ClientIP:        c.ClientIP(),
+			RequestHeaders:  keepHeaders(c.Request.Header, capturedRequestHeaders),
+			RequestBody:     sanitizeBody(requestBody, c.ContentType()),
+			Status:          writer.Status(),
+			ResponseHeaders: keepHeaders(writer.Header(), capturedResponseHeaders),
+			ResponseBody:    sanitizeBody(writer.body.Bytes(), writer.Header().Get("Content-Type")),
+			DurationMS:      time.Since(start).Milliseconds(),
+		}
+		plain, err := json.Marshal(traffic)
+		if err == nil {
+			var sealed []byte
+			if sealed, err = sealCapture(plain); err == nil {
+				for _, capture := range matched {
+					err = errors.Join(err, s.store.Captures.Record(ctx, CapturedExchange{
+						ID:        primitive.NewObjectID().Hex(),
+						CaptureID: capture.ID,
+						At:        start.UTC(),
+						Method:    traffic.Method,
+						Route:     route,
+						Status:    traffic.Status,
+						Sealed:    sealed,
+						DeleteAt:  start.UTC().Add(captureRetention),
+					}))
+				}
+			}
+		}
+		if err != nil {
+			log.Printf("Recording %s %s failed: %v", c.Request.Method, route, err)
+		}
+	}
+}
+
+// captureConsented reports whether the request may be recorded: a request
+// about a patient, or made by one, needs that patient's consent to
+// diagnostics, and anyone else's only on captureSafeRoutes.
+func (s *Server) captureConsented(ctx context.Context, c *gin.Context, user AuthUser) bool {
+	patientID := ""
+	if route := c.FullPath(); isPatientRoute(route) {
+		patientID = c.Param("id")
+	} else if user.Role == RolePatient {
+		patientID = user.ProfileID
+	} else {
+		return slices.Contains(captureSafeRoutes, route)
+	}
+	patient, err := s.store.Patients.Get(ctx, patientID)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(patient.ActiveConsents(time.Now()), store.ConsentDiagnostics)
+}
+
+func isPatientRoute(route string) bool {
+	return route == patientRoutes || strings.HasPrefix(route, patientRoutes+"/")
+}
+
+func filterCaptures(captures []Capture, keep func(Capture) bool) []Capture {
+	var kept []Capture
+	for _, capture := range captures {
+		if keep(capture) {
+			kept = append(kept, capture)
+		}
+	}
+	return kept
+}
+
+func keepHeaders(header http.Header, names []string) map[string][]string {
+	kept := map[string][]string{}
+	for _, name := range names {
+		if values := header.Values(name); len(values) > 0 {
+			kept[name] = values
+		}
+	}
+	return kept
+}
+
+func isSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, field := range sensitiveFields {
+		if strings.Contains(name, field) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactURL(u *url.URL) string {
+	query := u.Query()
+	for name := range query {
+		if isSensitive(name) {
+			query.Set(name, redactedValue)
+		}
+	}
+	redacted := *u
+	redacted.RawQuery = query.Encode()
+	return redacted.RequestURI()
+}
+
+// sanitizeBody returns a JSON body with its sensitive fields redacted.
+// Anything else, including JSON cut short by maxCapturedBody, is left out
+// since it can't be redacted reliably.
+func sanitizeBody(body []byte, contentType string) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		placeholder, _ := json.Marshal(fmt.Sprintf("[%d bytes of %s omitted]", len(body), contentType))
+		return placeholder
+	}
+	redactValue(value)
+	sanitized, _ := json.Marshal(value)
+	return sanitized
+}
+
+func redactValue(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for name, child := range v {
+			if isSensitive(name) {
+				v[name] = redactedValue
+			} else {
+				redactValue(child)
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			redactValue(child)
+		}
+	}
+}
+
+func captureCipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(captureKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealCapture encrypts plain with captureKey, prefixing the nonce.
+func sealCapture(plain []byte) ([]byte, error) {
+	aead, err := captureCipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+func openCapture(sealed []byte) ([]byte, error) {
+	aead, err := captureCipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed exchange is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// StartCapture records a user's or a route's traffic for the requested
+// minutes. Capturing a patient needs their consent to diagnostics, and a
+// route must be about one patient or carry no patient data.
+func (s *Server) StartCapture(c *gin.Context) {
+	ctx := c.Request.Context()
+	if len(captureKey) == 0 {
+		abortWithError(c, http.StatusServiceUnavailable, "Capture is turned off; set CAPTURE_KEY to enable it")
+		return
+	}
+	var req captureRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if req.Minutes == 0 {
+		req.Minutes = defaultCaptureMinutes
+	}
+	if req.Route != "" && !isPatientRoute(req.Route) && !slices.Contains(captureSafeRoutes, req.Route) {
+		abortWithDetails(c, fieldError{Field: "route", Message: "may return other patients' data; capture a route under " + patientRoutes + " or a user instead"})
+		return
+	}
+
+	patientID := ""
+	if req.Username != "" {
+		captured, err := s.store.Users.FindByUsername(ctx, req.Username)
+		if errors.Is(err, store.ErrNotFound) {
+			abortWithDetails(c, fieldError{Field: "username", Message: "doesn't exist"})
+			return
+		} else if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "Error retrieving user")
+			return
+		}
+		if captured.Role == RolePatient {
+			patientID = captured.ProfileID
+			patient, err := s.store.Patients.Get(ctx, patientID)
+			if err != nil || !slices.Contains(patient.ActiveConsents(time.Now()), store.ConsentDiagnostics) {
+				abortWithCode(c, http.StatusConflict, "consent_required", "The patient hasn't consented to diagnostics")
+				return
+			}
+		}
+	}
+
+	user, _ := currentUser(c)
+	now := time.Now().UTC()
+	capture := Capture{
+		ID:        primitive.NewObjectID().Hex(),
+		Username:  req.Username,
+		Route:     req.Route,
+		Reason:    req.Reason,
+		CreatedBy: user.Username,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Duration(req.Minutes) * time.Minute),
+	}
+	if err := s.store.Captures.Create(ctx, capture); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error starting capture")
+		return
+	}
+	s.reloadCaptures()
+	s.audit(c, "capture.started", patientID, capture.ID)
+	c.JSON(http.StatusCreated, capture)
+}
+
+func (s *Server) GetCaptures(c *gin.Context) {
+	captures, err := s.store.Captures.List(c.Request.Context())
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving captures")
+		return
+	}
+	c.JSON(http.StatusOK, captures)
+}
+
+func (s *Server) StopCapture(c *gin.Context) {
+	err := s.store.Captures.Stop(c.Request.Context(), c.Param("captureID"), time.Now().UTC())
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, "Capture not found")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error stopping capture")
+		return
+	}
+	s.reloadCaptures()
+	c.JSON(http.StatusOK, gin.H{"message": "Capture stopped"})
+}
+
+// GetCapturedExchanges decrypts what a capture recorded. Viewing is
+// audited since the exchanges hold patient data.
+func (s *Server) GetCapturedExchanges(c *gin.Context) {
+	captureID := c.Param("captureID")
+	exchanges, err := s.store.Captures.Exchanges(c.Request.Context(), captureID)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving captured exchanges")
+		return
+	}
+	views := make([]capturedExchangeView, 0, len(exchanges))
+	for _, exchange := range exchanges {
+		view := capturedExchangeView{CapturedExchange: exchange}
+		var traffic capturedTraffic
+		if plain, err := openCapture(exchange.Sealed); err != nil {
+			view.Error = "can't be decrypted with the current CAPTURE_KEY"
+		} else if err := json.Unmarshal(plain, &traffic); err != nil {
+			view.Error = "is corrupt"
+		} else {
+			view.Traffic = &traffic
+		}
+		views = append(views, view)
+	}
+	s.audit(c, "capture.viewed", "", captureID)
+	c.JSON(http.StatusOK, views)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCaptureTraffic(t *testing.T) {
+	f := newBookingFixture(t)
+	ctx := context.Background()
+	key := captureKey
+	captureKey = bytes.Repeat([]byte{7}, 32)
+	t.Cleanup(func() { captureKey = key })
+	for _, user := range []User{f.alice, f.bob} {
+		if err := f.store.Users.Create(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	admin := User{Username: "root", Role: RoleAdmin}
+
+	// Patients are only captured with their consent
+	start := gin.H{"username": "alice", "reason": "booking fails on her phone", "minutes": 30}
+	decode[apiError](t, f.do(t, http.MethodPost, "/api/admin/captures", admin, start), http.StatusConflict)
+	f.do(t, http.MethodPut, "/api/patients/p-alice/consents/diagnostics", f.alice, gin.H{"granted": true})
+	capture := decode[Capture](t, f.do(t, http.MethodPost, "/api/admin/captures", admin, start), http.StatusCreated)
+	login := decode[Capture](t, f.do(t, http.MethodPost, "/api/admin/captures", admin, gin.H{"route": "/api/login", "reason": "sign-in errors"}), http.StatusCreated)
+
+	f.book(t, f.alice, f.first)
+	f.book(t, f.bob, f.second)
+	f.do(t, http.MethodPost, "/api/login", User{}, gin.H{"username": "alice", "password": "hunter2"})
+
+	exchanges := decode[[]capturedExchangeView](t, f.do(t, http.MethodGet, "/api/admin/captures/"+capture.ID+"/exchanges", admin, nil), http.StatusOK)
+	if len(exchanges) != 1 || exchanges[0].Traffic == nil {
+		t.Fatalf("exchanges = %+v, want alice's booking", exchanges)
+	}
+	booking := exchanges[0]
+	if booking.Route != "/api/patients/:id/appointments" || booking.Traffic.Username != "alice" || !strings.Contains(string(booking.Traffic.RequestBody), `"doctorId":"d1"`) {
+		t.Errorf("booking = %+v", booking)
+	}
+	stored, err := f.store.Captures.Exchanges(ctx, capture.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored[0].Sealed, []byte("doctorId")) {
+		t.Error("exchange is stored in the clear")
+	}
+
+	exchanges = decode[[]capturedExchangeView](t, f.do(t, http.MethodGet, "/api/admin/captures/"+login.ID+"/exchanges", admin, nil), http.StatusOK)
+	if len(exchanges) != 1 || exchanges[0].Traffic == nil {
+		t.Fatalf("login exchanges = %+v, want the sign-in", exchanges)
+	}
+	if body := string(exchanges[0].Traffic.RequestBody); strings.Contains(body, "hunter2") || !strings.Contains(body, redactedValue) {
+		t.Errorf("login request body %s, want the password redacted", body)
+	}
+
+	// Revoking consent stops recording at once, and so does stopping
+	f.do(t, http.MethodPut, "/api/patients/p-alice/consents/diagnostics", f.alice, gin.H{"granted": false})
+	f.do(t, http.MethodGet, "/api/patients/p-alice/appointments", f.alice, nil)
+	f.do(t, http.MethodDelete, "/api/admin/captures/"+login.ID, admin, nil)
+	f.do(t, http.MethodPost, "/api/login", User{}, gin.H{"username": "bob", "password": "x"})
+	for _, id := range []string{capture.ID, login.ID} {
+		if stored, _ := f.store.Captures.Exchanges(ctx, id); len(stored) != 1 {
+			t.Errorf("capture %s has %d exchanges after consent was revoked or it stopped, want 1", id, len(stored))
+		}
+	}
+
+	decode[apiError](t, f.do(t, http.MethodPost, "/api/admin/captures", admin, gin.H{"reason": "no target"}), http.StatusBadRequest)
+	decode[apiError](t, f.do(t, http.MethodPost, "/api/admin/captures", f.alice, start), http.StatusForbidden)
+}
+
+func TestCaptureLeavesOutOtherPatientsData(t *testing.T) {
+	f := newBookingFixture(t)
+	ctx := context.Background()
+	key := captureKey
+	captureKey = bytes.Repeat([]byte{7}, 32)
+	t.Cleanup(func() { captureKey = key })
+	grey := User{Username: "grey", Role: RoleDoctor, ProfileID: "d1"}
+	if err := f.store.Users.Create(ctx, grey); err != nil {
+		t.Fatal(err)
+	}
+	admin := User{Username: "root", Role: RoleAdmin}
+
+	for _, route := range []string{"/api/signup", "/api/patients", "/api/patients/typeahead", "/api/doctors/:id/appointments", "/api/partner/referrals"} {
+		decode[apiError](t, f.do(t, http.MethodPost, "/api/admin/captures", admin, gin.H{"route": route, "reason": "slow listing"}), http.StatusBadRequest)
+	}
+
+	// A doctor's capture only records what concerns consenting patients
+	f.do(t, http.MethodPut, "/api/patients/p-alice/consents/diagnostics", f.alice, gin.H{"granted": true})
+	f.book(t, f.alice, f.first)
+	f.book(t, f.bob, f.second)
+	capture := decode[Capture](t, f.do(t, http.MethodPost, "/api/admin/captures", admin, gin.H{"username": "grey", "reason": "schedule view errors"}), http.StatusCreated)
+	for _, path := range []string{
+		"/api/patients",
+		"/api/patients/typeahead?q=bob",
+		"/api/doctors/d1/appointments",
+		"/api/patients/p-bob/appointments",
+		"/api/patients/p-alice/appointments",
+	} {
+		f.do(t, http.MethodGet, path, grey, nil)
+	}
+
+	exchanges := decode[[]capturedExchangeView](t, f.do(t, http.MethodGet, "/api/admin/captures/"+capture.ID+"/exchanges", admin, nil), http.StatusOK)
+	if len(exchanges) != 1 || exchanges[0].Traffic == nil || !strings.HasPrefix(exchanges[0].Traffic.URL, "/api/patients/p-alice/") {
+		t.Fatalf("exchanges = %+v, want only alice's appointments", exchanges)
+	}
+}
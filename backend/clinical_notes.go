@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	ClinicalTemplate = store.ClinicalTemplate
+	VisitNote        = store.VisitNote
+)
+
+const maxNoteTextLength = 10000
+
+type visitNoteRequest struct {
+	TemplateID string                 `json:"templateId" binding:"required"`
+	Values     map[string]interface{} `json:"values"`
+	Text       string                 `json:"text" binding:"max=10000"`
+}
+
+// validateClinicalTemplate checks what the binding tags can't: field keys
+// are unique and only choice fields have options.
+func validateClinicalTemplate(t ClinicalTemplate) []fieldError {
+	var errs []fieldError
+	seen := map[string]bool{}
+	for i, field := range t.Fields {
+		path := fmt.Sprintf("fields[%d]", i)
+		if seen[field.Key] {
+			errs = append(errs, fieldError{Field: path + ".key", Message: "must be unique"})
+		}
+		seen[field.Key] = true
+		if field.Type == store.FieldChoice && len(field.Options) == 0 {
+			errs = append(errs, fieldError{Field: path + ".options", Message: "is required for choice fields"})
+		} else if field.Type != store.FieldChoice && len(field.Options) > 0 {
+			errs = append(errs, fieldError{Field: path + ".options", Message: "is only allowed on choice fields"})
+		}
+	}
+	return errs
+}
+
+// validateNoteValues checks values against the template's fields. Numbers
+// arrive from JSON as float64.
+func validateNoteValues(t ClinicalTemplate, values map[string]interface{}) []fieldError {
+	var errs []fieldError
+	for key := range values {
+		if !slices.ContainsFunc(t.Fields, func(f store.TemplateField) bool { return f.Key == key }) {
+			errs = append(errs, fieldError{Field: "values." + key, Message: "is not a field of the template"})
+		}
+	}
+	for _, field := range t.Fields {
+		path := "values." + field.Key
+		value, ok := values[field.Key]
+		if !ok || value == nil {
+			if field.Required {
+				errs = append(errs, fieldError{Field: path, Message: "is required"})
+			}
+			continue
+		}
+
+		switch field.Type {
+		case store.FieldNumber:
+			if _, ok := value.(float64); !ok {
+				errs = append(errs, fieldError{Field: path, Message: "must be a number"})
+			}
+		case store.FieldBoolean:
+			if _, ok := value.(bool); !ok {
+				errs = append(errs, fieldError{Field: path, Message: "must be true or false"})
+			}
+		case store.FieldChoice:
+			if text, ok := value.(string); !ok || !slices.Contains(field.Options, text) {
+				errs = append(errs, fieldError{Field: path, Message: "must be one of the field's options"})
+			}
+		default:
+			if text, ok := value.(string); !ok || len(text) > maxNoteTextLength {
+				errs = append(errs, fieldError{Field: path, Message: fmt.Sprintf("must be text of at most %d characters", maxNoteTextLength)})
+			}
+		}
+	}
+	return errs
+}
+
+// GetClinicalTemplates lists the note templates, optionally of one
+// ?specialization=.
+func (s *Server) GetClinicalTemplates(c *gin.Context) {
+	templates, err := s.store.Templates.List(c.Request.Context(), c.Query("specialization"))
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving clinical templates")
+		return
+	}
+	c.JSON(http.StatusOK, templates)
+}
+
+// PutClinicalTemplate creates or replaces a note template, bumping its
+// version.
+func (s *Server) PutClinicalTemplate(c *gin.Context) {
+	ctx := c.Request.Context()
+	var template ClinicalTemplate
+	if !bindJSON(c, &template) {
+		return
+	}
+	if errs := validateClinicalTemplate(template); len(errs) > 0 {
+		abortWithDetails(c, errs...)
+		return
+	}
+
+	template.ID = c.Param("id")
+	template.Version = 1
+	existing, err := s.store.Templates.Get(ctx, template.ID)
+	if err == nil {
+		template.Version = existing.Version + 1
+	} else if !errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving clinical template")
+		return
+	}
+
+	if err := s.store.Templates.Put(ctx, template); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error saving clinical template")
+		return
+	}
+	c.JSON(http.StatusOK, template)
+}
+
+// PutVisitNote documents an appointment with one of the clinical templates.
+// Only the appointment's doctor may write it.
+func (s *Server) PutVisitNote(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req visitNoteRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	appointment, err := s.findAppointment(ctx, c.Param("id"), c.Param("appointmentID"))
+	if err != nil {
+		writeAppointmentError(c, err, "Error retrieving appointment")
+		return
+	}
+	if user, _ := currentUser(c); user.ProfileID != appointment.DoctorID {
+		abortWithError(c, http.StatusForbidden, "Only the appointment's doctor can document the visit")
+		return
+	}
+
+	template, err := s.store.Templates.Get(ctx, req.TemplateID)
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithDetails(c, fieldError{Field: "templateId", Message: "must be an existing clinical template"})
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving clinical template")
+		return
+	}
+	if errs := validateNoteValues(template, req.Values); len(errs) > 0 {
+		abortWithDetails(c, errs...)
+		return
+	}
+
+	note := VisitNote{
+		AppointmentID:   appointment.ID,
+		PatientID:       appointment.PatientID,
+		DoctorID:        appointment.DoctorID,
+		TemplateID:      template.ID,
+		TemplateVersion: template.Version,
+		Specialization:  template.Specialization,
+		Values:          req.Values,
+		Text:            req.Text,
+		UpdatedAt:       time.Now().UTC(),
+	}
+	if note.Values == nil {
+		note.Values = map[string]interface{}{}
+	}
+	if err := s.store.VisitNotes.Put(ctx, note); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error saving visit note")
+		return
+	}
+	c.JSON(http.StatusOK, note)
+}
+
+func (s *Server) GetVisitNote(c *gin.Context) {
+	ctx := c.Request.Context()
+	appointment, err := s.findAppointment(ctx, c.Param("id"), c.Param("appointmentID"))
+	if err != nil {
+		writeAppointmentError(c, err, "Error retrieving appointment")
+		return
+	}
+
+	note, err := s.store.VisitNotes.Get(ctx, appointment.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, "The visit has not been documented")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving visit note")
+		return
+	}
+	c.JSON(http.StatusOK, note)
+}
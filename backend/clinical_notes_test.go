@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestVisitNoteFromTemplate(t *testing.T) {
+	f := newBookingFixture(t)
+	admin := User{Username: "root", Role: RoleAdmin}
+	doctor := User{Username: "grey", Role: RoleDoctor, ProfileID: f.doctor.ID}
+
+	template := gin.H{
+		"specialization": "cardiology",
+		"name":           "Cardiology follow-up",
+		"fields": []gin.H{
+			{"key": "systolic", "label": "Systolic BP", "type": "number", "required": true},
+			{"key": "rhythm", "label": "Rhythm", "type": "choice", "options": []string{"regular", "irregular"}},
+			{"key": "smoker", "label": "Smoker", "type": "boolean"},
+		},
+	}
+	decode[apiError](t, f.do(t, http.MethodPut, "/api/admin/clinical-templates/cardio", admin, gin.H{
+		"specialization": "cardiology", "name": "Broken", "fields": []gin.H{{"key": "x", "label": "X", "type": "choice"}},
+	}), http.StatusBadRequest)
+	decode[ClinicalTemplate](t, f.do(t, http.MethodPut, "/api/admin/clinical-templates/cardio", admin, template), http.StatusOK)
+	saved := decode[ClinicalTemplate](t, f.do(t, http.MethodPut, "/api/admin/clinical-templates/cardio", admin, template), http.StatusOK)
+	if saved.Version != 2 {
+		t.Errorf("version after second save = %d, want 2", saved.Version)
+	}
+
+	booked := decode[bookingResponse](t, f.book(t, f.alice, f.first), http.StatusOK).Appointment
+	path := "/api/patients/p-alice/appointments/" + booked.ID + "/visit-note"
+	decode[apiError](t, f.do(t, http.MethodGet, path, doctor, nil), http.StatusNotFound)
+
+	bad := decode[apiError](t, f.do(t, http.MethodPut, path, doctor, gin.H{
+		"templateId": "cardio",
+		"values":     gin.H{"systolic": "high", "rhythm": "fast", "pulse": 80},
+	}), http.StatusBadRequest)
+	if len(bad.Details) != 3 {
+		t.Errorf("details = %+v, want errors for systolic, rhythm and pulse", bad.Details)
+	}
+
+	values := gin.H{"systolic": 128, "rhythm": "regular", "smoker": false}
+	decode[apiError](t, f.do(t, http.MethodPut, path, f.alice, gin.H{"templateId": "cardio", "values": values}), http.StatusForbidden)
+	decode[VisitNote](t, f.do(t, http.MethodPut, path, doctor, gin.H{"templateId": "cardio", "values": values, "text": "Stable"}), http.StatusOK)
+
+	note := decode[VisitNote](t, f.do(t, http.MethodGet, path, admin, nil), http.StatusOK)
+	if note.TemplateVersion != 2 || note.Specialization != "cardiology" || note.Values["systolic"] != float64(128) {
+		t.Errorf("note = %+v, want structured cardiology values against version 2", note)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type Consent = store.Consent
+
+// consentPurposes are the uses of patient data that need consent.
+var consentPurposes = []string{store.ConsentResearch, store.ConsentMarketing, store.ConsentInsurer, store.ConsentDiagnostics}
+
+// reportConsent is the consent patients need to be counted in the
+// analytics reports and their CSV exports.
+const reportConsent = store.ConsentResearch
+
+type consentRequest struct {
+	Granted *bool `json:"granted" binding:"required"`
+	// ExpiresAt optionally limits how long a grant lasts.
+	ExpiresAt *time.Time `json:"expiresAt" binding:"omitempty"`
+}
+
+type consentView struct {
+	Consent
+	Active bool `json:"active"`
+}
+
+// GetPatientConsents lists a patient's decision for every purpose,
+// including purposes they haven't decided on yet.
+func (s *Server) GetPatientConsents(c *gin.Context) {
+	patient, ok := s.findPatientOrAbort(c)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	views := make([]consentView, 0, len(consentPurposes))
+	for _, purpose := range consentPurposes {
+		view := consentView{Consent: Consent{Purpose: purpose}}
+		if i := slices.IndexFunc(patient.Consents, func(c Consent) bool { return c.Purpose == purpose }); i >= 0 {
+			view = consentView{Consent: patient.Consents[i], Active: patient.Consents[i].ActiveAt(now)}
+		}
+		views = append(views, view)
+	}
+	c.JSON(http.StatusOK, views)
+}
+
+// SetPatientConsent grants or revokes a patient's consent to the :purpose
+// path parameter.
+func (s *Server) SetPatientConsent(c *gin.Context) {
+	purpose := c.Param("purpose")
+	if !slices.Contains(consentPurposes, purpose) {
+		abortWithError(c, http.StatusNotFound, "Unknown consent purpose")
+		return
+	}
+	var req consentRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	now := time.Now().UTC()
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		abortWithDetails(c, fieldError{Field: "expiresAt", Message: "must be in the future"})
+		return
+	}
+
+	patient, ok := s.findPatientOrAbort(c)
+	if !ok {
+		return
+	}
+	user, _ := currentUser(c)
+	consent := Consent{Purpose: purpose, GrantedAt: now, ExpiresAt: req.ExpiresAt, RecordedBy: user.Username}
+	if !*req.Granted {
+		// Keep when consent was given, if it was
+		if i := slices.IndexFunc(patient.Consents, func(c Consent) bool { return c.Purpose == purpose }); i >= 0 {
+			consent.GrantedAt = patient.Consents[i].GrantedAt
+			consent.ExpiresAt = patient.Consents[i].ExpiresAt
+		}
+		consent.RevokedAt = &now
+	}
+
+	if err := s.store.Patients.SetConsent(c.Request.Context(), patient.ID, consent); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error updating consent")
+		return
+	}
+	c.JSON(http.StatusOK, consentView{Consent: consent, Active: consent.ActiveAt(now)})
+}
+
+// withConsent keeps only the documents whose patient, the patient ID at
+// field, has an active consent to purpose at now.
+func withConsent(pipeline mongo.Pipeline, field, purpose string, now time.Time) mongo.Pipeline {
+	active := bson.M{"consents": bson.M{"$elemMatch": bson.M{
+		"purpose":   purpose,
+		"grantedAt": bson.M{"$lte": now},
+		"revokedAt": nil,
+		"$or":       bson.A{bson.M{"expiresAt": nil}, bson.M{"expiresAt": bson.M{"$gt": now}}},
+	}}}
+	return append(pipeline,
+		bson.D{{Key: "$lookup", Value: bson.M{
+			"from":         "patients",
+			"localField":   field,
+			"foreignField": "id",
+			"pipeline":     mongo.Pipeline{{{Key: "$match", Value: active}}, {{Key: "$project", Value: bson.M{"_id": 1}}}},
+			"as":           "consenting",
+		}}},
+		bson.D{{Key: "$match", Value: bson.M{"consenting": bson.M{"$ne": bson.A{}}}}},
+		bson.D{{Key: "$unset", Value: "consenting"}},
+	)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"containerized-go-app/notification"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPatientConsents(t *testing.T) {
+	f := newBookingFixture(t)
+	const path = "/api/patients/p-alice/consents"
+
+	consents := decode[[]consentView](t, f.do(t, http.MethodGet, path, f.alice, nil), http.StatusOK)
+	if len(consents) != len(consentPurposes) || consents[0].Active {
+		t.Errorf("consents = %+v, want every purpose undecided", consents)
+	}
+
+	granted := decode[consentView](t, f.do(t, http.MethodPut, path+"/research", f.alice, gin.H{"granted": true}), http.StatusOK)
+	if !granted.Active || granted.RecordedBy != "alice" {
+		t.Errorf("granted = %+v, want active consent recorded by alice", granted)
+	}
+	revoked := decode[consentView](t, f.do(t, http.MethodPut, path+"/research", f.alice, gin.H{"granted": false}), http.StatusOK)
+	if revoked.Active || revoked.RevokedAt == nil || !revoked.GrantedAt.Equal(granted.GrantedAt) {
+		t.Errorf("revoked = %+v, want inactive consent keeping grantedAt %s", revoked, granted.GrantedAt)
+	}
+
+	decode[apiError](t, f.do(t, http.MethodPut, path+"/telepathy", f.alice, gin.H{"granted": true}), http.StatusNotFound)
+	past := time.Now().Add(-time.Hour)
+	decode[apiError](t, f.do(t, http.MethodPut, path+"/marketing", f.alice, gin.H{"granted": true, "expiresAt": past}), http.StatusBadRequest)
+	decode[apiError](t, f.do(t, http.MethodPut, "/api/patients/p-bob/consents/research", f.alice, gin.H{"granted": true}), http.StatusForbidden)
+}
+
+func TestWebhookNeedsConsent(t *testing.T) {
+	f := newBookingFixture(t)
+	ctx := context.Background()
+	f.do(t, http.MethodPut, "/api/patients/p-alice/consents/insurer", f.alice, gin.H{"granted": true})
+
+	received := make(chan notification.Event, 2)
+	insurer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event notification.Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Error(err)
+		}
+		received <- event
+	}))
+	defer insurer.Close()
+	sender := notification.WebhookSender{URL: insurer.URL, Consent: "insurer"}
+
+	for _, patient := range []string{f.alice.ProfileID, f.bob.ProfileID} {
+		event, err := f.appointmentEvent(ctx, notification.Booked, Appointment{ID: "a-" + patient, PatientID: patient, DoctorID: f.doctor.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sender.Send(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(received)
+
+	var sent []string
+	for event := range received {
+		sent = append(sent, event.PatientID)
+	}
+	if len(sent) != 1 || sent[0] != f.alice.ProfileID {
+		t.Errorf("webhook received events for %v, want only the consenting patient", sent)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultServerSelectionTimeout = 5 * time.Second
+	defaultConnectTimeout         = 10 * time.Second
+	// defaultQueryTimeout bounds Mongo calls made without a deadline, i.e.
+	// by background jobs; request calls are bounded by the request budget.
+	defaultQueryTimeout = 30 * time.Second
+	healthCheckTimeout  = 2 * time.Second
+)
+
+// mongoOptions builds the client options from DB_BASE_URL and the optional
+// MONGO_MAX_POOL_SIZE, MONGO_MIN_POOL_SIZE, MONGO_SERVER_SELECTION_TIMEOUT,
+// MONGO_CONNECT_TIMEOUT and MONGO_QUERY_TIMEOUT. Timeouts are Go durations,
+// e.g. "5s".
+func mongoOptions(uri string, monitor *event.CommandMonitor) (*options.ClientOptions, error) {
+	opts := options.Client().ApplyURI(uri).SetMonitor(monitor)
+
+	serverSelection, err := envDuration("MONGO_SERVER_SELECTION_TIMEOUT", defaultServerSelectionTimeout)
+	if err != nil {
+		return nil, err
+	}
+	connect, err := envDuration("MONGO_CONNECT_TIMEOUT", defaultConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	query, err := envDuration("MONGO_QUERY_TIMEOUT", defaultQueryTimeout)
+	if err != nil {
+		return nil, err
+	}
+	opts.SetServerSelectionTimeout(serverSelection).SetConnectTimeout(connect).SetTimeout(query)
+
+	if value := os.Getenv("MONGO_MAX_POOL_SIZE"); value != "" {
+		size, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MONGO_MAX_POOL_SIZE: %w", err)
+		}
+		opts.SetMaxPoolSize(size)
+	}
+	if value := os.Getenv("MONGO_MIN_POOL_SIZE"); value != "" {
+		size, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MONGO_MIN_POOL_SIZE: %w", err)
+		}
+		opts.SetMinPoolSize(size)
+	}
+	return opts, nil
+}
+
+func envDuration(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return d, nil
+}
+
+// Healthz reports whether the store is reachable, for readiness probes.
+func (s *Server) Healthz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), healthCheckTimeout)
+	defer cancel()
+
+	if err := s.store.Ping(ctx); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "mongo": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Delegation = store.Delegation
+
+type delegationRequest struct {
+	Username  string     `json:"username" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1,dive,oneof=view book"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	Guardian  bool       `json:"guardian"`
+}
+
+// RequirePatientAccess lets through doctors, admins, the patient in the :id
+// path parameter, and accounts that patient delegated scope to. It must run
+// after AuthRequired.
+func (s *Server) RequirePatientAccess(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := currentUser(c)
+		if !ok {
+			abortWithError(c, http.StatusForbidden, "Insufficient permissions")
+			return
+		}
+		if hasRole(user, []string{RoleDoctor, RoleAdmin}) || (user.Role == RolePatient && user.ProfileID == c.Param("id")) {
+			c.Next()
+			return
+		}
+
+		delegations, err := s.store.Delegations.ForDelegate(c.Request.Context(), user.Username)
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "Error checking permissions")
+			return
+		}
+		now := time.Now()
+		for _, d := range delegations {
+			if d.PatientID == c.Param("id") && d.Allows(scope, now) {
+				c.Next()
+				return
+			}
+		}
+		abortWithError(c, http.StatusForbidden, "Insufficient permissions")
+	}
+}
+
+// GetPatientDelegations lists the access a patient has delegated, including
+// revoked and expired delegations.
+func (s *Server) GetPatientDelegations(c *gin.Context) {
+	delegations, err := s.store.Delegations.ForPatient(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving delegations")
+		return
+	}
+	c.JSON(http.StatusOK, delegations)
+}
+
+// CreatePatientDelegation gives another account scoped access to a
+// patient's resources.
+func (s *Server) CreatePatientDelegation(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req delegationRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	now := time.Now().UTC()
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		abortWithDetails(c, fieldError{Field: "expiresAt", Message: "must be in the future"})
+		return
+	}
+
+	patient, ok := s.findPatientOrAbort(c)
+	if !ok {
+		return
+	}
+	delegate, err := s.store.Users.FindByUsername(ctx, req.Username)
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithDetails(c, fieldError{Field: "username", Message: "must be an existing account"})
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving account")
+		return
+	}
+	if delegate.ProfileID == patient.ID {
+		abortWithDetails(c, fieldError{Field: "username", Message: "must be another account"})
+		return
+	}
+
+	delegation := Delegation{
+		ID:        primitive.NewObjectID().Hex(),
+		PatientID: patient.ID,
+		Delegate:  delegate.Username,
+		Scopes:    req.Scopes,
+		GrantedAt: now,
+		ExpiresAt: req.ExpiresAt,
+		Guardian:  req.Guardian,
+	}
+	if err := s.store.Delegations.Create(ctx, delegation); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error creating delegation")
+		return
+	}
+	s.audit(c, "delegation.granted", patient.ID, delegation.Delegate)
+	c.JSON(http.StatusCreated, delegation)
+}
+
+// RevokePatientDelegation ends a delegation straight away.
+func (s *Server) RevokePatientDelegation(c *gin.Context) {
+	patientID := c.Param("id")
+	err := s.store.Delegations.Revoke(c.Request.Context(), patientID, c.Param("delegationID"), time.Now().UTC())
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, "Delegation not found")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error revoking delegation")
+		return
+	}
+	s.audit(c, "delegation.revoked", patientID, c.Param("delegationID"))
+	c.JSON(http.StatusOK, gin.H{"message": "Delegation revoked"})
+}
+
+// GetMyDelegations lists the active delegations granted to the current
+// user, so they can find the patients they may act for.
+func (s *Server) GetMyDelegations(c *gin.Context) {
+	user, _ := currentUser(c)
+	delegations, err := s.store.Delegations.ForDelegate(c.Request.Context(), user.Username)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving delegations")
+		return
+	}
+	now := time.Now()
+	active := []Delegation{}
+	for _, d := range delegations {
+		if d.ActiveAt(now) {
+			active = append(active, d)
+		}
+	}
+	c.JSON(http.StatusOK, active)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"containerized-go-app/notification"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDelegatedPatientAccess(t *testing.T) {
+	f := newBookingFixture(t)
+	for _, user := range []User{f.alice, f.bob} {
+		if err := f.store.Users.Create(context.Background(), user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const delegations = "/api/patients/p-alice/delegations"
+	aliceAppointments := "/api/patients/p-alice/appointments"
+
+	decode[apiError](t, f.do(t, http.MethodGet, aliceAppointments, f.bob, nil), http.StatusForbidden)
+	decode[apiError](t, f.do(t, http.MethodPost, delegations, f.alice, gin.H{"username": "alice", "scopes": []string{"view"}}), http.StatusBadRequest)
+	decode[apiError](t, f.do(t, http.MethodPost, delegations, f.alice, gin.H{"username": "bob", "scopes": []string{"admin"}}), http.StatusBadRequest)
+
+	// View only
+	view := decode[Delegation](t, f.do(t, http.MethodPost, delegations, f.alice, gin.H{"username": "bob", "scopes": []string{"view"}}), http.StatusCreated)
+	decode[[]Appointment](t, f.do(t, http.MethodGet, aliceAppointments, f.bob, nil), http.StatusOK)
+	rec := f.do(t, http.MethodPost, aliceAppointments, f.bob, gin.H{"doctorId": f.doctor.ID, "startTime": f.first})
+	decode[apiError](t, rec, http.StatusForbidden)
+	// Delegates don't get the patient's own settings
+	decode[apiError](t, f.do(t, http.MethodGet, "/api/patients/p-alice/consents", f.bob, nil), http.StatusForbidden)
+
+	// Booking on alice's behalf
+	expires := time.Now().Add(time.Hour)
+	decode[Delegation](t, f.do(t, http.MethodPost, delegations, f.alice, gin.H{"username": "bob", "scopes": []string{"book"}, "expiresAt": expires}), http.StatusCreated)
+	booked := decode[bookingResponse](t, f.do(t, http.MethodPost, aliceAppointments, f.bob, gin.H{"doctorId": f.doctor.ID, "startTime": f.first}), http.StatusOK).Appointment
+	if booked.PatientID != f.alice.ProfileID {
+		t.Errorf("booked for %s, want p-alice", booked.PatientID)
+	}
+	mine := decode[[]Delegation](t, f.do(t, http.MethodGet, "/api/delegations", f.bob, nil), http.StatusOK)
+	if len(mine) != 2 {
+		t.Errorf("bob's delegations = %+v, want both", mine)
+	}
+
+	// Revoking every delegation ends the access
+	for _, d := range decode[[]Delegation](t, f.do(t, http.MethodGet, delegations, f.alice, nil), http.StatusOK) {
+		decode[struct{}](t, f.do(t, http.MethodDelete, delegations+"/"+d.ID, f.alice, nil), http.StatusOK)
+	}
+	cancel := fmt.Sprintf("%s/%s", aliceAppointments, booked.ID)
+	decode[apiError](t, f.do(t, http.MethodDelete, cancel, f.bob, nil), http.StatusForbidden)
+	decode[apiError](t, f.do(t, http.MethodGet, aliceAppointments, f.bob, nil), http.StatusForbidden)
+	decode[apiError](t, f.do(t, http.MethodDelete, delegations+"/"+view.ID, f.bob, nil), http.StatusForbidden)
+}
+
+func TestDoctorCannotManageDelegations(t *testing.T) {
+	f := newBookingFixture(t)
+	if err := f.store.Users.Create(context.Background(), f.bob); err != nil {
+		t.Fatal(err)
+	}
+	const delegations = "/api/patients/p-alice/delegations"
+	granted := decode[Delegation](t, f.do(t, http.MethodPost, delegations, f.alice, gin.H{"username": "bob", "scopes": []string{"view"}}), http.StatusCreated)
+
+	grey := User{Username: "grey", Role: RoleDoctor, ProfileID: "d1"}
+	decode[apiError](t, f.do(t, http.MethodPost, delegations, grey, gin.H{"username": "grey", "scopes": []string{"book"}, "guardian": true}), http.StatusForbidden)
+	decode[apiError](t, f.do(t, http.MethodGet, delegations, grey, nil), http.StatusForbidden)
+	decode[apiError](t, f.do(t, http.MethodDelete, delegations+"/"+granted.ID, grey, nil), http.StatusForbidden)
+	if got := decode[[]Delegation](t, f.do(t, http.MethodGet, delegations, f.alice, nil), http.StatusOK); len(got) != 1 {
+		t.Errorf("delegations = %+v, want alice's grant untouched", got)
+	}
+
+	admin := User{Username: "root", Role: RoleAdmin}
+	decode[struct{}](t, f.do(t, http.MethodDelete, delegations+"/"+granted.ID, admin, nil), http.StatusOK)
+}
+
+func TestMinorNotificationsGoToGuardian(t *testing.T) {
+	f := newBookingFixture(t)
+	ctx := context.Background()
+	f.alice.Email, f.bob.Email = "alice@example.com", "bob@example.com"
+	for _, user := range []User{f.alice, f.bob} {
+		if err := f.store.Users.Create(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dateOfBirth := time.Now().AddDate(-10, 0, 0).Format(dateLayout)
+	if err := f.store.Patients.SetProfile(ctx, f.alice.ProfileID, PatientProfile{DateOfBirth: dateOfBirth}); err != nil {
+		t.Fatal(err)
+	}
+	appointment := Appointment{ID: "a1", PatientID: f.alice.ProfileID, DoctorID: f.doctor.ID, StartTime: f.first}
+
+	// Without a guardian on file nobody is emailed, not even the child
+	event, err := f.appointmentEvent(ctx, notification.Booked, appointment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.PatientEmail != "" {
+		t.Errorf("email of a minor without guardian = %q, want none", event.PatientEmail)
+	}
+
+	f.do(t, http.MethodPost, "/api/patients/p-alice/delegations", f.alice, gin.H{"username": "bob", "scopes": []string{"book"}, "guardian": true})
+	event, err = f.appointmentEvent(ctx, notification.Booked, appointment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.PatientEmail != "bob@example.com" || event.GuardianName != "bob" {
+		t.Errorf("event = %+v, want it addressed to guardian bob", event)
+	}
+}
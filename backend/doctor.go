@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	doctorDialTimeout = 5 * time.Second
+	// maxClockSkew is how far the clock may drift from Mongo's before
+	// token expiry, slot claims and reminders go noticeably wrong.
+	maxClockSkew = 5 * time.Second
+	minJWTSecret = 32
+)
+
+// Finding levels, in increasing severity.
+const (
+	findingOK   = "OK"
+	findingWarn = "WARN"
+	findingFail = "FAIL"
+)
+
+// finding is one result of the preflight checks. Message says what to do
+// about anything that isn't OK.
+type finding struct {
+	Level   string
+	Check   string
+	Message string
+}
+
+func (f finding) String() string {
+	return fmt.Sprintf("%-4s %-8s %s", f.Level, f.Check, f.Message)
+}
+
+// runDoctorCommand checks the configuration and everything the server
+// depends on, printing one line per finding. It returns 1 when any check
+// failed, so deploy scripts can stop there.
+func runDoctorCommand(ctx context.Context) int {
+	findings := checkConfig()
+	if uri := os.Getenv("DB_BASE_URL"); uri != "" {
+		findings = append(findings, checkMongo(ctx, uri)...)
+	}
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		findings = append(findings, checkSMTP(host, os.Getenv("SMTP_PORT"), os.Getenv("SMTP_USERNAME") != ""))
+	}
+
+	failed := 0
+	for _, f := range findings {
+		fmt.Println(f)
+		if f.Level == findingFail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, len(findings))
+		return 1
+	}
+	fmt.Println("Ready to serve")
+	return 0
+}
+
+// checkConfig validates the environment without connecting anywhere.
+func checkConfig() []finding {
+	var findings []finding
+	fail := func(message string) { findings = append(findings, finding{findingFail, "config", message}) }
+	warn := func(message string) { findings = append(findings, finding{findingWarn, "config", message}) }
+
+	if os.Getenv("DB_BASE_URL") == "" {
+		fail("DB_BASE_URL is not set; point it at the clinic's MongoDB, e.g. mongodb://mongo:27017")
+	} else if _, err := mongoOptions(os.Getenv("DB_BASE_URL"), nil); err != nil {
+		fail(fmt.Sprintf("Mongo settings are invalid: %v", err))
+	}
+	if secret := os.Getenv("JWT_SECRET"); secret == "" {
+		fail("JWT_SECRET is not set; generate one with `openssl rand -hex 32`")
+	} else if len(secret) < minJWTSecret {
+		warn(fmt.Sprintf("JWT_SECRET is only %d bytes; use at least %d random bytes", len(secret), minJWTSecret))
+	}
+
+	for _, name := range []string{"JWT_TTL", "MAGIC_LINK_TTL"} {
+		if value := os.Getenv(name); value != "" {
+			if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+				fail(fmt.Sprintf("%s=%q is not a positive duration like 24h", name, value))
+			}
+		}
+	}
+	for _, name := range []string{"MAX_SESSIONS_PER_USER", "ACCOUNT_CLOSURE_GRACE_DAYS", "RECORD_RETENTION_YEARS", "ARCHIVE_AFTER_YEARS", "REMINDER_HOURS", "NOTIFY_QUEUE_SIZE", "NOTIFY_SUPPRESS_MINUTES", "LATE_START_GRACE_MINUTES"} {
+		if value := os.Getenv(name); value != "" {
+			if n, err := strconv.Atoi(value); err != nil || n < 0 {
+				fail(fmt.Sprintf("%s=%q is not a whole number of at least 0", name, value))
+			}
+		}
+	}
+	if calendar := os.Getenv("SECONDARY_CALENDAR"); calendar != "" && calendar != calendarHijri {
+		fail(fmt.Sprintf("SECONDARY_CALENDAR=%q is not supported; leave it unset or use %q", calendar, calendarHijri))
+	}
+	if tz := os.Getenv("CLINIC_TIMEZONE"); tz != "" {
+		if _, err := time.LoadLocation(tz); err != nil {
+			fail(fmt.Sprintf("CLINIC_TIMEZONE=%q is not an IANA time zone like Africa/Cairo", tz))
+		}
+	}
+	if color := os.Getenv("CLINIC_PRIMARY_COLOR"); color != "" && !hexColor.MatchString(color) {
+		fail(fmt.Sprintf("CLINIC_PRIMARY_COLOR=%q is not a color like #1a73e8", color))
+	}
+	if hours := os.Getenv("CLINIC_HOURS"); hours != "" {
+		if _, err := parseClinicHours(hours); err != nil {
+			fail(fmt.Sprintf("CLINIC_HOURS %v", err))
+		}
+	}
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		if _, err := parseTrustedProxies(proxies); err != nil {
+			fail(fmt.Sprintf("TRUSTED_PROXIES: %v", err))
+		}
+	}
+	if key := os.Getenv("CAPTURE_KEY"); key != "" {
+		if parsed, err := hex.DecodeString(key); err != nil || len(parsed) != 32 {
+			fail("CAPTURE_KEY must be 64 hex characters; generate one with `openssl rand -hex 32`")
+		}
+	}
+	if (os.Getenv("ADMIN_USERNAME") == "") != (os.Getenv("ADMIN_PASSWORD") == "") {
+		fail("ADMIN_USERNAME and ADMIN_PASSWORD must be set together to seed the first admin")
+	}
+
+	// Settings that are valid on their own but don't fit together
+	email := os.Getenv("SMTP_HOST") != "" && os.Getenv("SMTP_FROM") != ""
+	if os.Getenv("SMTP_HOST") != "" && os.Getenv("SMTP_FROM") == "" {
+		warn("SMTP_HOST is set without SMTP_FROM, so no email is sent")
+	}
+	notify := email || os.Getenv("NOTIFY_WEBHOOK_URL") != ""
+	if !notify {
+		warn("no notification channel is set up; set SMTP_HOST and SMTP_FROM or NOTIFY_WEBHOOK_URL for confirmations, reminders and sign-in links")
+	}
+	if email {
+		for _, link := range []struct{ name, value string }{{"MAGIC_LINK_URL", magicLinkURL}, {"ACCOUNT_CLOSURE_URL", closureConfirmURL}} {
+			name, value := link.name, link.value
+			if custom := os.Getenv(name); custom != "" {
+				value = custom
+			}
+			if u, err := url.Parse(value); err != nil || u.Host == "" {
+				fail(fmt.Sprintf("%s=%q is not an absolute URL", name, value))
+			} else if strings.HasPrefix(u.Hostname(), "localhost") || u.Hostname() == "127.0.0.1" {
+				warn(fmt.Sprintf("%s points at %s; emailed links won't open for patients - set it to the clinic's frontend", name, u.Host))
+			}
+		}
+	}
+	for _, name := range []string{"NOTIFY_WEBHOOK_URL", "SHARE_WEBHOOK_URL"} {
+		if hook := os.Getenv(name); hook != "" {
+			if u, err := url.Parse(hook); err != nil || u.Host == "" {
+				fail(fmt.Sprintf("%s=%q is not an absolute URL", name, hook))
+			} else if u.Scheme != "https" {
+				warn(name + " isn't https; patient details would be sent unencrypted")
+			}
+		}
+	}
+	if consent := os.Getenv("SHARE_WEBHOOK_CONSENT"); os.Getenv("SHARE_WEBHOOK_URL") != "" && !slices.Contains(consentPurposes, consent) {
+		fail(fmt.Sprintf("SHARE_WEBHOOK_CONSENT=%q must be the consent purpose the third party needs, one of %s", consent, strings.Join(consentPurposes, ", ")))
+	}
+
+	if len(findings) == 0 {
+		findings = append(findings, finding{findingOK, "config", "environment is consistent"})
+	}
+	return findings
+}
+
+// checkMongo connects to Mongo, compares its clock with ours and checks
+// the schema.
+func checkMongo(ctx context.Context, uri string) []finding {
+	opts, err := mongoOptions(uri, nil)
+	if err != nil {
+		return nil // already reported by checkConfig
+	}
+	ctx, cancel := context.WithTimeout(ctx, defaultConnectTimeout)
+	defer cancel()
+
+	start := time.Now()
+	client, err := mongo.Connect(ctx, opts)
+	if err == nil {
+		defer client.Disconnect(context.WithoutCancel(ctx))
+		err = client.Ping(ctx, nil)
+	}
+	if err != nil {
+		return []finding{{findingFail, "mongo", fmt.Sprintf("can't reach MongoDB: %v; check DB_BASE_URL and that the server accepts connections from here", err)}}
+	}
+	findings := []finding{{findingOK, "mongo", fmt.Sprintf("connected in %s", time.Since(start).Round(time.Millisecond))}}
+	db := client.Database("hospital")
+
+	var hello struct {
+		LocalTime time.Time `bson:"localTime"`
+	}
+	sent := time.Now()
+	if err := db.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		findings = append(findings, finding{findingWarn, "clock", fmt.Sprintf("couldn't read Mongo's clock: %v", err)})
+	} else {
+		// Compare against the middle of the round trip
+		ours := sent.Add(time.Since(sent) / 2)
+		skew := ours.Sub(hello.LocalTime)
+		if skew < 0 {
+			skew = -skew
+		}
+		if skew > maxClockSkew {
+			findings = append(findings, finding{findingFail, "clock", fmt.Sprintf("clock is %s off from Mongo's; enable NTP on both hosts", skew.Round(time.Second))})
+		} else {
+			findings = append(findings, finding{findingOK, "clock", "in step with Mongo"})
+		}
+	}
+
+	drifts, err := checkSchema(ctx, db)
+	if err != nil {
+		return append(findings, finding{findingFail, "schema", fmt.Sprintf("couldn't check indexes and validators: %v", err)})
+	}
+	for _, drift := range drifts {
+		findings = append(findings, finding{findingFail, "schema", drift.String() + "; run `main schema -fix` or start with SCHEMA_AUTOFIX=true"})
+	}
+	if len(drifts) == 0 {
+		findings = append(findings, finding{findingOK, "schema", "indexes and validators are up to date"})
+	}
+	return findings
+}
+
+// checkSMTP greets the mail server the way the notifier will. Nothing is
+// sent.
+func checkSMTP(host, port string, authenticates bool) finding {
+	if port == "" {
+		port = "587"
+	}
+	addr := net.JoinHostPort(host, port)
+	conn, err := net.DialTimeout("tcp", addr, doctorDialTimeout)
+	if err != nil {
+		return finding{findingFail, "smtp", fmt.Sprintf("can't reach %s: %v; check SMTP_HOST, SMTP_PORT and the firewall", addr, err)}
+	}
+	conn.SetDeadline(time.Now().Add(doctorDialTimeout))
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return finding{findingFail, "smtp", fmt.Sprintf("%s didn't answer as a mail server: %v", addr, err)}
+	}
+	defer client.Close()
+	if err := client.Hello("localhost"); err != nil {
+		return finding{findingFail, "smtp", fmt.Sprintf("%s rejected the greeting: %v", addr, err)}
+	}
+	if tls, _ := client.Extension("STARTTLS"); !tls && authenticates {
+		return finding{findingFail, "smtp", fmt.Sprintf("%s doesn't offer STARTTLS, so SMTP_USERNAME/SMTP_PASSWORD can't be sent; use the submission port 587", addr)}
+	}
+	client.Quit()
+	return finding{findingOK, "smtp", fmt.Sprintf("%s is accepting mail", addr)}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	for _, name := range []string{"SMTP_HOST", "SMTP_FROM", "NOTIFY_WEBHOOK_URL", "SHARE_WEBHOOK_URL", "SHARE_WEBHOOK_CONSENT", "MAGIC_LINK_URL", "ACCOUNT_CLOSURE_URL", "ADMIN_USERNAME", "ADMIN_PASSWORD", "SECONDARY_CALENDAR", "CLINIC_TIMEZONE", "JWT_TTL"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("DB_BASE_URL", "mongodb://mongo:27017")
+	t.Setenv("JWT_SECRET", strings.Repeat("s", minJWTSecret))
+	t.Setenv("NOTIFY_WEBHOOK_URL", "https://hooks.example.com/clinic")
+	if findings := checkConfig(); len(findings) != 1 || findings[0].Level != findingOK {
+		t.Fatalf("findings = %v, want the configuration to pass", findings)
+	}
+
+	t.Setenv("JWT_SECRET", "short")
+	t.Setenv("JWT_TTL", "a day")
+	t.Setenv("ADMIN_USERNAME", "root")
+	t.Setenv("SMTP_HOST", "mail.example.com")
+	t.Setenv("SMTP_FROM", "clinic@example.com")
+	t.Setenv("SHARE_WEBHOOK_URL", "http://insurer.example.com/claims")
+	levels := map[string]string{}
+	for _, f := range checkConfig() {
+		levels[strings.Fields(f.Message)[0]] = f.Level
+	}
+	want := map[string]string{
+		"JWT_SECRET":               findingWarn,
+		"JWT_TTL=\"a":              findingFail,
+		"ADMIN_USERNAME":           findingFail,
+		"MAGIC_LINK_URL":           findingWarn,
+		"ACCOUNT_CLOSURE_URL":      findingWarn,
+		"SHARE_WEBHOOK_URL":        findingWarn,
+		`SHARE_WEBHOOK_CONSENT=""`: findingFail,
+	}
+	for subject, level := range want {
+		if levels[subject] != level {
+			t.Errorf("finding about %s = %q, want %q (all: %v)", subject, levels[subject], level, levels)
+		}
+	}
+	if len(levels) != len(want) {
+		t.Errorf("findings = %v, want only %v", levels, want)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"containerized-go-app/notification"
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EmergencyContact = store.EmergencyContact
+
+const (
+	maxEmergencyContacts = 5
+	// visitWindow is how long before its start and after its end an
+	// appointment counts as an ongoing visit.
+	visitWindow = 2 * time.Hour
+)
+
+type emergencyContactsRequest struct {
+	Contacts []EmergencyContact `json:"contacts" binding:"max=5,dive"`
+}
+
+type emergencyNotifyRequest struct {
+	Channel string `json:"channel" binding:"required,oneof=sms call"`
+	Message string `json:"message" binding:"required,notblank,max=500"`
+}
+
+// SetEmergencyContacts replaces a patient's emergency contacts.
+func (s *Server) SetEmergencyContacts(c *gin.Context) {
+	var req emergencyContactsRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if req.Contacts == nil {
+		req.Contacts = []EmergencyContact{}
+	}
+
+	err := s.store.Patients.SetEmergencyContacts(c.Request.Context(), c.Param("id"), req.Contacts)
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, "Patient not found")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error updating emergency contacts")
+		return
+	}
+	c.JSON(http.StatusOK, req.Contacts)
+}
+
+// NotifyEmergencyContacts lets the doctor seeing a patient have the
+// patient's emergency contacts reached by SMS or call. Each contact becomes
+// a task for the webhook, sent while the doctor waits rather than queued,
+// so the response says who was reached. The request is recorded in the
+// audit log.
+func (s *Server) NotifyEmergencyContacts(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req emergencyNotifyRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if !s.notifier.Enabled() {
+		abortWithError(c, http.StatusServiceUnavailable, "Notifications are not configured")
+		return
+	}
+
+	user, _ := currentUser(c)
+	appointment, err := s.findAppointment(ctx, c.Param("id"), c.Param("appointmentID"))
+	if errors.Is(err, errAppointmentNotFound) {
+		abortWithError(c, http.StatusNotFound, "Appointment not found")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving appointment")
+		return
+	}
+	if user.ProfileID != appointment.DoctorID {
+		abortWithError(c, http.StatusForbidden, "Only the doctor seeing the patient can notify their emergency contacts")
+		return
+	}
+	now := time.Now()
+	if appointment.Status == AppointmentCancelled || now.Before(appointment.StartTime.Add(-visitWindow)) || now.After(appointment.EndTime.Add(visitWindow)) {
+		abortWithCode(c, http.StatusConflict, "not_during_visit", "Emergency contacts can only be notified during a visit")
+		return
+	}
+
+	patient, err := s.findPatient(ctx, appointment.PatientID)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving patient")
+		return
+	}
+	if len(patient.EmergencyContacts) == 0 {
+		abortWithCode(c, http.StatusConflict, "no_emergency_contact", "The patient has no emergency contacts")
+		return
+	}
+	event, err := s.appointmentEvent(ctx, notification.EmergencyContact, appointment)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving patient")
+		return
+	}
+
+	notified, failed := []string{}, []string{}
+	for _, contact := range patient.EmergencyContacts {
+		event.Contact = &notification.Contact{
+			Name:         contact.Name,
+			Relationship: contact.Relationship,
+			Phone:        contact.Phone,
+			Channel:      req.Channel,
+			Message:      req.Message,
+		}
+		if err := s.notifier.Send(context.WithoutCancel(ctx), event); err != nil {
+			log.Printf("Notifying emergency contact %s of patient %s failed: %v", contact.Name, patient.ID, err)
+			failed = append(failed, contact.Name)
+			continue
+		}
+		notified = append(notified, contact.Name)
+	}
+	detail := fmt.Sprintf("%s to %s during appointment %s: %s", req.Channel, strings.Join(notified, ", "), appointment.ID, req.Message)
+	if len(failed) > 0 {
+		detail += fmt.Sprintf(" (failed: %s)", strings.Join(failed, ", "))
+	}
+	s.audit(c, "emergency_contact.notified", patient.ID, detail)
+
+	if len(notified) == 0 {
+		abortWithCode(c, http.StatusBadGateway, "not_delivered", "No emergency contact could be notified")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"notified": notified, "failed": failed})
+}
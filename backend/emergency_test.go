@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"containerized-go-app/notification"
+
+	"github.com/gin-gonic/gin"
+)
+
+type failingSender struct{}
+
+func (failingSender) Send(context.Context, notification.Event) error {
+	return errors.New("gateway unavailable")
+}
+
+func TestNotifyEmergencyContacts(t *testing.T) {
+	f := newBookingFixture(t)
+	sent := make(captureSender, 10)
+	f.notifier = notification.New(10, sent)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.notifier.Run(ctx)
+
+	// A visit going on right now
+	visit := Appointment{
+		ID:        "visit",
+		PatientID: f.alice.ProfileID,
+		DoctorID:  f.doctor.ID,
+		StartTime: time.Now().Add(-10 * time.Minute),
+		EndTime:   time.Now().Add(20 * time.Minute),
+		Status:    AppointmentScheduled,
+	}
+	if err := f.store.Appointments.Create(ctx, visit); err != nil {
+		t.Fatal(err)
+	}
+	doctor := User{Username: "grey", Role: RoleDoctor, ProfileID: f.doctor.ID}
+	notifyPath := fmt.Sprintf("/api/patients/%s/appointments/%s/emergency-contacts/notify", f.alice.ProfileID, visit.ID)
+	body := gin.H{"channel": "call", "message": "Please come to the clinic"}
+
+	resp := decode[apiError](t, f.do(t, http.MethodPost, notifyPath, doctor, body), http.StatusConflict)
+	if resp.Code != "no_emergency_contact" {
+		t.Errorf("code = %q, want no_emergency_contact", resp.Code)
+	}
+
+	contacts := gin.H{"contacts": []gin.H{{"name": "Bob", "relationship": "partner", "phone": "+14155550101"}}}
+	decode[[]EmergencyContact](t, f.do(t, http.MethodPut, "/api/patients/p-alice/emergency-contacts", f.alice, contacts), http.StatusOK)
+
+	decode[apiError](t, f.do(t, http.MethodPost, notifyPath, f.alice, body), http.StatusForbidden)
+	otherDoctor := User{Username: "house", Role: RoleDoctor, ProfileID: "d2"}
+	decode[apiError](t, f.do(t, http.MethodPost, notifyPath, otherDoctor, body), http.StatusForbidden)
+
+	// Emergency tasks go out straight away, even with notifications paused
+	f.notifier.Pause()
+	got := decode[struct {
+		Notified []string `json:"notified"`
+	}](t, f.do(t, http.MethodPost, notifyPath, doctor, body), http.StatusOK)
+	if len(got.Notified) != 1 || got.Notified[0] != "Bob" {
+		t.Errorf("notified = %v, want Bob", got.Notified)
+	}
+	select {
+	case event := <-sent:
+		if event.Kind != notification.EmergencyContact || event.Contact == nil || event.Contact.Phone != "+14155550101" || event.Contact.Channel != "call" {
+			t.Errorf("event = %+v, want a call task for Bob", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no emergency contact event sent")
+	}
+
+	// A gateway that fails leaves the doctor to call themselves
+	f.notifier = notification.New(10, failingSender{})
+	if resp := decode[apiError](t, f.do(t, http.MethodPost, notifyPath, doctor, body), http.StatusBadGateway); resp.Code != "not_delivered" {
+		t.Errorf("code = %q, want not_delivered", resp.Code)
+	}
+
+	admin := User{Username: "root", Role: RoleAdmin}
+	entries := decode[[]AuditEntry](t, f.do(t, http.MethodGet, "/api/admin/audit?patientId=p-alice", admin, nil), http.StatusOK)
+	if len(entries) != 2 || entries[0].Action != "emergency_contact.notified" || entries[0].Actor != "grey" {
+		t.Errorf("audit entries = %+v, want both notifications by grey", entries)
+	}
+
+	// Tomorrow's appointment isn't a visit yet
+	booked := decode[bookingResponse](t, f.book(t, f.alice, f.first), http.StatusOK).Appointment
+	later := fmt.Sprintf("/api/patients/%s/appointments/%s/emergency-contacts/notify", f.alice.ProfileID, booked.ID)
+	resp = decode[apiError](t, f.do(t, http.MethodPost, later, doctor, body), http.StatusConflict)
+	if resp.Code != "not_during_visit" {
+		t.Errorf("code = %q, want not_during_visit", resp.Code)
+	}
+}
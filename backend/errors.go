@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// apiError is the envelope every error response uses. Error keeps the
+// message under the "error" key existing clients already read; Code is a
+// stable machine-readable identifier and Details lists per-field problems
+// for form validation.
+type apiError struct {
+	XMLName xml.Name     `json:"-" xml:"error"`
+	Status  int          `json:"-" xml:"-"`
+	Code    string       `json:"code" xml:"code"`
+	Message string       `json:"error" xml:"message"`
+	Details []fieldError `json:"details,omitempty" xml:"details>field,omitempty"`
+	// Diagnostics says where the time went when a request timed out.
+	Diagnostics *budgetReport `json:"diagnostics,omitempty" xml:"diagnostics,omitempty"`
+}
+
+type fieldError struct {
+	Field   string `json:"field" xml:"name,attr"`
+	Message string `json:"message" xml:",chardata"`
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+const (
+	codeValidationFailed = "validation_failed"
+	codeInvalidBody      = "invalid_body"
+)
+
+// statusCodes is the default code for each status.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:          "bad_request",
+	http.StatusUnauthorized:        "unauthorized",
+	http.StatusForbidden:           "forbidden",
+	http.StatusNotFound:            "not_found",
+	http.StatusConflict:            "conflict",
+	http.StatusTooManyRequests:     "rate_limited",
+	http.StatusInternalServerError: "internal_error",
+	http.StatusGatewayTimeout:      "deadline_exceeded",
+}
+
+// abortWithError ends the request with an error response whose code is the
+// default for status.
+func abortWithError(c *gin.Context, status int, message string) {
+	abortWithCode(c, status, statusCodes[status], message)
+}
+
+// abortWithCode ends the request with an error response with a specific
+// code, e.g. slot_taken, that clients can branch on.
+func abortWithCode(c *gin.Context, status int, code, message string) {
+	c.Error(&apiError{Status: status, Code: code, Message: message})
+	c.Abort()
+}
+
+// abortWithDetails ends the request with a validation error on fields.
+func abortWithDetails(c *gin.Context, details ...fieldError) {
+	c.Error(&apiError{
+		Status:  http.StatusBadRequest,
+		Code:    codeValidationFailed,
+		Message: "Invalid input data",
+		Details: details,
+	})
+	c.Abort()
+}
+
+// bindJSON decodes and validates the request body into obj. On failure it
+// aborts with a validation error and returns false.
+func bindJSON(c *gin.Context, obj interface{}) bool {
+	if err := c.ShouldBindJSON(obj); err != nil {
+		c.Error(bindingError(err))
+		c.Abort()
+		return false
+	}
+	return true
+}
+
+// bindingError turns a decoding or validation error into an apiError.
+func bindingError(err error) *apiError {
+	var validationErrs validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	var timeErr *time.ParseError
+	switch {
+	case errors.As(err, &validationErrs):
+		details := make([]fieldError, 0, len(validationErrs))
+		for _, fe := range validationErrs {
+			details = append(details, fieldError{Field: fieldPath(fe), Message: validationMessage(fe)})
+		}
+		return &apiError{Status: http.StatusBadRequest, Code: codeValidationFailed, Message: "Invalid input data", Details: details}
+	case errors.As(err, &typeErr):
+		return &apiError{
+			Status:  http.StatusBadRequest,
+			Code:    codeValidationFailed,
+			Message: "Invalid input data",
+			Details: []fieldError{{Field: typeErr.Field, Message: "must be a " + typeErr.Type.String()}},
+		}
+	case errors.As(err, &timeErr):
+		return &apiError{Status: http.StatusBadRequest, Code: codeInvalidBody, Message: "Times must be RFC3339, e.g. 2024-01-02T09:00:00Z"}
+	case errors.Is(err, io.EOF):
+		return &apiError{Status: http.StatusBadRequest, Code: codeInvalidBody, Message: "Request body is empty"}
+	default:
+		return &apiError{Status: http.StatusBadRequest, Code: codeInvalidBody, Message: "Request body is not valid JSON"}
+	}
+}
+
+// ErrorEnvelope renders errors recorded with c.Error by handlers and
+// middleware as an apiError. Anything that isn't an apiError is logged and
+// reported as a generic 500 so internals don't leak to clients, and any
+// error after the request's deadline passed is reported as a 504.
+func ErrorEnvelope() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		// Whatever failed, it failed because the request ran out of time
+		if errors.Is(c.Request.Context().Err(), context.DeadlineExceeded) {
+			render(c, http.StatusGatewayTimeout, deadlineExceededError(c.Request.Context()), nil)
+			return
+		}
+
+		err := c.Errors.Last().Err
+		var apiErr *apiError
+		if !errors.As(err, &apiErr) {
+			log.Printf("%s %s: %v", c.Request.Method, c.FullPath(), err)
+			apiErr = &apiError{Status: http.StatusInternalServerError, Code: statusCodes[http.StatusInternalServerError], Message: "Internal server error"}
+		}
+		render(c, apiErr.Status, apiErr, nil)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// frontendFiles holds the built frontend when the binary is built with the
+// embedfrontend tag; otherwise it stays nil and the frontend is deployed on
+// its own.
+var frontendFiles fs.FS
+
+const (
+	frontendIndex = "index.html"
+	// The frontend build puts content-hashed bundles under assets/, so they
+	// never change under the same name
+	frontendAssets    = "assets/"
+	immutableCaching  = "public, max-age=31536000, immutable"
+	revalidateCaching = "no-cache"
+)
+
+// backendPrefixes are served by the API alone; a miss there stays a JSON
+// 404 rather than becoming the frontend's index page.
+var backendPrefixes = []string{"/api/", "/soap/", "/healthz"}
+
+// hasFrontend reports whether fsys holds a frontend build to serve.
+func hasFrontend(fsys fs.FS) bool {
+	if fsys == nil {
+		return false
+	}
+	info, err := fs.Stat(fsys, frontendIndex)
+	return err == nil && !info.IsDir()
+}
+
+// serveFrontend serves the frontend build in fsys for requests no route
+// matched. Paths without a file extension are the frontend's own routes and
+// get index.html, so reloading a deep link works.
+func serveFrontend(fsys fs.FS) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		urlPath := c.Request.URL.Path
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead || isBackendPath(urlPath) {
+			abortWithError(c, http.StatusNotFound, "Not found")
+			return
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+		if name == "" {
+			name = frontendIndex
+		}
+		info, err := fs.Stat(fsys, name)
+		if err != nil || info.IsDir() {
+			if path.Ext(name) != "" {
+				// A missing bundle or image, not a page
+				abortWithError(c, http.StatusNotFound, "Not found")
+				return
+			}
+			name = frontendIndex
+			if info, err = fs.Stat(fsys, name); err != nil {
+				abortWithError(c, http.StatusNotFound, "Not found")
+				return
+			}
+		}
+
+		file, err := fsys.Open(name)
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "Error serving the frontend")
+			return
+		}
+		defer file.Close()
+		content, ok := file.(io.ReadSeeker)
+		if !ok {
+			data, err := io.ReadAll(file)
+			if err != nil {
+				abortWithError(c, http.StatusInternalServerError, "Error serving the frontend")
+				return
+			}
+			content = bytes.NewReader(data)
+		}
+
+		if strings.HasPrefix(name, frontendAssets) {
+			c.Header("Cache-Control", immutableCaching)
+		} else {
+			c.Header("Cache-Control", revalidateCaching)
+		}
+		http.ServeContent(c.Writer, c.Request, name, info.ModTime(), content)
+	}
+}
+
+func isBackendPath(urlPath string) bool {
+	for _, prefix := range backendPrefixes {
+		if strings.HasPrefix(urlPath, prefix) || urlPath == strings.TrimSuffix(prefix, "/") {
+			return true
+		}
+	}
+	return false
+}
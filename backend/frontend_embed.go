@@ -0,0 +1,22 @@
+//go:build embedfrontend
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// Copy the frontend's production build into web/dist before building with
+// -tags embedfrontend.
+//
+//go:embed all:web/dist
+var embeddedFrontend embed.FS
+
+func init() {
+	dist, err := fs.Sub(embeddedFrontend, "web/dist")
+	if err != nil {
+		panic(err)
+	}
+	frontendFiles = dist
+}
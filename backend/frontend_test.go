@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"containerized-go-app/notification"
+	"containerized-go-app/store"
+)
+
+func TestFrontendServing(t *testing.T) {
+	srv := NewServer(store.NewMemory(), notification.New(1), nil)
+	srv.frontend = fstest.MapFS{
+		"index.html":         {Data: []byte("<div id=root></div>")},
+		"assets/app-1a2b.js": {Data: []byte("console.log(1)")},
+		"favicon.ico":        {Data: []byte("icon")},
+	}
+	handler := srv.Router(newProfiler(profilerWindow))
+
+	for _, tc := range []struct {
+		method, path string
+		status       int
+		body, cache  string
+	}{
+		{http.MethodGet, "/", http.StatusOK, "<div id=root></div>", revalidateCaching},
+		{http.MethodGet, "/appointments/a1", http.StatusOK, "<div id=root></div>", revalidateCaching},
+		{http.MethodGet, "/assets/app-1a2b.js", http.StatusOK, "console.log(1)", immutableCaching},
+		{http.MethodGet, "/favicon.ico", http.StatusOK, "icon", revalidateCaching},
+		{http.MethodGet, "/assets/app-old.js", http.StatusNotFound, "", ""},
+		{http.MethodGet, "/api/unknown", http.StatusNotFound, "", ""},
+		{http.MethodPost, "/appointments", http.StatusNotFound, "", ""},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+		if rec.Code != tc.status {
+			t.Errorf("%s %s: status %d, want %d", tc.method, tc.path, rec.Code, tc.status)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+				t.Errorf("%s %s: 404 content type %q, want JSON", tc.method, tc.path, ct)
+			}
+			continue
+		}
+		if rec.Body.String() != tc.body {
+			t.Errorf("%s %s: body %q, want %q", tc.method, tc.path, rec.Body.String(), tc.body)
+		}
+		if cache := rec.Header().Get("Cache-Control"); cache != tc.cache {
+			t.Errorf("%s %s: Cache-Control %q, want %q", tc.method, tc.path, cache, tc.cache)
+		}
+	}
+
+	// The API keeps working alongside the frontend
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("healthz: status %d", rec.Code)
+	}
+}
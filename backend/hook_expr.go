@@ -0,0 +1,319 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Hook conditions are Go expressions restricted to literals, the event's
+// fields, comparisons, && || ! and a few functions. Numbers are whole.
+// There are no loops, assignments or calls out of this file, so a
+// condition can only read the event and always finishes quickly.
+
+const (
+	maxConditionLength = 1000
+	maxConditionNodes  = 200
+)
+
+// hookEnv is what a condition can read: hookFields[root][field] values of
+// the event.
+type hookEnv map[string]map[string]interface{}
+
+// hookFields lists the fields conditions can use, with a zero value of
+// each field's type.
+var hookFields = hookEnv{
+	"appointment": {
+		"id": "", "doctorId": "", "notes": "", "queue": "", "partnerId": "",
+		// Local hour and weekday, 0 for Sunday
+		"hour": int64(0), "weekday": int64(0),
+	},
+	"patient": {
+		"id": "", "tags": []string{}, "hasInsurance": false,
+		// Age is -1 without a date of birth; visits counts completed ones
+		"age": int64(0), "visits": int64(0),
+	},
+	"doctor": {
+		"id": "", "specialization": "",
+	},
+}
+
+// hookFuncs are the functions conditions can call.
+var hookFuncs = map[string]func(args []interface{}) (interface{}, error){
+	"contains": func(args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, errors.New("contains takes a list or string and a string")
+		}
+		sub, ok := args[1].(string)
+		if !ok {
+			return nil, errors.New("contains of a non-string")
+		}
+		switch in := args[0].(type) {
+		case []string:
+			return slices.Contains(in, sub), nil
+		case string:
+			return strings.Contains(strings.ToLower(in), strings.ToLower(sub)), nil
+		}
+		return nil, errors.New("contains takes a list or string")
+	},
+	"hasPrefix": func(args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, errors.New("hasPrefix takes two strings")
+		}
+		s, ok1 := args[0].(string)
+		prefix, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, errors.New("hasPrefix takes two strings")
+		}
+		return strings.HasPrefix(s, prefix), nil
+	},
+	"len": func(args []interface{}) (interface{}, error) {
+		if len(args) == 1 {
+			switch v := args[0].(type) {
+			case []string:
+				return int64(len(v)), nil
+			case string:
+				return int64(len([]rune(v))), nil
+			}
+		}
+		return nil, errors.New("len takes a list or string")
+	},
+}
+
+// compileCondition parses a condition and checks it only uses what
+// conditions may, and that it is a boolean over the event's fields.
+func compileCondition(src string) (ast.Expr, error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, nil
+	}
+	if len(src) > maxConditionLength {
+		return nil, fmt.Errorf("must be at most %d characters", maxConditionLength)
+	}
+	expr, err := parser.ParseExpr(src)
+	if err != nil {
+		return nil, errors.New("is not a valid expression")
+	}
+
+	nodes := 0
+	if err := checkCondition(expr, &nodes); err != nil {
+		return nil, err
+	}
+
+	// Every field has a fixed type, so evaluating over the zero values
+	// finds the type errors
+	value, err := evalCondition(expr, hookFields)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := value.(bool); !ok {
+		return nil, errors.New("must be true or false, e.g. a comparison")
+	}
+	return expr, nil
+}
+
+// checkCondition reports the first thing in expr conditions can't use,
+// counting the nodes to bound the work of evaluating it.
+func checkCondition(expr ast.Expr, nodes *int) error {
+	if *nodes++; *nodes > maxConditionNodes {
+		return errors.New("is too long")
+	}
+	switch n := expr.(type) {
+	case *ast.BasicLit:
+		if n.Kind != token.INT && n.Kind != token.STRING {
+			return fmt.Errorf("can't use %s", n.Value)
+		}
+	case *ast.Ident:
+		if n.Name != "true" && n.Name != "false" {
+			return fmt.Errorf("has no field %s; use e.g. patient.%s", n.Name, n.Name)
+		}
+	case *ast.SelectorExpr:
+		root, ok := n.X.(*ast.Ident)
+		if !ok {
+			return errors.New("can only read fields like patient.tags")
+		}
+		if _, ok := hookFields[root.Name][n.Sel.Name]; !ok {
+			return fmt.Errorf("has no field %s.%s", root.Name, n.Sel.Name)
+		}
+	case *ast.ParenExpr:
+		return checkCondition(n.X, nodes)
+	case *ast.UnaryExpr:
+		if n.Op != token.NOT && n.Op != token.SUB {
+			return fmt.Errorf("can't use %s", n.Op)
+		}
+		return checkCondition(n.X, nodes)
+	case *ast.BinaryExpr:
+		switch n.Op {
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ, token.LAND, token.LOR:
+		default:
+			return fmt.Errorf("can't use %s", n.Op)
+		}
+		if err := checkCondition(n.X, nodes); err != nil {
+			return err
+		}
+		return checkCondition(n.Y, nodes)
+	case *ast.CallExpr:
+		fn, ok := n.Fun.(*ast.Ident)
+		if !ok || hookFuncs[fn.Name] == nil || n.Ellipsis.IsValid() {
+			return errors.New("can only call contains, hasPrefix and len")
+		}
+		for _, arg := range n.Args {
+			if err := checkCondition(arg, nodes); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New("can only use fields, literals, comparisons, && || ! and contains, hasPrefix and len")
+	}
+	return nil
+}
+
+// conditionHolds evaluates a compiled condition; nil always holds.
+func conditionHolds(expr ast.Expr, env hookEnv) (bool, error) {
+	if expr == nil {
+		return true, nil
+	}
+	value, err := evalCondition(expr, env)
+	if err != nil {
+		return false, err
+	}
+	holds, ok := value.(bool)
+	if !ok {
+		return false, errors.New("condition isn't true or false")
+	}
+	return holds, nil
+}
+
+func evalCondition(expr ast.Expr, env hookEnv) (interface{}, error) {
+	switch n := expr.(type) {
+	case *ast.BasicLit:
+		if n.Kind == token.INT {
+			return strconv.ParseInt(n.Value, 0, 64)
+		}
+		return strconv.Unquote(n.Value)
+	case *ast.Ident:
+		return n.Name == "true", nil
+	case *ast.SelectorExpr:
+		return env[n.X.(*ast.Ident).Name][n.Sel.Name], nil
+	case *ast.ParenExpr:
+		return evalCondition(n.X, env)
+	case *ast.UnaryExpr:
+		value, err := evalCondition(n.X, env)
+		if err != nil {
+			return nil, err
+		}
+		if n.Op == token.SUB {
+			i, ok := value.(int64)
+			if !ok {
+				return nil, errors.New("- needs a number")
+			}
+			return -i, nil
+		}
+		b, ok := value.(bool)
+		if !ok {
+			return nil, errors.New("! needs true or false")
+		}
+		return !b, nil
+	case *ast.CallExpr:
+		args := make([]interface{}, len(n.Args))
+		for i, arg := range n.Args {
+			value, err := evalCondition(arg, env)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = value
+		}
+		return hookFuncs[n.Fun.(*ast.Ident).Name](args)
+	case *ast.BinaryExpr:
+		return evalBinary(n, env)
+	}
+	return nil, fmt.Errorf("can't evaluate %T", expr)
+}
+
+func evalBinary(n *ast.BinaryExpr, env hookEnv) (interface{}, error) {
+	x, err := evalCondition(n.X, env)
+	if err != nil {
+		return nil, err
+	}
+	if n.Op == token.LAND || n.Op == token.LOR {
+		left, ok := x.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s needs true or false", n.Op)
+		}
+		// Both sides are checked even when the left decides, so type
+		// errors on the right don't hide until the left changes
+		y, err := evalCondition(n.Y, env)
+		if err != nil {
+			return nil, err
+		}
+		right, ok := y.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s needs true or false", n.Op)
+		}
+		if n.Op == token.LAND {
+			return left && right, nil
+		}
+		return left || right, nil
+	}
+
+	y, err := evalCondition(n.Y, env)
+	if err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case int64:
+		y, ok := y.(int64)
+		if !ok {
+			break
+		}
+		switch n.Op {
+		case token.EQL:
+			return x == y, nil
+		case token.NEQ:
+			return x != y, nil
+		case token.LSS:
+			return x < y, nil
+		case token.LEQ:
+			return x <= y, nil
+		case token.GTR:
+			return x > y, nil
+		case token.GEQ:
+			return x >= y, nil
+		}
+	case string:
+		y, ok := y.(string)
+		if !ok {
+			break
+		}
+		switch n.Op {
+		case token.EQL:
+			return x == y, nil
+		case token.NEQ:
+			return x != y, nil
+		case token.LSS:
+			return x < y, nil
+		case token.LEQ:
+			return x <= y, nil
+		case token.GTR:
+			return x > y, nil
+		case token.GEQ:
+			return x >= y, nil
+		}
+	case bool:
+		y, ok := y.(bool)
+		if !ok {
+			break
+		}
+		switch n.Op {
+		case token.EQL:
+			return x == y, nil
+		case token.NEQ:
+			return x != y, nil
+		}
+	}
+	return nil, fmt.Errorf("can't compare with %s there", n.Op)
+}
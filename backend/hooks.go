@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	Hook       = store.Hook
+	HookAction = store.HookAction
+)
+
+// Events hooks can be attached to.
+const hookAppointmentCreated = "appointment.created"
+
+// Hook action types.
+const (
+	hookActionTag  = "tag"
+	hookActionNote = "note"
+)
+
+// hookEnvFor is what conditions see of an appointment and its patient.
+func (s *Server) hookEnvFor(ctx context.Context, appointment Appointment, patient Patient) (hookEnv, error) {
+	doctor, err := s.findDoctor(ctx, appointment.DoctorID)
+	if err != nil {
+		return nil, err
+	}
+	filter := store.AppointmentFilter{PatientID: patient.ID, Statuses: []string{AppointmentCompleted}, IncludeArchived: true}
+	_, visits, err := s.store.Appointments.List(ctx, filter, store.Page{Limit: 1})
+	if err != nil {
+		return nil, err
+	}
+
+	start := appointment.StartTime.In(calendarLocation)
+	age := int64(-1)
+	if born, err := time.Parse(dateLayout, patient.DateOfBirth); err == nil {
+		now := time.Now().In(calendarLocation)
+		age = int64(now.Year() - born.Year())
+		if now.Month() < born.Month() || now.Month() == born.Month() && now.Day() < born.Day() {
+			age--
+		}
+	}
+	tags := patient.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return hookEnv{
+		"appointment": {
+			"id": appointment.ID, "doctorId": appointment.DoctorID, "notes": appointment.Notes,
+			"queue": appointment.Queue, "partnerId": appointment.PartnerID,
+			"hour": int64(start.Hour()), "weekday": int64(start.Weekday()),
+		},
+		"patient": {
+			"id": patient.ID, "tags": tags, "hasInsurance": patient.InsuranceNumber != "",
+			"age": age, "visits": visits,
+		},
+		"doctor": {
+			"id": doctor.ID, "specialization": doctor.Specialization,
+		},
+	}, nil
+}
+
+// runHooks runs the enabled hooks of event for the appointment and returns
+// the patient with their changes. A failing hook is logged and skipped;
+// it never fails what triggered it.
+func (s *Server) runHooks(ctx context.Context, event string, appointment Appointment, patient Patient) Patient {
+	hooks, err := s.store.Hooks.List(ctx)
+	if err != nil {
+		log.Printf("Loading %s hooks failed: %v", event, err)
+		return patient
+	}
+	hooks = slices.DeleteFunc(hooks, func(hook Hook) bool { return hook.Disabled || hook.Event != event })
+	if len(hooks) == 0 {
+		return patient
+	}
+	env, err := s.hookEnvFor(ctx, appointment, patient)
+	if err != nil {
+		log.Printf("Preparing %s hooks for appointment %s failed: %v", event, appointment.ID, err)
+		return patient
+	}
+
+	for _, hook := range hooks {
+		expr, err := compileCondition(hook.Condition)
+		if err != nil {
+			log.Printf("Hook %s has an invalid condition: %v", hook.ID, err)
+			continue
+		}
+		holds, err := conditionHolds(expr, env)
+		if err != nil {
+			log.Printf("Evaluating hook %s for appointment %s failed: %v", hook.ID, appointment.ID, err)
+			continue
+		} else if !holds {
+			continue
+		}
+		for _, action := range hook.Actions {
+			if err := s.runHookAction(ctx, hook, action, &patient); err != nil {
+				log.Printf("Hook %s failed to %s patient %s: %v", hook.ID, action.Type, patient.ID, err)
+			}
+		}
+		// Later hooks see the tags this one added
+		env["patient"]["tags"] = slices.Clone(patient.Tags)
+	}
+	return patient
+}
+
+func (s *Server) runHookAction(ctx context.Context, hook Hook, action HookAction, patient *Patient) error {
+	switch action.Type {
+	case hookActionTag:
+		if slices.Contains(patient.Tags, action.Value) {
+			return nil
+		}
+		tags := append(slices.Clone(patient.Tags), action.Value)
+		if err := s.store.Patients.SetTags(ctx, patient.ID, tags); err != nil {
+			return err
+		}
+		patient.Tags = tags
+	case hookActionNote:
+		note := PatientNote{Text: action.Value, Author: "hook:" + hook.ID, CreatedAt: time.Now().UTC()}
+		if err := s.store.Patients.AddNote(ctx, patient.ID, note); err != nil {
+			return err
+		}
+		patient.Notes = append(patient.Notes, note)
+	}
+	return nil
+}
+
+func (s *Server) GetHooks(c *gin.Context) {
+	hooks, err := s.store.Hooks.List(c.Request.Context())
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving hooks")
+		return
+	}
+	c.JSON(http.StatusOK, hooks)
+}
+
+// PutHook creates or replaces a hook. The condition is checked here, so a
+// saved hook only fails at run time on store errors.
+func (s *Server) PutHook(c *gin.Context) {
+	var hook Hook
+	if !bindJSON(c, &hook) {
+		return
+	}
+	if _, err := compileCondition(hook.Condition); err != nil {
+		abortWithDetails(c, fieldError{Field: "condition", Message: err.Error()})
+		return
+	}
+	user, _ := currentUser(c)
+	hook.ID = c.Param("hookID")
+	hook.UpdatedBy = user.Username
+	hook.UpdatedAt = time.Now().UTC()
+
+	if err := s.store.Hooks.Put(c.Request.Context(), hook); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error saving hook")
+		return
+	}
+	c.JSON(http.StatusOK, hook)
+}
+
+func (s *Server) DeleteHook(c *gin.Context) {
+	err := s.store.Hooks.Delete(c.Request.Context(), c.Param("hookID"))
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, "Hook not found")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error deleting hook")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Hook deleted"})
+}
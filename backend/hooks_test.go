@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCompileCondition(t *testing.T) {
+	env := hookEnv{
+		"appointment": {"id": "a1", "doctorId": "d1", "notes": "Follow-up on X-ray", "queue": "", "partnerId": "", "hour": int64(8), "weekday": int64(1)},
+		"patient":     {"id": "p1", "tags": []string{"diabetic"}, "hasInsurance": true, "age": int64(70), "visits": int64(12)},
+		"doctor":      {"id": "d1", "specialization": "cardiology"},
+	}
+	for _, tc := range []struct {
+		src   string
+		holds bool
+	}{
+		{``, true},
+		{`patient.visits >= 10 && !contains(patient.tags, "vip")`, true},
+		{`patient.age > 65 || appointment.hour < 9`, true},
+		{`doctor.specialization == "dermatology"`, false},
+		{`contains(appointment.notes, "x-ray") && len(patient.tags) == 1`, true},
+		{`hasPrefix(appointment.doctorId, "d") && patient.hasInsurance`, true},
+		{`patient.age != -1`, true},
+	} {
+		expr, err := compileCondition(tc.src)
+		if err != nil {
+			t.Errorf("compileCondition(%q) = %v", tc.src, err)
+			continue
+		}
+		if holds, err := conditionHolds(expr, env); err != nil || holds != tc.holds {
+			t.Errorf("%q holds = %t, %v; want %t", tc.src, holds, err, tc.holds)
+		}
+	}
+
+	for _, src := range []string{
+		`patient.visits`,                // not a boolean
+		`patient.name == "x"`,           // unknown field
+		`visits > 3`,                    // bare identifier
+		`patient.visits > "3"`,          // mixed types
+		`patient.age * 2 > 3`,           // arithmetic
+		`func() bool { return true }()`, // function literal
+		`os.Exit(1) == nil`,             // not a hook function
+		`contains(patient.tags, 3)`,     // wrong argument
+		`patient.tags[0] == "vip"`,      // indexing
+		`1.5 > patient.age`,             // floats
+		`patient.visits > 3 && (`,       // syntax error
+	} {
+		if _, err := compileCondition(src); err == nil {
+			t.Errorf("compileCondition(%q) succeeded, want an error", src)
+		}
+	}
+}
+
+func TestHookTagsPatientOnBooking(t *testing.T) {
+	f := newBookingFixture(t)
+	ctx := context.Background()
+	admin := User{Username: "root", Role: RoleAdmin}
+
+	hook := gin.H{
+		"event":     "appointment.created",
+		"condition": `patient.visits >= 1 && !contains(patient.tags, "vip")`,
+		"actions":   []gin.H{{"type": "tag", "value": "vip"}, {"type": "note", "value": "Offer the returning-patient lounge"}},
+	}
+	decode[Hook](t, f.do(t, http.MethodPut, "/api/admin/hooks/vip", admin, hook), http.StatusOK)
+	bad := gin.H{"event": "appointment.created", "condition": `patient.visits`, "actions": hook["actions"]}
+	if rec := f.do(t, http.MethodPut, "/api/admin/hooks/bad", admin, bad); rec.Code != http.StatusBadRequest {
+		t.Errorf("boolean-less condition status = %d, want 400", rec.Code)
+	}
+
+	past := f.first.AddDate(0, 0, -30)
+	visit := Appointment{ID: "past", PatientID: f.bob.ProfileID, DoctorID: f.doctor.ID, StartTime: past, EndTime: past.Add(slotDuration), Status: AppointmentCompleted}
+	if err := f.store.Appointments.Create(ctx, visit); err != nil {
+		t.Fatal(err)
+	}
+
+	decode[bookingResponse](t, f.book(t, f.alice, f.first), http.StatusOK)
+	if alice, _ := f.findPatient(ctx, f.alice.ProfileID); slices.Contains(alice.Tags, "vip") {
+		t.Errorf("first-time patient tags = %v, want no vip", alice.Tags)
+	}
+
+	resp := decode[struct {
+		Tags []string `json:"tags"`
+	}](t, f.do(t, http.MethodPost, "/api/patients/p-bob/appointments", admin, gin.H{"doctorId": f.doctor.ID, "startTime": f.second}), http.StatusOK)
+	if !slices.Contains(resp.Tags, "vip") {
+		t.Errorf("booking response tags = %v, want vip", resp.Tags)
+	}
+	bob, err := f.findPatient(ctx, f.bob.ProfileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(bob.Tags, "vip") || len(bob.Notes) != 1 || bob.Notes[0].Author != "hook:vip" {
+		t.Errorf("patient = %+v, want the vip tag and the hook's note", bob)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"containerized-go-app/notification"
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type (
+	InventoryItem  = store.InventoryItem
+	InventoryUsage = store.InventoryUsage
+)
+
+type suppliesRequest struct {
+	ItemID   string `json:"itemId" binding:"required"`
+	Quantity int    `json:"quantity" binding:"required,min=1,max=1000"`
+}
+
+// GetInventory lists the inventory items; ?low=true keeps only those at or
+// below their reorder level.
+func (s *Server) GetInventory(c *gin.Context) {
+	items, err := s.store.Inventory.List(c.Request.Context())
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving inventory")
+		return
+	}
+	if c.Query("low") == "true" {
+		low := []InventoryItem{}
+		for _, item := range items {
+			if item.Low() {
+				low = append(low, item)
+			}
+		}
+		items = low
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+// PutInventoryItem creates or replaces an item, e.g. to record a delivery
+// by setting its new stock.
+func (s *Server) PutInventoryItem(c *gin.Context) {
+	var item InventoryItem
+	if !bindJSON(c, &item) {
+		return
+	}
+	item.ID = c.Param("id")
+	item.UpdatedAt = time.Now().UTC()
+	if err := s.store.Inventory.Put(c.Request.Context(), item); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error saving inventory item")
+		return
+	}
+	c.JSON(http.StatusOK, item)
+}
+
+// RecordAppointmentSupplies takes items used during a visit out of stock.
+// Only the appointment's doctor may record them. Staff are alerted when the
+// item drops to its reorder level.
+func (s *Server) RecordAppointmentSupplies(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req suppliesRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	appointment, err := s.findAppointment(ctx, c.Param("id"), c.Param("appointmentID"))
+	if err != nil {
+		writeAppointmentError(c, err, "Error retrieving appointment")
+		return
+	}
+	user, _ := currentUser(c)
+	if user.ProfileID != appointment.DoctorID {
+		abortWithError(c, http.StatusForbidden, "Only the appointment's doctor can record supplies used")
+		return
+	}
+
+	usage := InventoryUsage{
+		ID:            primitive.NewObjectID().Hex(),
+		ItemID:        req.ItemID,
+		AppointmentID: appointment.ID,
+		Quantity:      req.Quantity,
+		RecordedBy:    user.Username,
+		RecordedAt:    time.Now().UTC(),
+	}
+	item, err := s.store.Inventory.Consume(ctx, usage)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		abortWithDetails(c, fieldError{Field: "itemId", Message: "must be an existing inventory item"})
+		return
+	case errors.Is(err, store.ErrInsufficientStock):
+		abortWithCode(c, http.StatusConflict, "insufficient_stock", fmt.Sprintf("Not enough %s in stock", req.ItemID))
+		return
+	case err != nil:
+		abortWithError(c, http.StatusInternalServerError, "Error recording supplies")
+		return
+	}
+
+	// Only alert when this usage crossed the reorder level, not on every
+	// use of an item already waiting to be restocked
+	if item.Low() && item.Stock+req.Quantity > item.ReorderLevel {
+		s.notifier.Notify(notification.Event{
+			Kind: notification.LowStock,
+			Item: &notification.StockLevel{
+				ItemID:       item.ID,
+				Name:         item.Name,
+				Unit:         item.Unit,
+				Stock:        item.Stock,
+				ReorderLevel: item.ReorderLevel,
+			},
+		})
+	}
+	c.JSON(http.StatusCreated, gin.H{"usage": usage, "item": item})
+}
+
+// GetAppointmentSupplies lists the items used during an appointment.
+func (s *Server) GetAppointmentSupplies(c *gin.Context) {
+	ctx := c.Request.Context()
+	appointment, err := s.findAppointment(ctx, c.Param("id"), c.Param("appointmentID"))
+	if err != nil {
+		writeAppointmentError(c, err, "Error retrieving appointment")
+		return
+	}
+	usage, err := s.store.Inventory.Usage(ctx, appointment.ID)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving supplies")
+		return
+	}
+	c.JSON(http.StatusOK, usage)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"containerized-go-app/notification"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRecordAppointmentSupplies(t *testing.T) {
+	f := newBookingFixture(t)
+	admin := User{Username: "root", Role: RoleAdmin}
+	doctor := User{Username: "grey", Role: RoleDoctor, ProfileID: f.doctor.ID}
+	booked := decode[bookingResponse](t, f.book(t, f.alice, f.first), http.StatusOK).Appointment
+
+	// Only events from here on are about stock
+	sent := make(captureSender, 10)
+	f.notifier = notification.New(10, sent)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.notifier.Run(ctx)
+
+	decode[InventoryItem](t, f.do(t, http.MethodPut, "/api/admin/inventory/gloves", admin, gin.H{
+		"name": "Nitrile gloves", "unit": "pair", "stock": 5, "reorderLevel": 2,
+	}), http.StatusOK)
+
+	path := "/api/patients/p-alice/appointments/" + booked.ID + "/supplies"
+	decode[apiError](t, f.do(t, http.MethodPost, path, f.alice, gin.H{"itemId": "gloves", "quantity": 1}), http.StatusForbidden)
+	decode[apiError](t, f.do(t, http.MethodPost, path, doctor, gin.H{"itemId": "masks", "quantity": 1}), http.StatusBadRequest)
+	resp := decode[apiError](t, f.do(t, http.MethodPost, path, doctor, gin.H{"itemId": "gloves", "quantity": 6}), http.StatusConflict)
+	if resp.Code != "insufficient_stock" {
+		t.Errorf("code = %q, want insufficient_stock", resp.Code)
+	}
+
+	// 5 -> 3 stays above the reorder level, 3 -> 1 crosses it, 1 -> 0 is
+	// already low
+	for _, quantity := range []int{2, 2, 1} {
+		decode[struct{}](t, f.do(t, http.MethodPost, path, doctor, gin.H{"itemId": "gloves", "quantity": quantity}), http.StatusCreated)
+	}
+	select {
+	case event := <-sent:
+		if event.Kind != notification.LowStock || event.Item == nil || event.Item.Stock != 1 {
+			t.Errorf("event = %+v, want a low-stock alert at 1 pair", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no low-stock event sent")
+	}
+	select {
+	case event := <-sent:
+		t.Errorf("unexpected second event %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	usage := decode[[]InventoryUsage](t, f.do(t, http.MethodGet, path, admin, nil), http.StatusOK)
+	if len(usage) != 3 || usage[0].RecordedBy != "grey" {
+		t.Errorf("usage = %+v, want three entries by grey", usage)
+	}
+	low := decode[[]InventoryItem](t, f.do(t, http.MethodGet, "/api/inventory?low=true", doctor, nil), http.StatusOK)
+	if len(low) != 1 || low[0].Stock != 0 {
+		t.Errorf("low items = %+v, want gloves out of stock", low)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	unixPrefix = "unix:"
+	// socketMode lets a reverse proxy in the same group connect
+	socketMode = 0o660
+)
+
+// listener is an address and the routes served on it.
+type listener struct {
+	addr    string
+	handler http.Handler
+}
+
+// parseListenAddrs splits a comma-separated list of addresses. Each is a
+// TCP address like :3000 or 127.0.0.1:9000, or unix:/path/to.sock.
+func parseListenAddrs(value string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(value, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// listeners decides what is served where. Everything is served on
+// LISTEN_ADDRS, or on PORT when that's unset, unless ADMIN_LISTEN_ADDRS
+// gives the admin routes, diagnostics and reports listeners of their own;
+// then the public listeners leave them out, so the admin surface can be
+// firewalled apart from patient traffic.
+func (s *Server) listeners(prof *profiler, public, admin []string) []listener {
+	var out []listener
+	if len(admin) == 0 {
+		routes := s.Router(prof)
+		for _, addr := range public {
+			out = append(out, listener{addr, routes})
+		}
+		return out
+	}
+
+	publicRoutes, adminRoutes := s.PublicRouter(prof), s.AdminRouter(prof)
+	for _, addr := range public {
+		out = append(out, listener{addr, publicRoutes})
+	}
+	for _, addr := range admin {
+		out = append(out, listener{addr, adminRoutes})
+	}
+	return out
+}
+
+// listen opens addr, replacing a socket file left behind by an unclean
+// exit.
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixPrefix)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and isn't a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, socketMode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// serve opens every listener before serving any, so a bad address stops
+// the server at start. Shut the returned servers down to stop.
+func serve(listeners []listener) ([]*http.Server, error) {
+	opened := make([]net.Listener, 0, len(listeners))
+	for _, l := range listeners {
+		ln, err := listen(l.addr)
+		if err != nil {
+			for _, ln := range opened {
+				ln.Close()
+			}
+			return nil, fmt.Errorf("listen on %s: %w", l.addr, err)
+		}
+		opened = append(opened, ln)
+	}
+
+	servers := make([]*http.Server, len(listeners))
+	for i, l := range listeners {
+		server := &http.Server{
+			Handler:           l.handler,
+			ReadHeaderTimeout: readHeaderTimeout,
+		}
+		servers[i] = server
+		ln := opened[i]
+		log.Printf("Listening on %s", l.addr)
+		go func() {
+			if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+				log.Fatal("Server error: ", err)
+			}
+		}()
+	}
+	return servers, nil
+}
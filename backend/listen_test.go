@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"containerized-go-app/notification"
+	"containerized-go-app/store"
+)
+
+func TestSeparateAdminListener(t *testing.T) {
+	srv := NewServer(store.NewMemory(), notification.New(1), nil)
+	prof := newProfiler(profilerWindow)
+	listeners := srv.listeners(prof, []string{":3000"}, []string{"127.0.0.1:9000"})
+	if len(listeners) != 2 {
+		t.Fatalf("got %d listeners, want 2", len(listeners))
+	}
+	public, admin := listeners[0].handler, listeners[1].handler
+
+	token, _, err := srv.startSession(context.Background(), User{Username: "root", Role: RoleAdmin}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(handler http.Handler, path string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for _, tc := range []struct {
+		name    string
+		handler http.Handler
+		path    string
+		status  int
+	}{
+		{"public doctors", public, "/api/doctors", http.StatusOK},
+		{"public admin", public, "/api/admin/diagnostics", http.StatusNotFound},
+		{"admin diagnostics", admin, "/api/admin/diagnostics", http.StatusOK},
+		{"admin doctors", admin, "/api/doctors", http.StatusNotFound},
+		{"admin healthz", admin, "/healthz", http.StatusOK},
+	} {
+		if status := get(tc.handler, tc.path); status != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.name, status, tc.status)
+		}
+	}
+
+	// Without an admin address everything shares the public listeners
+	listeners = srv.listeners(prof, []string{":3000", ":3001"}, nil)
+	if len(listeners) != 2 || get(listeners[1].handler, "/api/admin/diagnostics") != http.StatusOK {
+		t.Error("single surface doesn't serve the admin routes")
+	}
+}
+
+func TestUnixSocketListener(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clinic.sock")
+	// A socket left by a previous run is replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skip("unix sockets unavailable: ", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	srv := NewServer(store.NewMemory(), notification.New(1), nil)
+	servers, err := serve(srv.listeners(newProfiler(profilerWindow), []string{unixPrefix + path}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer servers[0].Close()
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != socketMode {
+		t.Errorf("socket mode %v, want %v", info.Mode().Perm(), os.FileMode(socketMode))
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://clinic/api/doctors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status %d, want 200", resp.StatusCode)
+	}
+
+	// A regular file in the way isn't removed
+	file := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(file, []byte("keep"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := serve([]listener{{unixPrefix + file, http.NotFoundHandler()}}); err == nil {
+		t.Error("listened over a regular file")
+	}
+}
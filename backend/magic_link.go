@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"containerized-go-app/notification"
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// magicLinkAudience keeps sign-in link tokens and bearer tokens apart, since
+// both are signed with jwtSecret.
+const magicLinkAudience = "magic-link"
+
+// magicLinkWindow is the window over which link requests are rate limited.
+const magicLinkWindow = 15 * time.Minute
+
+var (
+	magicLinkTTL = 15 * time.Minute
+	// magicLinkURL is the frontend page that signs in with the token query
+	// parameter.
+	magicLinkURL = "http://localhost:3000/magic-login"
+)
+
+type magicLinkRequest struct {
+	Username string `json:"username" binding:"required"`
+	// DeviceID identifies the browser or app asking for the link; the link
+	// only signs in from the same device.
+	DeviceID string `json:"deviceId" binding:"required,min=16,max=128"`
+}
+
+type magicLinkVerifyRequest struct {
+	Token    string `json:"token" binding:"required"`
+	DeviceID string `json:"deviceId" binding:"required,min=16,max=128"`
+}
+
+// RequestMagicLink emails a single-use sign-in link to a patient. The
+// response is the same whether or not a link was sent, so it doesn't reveal
+// which usernames exist.
+func (s *Server) RequestMagicLink(c *gin.Context) {
+	var req magicLinkRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if !s.notifier.Enabled() {
+		abortWithError(c, http.StatusServiceUnavailable, "Sign-in links are not available")
+		return
+	}
+
+	now := time.Now()
+	if !s.magicLinkIPLimit.allow(c.ClientIP(), now) || !s.magicLinkUserLimit.allow(req.Username, now) {
+		c.Header("Retry-After", "900")
+		abortWithError(c, http.StatusTooManyRequests, "Too many sign-in link requests, try again later")
+		return
+	}
+
+	if err := s.sendMagicLink(c, req, now); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error sending sign-in link")
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the account can sign in by email, a link is on its way"})
+}
+
+// sendMagicLink issues and sends a link when req names a patient account
+// with an email address, and does nothing otherwise.
+func (s *Server) sendMagicLink(c *gin.Context, req magicLinkRequest, now time.Time) error {
+	ctx := c.Request.Context()
+	user, err := s.store.Users.FindByUsername(ctx, req.Username)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Staff accounts keep to passwords
+	if (user.Role != "" && user.Role != RolePatient) || user.Email == "" {
+		return nil
+	}
+
+	link := store.MagicLink{ID: primitive.NewObjectID().Hex(), Username: user.Username, DeviceHash: hashDevice(req.DeviceID), ExpiresAt: now.Add(magicLinkTTL)}
+	claims := jwt.RegisteredClaims{
+		ID:        link.ID,
+		Subject:   link.Username,
+		Audience:  jwt.ClaimStrings{magicLinkAudience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(link.ExpiresAt),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+	if err != nil {
+		return err
+	}
+	if err := s.store.MagicLinks.Create(ctx, link); err != nil {
+		return err
+	}
+
+	event := notification.Event{
+		Kind:          notification.MagicLink,
+		PatientID:     user.ProfileID,
+		PatientEmail:  user.Email,
+		Link:          magicLinkURL + "?token=" + url.QueryEscape(token),
+		LinkExpiresAt: &link.ExpiresAt,
+	}
+	if patient, err := s.store.Patients.Get(ctx, user.ProfileID); err == nil {
+		event.PatientName = patient.PName
+		event.Consents = patient.ActiveConsents(now)
+	}
+	s.notifier.Notify(event)
+	return nil
+}
+
+// VerifyMagicLink signs in with a link token from the device that asked for
+// it. A token is used up by its first verification, successful or not.
+func (s *Server) VerifyMagicLink(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req magicLinkVerifyRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	var claims jwt.RegisteredClaims
+	_, err := jwt.ParseWithClaims(req.Token, &claims, func(*jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(magicLinkAudience), jwt.WithExpirationRequired())
+	if err != nil || claims.ID == "" {
+		abortWithError(c, http.StatusUnauthorized, "Invalid or expired sign-in link")
+		return
+	}
+
+	link, err := s.store.MagicLinks.Consume(ctx, claims.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusUnauthorized, "Invalid or expired sign-in link")
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error verifying sign-in link")
+		return
+	}
+	if link.Username != claims.Subject || !time.Now().Before(link.ExpiresAt) {
+		abortWithError(c, http.StatusUnauthorized, "Invalid or expired sign-in link")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(link.DeviceHash), []byte(hashDevice(req.DeviceID))) != 1 {
+		log.Printf("Sign-in link for %s used from another device", link.Username)
+		abortWithError(c, http.StatusUnauthorized, "Sign-in link was requested from another device")
+		return
+	}
+
+	user, err := s.store.Users.FindByUsername(ctx, link.Username)
+	if err != nil {
+		abortWithError(c, http.StatusUnauthorized, "Invalid or expired sign-in link")
+		return
+	}
+	s.respondWithToken(c, user)
+}
+
+func hashDevice(deviceID string) string {
+	sum := sha256.Sum256([]byte(deviceID))
+	return hex.EncodeToString(sum[:])
+}
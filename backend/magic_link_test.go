@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"containerized-go-app/notification"
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// captureSender hands every event it is sent to the test.
+type captureSender chan notification.Event
+
+func (s captureSender) Send(_ context.Context, event notification.Event) error {
+	s <- event
+	return nil
+}
+
+func TestMagicLinkLogin(t *testing.T) {
+	sent := make(captureSender, 10)
+	srv := NewServer(store.NewMemory(), notification.New(10, sent), nil)
+	ts := &testServer{Server: srv, handler: srv.Router(newProfiler(profilerWindow))}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.notifier.Run(ctx)
+
+	if err := srv.store.Users.Create(ctx, User{Username: "alice", Email: "alice@example.com", Role: RolePatient, ProfileID: "p-alice"}); err != nil {
+		t.Fatal(err)
+	}
+	const device = "browser-0123456789"
+	request := func(username string) *http.Response {
+		return ts.do(t, http.MethodPost, "/api/login/magic-link", User{}, gin.H{"username": username, "deviceId": device}).Result()
+	}
+	nextToken := func() string {
+		t.Helper()
+		select {
+		case event := <-sent:
+			link, err := url.Parse(event.Link)
+			if err != nil || event.Kind != notification.MagicLink || event.PatientEmail != "alice@example.com" {
+				t.Fatalf("event = %+v, want a sign-in link for alice", event)
+			}
+			return link.Query().Get("token")
+		case <-time.After(time.Second):
+			t.Fatal("no sign-in link sent")
+			return ""
+		}
+	}
+	verify := func(token, deviceID string) int {
+		return ts.do(t, http.MethodPost, "/api/login/magic-link/verify", User{}, gin.H{"token": token, "deviceId": deviceID}).Code
+	}
+
+	// Unknown accounts get the same answer and no email
+	if resp := request("nobody"); resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("unknown user: status = %d, want 202", resp.StatusCode)
+	}
+
+	if resp := request("alice"); resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", resp.StatusCode)
+	}
+	token := nextToken()
+	login := decode[struct {
+		Token     string `json:"token"`
+		ProfileID string `json:"profileId"`
+	}](t, ts.do(t, http.MethodPost, "/api/login/magic-link/verify", User{}, gin.H{"token": token, "deviceId": device}), http.StatusOK)
+	if login.Token == "" || login.ProfileID != "p-alice" {
+		t.Errorf("login = %+v, want a token for p-alice", login)
+	}
+	if code := verify(token, device); code != http.StatusUnauthorized {
+		t.Errorf("reusing a link: status = %d, want 401", code)
+	}
+
+	// A link opened on another device is burnt
+	request("alice")
+	token = nextToken()
+	if code := verify(token, "another-device-0123"); code != http.StatusUnauthorized {
+		t.Errorf("other device: status = %d, want 401", code)
+	}
+	if code := verify(token, device); code != http.StatusUnauthorized {
+		t.Errorf("link after use from another device: status = %d, want 401", code)
+	}
+
+	// Bearer tokens aren't sign-in links
+	if code := verify(login.Token, device); code != http.StatusUnauthorized {
+		t.Errorf("bearer token as link: status = %d, want 401", code)
+	}
+
+	// Three requests per username per window
+	request("alice")
+	nextToken()
+	resp := decode[apiError](t, ts.do(t, http.MethodPost, "/api/login/magic-link", User{}, gin.H{"username": "alice", "deviceId": device}), http.StatusTooManyRequests)
+	if resp.Code != "rate_limited" {
+		t.Errorf("code = %q, want rate_limited", resp.Code)
+	}
+}
@@ -2,40 +2,76 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"containerized-go-app/notification"
+	"containerized-go-app/store"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var client *mongo.Client
+const (
+	readHeaderTimeout      = 10 * time.Second
+	defaultShutdownTimeout = 20 * time.Second
+)
+
+// The stored records live in the store package; these aliases keep the
+// handlers' names short.
+type (
+	User        = store.User
+	Doctor      = store.Doctor
+	Patient     = store.Patient
+	PatientNote = store.PatientNote
+)
 
-type User struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
-	Email    string `json:"email"`
+// XML has no bare arrays, so list responses are wrapped in a root element.
+type doctorList struct {
+	XMLName xml.Name `xml:"doctors"`
+	Doctors []Doctor `xml:"doctor"`
 }
 
-type Doctor struct {
-	ID       string   `json:"id" bson:"id"`
-	DName    string   `json:"dname" bson:"dname"`
-	Schedule []string `json:"schedule" bson:"schedule"`
+type patientList struct {
+	XMLName  xml.Name  `xml:"patients"`
+	Patients []Patient `xml:"patient"`
 }
 
-type Patient struct {
-	ID       string   `json:"id" bson:"id"`
-	PName    string   `json:"pname" bson:"pname"`
-	Schedule []string `json:"schedule" bson:"schedule"`
+// offeredFormats are the formats list and detail endpoints can render.
+// JSON comes first so it stays the default when no Accept header is sent.
+var offeredFormats = []string{gin.MIMEJSON, gin.MIMEXML}
+
+// render writes data in the format chosen from the request's Accept header.
+// xmlData replaces data for XML responses when the JSON shape has no single
+// root element; pass nil to serialize data as-is.
+func render(c *gin.Context, code int, data, xmlData interface{}) {
+	if xmlData == nil {
+		xmlData = data
+	}
+	c.Negotiate(code, gin.Negotiate{
+		Offered:  offeredFormats,
+		JSONData: data,
+		XMLData:  xmlData,
+	})
 }
 
 func main() {
+	// `main doctor` checks the configuration and dependencies, then exits
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		os.Exit(runDoctorCommand(context.Background()))
+	}
+
 	// Read environment variables
 	dbBaseURL := os.Getenv("DB_BASE_URL")
 	port := os.Getenv("PORT")
@@ -50,19 +86,118 @@ func main() {
 		port = "3000" // Default port if not provided
 	}
 
+	jwtSecret = []byte(os.Getenv("JWT_SECRET"))
+	if len(jwtSecret) == 0 {
+		log.Fatal("JWT_SECRET environment variable not set")
+	}
+	if ttl := os.Getenv("JWT_TTL"); ttl != "" {
+		parsed, err := time.ParseDuration(ttl)
+		if err != nil {
+			log.Fatal("Invalid JWT_TTL: ", err)
+		}
+		tokenTTL = parsed
+	}
+	if ttl := os.Getenv("MAGIC_LINK_TTL"); ttl != "" {
+		parsed, err := time.ParseDuration(ttl)
+		if err != nil {
+			log.Fatal("Invalid MAGIC_LINK_TTL: ", err)
+		}
+		magicLinkTTL = parsed
+	}
+	if max := os.Getenv("MAX_SESSIONS_PER_USER"); max != "" {
+		parsed, err := strconv.Atoi(max)
+		if err != nil || parsed < 0 {
+			log.Fatal("Invalid MAX_SESSIONS_PER_USER: ", max)
+		}
+		maxSessionsPerUser = parsed
+	}
+	if url := os.Getenv("MAGIC_LINK_URL"); url != "" {
+		magicLinkURL = url
+	}
+	if days := os.Getenv("ACCOUNT_CLOSURE_GRACE_DAYS"); days != "" {
+		parsed, err := strconv.Atoi(days)
+		if err != nil || parsed < 0 {
+			log.Fatal("Invalid ACCOUNT_CLOSURE_GRACE_DAYS: ", days)
+		}
+		closureGracePeriod = time.Duration(parsed) * 24 * time.Hour
+	}
+	if years := os.Getenv("RECORD_RETENTION_YEARS"); years != "" {
+		parsed, err := strconv.Atoi(years)
+		if err != nil || parsed < 0 {
+			log.Fatal("Invalid RECORD_RETENTION_YEARS: ", years)
+		}
+		recordRetentionYears = parsed
+	}
+	if url := os.Getenv("ACCOUNT_CLOSURE_URL"); url != "" {
+		closureConfirmURL = url
+	}
+	if minutes := os.Getenv("LATE_START_GRACE_MINUTES"); minutes != "" {
+		parsed, err := strconv.Atoi(minutes)
+		if err != nil || parsed < 0 {
+			log.Fatal("Invalid LATE_START_GRACE_MINUTES: ", minutes)
+		}
+		lateStartGrace = time.Duration(parsed) * time.Minute
+	}
+	lateStartPush = os.Getenv("LATE_START_PUSH") == "true"
+	if name := os.Getenv("CLINIC_NAME"); name != "" {
+		branding.Name = name
+	}
+	branding.LogoURL = os.Getenv("CLINIC_LOGO_URL")
+	if color := os.Getenv("CLINIC_PRIMARY_COLOR"); color != "" {
+		if !hexColor.MatchString(color) {
+			log.Fatal("Invalid CLINIC_PRIMARY_COLOR: ", color)
+		}
+		branding.PrimaryColor = color
+	}
+	if hours := os.Getenv("CLINIC_HOURS"); hours != "" {
+		parsed, err := parseClinicHours(hours)
+		if err != nil {
+			log.Fatal("Invalid CLINIC_HOURS: ", err)
+		}
+		clinicHours = parsed
+	}
+	if key := os.Getenv("CAPTURE_KEY"); key != "" {
+		parsed, err := hex.DecodeString(key)
+		if err != nil || len(parsed) != 32 {
+			log.Fatal("Invalid CAPTURE_KEY: must be 64 hex characters")
+		}
+		captureKey = parsed
+	}
+	switch calendar := os.Getenv("SECONDARY_CALENDAR"); calendar {
+	case "", calendarHijri:
+		secondaryCalendar = calendar
+	default:
+		log.Fatal("Invalid SECONDARY_CALENDAR: ", calendar)
+	}
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		parsed, err := parseTrustedProxies(proxies)
+		if err != nil {
+			log.Fatal("Invalid TRUSTED_PROXIES: ", err)
+		}
+		trustedProxies = parsed
+	}
+	if tz := os.Getenv("CLINIC_TIMEZONE"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			log.Fatal("Invalid CLINIC_TIMEZONE: ", err)
+		}
+		calendarLocation = loc
+	}
+
+	// Background jobs and the server stop on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	// Initialize MongoDB client
-	ctx := context.TODO()
-	clientOptions := options.Client().ApplyURI(dbBaseURL)
-	var err error
-	client, err = mongo.Connect(ctx, clientOptions)
+	prof := newProfiler(profilerWindow)
+	clientOptions, err := mongoOptions(dbBaseURL, prof.commandMonitor())
+	if err != nil {
+		log.Fatal(err)
+	}
+	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer func() {
-		if err := client.Disconnect(ctx); err != nil {
-			log.Fatal(err)
-		}
-	}()
 
 	// Verify MongoDB connection
 	err = client.Ping(ctx, nil)
@@ -72,220 +207,358 @@ func main() {
 
 	fmt.Println("Connected to MongoDB!")
 
-	// Initialize Gin router
-	routes := gin.Default()
-
-	// Configure CORS
-	config := cors.DefaultConfig()
-	config.AllowOrigins = []string{"http://localhost:3000"}
-	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE"}
-	routes.Use(cors.New(config))
-
-	// Set up routes
-	routes.POST("/api/signup", SignUp)
-	routes.GET("/api/doctors", GetDoctors)
-	routes.GET("/api/doctors/:id", GetDoctorByID)
-	routes.POST("/api/doctors", CreateDoctor)
-	routes.PUT("/api/doctors/:id/schedule", SetDoctorSchedule)
-	routes.POST("/api/patients/:id/appointments", BookAppointment)
-	routes.PUT("/api/patients/:id/appointments/:appointmentID", UpdateAppointment)
-	routes.DELETE("/api/patients/:id/appointments/:appointmentID", CancelAppointment)
-
-	// Run the server
-	routes.Run(":" + port)
+	// `main schema [-fix]` checks indexes and validators, then exits
+	db := client.Database("hospital")
+	if len(os.Args) > 1 && os.Args[1] == "schema" {
+		code := runSchemaCommand(ctx, db, os.Args[2:])
+		client.Disconnect(ctx)
+		os.Exit(code)
+	}
+	ensureSchema(ctx, db, os.Getenv("SCHEMA_AUTOFIX") == "true")
+
+	// Booking, reschedule and cancellation emails plus reminders
+	notifier, notifyConfig := notification.FromEnv()
+	srv := NewServer(store.NewMongo(db), notifier, db)
+	sinks, err := auditSinksFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if sinks != nil {
+		srv.auditSinks = sinks
+		go sinks.Run(ctx)
+	}
+
+	// Seed the first admin so doctor and admin accounts can be created
+	if err := srv.ensureAdmin(ctx, os.Getenv("ADMIN_USERNAME"), os.Getenv("ADMIN_PASSWORD")); err != nil {
+		log.Fatal("Error creating admin user: ", err)
+	}
+
+	registerValidators()
+	publicAddrs := parseListenAddrs(os.Getenv("LISTEN_ADDRS"))
+	if len(publicAddrs) == 0 {
+		publicAddrs = []string{":" + port}
+	}
+	listeners := srv.listeners(prof, publicAddrs, parseListenAddrs(os.Getenv("ADMIN_LISTEN_ADDRS")))
+
+	go srv.runAvailabilityPrecompute(ctx)
+	go srv.runQualityMeasures(ctx)
+	go srv.runAccountClosures(ctx)
+	go srv.runPatientIndex(ctx)
+	if years := archiveAfterYears(); years > 0 {
+		go srv.runAppointmentArchival(ctx, years)
+	}
+	// The notifier outlives the servers, so what in-flight requests notify
+	// is still sent
+	notifyCtx, stopNotifier := context.WithCancel(context.Background())
+	notifierDone := make(chan struct{})
+	go func() {
+		notifier.Run(notifyCtx)
+		close(notifierDone)
+	}()
+	if notifier.Enabled() && notifyConfig.ReminderLead > 0 {
+		go srv.runReminders(ctx, notifyConfig.ReminderLead)
+	}
+
+	// Run the server until a shutdown signal, then let in-flight requests
+	// finish before disconnecting from Mongo
+	servers, err := serve(listeners)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	<-ctx.Done()
+	stop()
+	log.Println("Shutting down")
+
+	shutdownTimeout, err := envDuration("SHUTDOWN_TIMEOUT", defaultShutdownTimeout)
+	if err != nil {
+		log.Println(err)
+		shutdownTimeout = defaultShutdownTimeout
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Println("Server shutdown: ", err)
+		}
+	}
+	stopNotifier()
+	<-notifierDone
+	if err := client.Disconnect(shutdownCtx); err != nil {
+		log.Println("MongoDB disconnect: ", err)
+	}
 }
 
-func SignUp(c *gin.Context) {
+func (s *Server) SignUp(c *gin.Context) {
+	ctx := c.Request.Context()
 	var newUser User
-	if err := c.ShouldBindJSON(&newUser); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+	if !bindJSON(c, &newUser) {
+		return
+	}
+
+	// Anyone can sign up as a patient; other roles are created by admins
+	if newUser.Role == "" {
+		newUser.Role = RolePatient
+	}
+	if newUser.Role != RolePatient {
+		if user, ok := currentUser(c); !ok || user.Role != RoleAdmin {
+			abortWithError(c, http.StatusForbidden, "Only admins can create doctor or admin accounts")
+			return
+		}
+	}
+	if newUser.Role == RoleDoctor && newUser.ProfileID == "" {
+		abortWithDetails(c, fieldError{Field: "profileId", Message: "is required for doctor accounts"})
 		return
 	}
 
-	if exists, err := isUsernameTaken(newUser.Username); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking username availability"})
+	if exists, err := s.store.Users.Exists(ctx, newUser.Username); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error checking username availability")
 		return
 	} else if exists {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Username is already taken"})
+		abortWithCode(c, http.StatusConflict, "username_taken", "Username is already taken")
 		return
 	}
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newUser.Password), bcrypt.DefaultCost)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error hashing password"})
+		abortWithError(c, http.StatusInternalServerError, "Error hashing password")
 		return
 	}
 	newUser.Password = string(hashedPassword)
+	newUser.CreatedAt = time.Now().UTC()
 
-	userCollection := client.Database("hospital").Collection("users")
-	_, err = userCollection.InsertOne(context.Background(), newUser)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating user"})
+	// Every patient account gets its own patient record to book against.
+	// The user goes in first so a taken username leaves no stray record.
+	var patient Patient
+	switch newUser.Role {
+	case RolePatient:
+		newUser.ProfileID = primitive.NewObjectID().Hex()
+		patient = Patient{ID: newUser.ProfileID, PName: newUser.Username}
+	case RoleAdmin:
+		newUser.ProfileID = ""
+	}
+
+	if err := s.store.Users.Create(ctx, newUser); errors.Is(err, store.ErrDuplicate) {
+		abortWithCode(c, http.StatusConflict, "username_taken", "Username is already taken")
 		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error creating user")
+		return
+	}
+	if patient.ID != "" {
+		if err := s.store.Patients.Create(ctx, patient); err != nil {
+			if err := s.store.Users.Delete(context.WithoutCancel(ctx), newUser.Username); err != nil {
+				log.Printf("Removing user %s without a patient record failed: %v", newUser.Username, err)
+			}
+			abortWithError(c, http.StatusInternalServerError, "Error creating patient record")
+			return
+		}
+		s.patientIndex.put(patient)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "User created successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "User created successfully", "profileId": newUser.ProfileID})
 }
-func isUsernameTaken(username string) (bool, error) {
-	userCollection := client.Database("hospital").Collection("users")
-	count, err := userCollection.CountDocuments(context.Background(), bson.M{"username": username})
+
+// ensureAdmin creates an admin account with the given credentials unless
+// the username already exists. It does nothing when username is empty.
+func (s *Server) ensureAdmin(ctx context.Context, username, password string) error {
+	if username == "" || password == "" {
+		return nil
+	}
+	if exists, err := s.store.Users.Exists(ctx, username); err != nil || exists {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		return false, err
+		return err
 	}
-	return count > 0, nil
+
+	return s.store.Users.Create(ctx, User{
+		Username:  username,
+		Password:  string(hashedPassword),
+		Role:      RoleAdmin,
+		CreatedAt: time.Now().UTC(),
+	})
 }
 
-func GetDoctors(c *gin.Context) {
-	coll := client.Database("hospital").Collection("doctor")
-	cur, err := coll.Find(context.Background(), bson.D{})
+// GetDoctors lists doctors a page at a time. They can be filtered by a
+// case-insensitive ?name= substring, an exact ?specialization= and
+// ?availableOn=YYYY-MM-DD, which matches doctors with a free slot that day.
+func (s *Server) GetDoctors(c *gin.Context) {
+	ctx := c.Request.Context()
+	q, err := parseListQuery(c, "name", "name", "specialization")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching doctor data"})
+		abortWithError(c, http.StatusBadRequest, err.Error())
 		return
 	}
-	defer cur.Close(context.Background())
 
-	var doctors []Doctor
-	for cur.Next(context.Background()) {
-		var doctor Doctor
-		if err := cur.Decode(&doctor); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding doctor data"})
+	filter := store.DoctorFilter{NameContains: c.Query("name"), Specialization: c.Query("specialization")}
+	if value := c.Query("availableOn"); value != "" {
+		day, err := time.Parse(dateLayout, value)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, "availableOn must be formatted as YYYY-MM-DD")
+			return
+		}
+		ids, err := s.availableDoctorIDs(ctx, day)
+		if err == errNotPrecomputed {
+			abortWithError(c, http.StatusBadRequest, fmt.Sprintf("availableOn must be within the next %d days", precomputeDays))
+			return
+		} else if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "Error computing availability")
 			return
 		}
-		doctors = append(doctors, doctor)
+		filter.IDs = ids
 	}
 
-	c.JSON(http.StatusOK, doctors)
-}
-
-func GetDoctorByID(c *gin.Context) {
-	doctorID := c.Param("id")
+	doctors, total, err := s.store.Doctors.List(ctx, filter, q.page())
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error fetching doctor data")
+		return
+	}
 
-	coll := client.Database("hospital").Collection("doctor")
-	filter := bson.M{"id": doctorID}
+	setTotalCount(c, total)
+	render(c, http.StatusOK, doctors, doctorList{Doctors: doctors})
+}
 
-	var doctor Doctor
-	err := coll.FindOne(context.Background(), filter).Decode(&doctor)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Doctor not found"})
+func (s *Server) GetDoctorByID(c *gin.Context) {
+	doctor, err := s.store.Doctors.Get(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, "Doctor not found")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error fetching doctor data")
 		return
 	}
 
-	c.JSON(http.StatusOK, doctor)
+	render(c, http.StatusOK, doctor, nil)
 }
 
-func CreateDoctor(c *gin.Context) {
+func (s *Server) CreateDoctor(c *gin.Context) {
 	var newDoctor Doctor
-	if err := c.ShouldBindJSON(&newDoctor); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+	if !bindJSON(c, &newDoctor) {
 		return
 	}
 
-	coll := client.Database("hospital").Collection("doctor")
-	_, err := coll.InsertOne(context.Background(), newDoctor)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating doctor"})
+	if err := s.store.Doctors.Create(c.Request.Context(), newDoctor); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error creating doctor")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Doctor created successfully"})
 }
 
-func SetDoctorSchedule(c *gin.Context) {
+func (s *Server) SetDoctorSchedule(c *gin.Context) {
 	doctorID := c.Param("id")
 
 	var schedule []string
-	if err := c.ShouldBindJSON(&schedule); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+	if !bindJSON(c, &schedule) {
 		return
 	}
+	for i, entry := range schedule {
+		if _, err := time.Parse(time.RFC3339, entry); err != nil {
+			abortWithDetails(c, fieldError{Field: fmt.Sprintf("schedule[%d]", i), Message: "must be an RFC3339 slot start time"})
+			return
+		}
+	}
 
-	coll := client.Database("hospital").Collection("doctor")
-	filter := bson.M{"id": doctorID}
-	update := bson.M{"$set": bson.M{"schedule": schedule}}
-
-	_, err := coll.UpdateOne(context.Background(), filter, update)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating doctor's schedule"})
+	err := s.store.Doctors.SetSchedule(c.Request.Context(), doctorID, schedule)
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, "Doctor not found")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error updating doctor's schedule")
 		return
 	}
+	go s.refreshDoctorAvailability(doctorID)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Doctor's schedule updated successfully"})
 }
 
-func GetPatientAppointments(c *gin.Context) {
-	patientID := c.Param("id")
+// SetDoctorFollowUpSlots marks flat schedule slots, by RFC3339 start time,
+// as bookable only by the doctor's existing patients. Template doctors mark
+// follow-up windows in their weekly rules instead.
+func (s *Server) SetDoctorFollowUpSlots(c *gin.Context) {
+	doctorID := c.Param("id")
 
-	coll := client.Database("hospital").Collection("patients")
-	filter := bson.M{"id": patientID}
+	var starts []string
+	if !bindJSON(c, &starts) {
+		return
+	}
+	for i, entry := range starts {
+		if _, err := time.Parse(time.RFC3339, entry); err != nil {
+			abortWithDetails(c, fieldError{Field: fmt.Sprintf("followUpSlots[%d]", i), Message: "must be an RFC3339 slot start time"})
+			return
+		}
+	}
 
-	var patient Patient
-	err := coll.FindOne(context.Background(), filter).Decode(&patient)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Patient not found"})
+	err := s.store.Doctors.SetFollowUpSlots(c.Request.Context(), doctorID, starts)
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, "Doctor not found")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error updating doctor's follow-up slots")
 		return
 	}
+	go s.refreshDoctorAvailability(doctorID)
 
-	c.JSON(http.StatusOK, patient.Schedule)
+	c.JSON(http.StatusOK, gin.H{"message": "Doctor's follow-up slots updated successfully"})
 }
 
-func BookAppointment(c *gin.Context) {
-	patientID := c.Param("id")
-
-	var newAppointment string
-	if err := c.ShouldBindJSON(&newAppointment); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+func (s *Server) GetPatients(c *gin.Context) {
+	ctx := c.Request.Context()
+	q, err := parseListQuery(c, "name", "name")
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	coll := client.Database("hospital").Collection("patients")
-	filter := bson.M{"id": patientID}
-	update := bson.M{"$push": bson.M{"schedule": newAppointment}}
-
-	_, err := coll.UpdateOne(context.Background(), filter, update)
+	filter := store.PatientFilter{Tags: c.QueryArray("tag")}
+	patients, total, err := s.store.Patients.List(ctx, filter, q.page())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error booking appointment"})
+		abortWithError(c, http.StatusInternalServerError, "Error fetching patient data")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Appointment booked successfully"})
+	setTotalCount(c, total)
+	render(c, http.StatusOK, patients, patientList{Patients: patients})
 }
 
-func UpdateAppointment(c *gin.Context) {
-	patientID := c.Param("id")
-	appointmentID := c.Param("appointmentID")
-
-	var updatedAppointment string
-	if err := c.ShouldBindJSON(&updatedAppointment); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input data"})
+func (s *Server) SetPatientTags(c *gin.Context) {
+	var tags []string
+	if !bindJSON(c, &tags) {
 		return
 	}
 
-	coll := client.Database("hospital").Collection("patients")
-	filter := bson.M{"id": patientID, "schedule": appointmentID}
-	update := bson.M{"$set": bson.M{"schedule.$": updatedAppointment}}
-
-	_, err := coll.UpdateOne(context.Background(), filter, update)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating appointment"})
+	err := s.store.Patients.SetTags(c.Request.Context(), c.Param("id"), tags)
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, "Patient not found")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error updating patient tags")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Appointment updated successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Patient tags updated successfully"})
 }
 
-func CancelAppointment(c *gin.Context) {
-	patientID := c.Param("id")
-	appointmentID := c.Param("appointmentID")
-
-	coll := client.Database("hospital").Collection("patients")
-	filter := bson.M{"id": patientID}
-	update := bson.M{"$pull": bson.M{"schedule": appointmentID}}
+func (s *Server) AddPatientNote(c *gin.Context) {
+	var note PatientNote
+	if !bindJSON(c, &note) {
+		return
+	}
+	user, _ := currentUser(c)
+	note.Author = user.Username
+	note.CreatedAt = time.Now().UTC()
 
-	_, err := coll.UpdateOne(context.Background(), filter, update)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error canceling appointment"})
+	err := s.store.Patients.AddNote(c.Request.Context(), c.Param("id"), note)
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, "Patient not found")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error adding patient note")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Appointment canceled successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Patient note added successfully"})
 }
@@ -0,0 +1,90 @@
+package notification
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultQueueSize     = 256
+	defaultReminderHours = 24
+)
+
+// Config is the notification setup read from the environment.
+type Config struct {
+	// ReminderLead is how long before an appointment the reminder goes
+	// out; zero disables reminders.
+	ReminderLead time.Duration
+}
+
+// FromEnv builds a Notifier from the environment:
+//
+//	SMTP_HOST, SMTP_PORT (default 587), SMTP_USERNAME, SMTP_PASSWORD, SMTP_FROM
+//	NOTIFY_WEBHOOK_URL (delivery gateway, e.g. for SMS and calls)
+//	SHARE_WEBHOOK_URL, SHARE_WEBHOOK_CONSENT (third party and the consent purpose it needs)
+//	NOTIFY_QUEUE_SIZE (default 256)
+//	NOTIFY_SUPPRESS_MINUTES (default 0, the window to collapse a patient's events in)
+//	REMINDER_HOURS (default 24, 0 disables reminders)
+//
+// Email is enabled when SMTP_HOST and SMTP_FROM are set and the webhook when
+// NOTIFY_WEBHOOK_URL is set. With neither, notifications are disabled. The
+// third-party webhook is only sent appointment events of patients who gave
+// SHARE_WEBHOOK_CONSENT, and never takes the place of a channel.
+func FromEnv() (*Notifier, Config) {
+	var senders []Sender
+	if host, from := os.Getenv("SMTP_HOST"), os.Getenv("SMTP_FROM"); host != "" && from != "" {
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		senders = append(senders, SMTPSender{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		})
+	}
+	if url := os.Getenv("NOTIFY_WEBHOOK_URL"); url != "" {
+		senders = append(senders, WebhookSender{URL: url})
+	}
+	var shared []Sender
+	if url := os.Getenv("SHARE_WEBHOOK_URL"); url != "" {
+		if consent := os.Getenv("SHARE_WEBHOOK_CONSENT"); consent != "" {
+			shared = append(shared, WebhookSender{URL: url, Consent: consent})
+		} else {
+			log.Print("Ignoring SHARE_WEBHOOK_URL without SHARE_WEBHOOK_CONSENT")
+		}
+	}
+
+	queueSize := envInt("NOTIFY_QUEUE_SIZE", defaultQueueSize)
+	if queueSize < 1 {
+		queueSize = defaultQueueSize
+	}
+	reminderHours := envInt("REMINDER_HOURS", defaultReminderHours)
+	if reminderHours < 0 {
+		reminderHours = 0
+	}
+
+	notifier := New(queueSize, senders...)
+	notifier.ShareWith(shared...)
+	if minutes := envInt("NOTIFY_SUPPRESS_MINUTES", 0); minutes > 0 {
+		notifier.SuppressWithin(time.Duration(minutes) * time.Minute)
+	}
+	return notifier, Config{ReminderLead: time.Duration(reminderHours) * time.Hour}
+}
+
+func envInt(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q", name, value)
+		return fallback
+	}
+	return n
+}
@@ -0,0 +1,126 @@
+package notification
+
+import (
+	"context"
+	"time"
+)
+
+// Digest stands in for several appointment events of one patient that were
+// collapsed into a single message; Events holds the latest event of each
+// appointment.
+const Digest = "digest"
+
+// held are the events of one patient waiting out the suppression window.
+type held struct {
+	events []Event
+	due    time.Time
+}
+
+// SuppressWithin makes the notifier hold a patient's booking, reschedule and
+// cancellation events for window after the first one, then send them as a
+// single up-to-date event, or a Digest when they concern several
+// appointments. Reminders and other kinds are never held. It must be called
+// before Run; zero, the default, sends every event straight away.
+func (n *Notifier) SuppressWithin(window time.Duration) {
+	n.window = window
+}
+
+// hold reports whether event was held back for the suppression window.
+func (n *Notifier) hold(event Event, now time.Time) bool {
+	if n.window <= 0 || event.PatientID == "" || event.AppointmentID == "" {
+		return false
+	}
+	switch event.Kind {
+	case Booked, Rescheduled, Cancelled:
+	default:
+		return false
+	}
+
+	if n.pending == nil {
+		n.pending = map[string]*held{}
+	}
+	batch, ok := n.pending[event.PatientID]
+	if !ok {
+		batch = &held{due: now.Add(n.window)}
+		n.pending[event.PatientID] = batch
+	}
+	batch.events = append(batch.events, event)
+	return true
+}
+
+// nextDue returns when the earliest held batch is due, if there is one.
+func (n *Notifier) nextDue() (time.Time, bool) {
+	var next time.Time
+	for _, batch := range n.pending {
+		if next.IsZero() || batch.due.Before(next) {
+			next = batch.due
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// flushDue delivers the batches whose window has passed.
+func (n *Notifier) flushDue(ctx context.Context, now time.Time) {
+	for patientID, batch := range n.pending {
+		if batch.due.After(now) {
+			continue
+		}
+		delete(n.pending, patientID)
+		if event, ok := collapse(batch.events); ok {
+			n.deliver(ctx, event)
+		}
+	}
+}
+
+// collapse merges one patient's held events, oldest first, into the event
+// to send. It reports false when there is nothing left worth telling, like
+// an appointment booked and cancelled within the window.
+func collapse(events []Event) (Event, bool) {
+	var order []string
+	byAppointment := map[string]Event{}
+	for _, event := range events {
+		first, seen := byAppointment[event.AppointmentID]
+		if !seen {
+			order = append(order, event.AppointmentID)
+			byAppointment[event.AppointmentID] = event
+			continue
+		}
+		if first.Kind == Booked {
+			// The patient hasn't heard of the booking yet: tell them of it
+			// with the latest details, or not at all if it's cancelled
+			if event.Kind == Cancelled {
+				event.Kind = ""
+			} else {
+				event.Kind = Booked
+			}
+			if event.MissingProfileFields == nil {
+				event.MissingProfileFields = first.MissingProfileFields
+			}
+		}
+		byAppointment[event.AppointmentID] = event
+	}
+
+	var latest []Event
+	for _, id := range order {
+		if event := byAppointment[id]; event.Kind != "" {
+			latest = append(latest, event)
+		}
+	}
+	switch len(latest) {
+	case 0:
+		return Event{}, false
+	case 1:
+		return latest[0], true
+	}
+
+	last := latest[len(latest)-1]
+	return Event{
+		Kind:         Digest,
+		PatientID:    last.PatientID,
+		PatientName:  last.PatientName,
+		PatientEmail: last.PatientEmail,
+		GuardianName: last.GuardianName,
+		Consents:     last.Consents,
+		Events:       latest,
+	}, true
+}
@@ -0,0 +1,61 @@
+package notification
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// recorder collects the events sent to it.
+type recorder chan Event
+
+func (r recorder) Send(_ context.Context, event Event) error {
+	r <- event
+	return nil
+}
+
+func TestRunSendsHeldEventsWhenStopped(t *testing.T) {
+	sent := make(recorder, 10)
+	n := New(10, sent)
+	n.SuppressWithin(time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		n.Run(ctx)
+		close(done)
+	}()
+
+	n.Notify(Event{Kind: Booked, PatientID: "p1", AppointmentID: "a1"})
+	n.Notify(Event{Kind: Rescheduled, PatientID: "p1", AppointmentID: "a1", StartTime: time.Date(2030, 1, 2, 9, 0, 0, 0, time.UTC)})
+	select {
+	case event := <-sent:
+		t.Fatalf("sent %+v inside the suppression window", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return after cancelling")
+	}
+	select {
+	case event := <-sent:
+		if event.Kind != Booked || event.StartTime.Year() != 2030 {
+			t.Errorf("sent %+v, want the booking with its new time", event)
+		}
+	default:
+		t.Fatal("the held booking was lost when Run stopped")
+	}
+}
+
+func TestCollapse(t *testing.T) {
+	booked := Event{Kind: Booked, PatientID: "p1", AppointmentID: "a1"}
+	if _, ok := collapse([]Event{booked, {Kind: Cancelled, PatientID: "p1", AppointmentID: "a1"}}); ok {
+		t.Error("a booking cancelled within the window was still sent")
+	}
+	digest, ok := collapse([]Event{booked, {Kind: Cancelled, PatientID: "p1", AppointmentID: "a2"}})
+	if !ok || digest.Kind != Digest || len(digest.Events) != 2 {
+		t.Errorf("collapse = %+v, want a digest of both appointments", digest)
+	}
+}
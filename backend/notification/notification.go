@@ -0,0 +1,273 @@
+// Package notification delivers appointment events to patients. Events are
+// queued and sent by a background worker so a slow mail server or webhook
+// never holds up a booking request.
+package notification
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Event kinds.
+const (
+	Booked      = "booked"
+	Rescheduled = "rescheduled"
+	Cancelled   = "cancelled"
+	Reminder    = "reminder"
+	// MagicLink carries a sign-in link rather than appointment details.
+	MagicLink = "magic_link"
+	// EmergencyContact asks for a patient's emergency contact to be reached
+	// by SMS or phone call; it is a task for the webhook, not an email.
+	EmergencyContact = "emergency_contact"
+	// AccountClosure carries the link confirming a patient's request to
+	// close their account.
+	AccountClosure = "account_closure"
+	// LowStock tells staff an inventory item needs reordering; it is about
+	// no patient.
+	LowStock = "low_stock"
+)
+
+// Contact is the person an EmergencyContact event is about.
+type Contact struct {
+	Name         string `json:"name"`
+	Relationship string `json:"relationship,omitempty"`
+	Phone        string `json:"phone"`
+	// Channel is "sms" or "call".
+	Channel string `json:"channel"`
+	Message string `json:"message"`
+}
+
+// StockLevel is the inventory item a LowStock event is about.
+type StockLevel struct {
+	ItemID       string `json:"itemId"`
+	Name         string `json:"name"`
+	Unit         string `json:"unit,omitempty"`
+	Stock        int    `json:"stock"`
+	ReorderLevel int    `json:"reorderLevel"`
+}
+
+const sendTimeout = 30 * time.Second
+
+// shutdownGrace bounds sending the held events when Run stops.
+const shutdownGrace = 10 * time.Second
+
+// Event describes something that happened to an appointment.
+type Event struct {
+	Kind          string    `json:"kind"`
+	AppointmentID string    `json:"appointmentId"`
+	PatientID     string    `json:"patientId"`
+	PatientName   string    `json:"patientName"`
+	PatientEmail  string    `json:"patientEmail"`
+	DoctorID      string    `json:"doctorId"`
+	DoctorName    string    `json:"doctorName"`
+	StartTime     time.Time `json:"startTime"`
+	EndTime       time.Time `json:"endTime"`
+	Status        string    `json:"status"`
+	// MissingProfileFields, set before a patient's first visit, names the
+	// profile details they haven't provided yet.
+	MissingProfileFields []string `json:"missingProfileFields,omitempty"`
+	// GuardianName is set when the patient is a minor; PatientEmail is then
+	// their guardian's and the message is addressed to the guardian.
+	GuardianName string `json:"guardianName,omitempty"`
+	// Contact is only set on EmergencyContact events.
+	Contact *Contact `json:"contact,omitempty"`
+	// Item is only set on LowStock events.
+	Item *StockLevel `json:"item,omitempty"`
+	// Events is only set on Digest events.
+	Events []Event `json:"events,omitempty"`
+	// Consents are the purposes the patient has consented to; senders that
+	// share data with third parties check them.
+	Consents []string `json:"-"`
+	// Link and LinkExpiresAt are only set on MagicLink and AccountClosure
+	// events.
+	Link          string     `json:"link,omitempty"`
+	LinkExpiresAt *time.Time `json:"linkExpiresAt,omitempty"`
+}
+
+// Sender delivers a single event through one channel, e.g. email.
+type Sender interface {
+	Send(ctx context.Context, event Event) error
+}
+
+// Notifier fans queued events out to its senders.
+type Notifier struct {
+	senders []Sender
+	// shared are the third parties events are shared with; they deliver
+	// nothing to patients.
+	shared []Sender
+	queue  chan Event
+	// window and pending implement SuppressWithin; pending is only touched
+	// by Run.
+	window  time.Duration
+	pending map[string]*held
+	// paused stops Run delivering while events keep queueing; wake tells
+	// Run it changed.
+	paused atomic.Bool
+	wake   chan struct{}
+}
+
+// New returns a Notifier that buffers up to queueSize events. Nothing is
+// sent until Run is started.
+func New(queueSize int, senders ...Sender) *Notifier {
+	return &Notifier{senders: senders, queue: make(chan Event, queueSize), wake: make(chan struct{}, 1)}
+}
+
+// ShareWith also sends events to third parties, e.g. a consent-gated
+// WebhookSender. They don't count as channels for Enabled. It must be
+// called before Run.
+func (n *Notifier) ShareWith(senders ...Sender) {
+	n.shared = append(n.shared, senders...)
+}
+
+// Pause stops sending until Resume. Events queue up meanwhile, and are
+// dropped like any other once the queue is full.
+func (n *Notifier) Pause() {
+	n.paused.Store(true)
+	n.signal()
+}
+
+// Resume sends the queued events and carries on sending.
+func (n *Notifier) Resume() {
+	n.paused.Store(false)
+	n.signal()
+}
+
+func (n *Notifier) Paused() bool {
+	return n.paused.Load()
+}
+
+func (n *Notifier) signal() {
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Queued returns how many events are waiting to be sent.
+func (n *Notifier) Queued() int {
+	return len(n.queue)
+}
+
+// Drain drops the queued events and returns how many there were, e.g. to
+// stop a batch of wrong notifications going out while paused.
+func (n *Notifier) Drain() int {
+	dropped := 0
+	for {
+		select {
+		case <-n.queue:
+			dropped++
+		default:
+			return dropped
+		}
+	}
+}
+
+// Enabled reports whether any channel reaching patients or staff is
+// configured.
+func (n *Notifier) Enabled() bool {
+	return len(n.senders) > 0
+}
+
+// Notify queues event for delivery. It never blocks: when the queue is full
+// the event is dropped and logged.
+func (n *Notifier) Notify(event Event) {
+	if !n.Enabled() && len(n.shared) == 0 {
+		return
+	}
+	select {
+	case n.queue <- event:
+	default:
+		log.Printf("Notification queue full, dropping %s event for appointment %s", event.Kind, event.AppointmentID)
+	}
+}
+
+// Send delivers event through every channel straight away, bypassing the
+// queue, a pause and the suppression window, for events that mustn't be
+// dropped or wait, like EmergencyContact. Third parties aren't sent it.
+func (n *Notifier) Send(ctx context.Context, event Event) error {
+	if !n.Enabled() {
+		return errors.New("no notification channel is configured")
+	}
+	var errs []error
+	for _, sender := range n.senders {
+		sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+		errs = append(errs, sender.Send(sendCtx, event))
+		cancel()
+	}
+	return errors.Join(errs...)
+}
+
+// Run delivers queued events until ctx is cancelled. It then sends the
+// events still queued or held for the suppression window, taking up to
+// shutdownGrace, so a restart loses none; cancel ctx once nothing more is
+// notified. While paused they are dropped instead.
+func (n *Notifier) Run(ctx context.Context) {
+	for {
+		// While paused nothing is taken off the queue or flushed
+		queue := n.queue
+		var due <-chan time.Time
+		var timer *time.Timer
+		if n.paused.Load() {
+			queue = nil
+		} else if next, ok := n.nextDue(); ok {
+			timer = time.NewTimer(time.Until(next))
+			due = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			n.finish(context.WithoutCancel(ctx))
+			return
+		case <-n.wake:
+		case event := <-queue:
+			if !n.hold(event, time.Now()) {
+				n.deliver(ctx, event)
+			}
+		case now := <-due:
+			n.flushDue(ctx, now)
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// finish sends what is queued and held when Run stops.
+func (n *Notifier) finish(ctx context.Context) {
+	if n.paused.Load() {
+		if dropped := n.Drain() + len(n.pending); dropped > 0 {
+			log.Printf("Notifications paused at shutdown, dropping %d queued or held events", dropped)
+		}
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, shutdownGrace)
+	defer cancel()
+	now := time.Now()
+	for drained := false; !drained; {
+		select {
+		case event := <-n.queue:
+			if !n.hold(event, now) {
+				n.deliver(ctx, event)
+			}
+		default:
+			drained = true
+		}
+	}
+	// Every held batch is due by the end of its window
+	n.flushDue(ctx, now.Add(n.window))
+}
+
+func (n *Notifier) deliver(ctx context.Context, event Event) {
+	for _, senders := range [][]Sender{n.senders, n.shared} {
+		for _, sender := range senders {
+			sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+			if err := sender.Send(sendCtx, event); err != nil {
+				log.Printf("Sending %s notification for appointment %s failed: %v", event.Kind, event.AppointmentID, err)
+			}
+			cancel()
+		}
+	}
+}
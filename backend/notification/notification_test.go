@@ -0,0 +1,74 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// failing is a sender whose channel is down.
+type failing struct{}
+
+func (failing) Send(context.Context, Event) error {
+	return errors.New("gateway unreachable")
+}
+
+func TestNotifyDropsWhenQueueFull(t *testing.T) {
+	sent := make(recorder, 10)
+	n := New(1, sent)
+	n.Notify(Event{Kind: Booked, AppointmentID: "a1"})
+	n.Notify(Event{Kind: Booked, AppointmentID: "a2"})
+	if got := n.Queued(); got != 1 {
+		t.Fatalf("queued = %d, want 1 with the second event dropped", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.Run(ctx)
+	select {
+	case event := <-sent:
+		if event.AppointmentID != "a1" {
+			t.Errorf("sent %s, want a1", event.AppointmentID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the queued event wasn't sent")
+	}
+	select {
+	case event := <-sent:
+		t.Errorf("sent the dropped event %s", event.AppointmentID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestNotifyWithoutChannels(t *testing.T) {
+	n := New(1)
+	n.Notify(Event{Kind: Booked, AppointmentID: "a1"})
+	if got := n.Queued(); got != 0 {
+		t.Errorf("queued = %d with no channel configured, want 0", got)
+	}
+	if err := n.Send(context.Background(), Event{Kind: EmergencyContact}); err == nil {
+		t.Error("Send succeeded with no channel configured")
+	}
+}
+
+func TestDeliverCarriesOnAfterAFailure(t *testing.T) {
+	sent := make(recorder, 1)
+	New(1, failing{}, sent).deliver(context.Background(), Event{Kind: Booked, AppointmentID: "a1"})
+	select {
+	case <-sent:
+	default:
+		t.Error("a failing channel stopped the others being sent the event")
+	}
+}
+
+func TestSendReportsFailures(t *testing.T) {
+	sent := make(recorder, 1)
+	n := New(1, failing{}, sent)
+	if err := n.Send(context.Background(), Event{Kind: EmergencyContact}); err == nil {
+		t.Error("Send succeeded although a channel failed")
+	}
+	if len(sent) != 1 {
+		t.Error("the working channel wasn't sent the event")
+	}
+}
@@ -0,0 +1,131 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// SMTPSender emails the patient about an event.
+type SMTPSender struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s SMTPSender) Send(ctx context.Context, event Event) error {
+	if event.PatientEmail == "" || event.Kind == EmergencyContact {
+		// Nothing to do for patients without an email on file, and
+		// emergency contacts are reached by the webhook
+		return nil
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(net.JoinHostPort(s.Host, s.Port), auth, s.From, []string{event.PatientEmail}, s.message(event))
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s SMTPSender) message(event Event) []byte {
+	subject, body := render(event)
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", s.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", event.PatientEmail)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(msg.String())
+}
+
+// render returns the subject and plain-text body for an event.
+func render(event Event) (string, string) {
+	greeting := "Hello"
+	if event.PatientName != "" {
+		greeting = "Hello " + event.PatientName
+	}
+	// Guardians are told about the child's appointment rather than theirs
+	whose := "your"
+	if event.GuardianName != "" {
+		greeting = "Hello " + event.GuardianName
+		whose = "your child's"
+		if event.PatientName != "" {
+			whose = event.PatientName + "'s"
+		}
+	}
+
+	if event.Kind == MagicLink && event.LinkExpiresAt != nil {
+		expires := event.LinkExpiresAt.UTC().Format("15:04 UTC")
+		body := fmt.Sprintf("%s,\n\nuse this link to sign in to the patient portal. It works once, on the device you requested it from, until %s:\n\n%s\n\nIf you didn't ask to sign in, you can ignore this email.\n", greeting, expires, event.Link)
+		return "Your sign-in link", body
+	}
+	if event.Kind == AccountClosure && event.LinkExpiresAt != nil {
+		expires := event.LinkExpiresAt.UTC().Format("Monday 2 January 2006 at 15:04 UTC")
+		body := fmt.Sprintf("%s,\n\nwe received a request to close your patient portal account. To confirm, open this link before %s:\n\n%s\n\nYour upcoming appointments will be cancelled, and you can still keep the account open until the grace period ends. If you didn't ask to close your account, you can ignore this email.\n", greeting, expires, event.Link)
+		return "Confirm closing your account", body
+	}
+
+	if event.Kind == Digest {
+		lines := make([]string, len(event.Events))
+		for i, update := range event.Events {
+			_, line := describe(update, whose)
+			lines[i] = fmt.Sprintf("- %s (reference %s)", line, update.AppointmentID)
+		}
+		subject := "Updates to your appointments"
+		if event.GuardianName != "" && event.PatientName != "" {
+			subject = "Updates to appointments for " + event.PatientName
+		}
+		body := fmt.Sprintf("%s,\n\nhere are the latest changes to %s appointments:\n\n%s\n", greeting, whose, strings.Join(lines, "\n"))
+		return subject, body
+	}
+
+	subject, line := describe(event, whose)
+	if len(event.MissingProfileFields) > 0 {
+		line += fmt.Sprintf("\n\nBefore the first visit, please complete %s profile in the patient portal so we have everything we need.", whose)
+	}
+	if event.GuardianName != "" && event.PatientName != "" {
+		subject = strings.Replace(subject, "Your appointment", "Appointment for "+event.PatientName, 1)
+	}
+
+	body := fmt.Sprintf("%s,\n\n%s\n\nAppointment reference: %s\n", greeting, line, event.AppointmentID)
+	return subject, body
+}
+
+// describe returns the subject and the sentence about one appointment event;
+// whose is whose appointment it is, e.g. "your".
+func describe(event Event, whose string) (string, string) {
+	when := event.StartTime.UTC().Format("Monday 2 January 2006 at 15:04 UTC")
+	doctor := event.DoctorName
+	if doctor == "" {
+		doctor = "your doctor"
+	}
+
+	switch event.Kind {
+	case Booked:
+		return "Your appointment is booked", fmt.Sprintf("%s appointment with %s is booked for %s.", whose, doctor, when)
+	case Rescheduled:
+		return "Your appointment has changed", fmt.Sprintf("%s appointment with %s is now on %s.", whose, doctor, when)
+	case Cancelled:
+		return "Your appointment was cancelled", fmt.Sprintf("%s appointment with %s on %s was cancelled.", whose, doctor, when)
+	case Reminder:
+		return "Appointment reminder", fmt.Sprintf("this is a reminder of %s appointment with %s on %s.", whose, doctor, when)
+	default:
+		return "Appointment update", fmt.Sprintf("there is an update to %s appointment with %s on %s.", whose, doctor, when)
+	}
+}
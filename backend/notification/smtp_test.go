@@ -0,0 +1,107 @@
+package notification
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+// smtpServer is a minimal mail server accepting one connection. It answers
+// RCPT with rcptReply and sends the DATA it receives on the returned
+// channel.
+func smtpServer(t *testing.T, rcptReply string) (host, port string, messages chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	messages = make(chan string, 1)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch verb := strings.ToUpper(strings.Fields(line + " ")[0]); verb {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "RCPT":
+				reply(rcptReply)
+			case "DATA":
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				messages <- data.String()
+				reply("250 queued")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+
+	host, port, _ = net.SplitHostPort(ln.Addr().String())
+	return host, port, messages
+}
+
+func TestSMTPSendsEmail(t *testing.T) {
+	host, port, messages := smtpServer(t, "250 ok")
+	sender := SMTPSender{Host: host, Port: port, From: "clinic@example.com"}
+
+	event := Event{Kind: Booked, AppointmentID: "a1", PatientName: "Alice", PatientEmail: "alice@example.com", DoctorName: "Dr. Grey"}
+	if err := sender.Send(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-messages
+	for _, want := range []string{"To: alice@example.com\r\n", "Subject: Your appointment is booked\r\n", "Hello Alice", "Dr. Grey", "Appointment reference: a1"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message doesn't contain %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestSMTPReportsRejectedRecipient(t *testing.T) {
+	host, port, _ := smtpServer(t, "550 no such mailbox")
+	sender := SMTPSender{Host: host, Port: port, From: "clinic@example.com"}
+
+	err := sender.Send(context.Background(), Event{Kind: Booked, AppointmentID: "a1", PatientEmail: "gone@example.com"})
+	if err == nil || !strings.Contains(err.Error(), "no such mailbox") {
+		t.Errorf("Send = %v, want the server's rejection", err)
+	}
+}
+
+func TestSMTPSkipsEventsWithoutEmail(t *testing.T) {
+	// Nothing listens here, so any attempt to send would fail
+	sender := SMTPSender{Host: "127.0.0.1", Port: "1", From: "clinic@example.com"}
+	for _, event := range []Event{
+		{Kind: Booked, AppointmentID: "a1"},
+		{Kind: EmergencyContact, PatientEmail: "alice@example.com", Contact: &Contact{Name: "Sam"}},
+	} {
+		if err := sender.Send(context.Background(), event); err != nil {
+			t.Errorf("%s: Send = %v, want it skipped", event.Kind, err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// WebhookSender POSTs each event as JSON to URL, e.g. for an SMS gateway or
+// a chat integration. MagicLink and AccountClosure events are never sent:
+// their links act for the patient, and the receiver may be a third party.
+type WebhookSender struct {
+	URL    string
+	Client *http.Client
+	// Consent, when set, makes the receiver a third party rather than a
+	// delivery gateway: it is only sent the appointment events of patients
+	// with this consent purpose, and no reminders or emergency contact
+	// tasks.
+	Consent string
+}
+
+func (w WebhookSender) Send(ctx context.Context, event Event) error {
+	if event.Kind == MagicLink || event.Kind == AccountClosure {
+		return nil
+	}
+	if w.Consent != "" {
+		switch event.Kind {
+		case Booked, Rescheduled, Cancelled, Digest:
+		default:
+			return nil
+		}
+		if !slices.Contains(event.Consents, w.Consent) {
+			return nil
+		}
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	httpClient := w.Client
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// webhookReceiver records the bodies POSTed to it.
+func webhookReceiver(t *testing.T, status int) (*httptest.Server, chan string) {
+	t.Helper()
+	bodies := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, bodies
+}
+
+func TestWebhookPostsEventAsJSON(t *testing.T) {
+	srv, bodies := webhookReceiver(t, http.StatusAccepted)
+	sender := WebhookSender{URL: srv.URL}
+
+	if err := sender.Send(context.Background(), Event{Kind: Cancelled, PatientID: "p1", AppointmentID: "a1", DoctorName: "Dr. Grey"}); err != nil {
+		t.Fatal(err)
+	}
+	var got Event
+	if err := json.Unmarshal([]byte(<-bodies), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Kind != Cancelled || got.AppointmentID != "a1" || got.DoctorName != "Dr. Grey" {
+		t.Errorf("payload = %+v, want the cancelled appointment", got)
+	}
+}
+
+func TestWebhookReportsErrorStatus(t *testing.T) {
+	srv, _ := webhookReceiver(t, http.StatusBadGateway)
+	err := WebhookSender{URL: srv.URL}.Send(context.Background(), Event{Kind: Booked, AppointmentID: "a1"})
+	if err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("Send = %v, want the receiver's 502", err)
+	}
+}
+
+func TestWebhookNeverSendsSignInLinks(t *testing.T) {
+	srv, bodies := webhookReceiver(t, http.StatusOK)
+	sender := WebhookSender{URL: srv.URL}
+
+	expires := time.Now().Add(15 * time.Minute)
+	link := "https://clinic.example/login?token=secret-sign-in-token"
+	if err := sender.Send(context.Background(), Event{Kind: MagicLink, PatientID: "p1", Link: link, LinkExpiresAt: &expires}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.Send(context.Background(), Event{Kind: AccountClosure, PatientID: "p1", Link: link, LinkExpiresAt: &expires}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.Send(context.Background(), Event{Kind: Booked, PatientID: "p1", AppointmentID: "a1"}); err != nil {
+		t.Fatal(err)
+	}
+	close(bodies)
+	for body := range bodies {
+		if strings.Contains(body, "secret-sign-in-token") {
+			t.Errorf("webhook payload %s contains the sign-in token", body)
+		}
+	}
+}
+
+func TestSharedWebhookOnlyGetsConsentedAppointmentEvents(t *testing.T) {
+	gateway, gatewayBodies := webhookReceiver(t, http.StatusOK)
+	insurer, insurerBodies := webhookReceiver(t, http.StatusOK)
+	n := New(10, WebhookSender{URL: gateway.URL})
+	n.ShareWith(WebhookSender{URL: insurer.URL, Consent: "insurer"})
+
+	events := []Event{
+		{Kind: Booked, PatientID: "p1", AppointmentID: "consented", Consents: []string{"insurer"}},
+		{Kind: Booked, PatientID: "p2", AppointmentID: "private"},
+		{Kind: Reminder, PatientID: "p1", AppointmentID: "reminder", Consents: []string{"insurer"}},
+		{Kind: EmergencyContact, PatientID: "p2", AppointmentID: "emergency", Contact: &Contact{Name: "Sam", Phone: "+15550100", Channel: "sms"}},
+	}
+	for _, event := range events {
+		n.deliver(context.Background(), event)
+	}
+	close(gatewayBodies)
+	close(insurerBodies)
+
+	if got := len(gatewayBodies); got != len(events) {
+		t.Errorf("gateway received %d events, want all %d whatever the consents", got, len(events))
+	}
+	var shared []string
+	for body := range insurerBodies {
+		shared = append(shared, body)
+	}
+	if len(shared) != 1 || !strings.Contains(shared[0], `"consented"`) {
+		t.Errorf("insurer received %v, want only the consenting patient's booking", shared)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"containerized-go-app/notification"
+)
+
+const (
+	reminderInterval = 5 * time.Minute
+	// adultAge is the age from which patients get their own notifications.
+	adultAge = 18
+)
+
+// notifyAppointment queues a notification about appointment. Looking up the
+// patient's contact details happens off the request path.
+func (s *Server) notifyAppointment(kind string, appointment Appointment) {
+	if !s.notifier.Enabled() {
+		return
+	}
+	go func() {
+		event, err := s.appointmentEvent(context.Background(), kind, appointment)
+		if err != nil {
+			log.Printf("Preparing %s notification for appointment %s failed: %v", kind, appointment.ID, err)
+			return
+		}
+		s.notifier.Notify(event)
+	}()
+}
+
+func (s *Server) appointmentEvent(ctx context.Context, kind string, appointment Appointment) (notification.Event, error) {
+	event := notification.Event{
+		Kind:          kind,
+		AppointmentID: appointment.ID,
+		PatientID:     appointment.PatientID,
+		DoctorID:      appointment.DoctorID,
+		StartTime:     appointment.StartTime,
+		EndTime:       appointment.EndTime,
+		Status:        appointment.Status,
+	}
+
+	patient, err := s.findPatient(ctx, appointment.PatientID)
+	if err != nil {
+		return event, err
+	}
+	event.PatientName = patient.PName
+	event.Consents = patient.ActiveConsents(time.Now())
+
+	// Booking confirmations and reminders ahead of the first visit ask for
+	// the rest of the profile
+	if kind == notification.Booked || kind == notification.Reminder {
+		missing := statusOf(patient.PatientProfile).MissingFields
+		if first, err := s.firstVisitPending(ctx, patient.ID); err == nil && first && len(missing) > 0 {
+			event.MissingProfileFields = missing
+		}
+	}
+
+	if isMinor(patient.DateOfBirth, time.Now()) {
+		// A minor's own address is never used, even without a guardian on file
+		if guardian, ok := s.guardianOf(ctx, patient.ID); ok {
+			event.GuardianName = guardian.Username
+			event.PatientEmail = guardian.Email
+		}
+	} else if user, err := s.store.Users.FindByProfile(ctx, RolePatient, appointment.PatientID); err == nil {
+		event.PatientEmail = user.Email
+	}
+
+	if doctor, err := s.findDoctor(ctx, appointment.DoctorID); err == nil {
+		event.DoctorName = doctor.DName
+	}
+	return event, nil
+}
+
+// isMinor reports whether someone born on dateOfBirth, a YYYY-MM-DD date,
+// is under adultAge at t. An unknown date of birth counts as an adult.
+func isMinor(dateOfBirth string, t time.Time) bool {
+	born, err := time.Parse(dateLayout, dateOfBirth)
+	if err != nil {
+		return false
+	}
+	return t.Before(born.AddDate(adultAge, 0, 0))
+}
+
+// guardianOf returns the account of the patient's longest-standing active
+// guardian.
+func (s *Server) guardianOf(ctx context.Context, patientID string) (User, bool) {
+	delegations, err := s.store.Delegations.ForPatient(ctx, patientID)
+	if err != nil {
+		log.Printf("Looking up the guardian of patient %s failed: %v", patientID, err)
+		return User{}, false
+	}
+	now := time.Now()
+	for _, d := range delegations {
+		if !d.Guardian || !d.ActiveAt(now) {
+			continue
+		}
+		if user, err := s.store.Users.FindByUsername(ctx, d.Delegate); err == nil {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// runReminders sends a reminder for every scheduled appointment starting
+// within lead, checking every reminderInterval until ctx is cancelled.
+func (s *Server) runReminders(ctx context.Context, lead time.Duration) {
+	ticker := time.NewTicker(reminderInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.sendDueReminders(ctx, lead); err != nil {
+			log.Println("Sending reminders failed: ", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) sendDueReminders(ctx context.Context, lead time.Duration) error {
+	now := time.Now()
+	due, err := s.store.Appointments.DueReminders(ctx, now, now.Add(lead))
+	if err != nil {
+		return err
+	}
+
+	for _, appointment := range due {
+		// Marking before sending means a reminder is never sent twice, even
+		// with several replicas running this loop
+		marked, err := s.store.Appointments.MarkReminderSent(ctx, appointment.ID, now)
+		if err != nil {
+			return err
+		}
+		if marked {
+			s.notifyAppointment(notification.Reminder, appointment)
+		}
+	}
+	return nil
+}
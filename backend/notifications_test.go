@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"containerized-go-app/notification"
+)
+
+func TestNotifierCollapsesEventsWithinWindow(t *testing.T) {
+	sent := make(captureSender, 10)
+	notifier := notification.New(10, sent)
+	notifier.SuppressWithin(50 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go notifier.Run(ctx)
+
+	start := time.Date(2030, 1, 7, 9, 0, 0, 0, time.UTC)
+	event := func(kind, appointmentID string, hour int) notification.Event {
+		return notification.Event{Kind: kind, AppointmentID: appointmentID, PatientID: "p-alice", StartTime: start.Add(time.Duration(hour) * time.Hour)}
+	}
+	receive := func() notification.Event {
+		t.Helper()
+		select {
+		case got := <-sent:
+			return got
+		case <-time.After(time.Second):
+			t.Fatal("no notification sent")
+			return notification.Event{}
+		}
+	}
+
+	// Booking and rescheduling twice tells of the booking at the final time
+	notifier.Notify(event(notification.Booked, "a1", 0))
+	notifier.Notify(event(notification.Rescheduled, "a1", 1))
+	notifier.Notify(event(notification.Rescheduled, "a1", 2))
+	// Reminders aren't held
+	notifier.Notify(event(notification.Reminder, "a0", 0))
+	if got := receive(); got.Kind != notification.Reminder {
+		t.Fatalf("first event = %+v, want the reminder straight away", got)
+	}
+	if got := receive(); got.Kind != notification.Booked || !got.StartTime.Equal(start.Add(2*time.Hour)) {
+		t.Errorf("collapsed event = %+v, want a1 booked at %s", got, start.Add(2*time.Hour))
+	}
+
+	// Changes to several appointments make a digest; one booked and
+	// cancelled in the window is left out
+	notifier.Notify(event(notification.Rescheduled, "a1", 3))
+	notifier.Notify(event(notification.Booked, "a2", 4))
+	notifier.Notify(event(notification.Booked, "a3", 5))
+	notifier.Notify(event(notification.Cancelled, "a3", 5))
+	got := receive()
+	if got.Kind != notification.Digest || len(got.Events) != 2 || got.Events[0].AppointmentID != "a1" || got.Events[1].Kind != notification.Booked {
+		t.Errorf("digest = %+v, want a1 rescheduled and a2 booked", got)
+	}
+	select {
+	case extra := <-sent:
+		t.Errorf("unexpected extra notification %+v", extra)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
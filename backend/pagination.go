@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// totalCountHeader carries the number of matches across all pages, so list
+// bodies keep their existing shape.
+const totalCountHeader = "X-Total-Count"
+
+// listQuery is the page, limit and sort order of a listing request.
+type listQuery struct {
+	Page  int
+	Limit int
+	Sort  string
+	Desc  bool
+}
+
+// parseListQuery reads ?page= (from 1), ?limit= and ?sort=. sort names one
+// of sortable, the fields the store can order that listing by, optionally
+// prefixed with - for descending order. Without ?sort= the listing is in
+// ascending defaultSort order.
+func parseListQuery(c *gin.Context, defaultSort string, sortable ...string) (listQuery, error) {
+	q := listQuery{Page: 1, Limit: defaultPageLimit, Sort: defaultSort}
+
+	if value := c.Query("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 {
+			return q, errors.New("page must be a positive integer")
+		}
+		q.Page = page
+	}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return q, errors.New("limit must be between 1 and " + strconv.Itoa(maxPageLimit))
+		}
+		q.Limit = limit
+	}
+	if value := c.Query("sort"); value != "" {
+		name, descending := strings.CutPrefix(value, "-")
+		if !slices.Contains(sortable, name) {
+			return q, errors.New("cannot sort by " + name)
+		}
+		q.Sort = name
+		q.Desc = descending
+	}
+	return q, nil
+}
+
+func (q listQuery) page() store.Page {
+	return store.Page{
+		Skip:  int64(q.Page-1) * int64(q.Limit),
+		Limit: int64(q.Limit),
+		Sort:  q.Sort,
+		Desc:  q.Desc,
+	}
+}
+
+// parseTimeQuery reads an RFC3339 time or a YYYY-MM-DD date (midnight UTC)
+// from the query string. It returns the zero time when the parameter is
+// absent.
+func parseTimeQuery(c *gin.Context, name string) (time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	if t, err := time.Parse(dateLayout, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errors.New(name + " must be an RFC3339 time or a YYYY-MM-DD date")
+}
+
+func setTotalCount(c *gin.Context, total int64) {
+	c.Header(totalCountHeader, strconv.FormatInt(total, 10))
+}
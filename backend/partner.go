@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Partner = store.Partner
+
+// rolePartner marks partner tokens; like roleReporting it isn't a user
+// role, so these tokens only open the partner API.
+const rolePartner = "partner"
+
+// partnerKey is the gin context key holding the authenticated Partner.
+const partnerKey = "partner"
+
+const (
+	defaultPartnerDays = 365
+	// referralTag marks patient records created by a partner's booking
+	referralTag = "referral"
+)
+
+var errQuotaExceeded = errors.New("partner booking quota exceeded")
+
+type partnerRequest struct {
+	Name         string   `json:"name" binding:"required,notblank,max=200"`
+	DoctorIDs    []string `json:"doctorIds" binding:"max=100,dive,notblank"`
+	MonthlyQuota int      `json:"monthlyQuota" binding:"min=0,max=10000"`
+	// Days is how long the token lasts, defaultPartnerDays if unset.
+	Days int `json:"days" binding:"omitempty,min=1,max=730"`
+}
+
+// referralRequest books a referred patient, who gets a patient record of
+// their own since the partner has no account here.
+type referralRequest struct {
+	DoctorID  string          `json:"doctorId" binding:"required,notblank"`
+	StartTime time.Time       `json:"startTime" binding:"required"`
+	Patient   referralPatient `json:"patient" binding:"required"`
+	Notes     string          `json:"notes" binding:"max=2000"`
+}
+
+type referralPatient struct {
+	Name        string `json:"pname" binding:"required,notblank,max=200"`
+	Phone       string `json:"phone" binding:"required,e164"`
+	DateOfBirth string `json:"dateOfBirth" binding:"omitempty,datetime=2006-01-02"`
+}
+
+// RequirePartner lets active partner tokens through and stores the
+// partner for currentPartner.
+func (s *Server) RequirePartner() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := bearerToken(c)
+		if token == "" {
+			abortWithError(c, http.StatusUnauthorized, "Missing bearer token")
+			return
+		}
+		id, err := parseScopedToken(token, rolePartner)
+		if err != nil {
+			abortWithError(c, http.StatusUnauthorized, "Invalid or expired token")
+			return
+		}
+		partner, err := s.store.Partners.Get(c.Request.Context(), id)
+		if errors.Is(err, store.ErrNotFound) || err == nil && !partner.Active(time.Now()) {
+			abortWithError(c, http.StatusUnauthorized, "Invalid or expired token")
+			return
+		} else if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "Error checking token")
+			return
+		}
+		c.Set(partnerKey, partner)
+		c.Next()
+	}
+}
+
+func currentPartner(c *gin.Context) Partner {
+	partner, _ := c.Value(partnerKey).(Partner)
+	return partner
+}
+
+// partnerMayBook reports whether the partner's scope includes doctorID.
+func partnerMayBook(partner Partner, doctorID string) bool {
+	return len(partner.DoctorIDs) == 0 || slices.Contains(partner.DoctorIDs, doctorID)
+}
+
+// RequirePartnerDoctor answers 404, as if the doctor didn't exist, for the
+// :id doctors outside the partner's scope.
+func RequirePartnerDoctor(c *gin.Context) {
+	if !partnerMayBook(currentPartner(c), c.Param("id")) {
+		abortWithError(c, http.StatusNotFound, "Doctor not found")
+		return
+	}
+	c.Next()
+}
+
+// GetPartnerDoctors lists the doctors the partner may refer to.
+func (s *Server) GetPartnerDoctors(c *gin.Context) {
+	partner := currentPartner(c)
+	filter := store.DoctorFilter{Specialization: c.Query("specialization")}
+	if len(partner.DoctorIDs) > 0 {
+		filter.IDs = partner.DoctorIDs
+	}
+	doctors, total, err := s.store.Doctors.List(c.Request.Context(), filter, store.Page{Sort: "name"})
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error fetching doctor data")
+		return
+	}
+	setTotalCount(c, total)
+	c.JSON(http.StatusOK, doctors)
+}
+
+// referralMonth returns the clinic month of start, as YYYY-MM and as the
+// time it starts.
+func referralMonth(start time.Time) (string, time.Time) {
+	local := start.In(calendarLocation)
+	monthStart := time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, calendarLocation)
+	return monthStart.Format("2006-01"), monthStart
+}
+
+// countReferral counts a referral starting at start against the partner's
+// MonthlyQuota, returning errQuotaExceeded when the month's are used up.
+// The count is one atomic step, so concurrent referrals can't go over.
+func (s *Server) countReferral(ctx context.Context, partner Partner, start time.Time) error {
+	if partner.MonthlyQuota == 0 {
+		return nil
+	}
+	month, monthStart := referralMonth(start)
+	filter := store.AppointmentFilter{
+		PartnerID: partner.ID,
+		Statuses:  []string{AppointmentScheduled, AppointmentCompleted, AppointmentNoShow},
+		From:      monthStart.UTC(),
+		To:        monthStart.AddDate(0, 1, 0).UTC(),
+	}
+	_, booked, err := s.store.Appointments.List(ctx, filter, store.Page{Limit: 1})
+	if err != nil {
+		return err
+	}
+	counted, err := s.store.Partners.CountReferral(ctx, partner.ID, month, partner.MonthlyQuota, booked)
+	if err != nil {
+		return err
+	}
+	if !counted {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// uncountReferral gives a partner's appointment back to its quota once it
+// is cancelled or its booking fails. A referral moved to another month
+// stays counted in the month it was booked for.
+func (s *Server) uncountReferral(ctx context.Context, appointment Appointment) {
+	if appointment.PartnerID == "" {
+		return
+	}
+	month, _ := referralMonth(appointment.StartTime)
+	if err := s.store.Partners.UncountReferral(ctx, appointment.PartnerID, month); err != nil {
+		log.Printf("Giving back partner %s's referral %s failed: %v", appointment.PartnerID, appointment.ID, err)
+	}
+}
+
+// CreateReferral books a referred patient with one of the partner's
+// doctors. The slot is checked before the patient record is created, and
+// the record is deleted again if the booking still fails, e.g. when another
+// booking takes the slot first, so a booking turned away doesn't leave one
+// behind.
+func (s *Server) CreateReferral(c *gin.Context) {
+	ctx := c.Request.Context()
+	partner := currentPartner(c)
+	var req referralRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if !partnerMayBook(partner, req.DoctorID) {
+		abortWithError(c, http.StatusNotFound, "Doctor not found")
+		return
+	}
+	if err := s.countReferral(ctx, partner, req.StartTime); errors.Is(err, errQuotaExceeded) {
+		abortWithCode(c, http.StatusTooManyRequests, "quota_exceeded", "The partner's referrals for that month are used up")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error checking referral quota")
+		return
+	}
+	appointment := Appointment{DoctorID: req.DoctorID, StartTime: req.StartTime, Notes: req.Notes, PartnerID: partner.ID}
+	booked := false
+	defer func() {
+		if !booked {
+			s.uncountReferral(context.WithoutCancel(ctx), appointment)
+		}
+	}()
+
+	doctor, err := s.findDoctor(ctx, req.DoctorID)
+	if err != nil {
+		writeAppointmentError(c, err, "Error booking referral")
+		return
+	}
+	// A new patient record has never seen the doctor
+	if slot, err := fitToSlot(doctor, &appointment); err != nil {
+		writeAppointmentError(c, err, "Error booking referral")
+		return
+	} else if slot.FollowUpOnly {
+		writeAppointmentError(c, errFollowUpOnly, "Error booking referral")
+		return
+	}
+
+	now := time.Now().UTC()
+	patient := Patient{
+		ID:    primitive.NewObjectID().Hex(),
+		PName: req.Patient.Name,
+		Tags:  []string{referralTag},
+		Notes: []PatientNote{{Text: "Referred by " + partner.Name, Author: partner.Name, CreatedAt: now}},
+		PatientProfile: store.PatientProfile{
+			Phone:       req.Patient.Phone,
+			DateOfBirth: req.Patient.DateOfBirth,
+		},
+	}
+	if err := s.store.Patients.Create(ctx, patient); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error creating patient record")
+		return
+	}
+
+	appointment.PatientID = patient.ID
+	if _, err := s.createAppointment(ctx, &appointment); err != nil {
+		if err := s.store.Patients.Delete(context.WithoutCancel(ctx), patient.ID); err != nil {
+			log.Printf("Deleting referred patient %s after a failed booking failed: %v", patient.ID, err)
+		}
+		writeAppointmentError(c, err, "Error booking referral")
+		return
+	}
+	booked = true
+	s.patientIndex.put(patient)
+	appointment.SecondaryDate = secondaryDate(appointment.StartTime)
+	c.JSON(http.StatusCreated, gin.H{"appointment": appointment, "patientId": patient.ID})
+}
+
+// GetReferrals lists the appointments the partner booked, earliest first.
+func (s *Server) GetReferrals(c *gin.Context) {
+	filter := store.AppointmentFilter{PartnerID: currentPartner(c).ID}
+	appointments, total, err := s.store.Appointments.List(c.Request.Context(), filter, store.Page{Sort: "startTime"})
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving referrals")
+		return
+	}
+	setTotalCount(c, total)
+	c.JSON(http.StatusOK, appointments)
+}
+
+// CreatePartner registers a referring clinic and issues its token, which
+// is only in this response.
+func (s *Server) CreatePartner(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req partnerRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	for _, id := range req.DoctorIDs {
+		if exists, err := s.store.Doctors.Exists(ctx, id); err != nil {
+			abortWithError(c, http.StatusInternalServerError, "Error retrieving doctor")
+			return
+		} else if !exists {
+			abortWithDetails(c, fieldError{Field: "doctorIds", Message: "must be existing doctors"})
+			return
+		}
+	}
+	if req.Days == 0 {
+		req.Days = defaultPartnerDays
+	}
+
+	user, _ := currentUser(c)
+	now := time.Now().UTC().Truncate(time.Second)
+	partner := Partner{
+		ID:           primitive.NewObjectID().Hex(),
+		Name:         req.Name,
+		DoctorIDs:    req.DoctorIDs,
+		MonthlyQuota: req.MonthlyQuota,
+		CreatedBy:    user.Username,
+		CreatedAt:    now,
+		ExpiresAt:    now.AddDate(0, 0, req.Days),
+	}
+	if partner.DoctorIDs == nil {
+		partner.DoctorIDs = []string{}
+	}
+	token, err := issueScopedToken(rolePartner, partner.ID, partner.CreatedAt, partner.ExpiresAt)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error issuing token")
+		return
+	}
+	if err := s.store.Partners.Create(ctx, partner); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error saving partner")
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"token": token, "partner": partner})
+}
+
+func (s *Server) GetPartners(c *gin.Context) {
+	partners, err := s.store.Partners.List(c.Request.Context())
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving partners")
+		return
+	}
+	c.JSON(http.StatusOK, partners)
+}
+
+func (s *Server) RevokePartner(c *gin.Context) {
+	err := s.store.Partners.Revoke(c.Request.Context(), c.Param("partnerID"), time.Now().UTC())
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, "Partner not found")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error revoking partner")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Partner revoked"})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPartnerReferrals(t *testing.T) {
+	f := newBookingFixture(t)
+	if err := f.store.Doctors.Create(context.Background(), Doctor{ID: "d2", DName: "Dr. Shepherd"}); err != nil {
+		t.Fatal(err)
+	}
+	admin := User{Username: "root", Role: RoleAdmin}
+	created := decode[struct {
+		Token   string  `json:"token"`
+		Partner Partner `json:"partner"`
+	}](t, f.do(t, http.MethodPost, "/api/admin/partners", admin, gin.H{"name": "Nile Clinic", "doctorIds": []string{"d1"}, "monthlyQuota": 1}), http.StatusCreated)
+
+	partnerDo := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		t.Helper()
+		var payload bytes.Buffer
+		if body != nil {
+			if err := json.NewEncoder(&payload).Encode(body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, &payload)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+created.Token)
+		f.handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	doctors := decode[[]Doctor](t, partnerDo(http.MethodGet, "/api/partner/doctors", nil), http.StatusOK)
+	if len(doctors) != 1 || doctors[0].ID != "d1" {
+		t.Errorf("partner doctors = %+v, want only d1", doctors)
+	}
+	day := f.first.Format(dateLayout)
+	slots := decode[[]Slot](t, partnerDo(http.MethodGet, "/api/partner/doctors/d1/slots?from="+day+"&to="+day, nil), http.StatusOK)
+	if len(slots) != 2 {
+		t.Errorf("slots = %+v, want both", slots)
+	}
+	decode[apiError](t, partnerDo(http.MethodGet, "/api/partner/doctors/d2/slots?from="+day+"&to="+day, nil), http.StatusNotFound)
+
+	patient := gin.H{"pname": "Mona Adel", "phone": "+201005550000"}
+	decode[apiError](t, partnerDo(http.MethodPost, "/api/partner/referrals", gin.H{"doctorId": "d2", "startTime": f.first, "patient": patient}), http.StatusNotFound)
+	booked := decode[struct {
+		Appointment Appointment `json:"appointment"`
+		PatientID   string      `json:"patientId"`
+	}](t, partnerDo(http.MethodPost, "/api/partner/referrals", gin.H{"doctorId": "d1", "startTime": f.first, "patient": patient}), http.StatusCreated)
+	if booked.Appointment.PartnerID != created.Partner.ID {
+		t.Errorf("appointment partnerId = %q, want %q", booked.Appointment.PartnerID, created.Partner.ID)
+	}
+	record, err := f.store.Patients.Get(context.Background(), booked.PatientID)
+	if err != nil || record.Phone != "+201005550000" || len(record.Tags) != 1 || record.Tags[0] != referralTag {
+		t.Errorf("referred patient = %+v, %v", record, err)
+	}
+
+	// The month's quota of one is used up
+	rec := partnerDo(http.MethodPost, "/api/partner/referrals", gin.H{"doctorId": "d1", "startTime": f.second, "patient": patient})
+	if body := decode[apiError](t, rec, http.StatusTooManyRequests); body.Code != "quota_exceeded" {
+		t.Errorf("code = %q, want quota_exceeded", body.Code)
+	}
+	referrals := decode[[]Appointment](t, partnerDo(http.MethodGet, "/api/partner/referrals", nil), http.StatusOK)
+	if len(referrals) != 1 {
+		t.Errorf("referrals = %+v, want the one booked", referrals)
+	}
+
+	// Partner tokens open nothing else, and revoking ends them
+	decode[apiError](t, partnerDo(http.MethodGet, "/api/patients", nil), http.StatusUnauthorized)
+	f.do(t, http.MethodDelete, "/api/admin/partners/"+created.Partner.ID, admin, nil)
+	decode[apiError](t, partnerDo(http.MethodGet, "/api/partner/doctors", nil), http.StatusUnauthorized)
+}
+
+// newReferrer registers a partner with monthlyQuota and returns a function
+// referring a new patient to d1 at start with its token.
+func newReferrer(t *testing.T, f *bookingFixture, monthlyQuota int) func(start time.Time) *httptest.ResponseRecorder {
+	t.Helper()
+	admin := User{Username: "root", Role: RoleAdmin}
+	created := decode[struct {
+		Token string `json:"token"`
+	}](t, f.do(t, http.MethodPost, "/api/admin/partners", admin, gin.H{"name": "Nile Clinic", "monthlyQuota": monthlyQuota}), http.StatusCreated)
+	return func(start time.Time) *httptest.ResponseRecorder {
+		var payload bytes.Buffer
+		json.NewEncoder(&payload).Encode(gin.H{"doctorId": "d1", "startTime": start, "patient": gin.H{"pname": "Mona Adel", "phone": "+201005550000"}})
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/partner/referrals", &payload)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+created.Token)
+		f.handler.ServeHTTP(rec, req)
+		return rec
+	}
+}
+
+// racingReferrals holds each partner's booked referrals, once listed,
+// until all parties have listed them, like referrals arriving at once.
+type racingReferrals struct {
+	store.AppointmentRepository
+	mu      sync.Mutex
+	parties int
+	all     chan struct{}
+}
+
+func (r *racingReferrals) List(ctx context.Context, filter store.AppointmentFilter, page store.Page) ([]Appointment, int64, error) {
+	appointments, total, err := r.AppointmentRepository.List(ctx, filter, page)
+	if filter.PartnerID != "" && len(filter.Statuses) > 0 {
+		r.mu.Lock()
+		if r.parties--; r.parties == 0 {
+			close(r.all)
+		}
+		r.mu.Unlock()
+		select {
+		case <-r.all:
+		case <-time.After(time.Second):
+		}
+	}
+	return appointments, total, err
+}
+
+func TestPartnerQuotaHoldsUnderConcurrentReferrals(t *testing.T) {
+	f := newBookingFixture(t)
+	admin := User{Username: "root", Role: RoleAdmin}
+	refer := newReferrer(t, f, 1)
+
+	// A referral turned away doesn't use up the quota
+	if rec := refer(f.first.Add(time.Hour)); rec.Code != http.StatusBadRequest {
+		t.Fatalf("off-schedule referral status = %d, want 400", rec.Code)
+	}
+
+	appointments := f.store.Appointments
+	f.store.Appointments = &racingReferrals{AppointmentRepository: appointments, parties: 2, all: make(chan struct{})}
+	codes := make(chan int, 2)
+	var wg sync.WaitGroup
+	for _, start := range []time.Time{f.first, f.second} {
+		start := start
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- refer(start).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	f.store.Appointments = appointments
+	booked := 0
+	for code := range codes {
+		if code == http.StatusCreated {
+			booked++
+		}
+	}
+	if booked != 1 {
+		t.Fatalf("%d referrals booked on a quota of 1", booked)
+	}
+
+	// Cancelling the referral gives it back
+	referral, _, err := f.store.Appointments.List(context.Background(), store.AppointmentFilter{Statuses: []string{AppointmentScheduled}}, store.Page{})
+	if err != nil || len(referral) != 1 {
+		t.Fatalf("scheduled = %+v, %v; want the one referral", referral, err)
+	}
+	path := "/api/patients/" + referral[0].PatientID + "/appointments/" + referral[0].ID + "?reason=patient_request"
+	decode[struct{}](t, f.do(t, http.MethodDelete, path, admin, nil), http.StatusOK)
+	if rec := refer(referral[0].StartTime); rec.Code != http.StatusCreated {
+		t.Errorf("referral after the cancellation status = %d, want 201", rec.Code)
+	}
+}
+
+func TestFailedReferralLeavesNoPatient(t *testing.T) {
+	f := newBookingFixture(t)
+	ctx := context.Background()
+	refer := newReferrer(t, f, 0)
+
+	// Another booking takes the slot between the check and the claim
+	if err := f.store.Slots.Claim(ctx, store.SlotClaim{ID: store.SlotClaimID("d1", f.first), DoctorID: "d1", StartTime: f.first, AppointmentID: "racing"}); err != nil {
+		t.Fatal(err)
+	}
+	if rec := refer(f.first); rec.Code != http.StatusConflict {
+		t.Fatalf("referral to a taken slot status = %d, want 409", rec.Code)
+	}
+	if _, total, err := f.store.Patients.List(ctx, store.PatientFilter{}, store.Page{}); err != nil || total != 2 {
+		t.Errorf("patients = %d, %v; want only alice and bob", total, err)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PatientProfile = store.PatientProfile
+
+// profileFields are the profile details counted towards completeness, by
+// JSON name.
+var profileFields = []struct {
+	name  string
+	value func(PatientProfile) string
+}{
+	{"dateOfBirth", func(p PatientProfile) string { return p.DateOfBirth }},
+	{"phone", func(p PatientProfile) string { return p.Phone }},
+	{"address", func(p PatientProfile) string { return p.Address }},
+	{"insuranceNumber", func(p PatientProfile) string { return p.InsuranceNumber }},
+}
+
+// profileUpdate changes the profile fields that are present and leaves the
+// others alone; an empty string clears a field. The formats of
+// dateOfBirth and phone are checked by check, since binding tags would
+// reject clearing them too.
+type profileUpdate struct {
+	DateOfBirth     *string `json:"dateOfBirth"`
+	Phone           *string `json:"phone"`
+	Address         *string `json:"address" binding:"omitempty,max=500"`
+	InsuranceNumber *string `json:"insuranceNumber" binding:"omitempty,max=64"`
+}
+
+// check validates the formatted fields being set rather than cleared.
+func (u profileUpdate) check() []fieldError {
+	var details []fieldError
+	for _, field := range []struct {
+		name  string
+		value *string
+		tags  string
+	}{
+		{"dateOfBirth", u.DateOfBirth, "datetime=2006-01-02"},
+		{"phone", u.Phone, "e164"},
+	} {
+		if field.value == nil || *field.value == "" {
+			continue
+		}
+		if message := checkVar(*field.value, field.tags); message != "" {
+			details = append(details, fieldError{Field: field.name, Message: message})
+		}
+	}
+	return details
+}
+
+func (u profileUpdate) apply(profile *PatientProfile) {
+	if u.DateOfBirth != nil {
+		profile.DateOfBirth = *u.DateOfBirth
+	}
+	if u.Phone != nil {
+		profile.Phone = *u.Phone
+	}
+	if u.Address != nil {
+		profile.Address = *u.Address
+	}
+	if u.InsuranceNumber != nil {
+		profile.InsuranceNumber = *u.InsuranceNumber
+	}
+}
+
+// profileStatus reports how much of a patient's profile is filled in.
+type profileStatus struct {
+	// Completeness is the percentage of profileFields filled in.
+	Completeness  int      `json:"completeness"`
+	MissingFields []string `json:"missingFields"`
+}
+
+func statusOf(profile PatientProfile) profileStatus {
+	status := profileStatus{MissingFields: []string{}}
+	for _, field := range profileFields {
+		if field.value(profile) == "" {
+			status.MissingFields = append(status.MissingFields, field.name)
+		}
+	}
+	status.Completeness = 100 * (len(profileFields) - len(status.MissingFields)) / len(profileFields)
+	return status
+}
+
+// UpdatePatientProfile fills in some of a patient's profile and reports
+// what is still missing.
+func (s *Server) UpdatePatientProfile(c *gin.Context) {
+	ctx := c.Request.Context()
+	var update profileUpdate
+	if !bindJSON(c, &update) {
+		return
+	}
+	if details := update.check(); len(details) > 0 {
+		abortWithDetails(c, details...)
+		return
+	}
+
+	patient, ok := s.findPatientOrAbort(c)
+	if !ok {
+		return
+	}
+	update.apply(&patient.PatientProfile)
+	if err := s.store.Patients.SetProfile(ctx, patient.ID, patient.PatientProfile); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error updating patient profile")
+		return
+	}
+	s.patientIndex.put(patient)
+
+	c.JSON(http.StatusOK, gin.H{"profile": patient.PatientProfile, "status": statusOf(patient.PatientProfile)})
+}
+
+// GetMissingProfileFields lists the profile details a patient hasn't
+// provided yet.
+func (s *Server) GetMissingProfileFields(c *gin.Context) {
+	patient, ok := s.findPatientOrAbort(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, statusOf(patient.PatientProfile))
+}
+
+// findPatientOrAbort loads the patient in the :id path parameter, ending
+// the request when that fails.
+func (s *Server) findPatientOrAbort(c *gin.Context) (Patient, bool) {
+	patient, err := s.findPatient(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, errPatientNotFound) {
+		abortWithError(c, http.StatusNotFound, "Patient not found")
+		return patient, false
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving patient")
+		return patient, false
+	}
+	return patient, true
+}
+
+// firstVisitPending reports whether patient has never completed an
+// appointment.
+func (s *Server) firstVisitPending(ctx context.Context, patientID string) (bool, error) {
+	visited, err := s.hasVisited(ctx, patientID, "")
+	return !visited, err
+}
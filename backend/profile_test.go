@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestProgressivePatientProfile(t *testing.T) {
+	f := newBookingFixture(t)
+	const path = "/api/patients/p-alice/profile"
+
+	// Booking doesn't need a profile
+	decode[bookingResponse](t, f.book(t, f.alice, f.first), http.StatusOK)
+	status := decode[profileStatus](t, f.do(t, http.MethodGet, path+"/missing-fields", f.alice, nil), http.StatusOK)
+	if status.Completeness != 0 || len(status.MissingFields) != len(profileFields) {
+		t.Errorf("new patient status = %+v, want every field missing", status)
+	}
+
+	decode[apiError](t, f.do(t, http.MethodPatch, path, f.alice, gin.H{"phone": "555-0100"}), http.StatusBadRequest)
+
+	decode[struct{}](t, f.do(t, http.MethodPatch, path, f.alice, gin.H{"phone": "+14155550100", "dateOfBirth": "1990-04-01"}), http.StatusOK)
+	updated := decode[struct {
+		Profile PatientProfile `json:"profile"`
+		Status  profileStatus  `json:"status"`
+	}](t, f.do(t, http.MethodPatch, path, f.alice, gin.H{"address": "1 Main St"}), http.StatusOK)
+	if updated.Profile.Phone != "+14155550100" || updated.Profile.Address != "1 Main St" {
+		t.Errorf("profile = %+v, want earlier fields kept", updated.Profile)
+	}
+	if updated.Status.Completeness != 75 || !slices.Equal(updated.Status.MissingFields, []string{"insuranceNumber"}) {
+		t.Errorf("status = %+v, want 75%% with insuranceNumber missing", updated.Status)
+	}
+
+	// An empty string clears a field
+	cleared := decode[struct {
+		Profile PatientProfile `json:"profile"`
+	}](t, f.do(t, http.MethodPatch, path, f.alice, gin.H{"phone": "", "dateOfBirth": "", "address": ""}), http.StatusOK)
+	if cleared.Profile.Phone != "" || cleared.Profile.DateOfBirth != "" || cleared.Profile.Address != "" {
+		t.Errorf("profile = %+v, want phone, date of birth and address cleared", cleared.Profile)
+	}
+	decode[apiError](t, f.do(t, http.MethodPatch, path, f.alice, gin.H{"dateOfBirth": "01/04/1990"}), http.StatusBadRequest)
+
+	decode[apiError](t, f.do(t, http.MethodGet, "/api/patients/p-bob/profile/missing-fields", f.alice, nil), http.StatusForbidden)
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+const (
+	profilerWindow     = 15 * time.Minute
+	profilerMaxSamples = 5000
+	profilerTopN       = 10
+)
+
+// profiledCommands are the Mongo commands worth profiling; handshakes,
+// pings and cursor continuations are skipped.
+var profiledCommands = map[string]bool{
+	"find":          true,
+	"aggregate":     true,
+	"count":         true,
+	"distinct":      true,
+	"insert":        true,
+	"update":        true,
+	"delete":        true,
+	"findAndModify": true,
+}
+
+type QuerySample struct {
+	Command        string    `json:"command"`
+	Database       string    `json:"database"`
+	Collection     string    `json:"collection"`
+	Filter         string    `json:"filter,omitempty"`
+	Sort           string    `json:"sort,omitempty"`
+	SuggestedIndex string    `json:"suggestedIndex,omitempty"`
+	DurationMS     float64   `json:"durationMs"`
+	Failed         bool      `json:"failed"`
+	At             time.Time `json:"at"`
+}
+
+type RequestSample struct {
+	Method     string    `json:"method"`
+	Route      string    `json:"route"`
+	Status     int       `json:"status"`
+	DurationMS float64   `json:"durationMs"`
+	At         time.Time `json:"at"`
+}
+
+type EndpointStats struct {
+	Method        string  `json:"method"`
+	Route         string  `json:"route"`
+	Count         int     `json:"count"`
+	AvgDurationMS float64 `json:"avgDurationMs"`
+	MaxDurationMS float64 `json:"maxDurationMs"`
+}
+
+// profiler records Mongo queries and HTTP requests over a rolling window
+// so the slowest ones can be inspected from the diagnostics endpoint.
+type profiler struct {
+	window time.Duration
+
+	mu       sync.Mutex
+	pending  map[int64]QuerySample
+	queries  []QuerySample
+	requests []RequestSample
+}
+
+func newProfiler(window time.Duration) *profiler {
+	return &profiler{
+		window:  window,
+		pending: make(map[int64]QuerySample),
+	}
+}
+
+// commandMonitor returns a Mongo command monitor feeding the profiler.
+func (p *profiler) commandMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, evt *event.CommandStartedEvent) {
+			if !profiledCommands[evt.CommandName] {
+				return
+			}
+			sample := describeCommand(evt.CommandName, evt.Command)
+			sample.Database = evt.DatabaseName
+
+			p.mu.Lock()
+			p.pending[evt.RequestID] = sample
+			p.mu.Unlock()
+		},
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			if sample, ok := p.finishQuery(evt.RequestID, evt.Duration, false); ok {
+				recordStep(ctx, sample.Command+" "+sample.Collection, evt.Duration, false)
+			}
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			if sample, ok := p.finishQuery(evt.RequestID, evt.Duration, true); ok {
+				recordStep(ctx, sample.Command+" "+sample.Collection, evt.Duration, true)
+			}
+		},
+	}
+}
+
+func (p *profiler) finishQuery(requestID int64, duration time.Duration, failed bool) (QuerySample, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sample, ok := p.pending[requestID]
+	if !ok {
+		return sample, false
+	}
+	delete(p.pending, requestID)
+
+	sample.DurationMS = durationMS(duration)
+	sample.Failed = failed
+	sample.At = time.Now()
+	p.queries = appendSample(p.queries, sample, p.cutoff())
+	return sample, true
+}
+
+// middleware records the duration of every request by its route template.
+func (p *profiler) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		sample := RequestSample{
+			Method:     c.Request.Method,
+			Route:      route,
+			Status:     c.Writer.Status(),
+			DurationMS: durationMS(time.Since(start)),
+			At:         time.Now(),
+		}
+
+		p.mu.Lock()
+		p.requests = appendSample(p.requests, sample, p.cutoff())
+		p.mu.Unlock()
+	}
+}
+
+func (p *profiler) GetDiagnostics(c *gin.Context) {
+	p.mu.Lock()
+	cutoff := p.cutoff()
+	queries := append([]QuerySample(nil), pruneSamples(p.queries, cutoff)...)
+	requests := append([]RequestSample(nil), pruneSamples(p.requests, cutoff)...)
+	p.mu.Unlock()
+
+	sort.Slice(queries, func(i, j int) bool { return queries[i].DurationMS > queries[j].DurationMS })
+	sort.Slice(requests, func(i, j int) bool { return requests[i].DurationMS > requests[j].DurationMS })
+
+	c.JSON(http.StatusOK, gin.H{
+		"windowSeconds": p.window.Seconds(),
+		"slowQueries":   topN(queries, profilerTopN),
+		"slowRequests":  topN(requests, profilerTopN),
+		"hotEndpoints":  endpointStats(requests),
+	})
+}
+
+func (p *profiler) cutoff() time.Time {
+	return time.Now().Add(-p.window)
+}
+
+type timedSample interface {
+	recordedAt() time.Time
+}
+
+func (s QuerySample) recordedAt() time.Time   { return s.At }
+func (s RequestSample) recordedAt() time.Time { return s.At }
+
+// pruneSamples drops samples recorded before cutoff. Samples are appended
+// in time order, so everything expired sits at the front of the slice.
+func pruneSamples[T timedSample](samples []T, cutoff time.Time) []T {
+	i := sort.Search(len(samples), func(i int) bool { return !samples[i].recordedAt().Before(cutoff) })
+	return samples[i:]
+}
+
+// appendSample adds a sample, evicting expired ones and then the oldest
+// ones once the buffer is full.
+func appendSample[T timedSample](samples []T, sample T, cutoff time.Time) []T {
+	samples = pruneSamples(samples, cutoff)
+	if len(samples) >= profilerMaxSamples {
+		samples = samples[len(samples)-profilerMaxSamples+1:]
+	}
+	return append(samples, sample)
+}
+
+func topN[T any](samples []T, n int) []T {
+	if len(samples) > n {
+		return samples[:n]
+	}
+	return samples
+}
+
+// endpointStats groups requests by route, busiest first.
+func endpointStats(requests []RequestSample) []EndpointStats {
+	byRoute := make(map[string]*EndpointStats)
+	for _, r := range requests {
+		key := r.Method + " " + r.Route
+		stats, ok := byRoute[key]
+		if !ok {
+			stats = &EndpointStats{Method: r.Method, Route: r.Route}
+			byRoute[key] = stats
+		}
+		stats.Count++
+		stats.AvgDurationMS += r.DurationMS
+		if r.DurationMS > stats.MaxDurationMS {
+			stats.MaxDurationMS = r.DurationMS
+		}
+	}
+
+	result := make([]EndpointStats, 0, len(byRoute))
+	for _, stats := range byRoute {
+		stats.AvgDurationMS /= float64(stats.Count)
+		result = append(result, *stats)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Count > result[j].Count })
+	return topN(result, profilerTopN)
+}
+
+// describeCommand extracts the collection, filter shape and sort of a
+// command with all literal values redacted.
+func describeCommand(name string, command bson.Raw) QuerySample {
+	sample := QuerySample{Command: name}
+	if coll, ok := command.Lookup(name).StringValueOK(); ok {
+		sample.Collection = coll
+	}
+
+	var filter, sortSpec bson.Raw
+	switch name {
+	case "find":
+		filter, _ = command.Lookup("filter").DocumentOK()
+		sortSpec, _ = command.Lookup("sort").DocumentOK()
+	case "count", "distinct", "findAndModify":
+		filter, _ = command.Lookup("query").DocumentOK()
+		sortSpec, _ = command.Lookup("sort").DocumentOK()
+	case "update", "delete":
+		filter = firstStatementFilter(command, map[string]string{"update": "updates", "delete": "deletes"}[name])
+	case "aggregate":
+		filter = firstMatchStage(command)
+	}
+
+	var filterShape, sortShape bson.D
+	if filter != nil {
+		filterShape = redact(filter)
+		sample.Filter = extJSON(filterShape)
+	}
+	if sortSpec != nil {
+		_ = bson.Unmarshal(sortSpec, &sortShape)
+		sample.Sort = extJSON(sortShape)
+	}
+	if index := suggestIndex(filterShape, sortShape); len(index) > 0 {
+		sample.SuggestedIndex = extJSON(index)
+	}
+	return sample
+}
+
+func firstStatementFilter(command bson.Raw, field string) bson.Raw {
+	statements, ok := command.Lookup(field).ArrayOK()
+	if !ok {
+		return nil
+	}
+	values, err := statements.Values()
+	if err != nil || len(values) == 0 {
+		return nil
+	}
+	statement, ok := values[0].DocumentOK()
+	if !ok {
+		return nil
+	}
+	filter, _ := statement.Lookup("q").DocumentOK()
+	return filter
+}
+
+func firstMatchStage(command bson.Raw) bson.Raw {
+	pipeline, ok := command.Lookup("pipeline").ArrayOK()
+	if !ok {
+		return nil
+	}
+	stages, err := pipeline.Values()
+	if err != nil || len(stages) == 0 {
+		return nil
+	}
+	stage, ok := stages[0].DocumentOK()
+	if !ok {
+		return nil
+	}
+	match, _ := stage.Lookup("$match").DocumentOK()
+	return match
+}
+
+// redact replaces every literal in a filter with "?", keeping field names
+// and operators so queries with the same shape group together.
+func redact(doc bson.Raw) bson.D {
+	elems, err := doc.Elements()
+	if err != nil {
+		return nil
+	}
+
+	shape := make(bson.D, 0, len(elems))
+	for _, elem := range elems {
+		var value interface{} = "?"
+		if sub, ok := elem.Value().DocumentOK(); ok {
+			value = redact(sub)
+		} else if arr, ok := elem.Value().ArrayOK(); ok && strings.HasPrefix(elem.Key(), "$") && elem.Key() != "$in" && elem.Key() != "$nin" {
+			// Logical operators ($and, $or, ...) hold sub-filters worth keeping
+			values, _ := arr.Values()
+			subShapes := make(bson.A, 0, len(values))
+			for _, v := range values {
+				if d, ok := v.DocumentOK(); ok {
+					subShapes = append(subShapes, redact(d))
+				}
+			}
+			value = subShapes
+		}
+		shape = append(shape, bson.E{Key: elem.Key(), Value: value})
+	}
+	return shape
+}
+
+// suggestIndex proposes a compound index following the equality, sort,
+// range ordering rule. Only top-level fields are considered.
+func suggestIndex(filter, sortSpec bson.D) bson.D {
+	var equality, ranges bson.D
+	for _, e := range filter {
+		if strings.HasPrefix(e.Key, "$") {
+			continue
+		}
+		if ops, ok := e.Value.(bson.D); ok && len(ops) > 0 && strings.HasPrefix(ops[0].Key, "$") && ops[0].Key != "$eq" && ops[0].Key != "$in" {
+			ranges = append(ranges, bson.E{Key: e.Key, Value: 1})
+			continue
+		}
+		equality = append(equality, bson.E{Key: e.Key, Value: 1})
+	}
+
+	index := append(bson.D{}, equality...)
+	seen := make(map[string]bool, len(filter)+len(sortSpec))
+	for _, e := range index {
+		seen[e.Key] = true
+	}
+	for _, e := range sortSpec {
+		if !seen[e.Key] {
+			index = append(index, e)
+			seen[e.Key] = true
+		}
+	}
+	for _, e := range ranges {
+		if !seen[e.Key] {
+			index = append(index, e)
+		}
+	}
+	return index
+}
+
+func extJSON(doc bson.D) string {
+	out, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
+
+func durationMS(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDescribeCommandRedactsLiterals(t *testing.T) {
+	after := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name       string
+		command    bson.D
+		collection string
+		filter     string
+		sort       string
+		index      string
+	}{
+		{
+			name: "find",
+			command: bson.D{
+				{Key: "find", Value: "appointments"},
+				{Key: "filter", Value: bson.D{{Key: "patientId", Value: "p-alice"}, {Key: "start", Value: bson.D{{Key: "$gte", Value: after}}}}},
+				{Key: "sort", Value: bson.D{{Key: "start", Value: 1}}},
+			},
+			collection: "appointments",
+			filter:     `{"patientId":"?","start":{"$gte":"?"}}`,
+			sort:       `{"start":1}`,
+			index:      `{"patientId":1,"start":1}`,
+		},
+		{
+			name: "$in",
+			command: bson.D{
+				{Key: "find", Value: "appointments"},
+				{Key: "filter", Value: bson.D{
+					{Key: "patientId", Value: bson.D{{Key: "$in", Value: bson.A{"p-alice", "p-bob"}}}},
+					{Key: "doctorId", Value: "d1"},
+				}},
+			},
+			collection: "appointments",
+			filter:     `{"patientId":{"$in":"?"},"doctorId":"?"}`,
+			index:      `{"patientId":1,"doctorId":1}`,
+		},
+		{
+			name: "$or",
+			command: bson.D{
+				{Key: "count", Value: "patients"},
+				{Key: "query", Value: bson.D{{Key: "$or", Value: bson.A{
+					bson.D{{Key: "name", Value: "Alice Smith"}},
+					bson.D{{Key: "email", Value: "alice@example.com"}},
+				}}}},
+			},
+			collection: "patients",
+			filter:     `{"$or":[{"name":"?"},{"email":"?"}]}`,
+		},
+		{
+			name: "update",
+			command: bson.D{
+				{Key: "update", Value: "patients"},
+				{Key: "updates", Value: bson.A{bson.D{
+					{Key: "q", Value: bson.D{{Key: "id", Value: "p-alice"}}},
+					{Key: "u", Value: bson.D{{Key: "$set", Value: bson.D{{Key: "phone", Value: "555-0100"}}}}},
+				}}},
+			},
+			collection: "patients",
+			filter:     `{"id":"?"}`,
+			index:      `{"id":1}`,
+		},
+		{
+			name: "aggregate",
+			command: bson.D{
+				{Key: "aggregate", Value: "appointments"},
+				{Key: "pipeline", Value: bson.A{
+					bson.D{{Key: "$match", Value: bson.D{{Key: "doctorId", Value: "d1"}, {Key: "start", Value: bson.D{{Key: "$lt", Value: after}}}}}},
+					bson.D{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$status"}}}},
+				}},
+			},
+			collection: "appointments",
+			filter:     `{"doctorId":"?","start":{"$lt":"?"}}`,
+			index:      `{"doctorId":1,"start":1}`,
+		},
+		{
+			name: "findAndModify",
+			command: bson.D{
+				{Key: "findAndModify", Value: "slots"},
+				{Key: "query", Value: bson.D{{Key: "doctorId", Value: "d1"}, {Key: "start", Value: bson.D{{Key: "$gt", Value: after}}}}},
+				{Key: "sort", Value: bson.D{{Key: "createdAt", Value: -1}}},
+			},
+			collection: "slots",
+			filter:     `{"doctorId":"?","start":{"$gt":"?"}}`,
+			sort:       `{"createdAt":-1}`,
+			index:      `{"doctorId":1,"createdAt":-1,"start":1}`,
+		},
+		{
+			name: "insert",
+			command: bson.D{
+				{Key: "insert", Value: "patients"},
+				{Key: "documents", Value: bson.A{bson.D{{Key: "id", Value: "p-alice"}}}},
+			},
+			collection: "patients",
+		},
+	} {
+		command, err := bson.Marshal(tc.command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := describeCommand(tc.command[0].Key, command)
+		if got.Collection != tc.collection || got.Filter != tc.filter || got.Sort != tc.sort || got.SuggestedIndex != tc.index {
+			t.Errorf("%s: got collection %q, filter %s, sort %s, index %s; want %q, %s, %s, %s",
+				tc.name, got.Collection, got.Filter, got.Sort, got.SuggestedIndex, tc.collection, tc.filter, tc.sort, tc.index)
+		}
+		for _, literal := range []string{"alice", "Alice", "p-bob", "d1", "2024"} {
+			if strings.Contains(got.Filter, literal) {
+				t.Errorf("%s: filter %s leaks %q", tc.name, got.Filter, literal)
+			}
+		}
+	}
+}
+
+func TestSuggestIndex(t *testing.T) {
+	eq := bson.D{{Key: "$eq", Value: "?"}}
+	gte := bson.D{{Key: "$gte", Value: "?"}}
+	for _, tc := range []struct {
+		name         string
+		filter, sort bson.D
+		want         string
+	}{
+		{"equality only", bson.D{{Key: "doctorId", Value: "?"}}, nil, `{"doctorId":1}`},
+		{"$eq counts as equality", bson.D{{Key: "start", Value: gte}, {Key: "status", Value: eq}}, nil, `{"status":1,"start":1}`},
+		{"sort before range", bson.D{{Key: "start", Value: gte}, {Key: "doctorId", Value: "?"}}, bson.D{{Key: "name", Value: 1}}, `{"doctorId":1,"name":1,"start":1}`},
+		{"sorted range field once", bson.D{{Key: "start", Value: gte}}, bson.D{{Key: "start", Value: -1}}, `{"start":-1}`},
+		{"sorted equality field once", bson.D{{Key: "doctorId", Value: "?"}}, bson.D{{Key: "doctorId", Value: 1}}, `{"doctorId":1}`},
+		{"operators skipped", bson.D{{Key: "$or", Value: bson.A{}}}, nil, `{}`},
+	} {
+		if got := extJSON(suggestIndex(tc.filter, tc.sort)); got != tc.want {
+			t.Errorf("%s: index = %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestProfilerWindowPruning(t *testing.T) {
+	now := time.Now()
+	cutoff := now.Add(-profilerWindow)
+	samples := []RequestSample{
+		{Route: "/expired", At: now.Add(-2 * profilerWindow)},
+		{Route: "/edge", At: cutoff},
+		{Route: "/recent", At: now.Add(-time.Minute)},
+	}
+	if kept := pruneSamples(samples, cutoff); len(kept) != 2 || kept[0].Route != "/edge" {
+		t.Errorf("kept %+v, want the samples from the cutoff on", kept)
+	}
+	samples = appendSample(samples, RequestSample{Route: "/new", At: now}, cutoff)
+	if len(samples) != 3 || samples[0].Route != "/edge" || samples[2].Route != "/new" {
+		t.Errorf("samples = %+v, want the expired one evicted and the new one last", samples)
+	}
+
+	// A full buffer evicts its oldest samples
+	samples = nil
+	for i := 0; i < profilerMaxSamples+10; i++ {
+		samples = appendSample(samples, RequestSample{Status: i, At: now}, cutoff)
+	}
+	if len(samples) != profilerMaxSamples || samples[0].Status != 10 || samples[len(samples)-1].Status != profilerMaxSamples+9 {
+		t.Errorf("buffer holds %d samples from %d, want %d from 10", len(samples), samples[0].Status, profilerMaxSamples)
+	}
+}
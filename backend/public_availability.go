@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// publicAvailabilityTTL is how long the public summary is served from
+	// memory before it is computed again.
+	publicAvailabilityTTL = 5 * time.Minute
+	// publicRefreshTimeout bounds computing the summary. It runs detached
+	// from the visitor's request, so its time doesn't come out of their
+	// budget.
+	publicRefreshTimeout    = 10 * time.Second
+	publicRequestsPerMinute = 60
+)
+
+// publicSpecialty is the next day a specialty can be booked, with no doctor
+// or slot detail. NextAvailable is nil when nothing is free within
+// precomputeDays.
+type publicSpecialty struct {
+	Specialization string  `json:"specialization"`
+	NextAvailable  *string `json:"nextAvailable"`
+}
+
+// publicAvailability caches the summary for the website widget.
+type publicAvailability struct {
+	mu         sync.Mutex
+	computedAt time.Time
+	rows       []publicSpecialty
+}
+
+// nextAvailableBySpecialty finds, per specialization, the day of the
+// earliest free slot anyone may book in the next precomputeDays days.
+func (s *Server) nextAvailableBySpecialty(ctx context.Context, now time.Time) ([]publicSpecialty, error) {
+	doctors, err := s.store.Doctors.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	earliest := map[string]time.Time{}
+	for _, doctor := range doctors {
+		if doctor.Specialization == "" {
+			continue
+		}
+		if _, ok := earliest[doctor.Specialization]; !ok {
+			earliest[doctor.Specialization] = time.Time{}
+		}
+		slots, err := s.freeSlots(ctx, doctor, now, now.AddDate(0, 0, precomputeDays))
+		if err != nil {
+			return nil, err
+		}
+		for _, slot := range slots {
+			if slot.FollowUpOnly {
+				continue
+			}
+			if current := earliest[doctor.Specialization]; current.IsZero() || slot.StartTime.Before(current) {
+				earliest[doctor.Specialization] = slot.StartTime
+			}
+		}
+	}
+
+	rows := make([]publicSpecialty, 0, len(earliest))
+	for specialization, start := range earliest {
+		row := publicSpecialty{Specialization: specialization}
+		if !start.IsZero() {
+			day := start.In(calendarLocation).Format(dateLayout)
+			row.NextAvailable = &day
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Specialization < rows[j].Specialization })
+	return rows, nil
+}
+
+// summary returns the cached rows, computing them when they are older than
+// publicAvailabilityTTL. A stale summary is served when computing fails.
+func (p *publicAvailability) summary(ctx context.Context, s *Server, now time.Time) ([]publicSpecialty, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.rows != nil && now.Sub(p.computedAt) < publicAvailabilityTTL {
+		return p.rows, nil
+	}
+
+	// Holding the lock while computing makes concurrent visitors wait for
+	// one computation instead of each starting their own
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), publicRefreshTimeout)
+	defer cancel()
+	rows, err := s.nextAvailableBySpecialty(ctx, now)
+	if err != nil {
+		if p.rows != nil {
+			return p.rows, nil
+		}
+		return nil, err
+	}
+	p.rows, p.computedAt = rows, now
+	return rows, nil
+}
+
+// GetPublicNextAvailable serves the next available day per specialty
+// without authentication, for embedding on the clinic website. It is rate
+// limited per client IP on its own, apart from the rest of the API.
+func (s *Server) GetPublicNextAvailable(c *gin.Context) {
+	now := time.Now().UTC()
+	if !s.publicLimit.allow(c.ClientIP(), now) {
+		c.Header("Retry-After", "60")
+		abortWithError(c, http.StatusTooManyRequests, "Too many requests, try again later")
+		return
+	}
+
+	rows, err := s.publicAvailability.summary(c.Request.Context(), s, now)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error computing availability")
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, rows)
+}
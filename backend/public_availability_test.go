@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPublicNextAvailable(t *testing.T) {
+	f := newBookingFixture(t)
+	cardiologist := Doctor{ID: "d2", DName: "Dr. House", Specialization: "cardiology", Schedule: []string{f.second.Format(time.RFC3339)}}
+	if err := f.store.Doctors.Create(context.Background(), cardiologist); err != nil {
+		t.Fatal(err)
+	}
+	f.publicLimit = newRateLimiter(2, time.Minute)
+
+	rec := f.do(t, http.MethodGet, "/api/public/next-available", User{}, nil)
+	rows := decode[[]publicSpecialty](t, rec, http.StatusOK)
+	if len(rows) != 1 || rows[0].Specialization != "cardiology" || rows[0].NextAvailable == nil || *rows[0].NextAvailable != f.second.Format(dateLayout) {
+		t.Fatalf("rows = %+v, want cardiology available on %s", rows, f.second.Format(dateLayout))
+	}
+	if rec.Header().Get("Cache-Control") == "" {
+		t.Error("response has no Cache-Control header")
+	}
+
+	// Served from the cache: taking the only slot doesn't show yet
+	if err := f.claimSlot(context.Background(), cardiologist.ID, f.second, "a1"); err != nil {
+		t.Fatal(err)
+	}
+	rows = decode[[]publicSpecialty](t, f.do(t, http.MethodGet, "/api/public/next-available", User{}, nil), http.StatusOK)
+	if rows[0].NextAvailable == nil {
+		t.Errorf("rows = %+v, want the cached summary", rows)
+	}
+
+	decode[apiError](t, f.do(t, http.MethodGet, "/api/public/next-available", User{}, nil), http.StatusTooManyRequests)
+}
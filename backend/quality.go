@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	qualityInterval = 24 * time.Hour
+	// diabeticTag marks the patients the diabetic review measure covers.
+	diabeticTag = "diabetic"
+	// qualityBatchSize is how many patients a measure loads at a time.
+	qualityBatchSize = 500
+)
+
+// qualityMeasure computes the numerator and denominator of a clinic quality
+// indicator at now. Like the reports, measures only count patients with
+// reportConsent.
+type qualityMeasure struct {
+	name        string
+	description string
+	compute     func(s *Server, ctx context.Context, now time.Time) (numerator, denominator int, err error)
+}
+
+// qualityMeasures are the indicators snapshotted every day. Measures on lab
+// results, such as follow-up of abnormal labs, need lab data the system
+// doesn't hold yet.
+var qualityMeasures = []qualityMeasure{
+	{
+		name:        "diabetic-annual-review",
+		description: "Patients tagged " + diabeticTag + " with a completed visit in the last 12 months",
+		compute:     (*Server).diabeticReviewCoverage,
+	},
+}
+
+// runQualityMeasures snapshots every quality measure on start and then
+// every qualityInterval until ctx is cancelled.
+func (s *Server) runQualityMeasures(ctx context.Context) {
+	ticker := time.NewTicker(qualityInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.snapshotQualityMeasures(ctx, time.Now().UTC()); err != nil {
+			log.Println("Computing quality measures failed: ", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// snapshotQualityMeasures stores each measure's value for now's day.
+func (s *Server) snapshotQualityMeasures(ctx context.Context, now time.Time) error {
+	for _, measure := range qualityMeasures {
+		numerator, denominator, err := measure.compute(s, ctx, now)
+		if err != nil {
+			return err
+		}
+		snapshot := store.QualitySnapshot{
+			Measure:     measure.name,
+			Date:        now.Format(dateLayout),
+			Numerator:   numerator,
+			Denominator: denominator,
+			ComputedAt:  now,
+		}
+		if denominator > 0 {
+			snapshot.Rate = float64(numerator) / float64(denominator)
+		}
+		if err := s.store.Quality.Put(ctx, snapshot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) diabeticReviewCoverage(ctx context.Context, now time.Time) (int, int, error) {
+	covered, total := 0, 0
+	// Unsorted listings are in ID order, which unlike names has no ties,
+	// so batches neither skip nor repeat patients
+	page := store.Page{Limit: qualityBatchSize}
+	for {
+		patients, _, err := s.store.Patients.List(ctx, store.PatientFilter{Tags: []string{diabeticTag}}, page)
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, patient := range patients {
+			if !slices.Contains(patient.ActiveConsents(now), reportConsent) {
+				continue
+			}
+			total++
+			filter := store.AppointmentFilter{
+				PatientID:       patient.ID,
+				Statuses:        []string{AppointmentCompleted},
+				From:            now.AddDate(-1, 0, 0),
+				To:              now,
+				IncludeArchived: true,
+			}
+			if _, visits, err := s.store.Appointments.List(ctx, filter, store.Page{Limit: 1}); err != nil {
+				return 0, 0, err
+			} else if visits > 0 {
+				covered++
+			}
+		}
+		if len(patients) < qualityBatchSize {
+			return covered, total, nil
+		}
+		page.Skip += qualityBatchSize
+	}
+}
+
+// GetQualityMeasures lists the quality measures with their latest value.
+func (s *Server) GetQualityMeasures(c *gin.Context) {
+	today := time.Now().UTC().Format(dateLayout)
+	rows := []gin.H{}
+	for _, measure := range qualityMeasures {
+		// Snapshots are daily, so the last week always holds the latest one
+		snapshots, err := s.store.Quality.List(c.Request.Context(), measure.name, time.Now().UTC().AddDate(0, 0, -7).Format(dateLayout), today)
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "Error retrieving quality measures")
+			return
+		}
+		row := gin.H{"measure": measure.name, "description": measure.description, "latest": nil}
+		if len(snapshots) > 0 {
+			row["latest"] = snapshots[len(snapshots)-1]
+		}
+		rows = append(rows, row)
+	}
+	c.JSON(http.StatusOK, gin.H{"consent": reportConsent, "measures": rows})
+}
+
+// GetQualityTrend returns the daily snapshots of the :measure path
+// parameter between ?from= and ?to=, the last 90 days by default.
+func (s *Server) GetQualityTrend(c *gin.Context) {
+	name := c.Param("measure")
+	if !slices.ContainsFunc(qualityMeasures, func(m qualityMeasure) bool { return m.name == name }) {
+		abortWithError(c, http.StatusNotFound, "Unknown quality measure")
+		return
+	}
+	to := c.DefaultQuery("to", time.Now().UTC().Format(dateLayout))
+	from := c.DefaultQuery("from", time.Now().UTC().AddDate(0, 0, -90).Format(dateLayout))
+	for _, date := range []string{from, to} {
+		if _, err := time.Parse(dateLayout, date); err != nil {
+			abortWithError(c, http.StatusBadRequest, "from and to must be formatted as YYYY-MM-DD")
+			return
+		}
+	}
+
+	snapshots, err := s.store.Quality.List(c.Request.Context(), name, from, to)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving quality measure")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"measure": name, "from": from, "to": to, "consent": reportConsent, "snapshots": snapshots})
+}
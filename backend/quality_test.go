@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"containerized-go-app/store"
+)
+
+func TestDiabeticReviewCoverage(t *testing.T) {
+	f := newBookingFixture(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	for _, patient := range []string{f.alice.ProfileID, f.bob.ProfileID} {
+		if err := f.store.Patients.SetTags(ctx, patient, []string{diabeticTag}); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.store.Patients.SetConsent(ctx, patient, store.Consent{Purpose: reportConsent, GrantedAt: now}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	past := now.AddDate(0, -3, 0)
+	visit := Appointment{ID: "visit", PatientID: f.alice.ProfileID, DoctorID: f.doctor.ID, StartTime: past, EndTime: past.Add(slotDuration), Status: AppointmentCompleted}
+	if err := f.store.Appointments.Create(ctx, visit); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.snapshotQualityMeasures(ctx, now); err != nil {
+		t.Fatal(err)
+	}
+	admin := User{Username: "root", Role: RoleAdmin}
+	trend := decode[struct {
+		Snapshots []store.QualitySnapshot `json:"snapshots"`
+	}](t, f.do(t, http.MethodGet, "/api/admin/quality/diabetic-annual-review", admin, nil), http.StatusOK)
+	if len(trend.Snapshots) != 1 {
+		t.Fatalf("snapshots = %+v, want today's", trend.Snapshots)
+	}
+	if got := trend.Snapshots[0]; got.Numerator != 1 || got.Denominator != 2 || got.Rate != 0.5 {
+		t.Errorf("snapshot = %+v, want 1 of 2 patients reviewed", got)
+	}
+
+	decode[apiError](t, f.do(t, http.MethodGet, "/api/admin/quality/unknown", admin, nil), http.StatusNotFound)
+	decode[apiError](t, f.do(t, http.MethodGet, "/api/admin/quality/diabetic-annual-review", f.alice, nil), http.StatusForbidden)
+}
+
+// shuffledTies lists ties in name order the other way round on every other
+// call, as Mongo may, since it doesn't order documents with equal sort keys.
+type shuffledTies struct {
+	store.PatientRepository
+	calls int
+}
+
+func (r *shuffledTies) List(ctx context.Context, f store.PatientFilter, page store.Page) ([]store.Patient, int64, error) {
+	r.calls++
+	if page.Sort != "" && r.calls%2 == 0 {
+		page.Desc = !page.Desc
+	}
+	return r.PatientRepository.List(ctx, f, page)
+}
+
+func TestDiabeticReviewCoverageAcrossBatches(t *testing.T) {
+	f := newBookingFixture(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	// Everyone shares a name, and only the patients past the first batch
+	// and the very first one had a review
+	const patients = qualityBatchSize + 20
+	for i := 0; i < patients; i++ {
+		patient := store.Patient{
+			ID:       fmt.Sprintf("p-%04d", i),
+			PName:    "Sam Lee",
+			Tags:     []string{diabeticTag},
+			Consents: []store.Consent{{Purpose: reportConsent, GrantedAt: now}},
+		}
+		if err := f.store.Patients.Create(ctx, patient); err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 && i < qualityBatchSize {
+			continue
+		}
+		start := now.AddDate(0, -1, 0).Add(time.Duration(i) * slotDuration)
+		visit := Appointment{ID: "visit-" + patient.ID, PatientID: patient.ID, DoctorID: f.doctor.ID, StartTime: start, EndTime: start.Add(slotDuration), Status: AppointmentCompleted}
+		if err := f.store.Appointments.Create(ctx, visit); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f.store.Patients = &shuffledTies{PatientRepository: f.store.Patients}
+	numerator, denominator, err := f.diabeticReviewCoverage(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numerator != 21 || denominator != patients {
+		t.Errorf("coverage = %d of %d, want 21 of %d", numerator, denominator, patients)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// queueHorizon is how far ahead a specialty queue looks for the next
+// available slot.
+const queueHorizon = 14 * 24 * time.Hour
+
+var errQueueEmpty = errors.New("no doctor in the queue has a free slot")
+
+// queueRequest books the next available doctor of a specialization, at
+// StartTime when given and at the earliest free slot otherwise. Without a
+// specialization the patient's pre-screening chooses it.
+type queueRequest struct {
+	Specialization string     `json:"specialization" binding:"omitempty,notblank"`
+	StartTime      *time.Time `json:"startTime"`
+	Notes          string     `json:"notes" binding:"max=2000"`
+}
+
+// queueCandidate is a free slot of one of the queue's doctors, along with
+// how many upcoming appointments that doctor already has.
+type queueCandidate struct {
+	doctorID string
+	slot     Slot
+	load     int64
+}
+
+// BookFromQueue books an appointment with the least-loaded doctor of the
+// requested specialization who has a free slot, at their earliest one.
+func (s *Server) BookFromQueue(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req queueRequest
+	if !bindJSON(c, &req) || !s.checkTriageOrAbort(c, c.Param("id")) {
+		return
+	}
+	assessment, triaged, err := s.latestTriage(ctx, c.Param("id"))
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error checking pre-screening")
+		return
+	}
+	if req.Specialization == "" && triaged {
+		req.Specialization = assessment.Specialization
+	}
+	if req.Specialization == "" {
+		abortWithDetails(c, fieldError{Field: "specialization", Message: "is required unless the pre-screening chose one"})
+		return
+	}
+
+	// Urgent pre-screenings only take the slots soon enough for them
+	horizon := queueHorizon
+	if window, ok := urgencyWindows[assessment.Urgency]; triaged && ok {
+		horizon = min(horizon, window)
+	}
+	from, to := time.Now().UTC(), time.Now().UTC().Add(horizon)
+	if req.StartTime != nil {
+		from, to = req.StartTime.UTC(), req.StartTime.UTC().Add(time.Nanosecond)
+	}
+	appointment := Appointment{PatientID: c.Param("id"), Notes: req.Notes, Queue: req.Specialization}
+	patient, err := s.bookFromQueue(ctx, &appointment, from, to, "")
+	if errors.Is(err, errQueueEmpty) {
+		abortWithCode(c, http.StatusConflict, "queue_empty", "No "+req.Specialization+" doctor has a free slot then")
+		return
+	} else if err != nil {
+		writeAppointmentError(c, err, "Error booking appointment")
+		return
+	}
+
+	appointment.SecondaryDate = secondaryDate(appointment.StartTime)
+	c.JSON(http.StatusOK, bookedBody(c, appointment, patient))
+}
+
+// bookFromQueue creates appointment with the best candidate of its queue
+// starting in [from, to), moving on to the next candidate when a slot is
+// taken concurrently or reserved for follow-ups.
+func (s *Server) bookFromQueue(ctx context.Context, appointment *Appointment, from, to time.Time, excludeDoctorID string) (Patient, error) {
+	candidates, err := s.queueCandidates(ctx, appointment.Queue, from, to, excludeDoctorID)
+	if err != nil {
+		return Patient{}, err
+	}
+	for _, candidate := range candidates {
+		appointment.DoctorID = candidate.doctorID
+		appointment.StartTime = candidate.slot.StartTime
+		appointment.EndTime = time.Time{}
+		patient, err := s.createAppointment(ctx, appointment)
+		if errors.Is(err, errSlotTaken) || errors.Is(err, errFollowUpOnly) {
+			continue
+		}
+		return patient, err
+	}
+	return Patient{}, errQueueEmpty
+}
+
+// queueCandidates returns the free slots starting in [from, to) of every
+// doctor with specialization except excludeDoctorID, least-loaded doctor
+// first and each doctor's earliest slot first.
+func (s *Server) queueCandidates(ctx context.Context, specialization string, from, to time.Time, excludeDoctorID string) ([]queueCandidate, error) {
+	doctors, _, err := s.store.Doctors.List(ctx, store.DoctorFilter{Specialization: specialization}, store.Page{})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	candidates := []queueCandidate{}
+	for _, doctor := range doctors {
+		if doctor.ID == excludeDoctorID {
+			continue
+		}
+		slots, err := s.freeSlots(ctx, doctor, from, to)
+		if err != nil {
+			return nil, err
+		}
+		if len(slots) == 0 {
+			continue
+		}
+		filter := store.AppointmentFilter{DoctorID: doctor.ID, Statuses: []string{AppointmentScheduled}, From: now}
+		_, load, err := s.store.Appointments.List(ctx, filter, store.Page{Limit: 1})
+		if err != nil {
+			return nil, err
+		}
+		for _, slot := range slots {
+			candidates = append(candidates, queueCandidate{doctorID: doctor.ID, slot: slot, load: load})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.load != b.load {
+			return a.load < b.load
+		}
+		if a.doctorID != b.doctorID {
+			return a.doctorID < b.doctorID
+		}
+		return a.slot.StartTime.Before(b.slot.StartTime)
+	})
+	return candidates, nil
+}
+
+// reassignFromQueue moves a queue appointment its doctor is cancelling to
+// another doctor of the queue, at the same time if one is free and at the
+// next available slot otherwise. It reports false when no doctor is free,
+// leaving the appointment to be cancelled.
+func (s *Server) reassignFromQueue(ctx context.Context, existing Appointment) (Appointment, bool, error) {
+	windows := [][2]time.Time{
+		{existing.StartTime, existing.StartTime.Add(time.Nanosecond)},
+		{time.Now().UTC(), time.Now().UTC().Add(queueHorizon)},
+	}
+	for _, window := range windows {
+		candidates, err := s.queueCandidates(ctx, existing.Queue, window[0], window[1], existing.DoctorID)
+		if err != nil {
+			return Appointment{}, false, err
+		}
+		for _, candidate := range candidates {
+			updated := existing
+			updated.DoctorID = candidate.doctorID
+			updated.StartTime = candidate.slot.StartTime
+			updated.EndTime = time.Time{}
+			err := s.rescheduleAppointment(ctx, existing, &updated)
+			if errors.Is(err, errSlotTaken) || errors.Is(err, errFollowUpOnly) {
+				continue
+			}
+			if err != nil {
+				return Appointment{}, false, err
+			}
+			log.Printf("Reassigned appointment %s from doctor %s to %s", existing.ID, existing.DoctorID, updated.DoctorID)
+			return updated, true, nil
+		}
+	}
+	return Appointment{}, false, nil
+}
+
+// handOverQueueAppointment reassigns existing when the current user is the
+// doctor of a queue appointment cancelling it. It reports whether it has
+// responded, either with the reassigned appointment or an error.
+func (s *Server) handOverQueueAppointment(c *gin.Context, existing Appointment) bool {
+	user, _ := currentUser(c)
+	if user.Role != RoleDoctor || user.ProfileID != existing.DoctorID || existing.Queue == "" || existing.Status != AppointmentScheduled {
+		return false
+	}
+
+	reassigned, ok, err := s.reassignFromQueue(c.Request.Context(), existing)
+	if err != nil {
+		writeAppointmentError(c, err, "Error reassigning appointment")
+		return true
+	}
+	if !ok {
+		return false
+	}
+	reassigned.SecondaryDate = secondaryDate(reassigned.StartTime)
+	c.JSON(http.StatusOK, gin.H{"message": "Appointment reassigned to another doctor", "appointment": reassigned})
+	return true
+}
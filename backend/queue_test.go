@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSpecialtyQueue(t *testing.T) {
+	f := newBookingFixture(t)
+	ctx := context.Background()
+	third := f.second.Add(slotDuration)
+	schedules := map[string][]string{
+		"c1": {f.first.Format(time.RFC3339), f.second.Format(time.RFC3339), third.Format(time.RFC3339)},
+		"c2": {f.second.Format(time.RFC3339)},
+	}
+	for id, schedule := range schedules {
+		if err := f.store.Doctors.Create(ctx, Doctor{ID: id, DName: id, Specialization: "cardiology", Schedule: schedule}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// c1 is the busier cardiologist, though free earlier
+	f.do(t, http.MethodPost, "/api/patients/p-bob/appointments", f.bob, gin.H{"doctorId": "c1", "startTime": third})
+
+	const queue = "/api/patients/p-alice/appointments/next-available"
+	booked := decode[bookingResponse](t, f.do(t, http.MethodPost, queue, f.alice, gin.H{"specialization": "cardiology"}), http.StatusOK).Appointment
+	if booked.DoctorID != "c2" || !booked.StartTime.Equal(f.second) || booked.Queue != "cardiology" {
+		t.Errorf("booked %+v, want the least-loaded cardiologist c2 at %s", booked, f.second)
+	}
+
+	// c2 dropping the appointment hands it to c1 at the same time
+	path := fmt.Sprintf("/api/patients/p-alice/appointments/%s", booked.ID)
+	c2 := User{Username: "c2", Role: RoleDoctor, ProfileID: "c2"}
+	moved := decode[bookingResponse](t, f.do(t, http.MethodDelete, path+"?reason=clinic_initiated", c2, nil), http.StatusOK).Appointment
+	if moved.DoctorID != "c1" || !moved.StartTime.Equal(f.second) || moved.Status != AppointmentScheduled {
+		t.Errorf("after c2 cancelled: %+v, want it scheduled with c1 at %s", moved, f.second)
+	}
+
+	// Only the appointment's own doctor hands it over; c2 cancelling c1's
+	// appointment cancels it like any staff cancellation
+	decode[struct{}](t, f.do(t, http.MethodDelete, path+"?reason=clinic_initiated", c2, nil), http.StatusOK)
+	if got, err := f.store.Appointments.Get(ctx, "p-alice", booked.ID); err != nil || got.DoctorID != "c1" || got.Status != AppointmentCancelled {
+		t.Errorf("after c2 cancelled c1's appointment: %+v, %v; want it cancelled with c1", got, err)
+	}
+
+	// Patients cancelling their queue appointment really cancel it
+	const bobQueue = "/api/patients/p-bob/appointments/next-available"
+	bobs := decode[bookingResponse](t, f.do(t, http.MethodPost, bobQueue, f.bob, gin.H{"specialization": "cardiology"}), http.StatusOK).Appointment
+	decode[struct{}](t, f.do(t, http.MethodDelete, "/api/patients/p-bob/appointments/"+bobs.ID+"?reason=patient_request", f.bob, nil), http.StatusOK)
+
+	resp := decode[apiError](t, f.do(t, http.MethodPost, queue, f.alice, gin.H{"specialization": "dermatology"}), http.StatusConflict)
+	if resp.Code != "queue_empty" {
+		t.Errorf("code = %q, want queue_empty", resp.Code)
+	}
+}
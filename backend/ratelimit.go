@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiterSweepSize is how many keys a rateLimiter tracks before it
+// drops the ones with no recent events.
+const rateLimiterSweepSize = 10000
+
+// rateLimiter allows up to limit events per key in any sliding window. The
+// counts are kept in this process, so each replica enforces the limit on its
+// own.
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu     sync.Mutex
+	events map[string][]time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, events: map[string][]time.Time{}}
+}
+
+// allow records an event for key at now and reports whether it is within the
+// limit. Rejected events don't count towards it.
+func (l *rateLimiter) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.events) >= rateLimiterSweepSize {
+		for k, times := range l.events {
+			if len(l.recent(times, now)) == 0 {
+				delete(l.events, k)
+			}
+		}
+	}
+
+	times := l.recent(l.events[key], now)
+	if len(times) >= l.limit {
+		l.events[key] = times
+		return false
+	}
+	l.events[key] = append(times, now)
+	return true
+}
+
+// recent returns the suffix of times, which is in order, inside the window
+// ending at now.
+func (l *rateLimiter) recent(times []time.Time, now time.Time) []time.Time {
+	cutoff := now.Add(-l.window)
+	for len(times) > 0 && !times[0].After(cutoff) {
+		times = times[1:]
+	}
+	return times
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ReportingToken = store.ReportingToken
+
+// roleReporting marks reporting tokens. It isn't a valid user role, so
+// AuthRequired turns these tokens away and they only open the reports.
+const roleReporting = "reporting"
+
+// reportScopeKey is the gin context key holding the doctors a reporting
+// token is limited to.
+const reportScopeKey = "reportScope"
+
+const defaultReportingTokenDays = 90
+
+type reportingTokenRequest struct {
+	Name      string   `json:"name" binding:"required,notblank,max=200"`
+	DoctorIDs []string `json:"doctorIds" binding:"max=50,dive,notblank"`
+	// Days is how long the token lasts, defaultReportingTokenDays if unset.
+	Days int `json:"days" binding:"omitempty,min=1,max=365"`
+}
+
+// issueReportingToken signs a token standing for record.
+func issueReportingToken(record ReportingToken) (string, error) {
+	return issueScopedToken(roleReporting, record.ID, record.CreatedAt, record.ExpiresAt)
+}
+
+// parseReportingToken returns the ID of the reporting token tokenString
+// stands for.
+func parseReportingToken(tokenString string) (string, error) {
+	return parseScopedToken(tokenString, roleReporting)
+}
+
+// issueScopedToken signs a token for a stored grant such as a reporting or
+// partner token. role is never a user role, so only the grant's own routes
+// accept it.
+func issueScopedToken(role, id string, issuedAt, expiresAt time.Time) (string, error) {
+	claims := authClaims{
+		Role: role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   id,
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+}
+
+// parseScopedToken returns the ID of the grant tokenString stands for when
+// it was issued for role.
+func parseScopedToken(tokenString, role string) (string, error) {
+	var claims authClaims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(*jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return "", err
+	}
+	if claims.Role != role || claims.Subject == "" {
+		return "", fmt.Errorf("not a %s token", role)
+	}
+	return claims.Subject, nil
+}
+
+// RequireReportAccess lets admins and active reporting tokens through. A
+// reporting token's doctors are kept for reportScope.
+func (s *Server) RequireReportAccess() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := bearerToken(c)
+		if token == "" {
+			abortWithError(c, http.StatusUnauthorized, "Missing bearer token")
+			return
+		}
+		user, err := s.authenticate(c.Request.Context(), token)
+		if err == nil {
+			if user.Role != RoleAdmin {
+				abortWithError(c, http.StatusForbidden, "Insufficient permissions")
+				return
+			}
+			c.Set(authUserKey, user)
+			c.Next()
+			return
+		} else if !errors.Is(err, errInvalidToken) {
+			abortOnSessionError(c, err)
+			return
+		}
+
+		id, err := parseReportingToken(token)
+		if err != nil {
+			abortWithError(c, http.StatusUnauthorized, "Invalid or expired token")
+			return
+		}
+		record, err := s.store.Reporting.Get(c.Request.Context(), id)
+		if errors.Is(err, store.ErrNotFound) || err == nil && !record.Active(time.Now()) {
+			abortWithError(c, http.StatusUnauthorized, "Invalid or expired token")
+			return
+		} else if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "Error checking token")
+			return
+		}
+		c.Set(reportScopeKey, record.DoctorIDs)
+		c.Next()
+	}
+}
+
+// reportScope returns the doctors the request's reports are limited to, or
+// nil for the whole clinic.
+func reportScope(c *gin.Context) []string {
+	doctorIDs, _ := c.Value(reportScopeKey).([]string)
+	return doctorIDs
+}
+
+// CreateReportingToken issues a token for an external analytics tool. The
+// token is only in this response; the stored record can't recreate it.
+func (s *Server) CreateReportingToken(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req reportingTokenRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	for _, id := range req.DoctorIDs {
+		if exists, err := s.store.Doctors.Exists(ctx, id); err != nil {
+			abortWithError(c, http.StatusInternalServerError, "Error retrieving doctor")
+			return
+		} else if !exists {
+			abortWithDetails(c, fieldError{Field: "doctorIds", Message: "must be existing doctors"})
+			return
+		}
+	}
+	if req.Days == 0 {
+		req.Days = defaultReportingTokenDays
+	}
+
+	user, _ := currentUser(c)
+	now := time.Now().UTC().Truncate(time.Second)
+	record := ReportingToken{
+		ID:        primitive.NewObjectID().Hex(),
+		Name:      req.Name,
+		DoctorIDs: req.DoctorIDs,
+		CreatedBy: user.Username,
+		CreatedAt: now,
+		ExpiresAt: now.AddDate(0, 0, req.Days),
+	}
+	if record.DoctorIDs == nil {
+		record.DoctorIDs = []string{}
+	}
+	token, err := issueReportingToken(record)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error issuing token")
+		return
+	}
+	if err := s.store.Reporting.Create(ctx, record); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error saving reporting token")
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"token": token, "reportingToken": record})
+}
+
+func (s *Server) GetReportingTokens(c *gin.Context) {
+	tokens, err := s.store.Reporting.List(c.Request.Context())
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving reporting tokens")
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (s *Server) RevokeReportingToken(c *gin.Context) {
+	err := s.store.Reporting.Revoke(c.Request.Context(), c.Param("tokenID"), time.Now().UTC())
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, "Reporting token not found")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error revoking reporting token")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Reporting token revoked"})
+}
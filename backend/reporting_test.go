@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestReportingTokens(t *testing.T) {
+	f := newBookingFixture(t)
+	admin := User{Username: "root", Role: RoleAdmin}
+
+	decode[apiError](t, f.do(t, http.MethodPost, "/api/admin/reporting-tokens", admin, gin.H{"name": "BI", "doctorIds": []string{"nobody"}}), http.StatusBadRequest)
+	issued := decode[struct {
+		Token          string         `json:"token"`
+		ReportingToken ReportingToken `json:"reportingToken"`
+	}](t, f.do(t, http.MethodPost, "/api/admin/reporting-tokens", admin, gin.H{"name": "BI", "doctorIds": []string{f.doctor.ID}, "days": 30}), http.StatusCreated)
+
+	send := func(handler http.Handler, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+issued.Token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The token opens nothing outside the reports
+	for _, path := range []string{"/api/patients", "/api/patients/p-alice/appointments", "/api/admin/reporting-tokens", "/api/delegations"} {
+		if rec := send(f.handler, path); rec.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s with a reporting token: status = %d, want 401", path, rec.Code)
+		}
+	}
+
+	// Reports see the token's doctors
+	reports := gin.New()
+	reports.Use(ErrorEnvelope())
+	reports.GET("/report", f.RequireReportAccess(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, reportScope(c))
+	})
+	if scope := decode[[]string](t, send(reports, "/report"), http.StatusOK); len(scope) != 1 || scope[0] != f.doctor.ID {
+		t.Errorf("scope = %v, want [%s]", scope, f.doctor.ID)
+	}
+
+	decode[struct{}](t, f.do(t, http.MethodDelete, "/api/admin/reporting-tokens/"+issued.ReportingToken.ID, admin, nil), http.StatusOK)
+	decode[apiError](t, send(reports, "/report"), http.StatusUnauthorized)
+	tokens := decode[[]ReportingToken](t, f.do(t, http.MethodGet, "/api/admin/reporting-tokens", admin, nil), http.StatusOK)
+	if len(tokens) != 1 || tokens[0].RevokedAt == nil {
+		t.Errorf("tokens = %+v, want the revoked token", tokens)
+	}
+}
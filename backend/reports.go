@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const defaultReportDays = 30
+
+// reportIntervals are the ?interval= values and the $dateTrunc unit for each.
+var reportIntervals = map[string]string{"day": "day", "week": "week", "month": "month"}
+
+// reportRange is the [From, To) window and bucket size of a report, and the
+// doctors it is limited to when pulled with a scoped reporting token.
+type reportRange struct {
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Interval  string    `json:"interval,omitempty"`
+	DoctorIDs []string  `json:"doctorIds,omitempty"`
+}
+
+// parseReportRange reads ?from=, ?to= and ?interval=. The window defaults to
+// the last defaultReportDays days and the interval to day.
+func parseReportRange(c *gin.Context) (reportRange, bool) {
+	from, err := parseTimeQuery(c, "from")
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err.Error())
+		return reportRange{}, false
+	}
+	to, err := parseTimeQuery(c, "to")
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err.Error())
+		return reportRange{}, false
+	}
+	if to.IsZero() {
+		to = time.Now().UTC()
+	}
+	if from.IsZero() {
+		from = to.AddDate(0, 0, -defaultReportDays)
+	}
+	if !to.After(from) {
+		abortWithError(c, http.StatusBadRequest, "to must be after from")
+		return reportRange{}, false
+	}
+
+	interval := c.DefaultQuery("interval", "day")
+	if _, ok := reportIntervals[interval]; !ok {
+		abortWithError(c, http.StatusBadRequest, "interval must be day, week or month")
+		return reportRange{}, false
+	}
+	return reportRange{From: from, To: to, Interval: interval, DoctorIDs: reportScope(c)}, true
+}
+
+// reportRow is one row of a report, also written as one CSV record.
+type reportRow interface {
+	csvRecord() []string
+}
+
+// writeReport responds with rows as JSON, or as a CSV download with header
+// when the request has ?format=csv.
+func writeReport[T reportRow](c *gin.Context, name string, r reportRange, header []string, rows []T) {
+	if c.Query("format") != "csv" {
+		c.JSON(http.StatusOK, gin.H{"report": name, "range": r, "consent": reportConsent, "rows": rows})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+	c.Status(http.StatusOK)
+	w := csv.NewWriter(c.Writer)
+	w.Write(header)
+	for _, row := range rows {
+		w.Write(row.csvRecord())
+	}
+	w.Flush()
+}
+
+// appointmentsInRange starts a pipeline over the appointments starting in
+// r, including archived ones when r reaches back past the archive cutoff.
+// Only appointments of patients with reportConsent, and of r's doctors when
+// it has any, are included.
+func appointmentsInRange(r reportRange) mongo.Pipeline {
+	filter := bson.M{"startTime": bson.M{"$gte": r.From, "$lt": r.To}}
+	if len(r.DoctorIDs) > 0 {
+		filter["doctorId"] = bson.M{"$in": r.DoctorIDs}
+	}
+	match := bson.D{{Key: "$match", Value: filter}}
+	pipeline := mongo.Pipeline{match}
+	if years := archiveAfterYears(); years > 0 && r.From.Before(time.Now().UTC().AddDate(-years, 0, 0)) {
+		pipeline = append(pipeline, bson.D{{Key: "$unionWith", Value: bson.M{
+			"coll":     "appointments_archive",
+			"pipeline": mongo.Pipeline{match},
+		}}})
+	}
+	return withConsent(pipeline, "patientId", reportConsent, time.Now())
+}
+
+// periodStart buckets the date at field into the report's interval.
+func periodStart(field string, r reportRange) bson.M {
+	return bson.M{"$dateTrunc": bson.M{"date": field, "unit": reportIntervals[r.Interval], "startOfWeek": "monday"}}
+}
+
+// withDoctorNames adds a doctorName field looked up from doctorId.
+func withDoctorNames(pipeline mongo.Pipeline) mongo.Pipeline {
+	return append(pipeline,
+		bson.D{{Key: "$lookup", Value: bson.M{"from": "doctor", "localField": "doctorId", "foreignField": "id", "as": "doctor"}}},
+		bson.D{{Key: "$set", Value: bson.M{"doctorName": bson.M{"$ifNull": bson.A{bson.M{"$first": "$doctor.dname"}, ""}}}}},
+		bson.D{{Key: "$unset", Value: "doctor"}},
+	)
+}
+
+func aggregateReport[T any](ctx context.Context, coll *mongo.Collection, pipeline mongo.Pipeline) ([]T, error) {
+	cur, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	rows := []T{}
+	if err := cur.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+type doctorVolumeRow struct {
+	DoctorID     string    `json:"doctorId" bson:"doctorId"`
+	DoctorName   string    `json:"doctorName" bson:"doctorName"`
+	Period       time.Time `json:"period" bson:"period"`
+	Appointments int       `json:"appointments" bson:"appointments"`
+}
+
+func (r doctorVolumeRow) csvRecord() []string {
+	return []string{r.DoctorID, r.DoctorName, r.Period.Format(dateLayout), strconv.Itoa(r.Appointments)}
+}
+
+// GetDoctorVolumeReport counts each doctor's non-cancelled appointments per
+// day, week or month.
+func (s *Server) GetDoctorVolumeReport(c *gin.Context) {
+	ctx := c.Request.Context()
+	r, ok := parseReportRange(c)
+	if !ok {
+		return
+	}
+
+	pipeline := append(appointmentsInRange(r),
+		bson.D{{Key: "$match", Value: bson.M{"status": bson.M{"$ne": AppointmentCancelled}}}},
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":          bson.M{"doctorId": "$doctorId", "period": periodStart("$startTime", r)},
+			"appointments": bson.M{"$sum": 1},
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{"_id": 0, "doctorId": "$_id.doctorId", "period": "$_id.period", "appointments": 1}}},
+	)
+	pipeline = append(withDoctorNames(pipeline), bson.D{{Key: "$sort", Value: bson.D{{Key: "period", Value: 1}, {Key: "doctorId", Value: 1}}}})
+
+	rows, err := aggregateReport[doctorVolumeRow](ctx, s.db.Collection("appointments"), pipeline)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error building report")
+		return
+	}
+	writeReport(c, "doctor-volume", r, []string{"doctorId", "doctorName", "period", "appointments"}, rows)
+}
+
+type attendanceRow struct {
+	DoctorID         string  `json:"doctorId" bson:"doctorId"`
+	DoctorName       string  `json:"doctorName" bson:"doctorName"`
+	Appointments     int     `json:"appointments" bson:"appointments"`
+	Cancelled        int     `json:"cancelled" bson:"cancelled"`
+	NoShows          int     `json:"noShows" bson:"noShows"`
+	CancellationRate float64 `json:"cancellationRate" bson:"-"`
+	NoShowRate       float64 `json:"noShowRate" bson:"-"`
+}
+
+func (r attendanceRow) csvRecord() []string {
+	return []string{
+		r.DoctorID, r.DoctorName,
+		strconv.Itoa(r.Appointments), strconv.Itoa(r.Cancelled), strconv.Itoa(r.NoShows),
+		strconv.FormatFloat(r.CancellationRate, 'f', 4, 64), strconv.FormatFloat(r.NoShowRate, 'f', 4, 64),
+	}
+}
+
+func (r *attendanceRow) computeRates() {
+	if r.Appointments == 0 {
+		return
+	}
+	r.CancellationRate = float64(r.Cancelled) / float64(r.Appointments)
+	r.NoShowRate = float64(r.NoShows) / float64(r.Appointments)
+}
+
+// GetAttendanceReport returns cancellation and no-show rates per doctor,
+// followed by a row with an empty doctorId for all of them.
+func (s *Server) GetAttendanceReport(c *gin.Context) {
+	ctx := c.Request.Context()
+	r, ok := parseReportRange(c)
+	if !ok {
+		return
+	}
+	r.Interval = ""
+
+	countStatus := func(status string) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$status", status}}, 1, 0}}}
+	}
+	pipeline := append(appointmentsInRange(r),
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":          "$doctorId",
+			"appointments": bson.M{"$sum": 1},
+			"cancelled":    countStatus(AppointmentCancelled),
+			"noShows":      countStatus(AppointmentNoShow),
+		}}},
+		bson.D{{Key: "$set", Value: bson.M{"doctorId": "$_id"}}},
+		bson.D{{Key: "$unset", Value: "_id"}},
+	)
+	pipeline = append(withDoctorNames(pipeline), bson.D{{Key: "$sort", Value: bson.D{{Key: "doctorId", Value: 1}}}})
+
+	rows, err := aggregateReport[attendanceRow](ctx, s.db.Collection("appointments"), pipeline)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error building report")
+		return
+	}
+
+	total := attendanceRow{DoctorName: "All doctors"}
+	for i := range rows {
+		rows[i].computeRates()
+		total.Appointments += rows[i].Appointments
+		total.Cancelled += rows[i].Cancelled
+		total.NoShows += rows[i].NoShows
+	}
+	total.computeRates()
+	rows = append(rows, total)
+
+	writeReport(c, "attendance", r, []string{"doctorId", "doctorName", "appointments", "cancelled", "noShows", "cancellationRate", "noShowRate"}, rows)
+}
+
+type busySlotRow struct {
+	Weekday      string `json:"weekday" bson:"-"`
+	IsoWeekday   int    `json:"-" bson:"isoWeekday"`
+	Hour         int    `json:"hour" bson:"hour"`
+	Appointments int    `json:"appointments" bson:"appointments"`
+}
+
+func (r busySlotRow) csvRecord() []string {
+	return []string{r.Weekday, strconv.Itoa(r.Hour), strconv.Itoa(r.Appointments)}
+}
+
+// GetBusiestSlotsReport ranks weekday and hour combinations by the number of
+// non-cancelled appointments starting in them. Hours are in ?tz= (an IANA
+// zone, UTC by default) and ?limit= caps the rows (default 20).
+func (s *Server) GetBusiestSlotsReport(c *gin.Context) {
+	ctx := c.Request.Context()
+	r, ok := parseReportRange(c)
+	if !ok {
+		return
+	}
+	r.Interval = ""
+
+	tz := c.DefaultQuery("tz", "UTC")
+	if _, err := time.LoadLocation(tz); err != nil {
+		abortWithError(c, http.StatusBadRequest, "tz must be an IANA time zone")
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 7*24 {
+		abortWithError(c, http.StatusBadRequest, "limit must be between 1 and 168")
+		return
+	}
+
+	pipeline := append(appointmentsInRange(r),
+		bson.D{{Key: "$match", Value: bson.M{"status": bson.M{"$ne": AppointmentCancelled}}}},
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"isoWeekday": bson.M{"$isoDayOfWeek": bson.M{"date": "$startTime", "timezone": tz}},
+				"hour":       bson.M{"$hour": bson.M{"date": "$startTime", "timezone": tz}},
+			},
+			"appointments": bson.M{"$sum": 1},
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{"_id": 0, "isoWeekday": "$_id.isoWeekday", "hour": "$_id.hour", "appointments": 1}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "appointments", Value: -1}, {Key: "isoWeekday", Value: 1}, {Key: "hour", Value: 1}}}},
+		bson.D{{Key: "$limit", Value: limit}},
+	)
+
+	rows, err := aggregateReport[busySlotRow](ctx, s.db.Collection("appointments"), pipeline)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error building report")
+		return
+	}
+	for i := range rows {
+		// ISO weekdays run Monday=1 to Sunday=7
+		rows[i].Weekday = time.Weekday(rows[i].IsoWeekday % 7).String()
+	}
+	writeReport(c, "busiest-slots", r, []string{"weekday", "hour", "appointments"}, rows)
+}
+
+type signupRow struct {
+	Period  time.Time `json:"period" bson:"period"`
+	Signups int       `json:"signups" bson:"signups"`
+}
+
+func (r signupRow) csvRecord() []string {
+	return []string{r.Period.Format(dateLayout), strconv.Itoa(r.Signups)}
+}
+
+// GetSignupsReport counts new patient accounts per day, week or month,
+// counting only patients with reportConsent. Signups belong to no doctor,
+// so tokens scoped to doctors can't pull it.
+func (s *Server) GetSignupsReport(c *gin.Context) {
+	ctx := c.Request.Context()
+	r, ok := parseReportRange(c)
+	if !ok {
+		return
+	}
+	if len(r.DoctorIDs) > 0 {
+		abortWithError(c, http.StatusForbidden, "The signups report isn't available to tokens scoped to doctors")
+		return
+	}
+
+	// Accounts created before createdat was recorded fall back to the
+	// creation time embedded in their ObjectID
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: bson.M{"role": RolePatient}}},
+		bson.D{{Key: "$project", Value: bson.M{"profileid": 1, "createdAt": bson.M{"$ifNull": bson.A{"$createdat", bson.M{"$toDate": "$_id"}}}}}},
+		bson.D{{Key: "$match", Value: bson.M{"createdAt": bson.M{"$gte": r.From, "$lt": r.To}}}},
+	}
+	pipeline = append(withConsent(pipeline, "profileid", reportConsent, time.Now()),
+		bson.D{{Key: "$group", Value: bson.M{"_id": periodStart("$createdAt", r), "signups": bson.M{"$sum": 1}}}},
+		bson.D{{Key: "$project", Value: bson.M{"_id": 0, "period": "$_id", "signups": 1}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "period", Value: 1}}}},
+	)
+
+	rows, err := aggregateReport[signupRow](ctx, s.db.Collection("users"), pipeline)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error building report")
+		return
+	}
+	writeReport(c, "signups", r, []string{"period", "signups"}, rows)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// roleRunbook marks confirmation tokens; like roleReporting it isn't a
+// user role, so they open nothing.
+const roleRunbook = "runbook"
+
+// runbookConfirmWindow is how long an admin has to confirm an action.
+const runbookConfirmWindow = 2 * time.Minute
+
+// runbookAction is an incident procedure on-call admins can run without
+// shell access. Actions on in-process state only affect the instance that
+// serves the confirmation.
+type runbookAction struct {
+	Description string
+	run         func(ctx context.Context, s *Server) (gin.H, error)
+}
+
+var runbookActions = map[string]runbookAction{
+	"flush-caches": {
+		Description: "Recompute the availability cache and public summary, rebuild the patient typeahead and reload traffic captures",
+		run: func(ctx context.Context, s *Server) (gin.H, error) {
+			s.publicAvailability.mu.Lock()
+			s.publicAvailability.rows = nil
+			s.publicAvailability.mu.Unlock()
+			s.reloadCaptures()
+			if err := s.refreshPatientIndex(ctx); err != nil {
+				return nil, err
+			}
+			if err := s.precomputeAllAvailability(ctx); err != nil {
+				return nil, err
+			}
+			return gin.H{"flushed": []string{"availability", "publicAvailability", "patientTypeahead", "captures"}}, nil
+		},
+	},
+	"pause-notifications": {
+		Description: "Stop sending notifications; they queue until resumed",
+		run: func(_ context.Context, s *Server) (gin.H, error) {
+			s.notifier.Pause()
+			return gin.H{"paused": true, "queued": s.notifier.Queued()}, nil
+		},
+	},
+	"resume-notifications": {
+		Description: "Send the queued notifications and carry on sending",
+		run: func(_ context.Context, s *Server) (gin.H, error) {
+			queued := s.notifier.Queued()
+			s.notifier.Resume()
+			return gin.H{"paused": false, "queued": queued}, nil
+		},
+	},
+	"drain-notifications": {
+		Description: "Drop the queued notifications, e.g. a wrong batch held back by pausing",
+		run: func(_ context.Context, s *Server) (gin.H, error) {
+			return gin.H{"dropped": s.notifier.Drain()}, nil
+		},
+	},
+}
+
+type runbookConfirmation struct {
+	ConfirmationToken string `json:"confirmationToken" binding:"required"`
+}
+
+// runbookSubject ties a confirmation token to the action and the admin who
+// asked for it.
+func runbookSubject(action, username string) string {
+	return action + ":" + username
+}
+
+// GetRunbook lists the actions and the state they change.
+func (s *Server) GetRunbook(c *gin.Context) {
+	actions := []gin.H{}
+	for name, action := range runbookActions {
+		actions = append(actions, gin.H{"action": name, "description": action.Description})
+	}
+	sort.Slice(actions, func(i, j int) bool { return actions[i]["action"].(string) < actions[j]["action"].(string) })
+	c.JSON(http.StatusOK, gin.H{
+		"actions": actions,
+		"notifications": gin.H{
+			"enabled": s.notifier.Enabled(),
+			"paused":  s.notifier.Paused(),
+			"queued":  s.notifier.Queued(),
+		},
+	})
+}
+
+// RequestRunbookAction issues the token that confirms an action, so a
+// stray request can't run one by itself.
+func (s *Server) RequestRunbookAction(c *gin.Context) {
+	name := c.Param("action")
+	action, ok := runbookActions[name]
+	if !ok {
+		abortWithError(c, http.StatusNotFound, "Unknown runbook action")
+		return
+	}
+	user, _ := currentUser(c)
+	now := time.Now().UTC().Truncate(time.Second)
+	expiresAt := now.Add(runbookConfirmWindow)
+	token, err := issueScopedToken(roleRunbook, runbookSubject(name, user.Username), now, expiresAt)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error issuing confirmation token")
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"action":            name,
+		"description":       action.Description,
+		"confirmationToken": token,
+		"expiresAt":         expiresAt,
+	})
+}
+
+// ConfirmRunbookAction runs the action when the token is the admin's own
+// and still valid, and audits it.
+func (s *Server) ConfirmRunbookAction(c *gin.Context) {
+	name := c.Param("action")
+	action, ok := runbookActions[name]
+	if !ok {
+		abortWithError(c, http.StatusNotFound, "Unknown runbook action")
+		return
+	}
+	var req runbookConfirmation
+	if !bindJSON(c, &req) {
+		return
+	}
+	user, _ := currentUser(c)
+	subject, err := parseScopedToken(req.ConfirmationToken, roleRunbook)
+	if err != nil || subject != runbookSubject(name, user.Username) {
+		abortWithCode(c, http.StatusForbidden, "invalid_confirmation", "The confirmation token is invalid, expired or for another action")
+		return
+	}
+
+	result, err := action.run(context.WithoutCancel(c.Request.Context()), s)
+	if err != nil {
+		s.audit(c, "runbook."+name, "", "failed: "+err.Error())
+		abortWithError(c, http.StatusInternalServerError, "Error running "+name)
+		return
+	}
+	s.audit(c, "runbook."+name, "", fmt.Sprint(result))
+	c.JSON(http.StatusOK, gin.H{"action": name, "result": result})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"containerized-go-app/notification"
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+type runbookTicket struct {
+	ConfirmationToken string `json:"confirmationToken"`
+}
+
+func TestRunbookPauseAndDrainNotifications(t *testing.T) {
+	sent := make(captureSender, 10)
+	srv := NewServer(store.NewMemory(), notification.New(10, sent), nil)
+	ts := &testServer{Server: srv, handler: srv.Router(newProfiler(profilerWindow))}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.notifier.Run(ctx)
+
+	admin := User{Username: "root", Role: RoleAdmin}
+	run := func(action string) *http.Response {
+		t.Helper()
+		ticket := decode[runbookTicket](t, ts.do(t, http.MethodPost, "/api/admin/runbook/"+action, admin, nil), http.StatusAccepted)
+		return ts.do(t, http.MethodPost, "/api/admin/runbook/"+action+"/confirm", admin, gin.H{"confirmationToken": ticket.ConfirmationToken}).Result()
+	}
+
+	if resp := run("pause-notifications"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("pause status = %d, want 200", resp.StatusCode)
+	}
+	srv.notifier.Notify(notification.Event{Kind: notification.Booked, AppointmentID: "a1"})
+	srv.notifier.Notify(notification.Event{Kind: notification.Booked, AppointmentID: "a2"})
+	select {
+	case event := <-sent:
+		t.Fatalf("sent %+v while paused", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if resp := run("drain-notifications"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("drain status = %d, want 200", resp.StatusCode)
+	}
+	srv.notifier.Notify(notification.Event{Kind: notification.Booked, AppointmentID: "a3"})
+	run("resume-notifications")
+	select {
+	case event := <-sent:
+		if event.AppointmentID != "a3" {
+			t.Errorf("sent %s first, want the drained events gone and a3 sent", event.AppointmentID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("nothing sent after resuming")
+	}
+
+	entries, _, err := srv.store.Audit.List(ctx, store.AuditFilter{Action: "runbook.drain-notifications"}, store.Page{})
+	if err != nil || len(entries) != 1 || entries[0].Actor != admin.Username {
+		t.Errorf("audit = %+v, %v; want root's drain", entries, err)
+	}
+}
+
+func TestRunbookConfirmation(t *testing.T) {
+	ts := newTestServer(t)
+	admin := User{Username: "root", Role: RoleAdmin}
+	other := User{Username: "ops", Role: RoleAdmin}
+
+	ticket := decode[runbookTicket](t, ts.do(t, http.MethodPost, "/api/admin/runbook/pause-notifications", admin, nil), http.StatusAccepted)
+	for name, tc := range map[string]struct {
+		user   User
+		action string
+	}{
+		"another admin":  {other, "pause-notifications"},
+		"another action": {admin, "drain-notifications"},
+	} {
+		path := "/api/admin/runbook/" + tc.action + "/confirm"
+		if got := decode[apiError](t, ts.do(t, http.MethodPost, path, tc.user, gin.H{"confirmationToken": ticket.ConfirmationToken}), http.StatusForbidden); got.Code != "invalid_confirmation" {
+			t.Errorf("%s: code = %q, want invalid_confirmation", name, got.Code)
+		}
+	}
+	if ts.notifier.Paused() {
+		t.Error("notifications paused without a valid confirmation")
+	}
+
+	if rec := ts.do(t, http.MethodPost, "/api/admin/runbook/reboot", admin, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown action status = %d, want 404", rec.Code)
+	}
+	if rec := ts.do(t, http.MethodPost, "/api/admin/runbook/flush-caches", User{Username: "grey", Role: RoleDoctor, ProfileID: "d1"}, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("doctor status = %d, want 403", rec.Code)
+	}
+
+	flush := decode[runbookTicket](t, ts.do(t, http.MethodPost, "/api/admin/runbook/flush-caches", admin, nil), http.StatusAccepted)
+	decode[gin.H](t, ts.do(t, http.MethodPost, "/api/admin/runbook/flush-caches/confirm", admin, gin.H{"confirmationToken": flush.ConfirmationToken}), http.StatusOK)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// draftPreviewDays is how far ahead a draft is compared with the live
+// schedule by default.
+const draftPreviewDays = 28
+
+type ScheduleDraft = store.ScheduleDraft
+
+// scheduleDiff is what changes for patients when a draft is published.
+type scheduleDiff struct {
+	Added   []Slot `json:"added"`
+	Removed []Slot `json:"removed"`
+}
+
+// scheduleImpact is a scheduleDiff together with the booked appointments
+// that would lose their slot.
+type scheduleImpact struct {
+	scheduleDiff
+	Conflicts []Appointment `json:"conflicts"`
+}
+
+// scheduleImpact compares the slots of before and after starting in
+// [from, to) and finds the doctor's scheduled appointments in that range
+// that after has no slot for.
+func (s *Server) scheduleImpact(ctx context.Context, before, after Doctor, from, to time.Time) (scheduleImpact, error) {
+	diff, err := diffSlots(before, after, from, to)
+	if err != nil {
+		return scheduleImpact{}, err
+	}
+
+	filter := store.AppointmentFilter{DoctorID: before.ID, Statuses: []string{AppointmentScheduled}, From: from, To: to}
+	appointments, _, err := s.store.Appointments.List(ctx, filter, store.Page{Sort: "startTime"})
+	if err != nil {
+		return scheduleImpact{}, err
+	}
+	impact := scheduleImpact{scheduleDiff: diff, Conflicts: []Appointment{}}
+	for _, appointment := range appointments {
+		if _, ok := findSlot(after, appointment.StartTime); !ok {
+			impact.Conflicts = append(impact.Conflicts, appointment)
+		}
+	}
+	return impact, nil
+}
+
+// diffSlots compares the slots of before and after starting in [from, to).
+// A slot whose follow-up restriction changes is both removed and added.
+func diffSlots(before, after Doctor, from, to time.Time) (scheduleDiff, error) {
+	old, err := doctorSlots(before, from, to)
+	if err != nil {
+		return scheduleDiff{}, err
+	}
+	updated, err := doctorSlots(after, from, to)
+	if err != nil {
+		return scheduleDiff{}, err
+	}
+
+	key := func(slot Slot) string {
+		return fmt.Sprintf("%d|%d|%t", slot.StartTime.UnixNano(), slot.EndTime.UnixNano(), slot.FollowUpOnly)
+	}
+	oldKeys := make(map[string]bool, len(old))
+	for _, slot := range old {
+		oldKeys[key(slot)] = true
+	}
+	updatedKeys := make(map[string]bool, len(updated))
+	diff := scheduleDiff{Added: []Slot{}, Removed: []Slot{}}
+	for _, slot := range updated {
+		updatedKeys[key(slot)] = true
+		if !oldKeys[key(slot)] {
+			diff.Added = append(diff.Added, slot)
+		}
+	}
+	for _, slot := range old {
+		if !updatedKeys[key(slot)] {
+			diff.Removed = append(diff.Removed, slot)
+		}
+	}
+	return diff, nil
+}
+
+// previewRange parses the optional from and to dates (YYYY-MM-DD, both
+// inclusive) of a preview, defaulting to the next draftPreviewDays days.
+func previewRange(c *gin.Context) (time.Time, time.Time, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from, to := today, today.AddDate(0, 0, draftPreviewDays)
+	if q := c.Query("from"); q != "" {
+		parsed, err := time.Parse(dateLayout, q)
+		if err != nil {
+			return from, to, errors.New("from must be formatted as YYYY-MM-DD")
+		}
+		from = parsed
+	}
+	if q := c.Query("to"); q != "" {
+		parsed, err := time.Parse(dateLayout, q)
+		if err != nil {
+			return from, to, errors.New("to must be formatted as YYYY-MM-DD")
+		}
+		to = parsed.AddDate(0, 0, 1)
+	}
+	if !to.After(from) || to.Sub(from) > maxSlotRange {
+		return from, to, errors.New("to must be on or after from and at most 62 days later")
+	}
+	return from, to, nil
+}
+
+// findDoctorOrAbort loads the doctor in the :id path parameter, writing the
+// error response when that fails.
+func (s *Server) findDoctorOrAbort(c *gin.Context) (Doctor, bool) {
+	doctor, err := s.findDoctor(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, errDoctorNotFound) {
+		abortWithError(c, http.StatusNotFound, "Doctor not found")
+		return Doctor{}, false
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error fetching doctor data")
+		return Doctor{}, false
+	}
+	return doctor, true
+}
+
+func (s *Server) GetDoctorScheduleDraft(c *gin.Context) {
+	doctor, ok := s.findDoctorOrAbort(c)
+	if !ok {
+		return
+	}
+	if doctor.Draft == nil {
+		abortWithError(c, http.StatusNotFound, "The doctor has no schedule draft")
+		return
+	}
+	c.JSON(http.StatusOK, doctor.Draft)
+}
+
+// bindScheduleDraft binds and validates a proposed slot source: a flat
+// schedule, or a template which then takes precedence.
+func bindScheduleDraft(c *gin.Context) (ScheduleDraft, bool) {
+	var draft ScheduleDraft
+	if !bindJSON(c, &draft) {
+		return draft, false
+	}
+	if draft.Template != nil {
+		if err := validateTemplate(*draft.Template); err != nil {
+			abortWithDetails(c, fieldError{Field: "scheduleTemplate", Message: err.Error()})
+			return draft, false
+		}
+	}
+	if draft.Schedule == nil {
+		draft.Schedule = []string{}
+	}
+	return draft, true
+}
+
+// PutDoctorScheduleDraft stores schedule changes without publishing them.
+// The draft replaces the whole slot source.
+func (s *Server) PutDoctorScheduleDraft(c *gin.Context) {
+	draft, ok := bindScheduleDraft(c)
+	if !ok {
+		return
+	}
+	user, _ := currentUser(c)
+	draft.UpdatedBy = user.Username
+	draft.UpdatedAt = time.Now().UTC()
+
+	err := s.store.Doctors.SetDraft(c.Request.Context(), c.Param("id"), &draft)
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, "Doctor not found")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error saving schedule draft")
+		return
+	}
+	c.JSON(http.StatusOK, draft)
+}
+
+func (s *Server) DeleteDoctorScheduleDraft(c *gin.Context) {
+	err := s.store.Doctors.SetDraft(c.Request.Context(), c.Param("id"), nil)
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, "Doctor not found")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error discarding schedule draft")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Schedule draft discarded"})
+}
+
+// GetDoctorScheduleDraftDiff previews the slots publishing the draft would
+// add and remove between ?from= and ?to=, and the appointments it would
+// leave without a slot.
+func (s *Server) GetDoctorScheduleDraftDiff(c *gin.Context) {
+	from, to, err := previewRange(c)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	doctor, ok := s.findDoctorOrAbort(c)
+	if !ok {
+		return
+	}
+	if doctor.Draft == nil {
+		abortWithError(c, http.StatusNotFound, "The doctor has no schedule draft")
+		return
+	}
+
+	impact, err := s.scheduleImpact(c.Request.Context(), doctor, doctor.Published(*doctor.Draft), from, to)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error comparing schedules")
+		return
+	}
+	c.JSON(http.StatusOK, impact)
+}
+
+// PostDoctorScheduleImpact is a dry run of replacing the doctor's schedule
+// with the one in the body: it reports the slots gained and lost between
+// ?from= and ?to= and the appointments that would conflict, changing
+// nothing.
+func (s *Server) PostDoctorScheduleImpact(c *gin.Context) {
+	from, to, err := previewRange(c)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	proposed, ok := bindScheduleDraft(c)
+	if !ok {
+		return
+	}
+	doctor, ok := s.findDoctorOrAbort(c)
+	if !ok {
+		return
+	}
+
+	impact, err := s.scheduleImpact(c.Request.Context(), doctor, doctor.Published(proposed), from, to)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error comparing schedules")
+		return
+	}
+	c.JSON(http.StatusOK, impact)
+}
+
+// PublishDoctorScheduleDraft makes the draft the doctor's live schedule.
+func (s *Server) PublishDoctorScheduleDraft(c *gin.Context) {
+	doctorID := c.Param("id")
+	if _, ok := s.findDoctorOrAbort(c); !ok {
+		return
+	}
+
+	err := s.store.Doctors.PublishDraft(c.Request.Context(), doctorID)
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, "The doctor has no schedule draft")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error publishing schedule draft")
+		return
+	}
+	go s.refreshDoctorAvailability(doctorID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Schedule draft published"})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPublishScheduleDraft(t *testing.T) {
+	f := newBookingFixture(t)
+	doctor := User{Username: "grey", Role: RoleDoctor, ProfileID: f.doctor.ID}
+	const path = "/api/doctors/d1/schedule-draft"
+	third := f.second.Add(slotDuration)
+	draft := gin.H{"schedule": []string{f.second.Format(time.RFC3339), third.Format(time.RFC3339)}}
+
+	decode[apiError](t, f.do(t, http.MethodPut, path, f.alice, draft), http.StatusForbidden)
+	decode[apiError](t, f.do(t, http.MethodPut, path, doctor, gin.H{"schedule": []string{"tomorrow"}}), http.StatusBadRequest)
+	decode[ScheduleDraft](t, f.do(t, http.MethodPut, path, doctor, draft), http.StatusOK)
+
+	// Patients still see the published schedule
+	if slots := f.freeSlots(t); len(slots) != 2 || !slots[0].StartTime.Equal(f.first) {
+		t.Fatalf("slots while drafting = %+v, want the live schedule", slots)
+	}
+
+	diff := decode[scheduleDiff](t, f.do(t, http.MethodGet, path+"/diff", doctor, nil), http.StatusOK)
+	if len(diff.Added) != 1 || !diff.Added[0].StartTime.Equal(third) || len(diff.Removed) != 1 || !diff.Removed[0].StartTime.Equal(f.first) {
+		t.Errorf("diff = %+v, want %s added and %s removed", diff, third, f.first)
+	}
+
+	decode[struct{}](t, f.do(t, http.MethodPost, path+"/publish", doctor, nil), http.StatusOK)
+	if slots := f.freeSlots(t); len(slots) != 2 || !slots[1].StartTime.Equal(third) {
+		t.Errorf("slots after publishing = %+v, want the draft's", slots)
+	}
+	decode[apiError](t, f.do(t, http.MethodGet, path, doctor, nil), http.StatusNotFound)
+	decode[apiError](t, f.do(t, http.MethodPost, path+"/publish", doctor, nil), http.StatusNotFound)
+}
+
+func TestScheduleImpactDryRun(t *testing.T) {
+	f := newBookingFixture(t)
+	doctor := User{Username: "grey", Role: RoleDoctor, ProfileID: f.doctor.ID}
+	booked := decode[bookingResponse](t, f.book(t, f.alice, f.first), http.StatusOK).Appointment
+
+	proposed := gin.H{"schedule": []string{f.second.Format(time.RFC3339)}}
+	impact := decode[scheduleImpact](t, f.do(t, http.MethodPost, "/api/doctors/d1/schedule-impact", doctor, proposed), http.StatusOK)
+	if len(impact.Conflicts) != 1 || impact.Conflicts[0].ID != booked.ID {
+		t.Errorf("conflicts = %+v, want alice's appointment", impact.Conflicts)
+	}
+	if len(impact.Removed) != 1 || len(impact.Added) != 0 {
+		t.Errorf("diff = %+v, want only the booked slot removed", impact.scheduleDiff)
+	}
+
+	// Nothing changed
+	if slots := f.freeSlots(t); len(slots) != 1 || !slots[0].StartTime.Equal(f.second) {
+		t.Errorf("slots after the dry run = %+v, want the second slot still free", slots)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	_ "time/tzdata" // templates name IANA zones; don't depend on the host's zoneinfo
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	minSlotMinutes = 5
+	maxSlotMinutes = 8 * 60
+	maxSlotRange   = 62 * 24 * time.Hour
+)
+
+type (
+	ScheduleTemplate = store.ScheduleTemplate
+	WeeklyRule       = store.WeeklyRule
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// clockMinutes parses an HH:MM time of day into minutes since midnight.
+func clockMinutes(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an HH:MM time", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// templateLocation is the zone a template's rule times are in.
+func templateLocation(t ScheduleTemplate) *time.Location {
+	if t.TimeZone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(t.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func validateTemplate(t ScheduleTemplate) error {
+	if t.SlotMinutes < minSlotMinutes || t.SlotMinutes > maxSlotMinutes {
+		return fmt.Errorf("slotMinutes must be between %d and %d", minSlotMinutes, maxSlotMinutes)
+	}
+	if t.TimeZone != "" {
+		if _, err := time.LoadLocation(t.TimeZone); err != nil {
+			return fmt.Errorf("unknown timeZone %q", t.TimeZone)
+		}
+	}
+	for _, rule := range t.Weekly {
+		if _, ok := weekdays[strings.ToLower(rule.Weekday)]; !ok {
+			return fmt.Errorf("unknown weekday %q", rule.Weekday)
+		}
+		if rule.SlotMinutes != 0 && (rule.SlotMinutes < minSlotMinutes || rule.SlotMinutes > maxSlotMinutes) {
+			return fmt.Errorf("%s slotMinutes must be between %d and %d", rule.Weekday, minSlotMinutes, maxSlotMinutes)
+		}
+		start, err := clockMinutes(rule.Start)
+		if err != nil {
+			return err
+		}
+		end, err := clockMinutes(rule.End)
+		if err != nil {
+			return err
+		}
+		if end-start < ruleSlotMinutes(t, rule) {
+			return fmt.Errorf("%s %s-%s is shorter than one slot", rule.Weekday, rule.Start, rule.End)
+		}
+	}
+	// Overlapping windows would offer the same time twice, or slots off
+	// each other's grid, and double-book the doctor
+	for i, a := range t.Weekly {
+		for _, b := range t.Weekly[i+1:] {
+			if !strings.EqualFold(a.Weekday, b.Weekday) {
+				continue
+			}
+			aStart, _ := clockMinutes(a.Start)
+			aEnd, _ := clockMinutes(a.End)
+			bStart, _ := clockMinutes(b.Start)
+			bEnd, _ := clockMinutes(b.End)
+			if aStart < bEnd && bStart < aEnd {
+				return fmt.Errorf("%s %s-%s overlaps %s-%s", a.Weekday, a.Start, a.End, b.Start, b.End)
+			}
+		}
+	}
+	for _, date := range t.Exceptions {
+		if _, err := time.Parse(dateLayout, date); err != nil {
+			return fmt.Errorf("exception %q is not a YYYY-MM-DD date", date)
+		}
+	}
+	return nil
+}
+
+// ruleSlotMinutes is the length of the slots in rule's window.
+func ruleSlotMinutes(t ScheduleTemplate, rule WeeklyRule) int {
+	if rule.SlotMinutes != 0 {
+		return rule.SlotMinutes
+	}
+	return t.SlotMinutes
+}
+
+// generateSlots returns the template's slots starting in [from, to), in
+// start order. The template must be valid.
+func generateSlots(t ScheduleTemplate, from, to time.Time) []Slot {
+	loc := templateLocation(t)
+
+	exceptions := make(map[string]bool, len(t.Exceptions))
+	for _, date := range t.Exceptions {
+		exceptions[date] = true
+	}
+
+	slots := []Slot{}
+	localFrom := from.In(loc)
+	day := time.Date(localFrom.Year(), localFrom.Month(), localFrom.Day(), 0, 0, 0, 0, loc)
+	for ; day.Before(to); day = day.AddDate(0, 0, 1) {
+		if exceptions[day.Format(dateLayout)] {
+			continue
+		}
+		for _, rule := range t.Weekly {
+			if weekdays[strings.ToLower(rule.Weekday)] != day.Weekday() {
+				continue
+			}
+			slotLength := time.Duration(ruleSlotMinutes(t, rule)) * time.Minute
+			startMin, _ := clockMinutes(rule.Start)
+			endMin, _ := clockMinutes(rule.End)
+			windowEnd := time.Date(day.Year(), day.Month(), day.Day(), endMin/60, endMin%60, 0, 0, loc)
+
+			start := time.Date(day.Year(), day.Month(), day.Day(), startMin/60, startMin%60, 0, 0, loc)
+			for ; !start.Add(slotLength).After(windowEnd); start = start.Add(slotLength) {
+				if start.Before(from) || !start.Before(to) {
+					continue
+				}
+				slots = append(slots, Slot{StartTime: start.UTC(), EndTime: start.Add(slotLength).UTC(), FollowUpOnly: rule.FollowUpOnly})
+			}
+		}
+	}
+
+	sort.Slice(slots, func(i, j int) bool { return slots[i].StartTime.Before(slots[j].StartTime) })
+	return slots
+}
+
+func (s *Server) SetDoctorScheduleTemplate(c *gin.Context) {
+	ctx := c.Request.Context()
+	doctorID := c.Param("id")
+
+	var template ScheduleTemplate
+	if !bindJSON(c, &template) {
+		return
+	}
+	if err := validateTemplate(template); err != nil {
+		abortWithDetails(c, fieldError{Field: "scheduleTemplate", Message: err.Error()})
+		return
+	}
+
+	err := s.store.Doctors.SetTemplate(ctx, doctorID, &template)
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, "Doctor not found")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error updating doctor's schedule template")
+		return
+	}
+	go s.refreshDoctorAvailability(doctorID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Doctor's schedule template updated successfully"})
+}
+
+// DeleteDoctorScheduleTemplate removes the doctor's template, so only the
+// flat schedule is offered again.
+func (s *Server) DeleteDoctorScheduleTemplate(c *gin.Context) {
+	doctorID := c.Param("id")
+	err := s.store.Doctors.SetTemplate(c.Request.Context(), doctorID, nil)
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, "Doctor not found")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error removing doctor's schedule template")
+		return
+	}
+	go s.refreshDoctorAvailability(doctorID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Doctor's schedule template removed"})
+}
+
+// GetDoctorSlots returns the free slots between the from and to dates
+// (YYYY-MM-DD, both inclusive).
+func (s *Server) GetDoctorSlots(c *gin.Context) {
+	ctx := c.Request.Context()
+	doctorID := c.Param("id")
+
+	from, errFrom := time.Parse(dateLayout, c.Query("from"))
+	to, errTo := time.Parse(dateLayout, c.Query("to"))
+	if errFrom != nil || errTo != nil {
+		abortWithError(c, http.StatusBadRequest, "from and to must be formatted as YYYY-MM-DD")
+		return
+	}
+	to = to.AddDate(0, 0, 1)
+	if !to.After(from) || to.Sub(from) > maxSlotRange {
+		abortWithError(c, http.StatusBadRequest, "to must be on or after from and at most 62 days later")
+		return
+	}
+
+	doctor, err := s.findDoctor(ctx, doctorID)
+	if err == errDoctorNotFound {
+		abortWithError(c, http.StatusNotFound, "Doctor not found")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error fetching doctor data")
+		return
+	}
+
+	slots, err := s.freeSlots(ctx, doctor, from, to)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error computing availability")
+		return
+	}
+
+	addSlotDates(slots)
+	render(c, http.StatusOK, slots, slotList{Slots: slots})
+}
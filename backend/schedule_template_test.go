@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSlotLengthPerWeekday(t *testing.T) {
+	template := ScheduleTemplate{
+		SlotMinutes: 45,
+		Weekly: []WeeklyRule{
+			{Weekday: "monday", Start: "09:00", End: "10:00", SlotMinutes: 15},
+			{Weekday: "tuesday", Start: "09:00", End: "10:30"},
+		},
+	}
+	if err := validateTemplate(template); err != nil {
+		t.Fatal(err)
+	}
+
+	// 2024-01-01 is a Monday
+	monday := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	slots := generateSlots(template, monday, monday.AddDate(0, 0, 2))
+	var lengths []time.Duration
+	for _, slot := range slots {
+		lengths = append(lengths, slot.EndTime.Sub(slot.StartTime))
+	}
+	want := []time.Duration{15 * time.Minute, 15 * time.Minute, 15 * time.Minute, 15 * time.Minute, 45 * time.Minute, 45 * time.Minute}
+	if len(lengths) != len(want) {
+		t.Fatalf("slot lengths = %v, want %v", lengths, want)
+	}
+	for i := range want {
+		if lengths[i] != want[i] {
+			t.Fatalf("slot lengths = %v, want %v", lengths, want)
+		}
+	}
+
+	// Booking honours the window's length and grid
+	doctor := Doctor{ID: "d1", Template: &template}
+	quarter := Appointment{StartTime: monday.Add(9*time.Hour + 15*time.Minute)}
+	if _, err := fitToSlot(doctor, &quarter); err != nil || !quarter.EndTime.Equal(quarter.StartTime.Add(15*time.Minute)) {
+		t.Errorf("monday 09:15 = %v, ends %s; want a 15 minute slot", err, quarter.EndTime)
+	}
+	offGrid := Appointment{StartTime: monday.AddDate(0, 0, 1).Add(9*time.Hour + 15*time.Minute)}
+	if _, err := fitToSlot(doctor, &offGrid); err != errSlotUnavailable {
+		t.Errorf("tuesday 09:15 err = %v, want errSlotUnavailable on the 45 minute grid", err)
+	}
+}
+
+func TestValidateTemplateSlotLengths(t *testing.T) {
+	for name, template := range map[string]ScheduleTemplate{
+		"rule too short":        {SlotMinutes: 30, Weekly: []WeeklyRule{{Weekday: "monday", Start: "09:00", End: "10:00", SlotMinutes: 2}}},
+		"window under one slot": {SlotMinutes: 30, Weekly: []WeeklyRule{{Weekday: "monday", Start: "09:00", End: "09:30", SlotMinutes: 45}}},
+		"overlapping lengths": {SlotMinutes: 30, Weekly: []WeeklyRule{
+			{Weekday: "monday", Start: "09:00", End: "12:00"},
+			{Weekday: "Monday", Start: "11:00", End: "13:00", SlotMinutes: 15},
+		}},
+		"overlapping windows": {SlotMinutes: 30, Weekly: []WeeklyRule{
+			{Weekday: "monday", Start: "09:00", End: "12:00"},
+			{Weekday: "monday", Start: "11:00", End: "13:00"},
+		}},
+	} {
+		if err := validateTemplate(template); err == nil {
+			t.Errorf("%s: validateTemplate succeeded, want an error", name)
+		}
+	}
+}
+
+func TestValidateTemplateAdjacentWindows(t *testing.T) {
+	template := ScheduleTemplate{SlotMinutes: 30, Weekly: []WeeklyRule{
+		{Weekday: "monday", Start: "09:00", End: "12:00"},
+		{Weekday: "monday", Start: "12:00", End: "13:00", SlotMinutes: 15},
+		{Weekday: "tuesday", Start: "10:00", End: "11:00"},
+	}}
+	if err := validateTemplate(template); err != nil {
+		t.Errorf("validateTemplate = %v, want windows that only touch accepted", err)
+	}
+}
+
+func TestDeleteDoctorScheduleTemplate(t *testing.T) {
+	f := newBookingFixture(t)
+	grey := User{Username: "grey", Role: RoleDoctor, ProfileID: "d1"}
+	path := "/api/doctors/d1/schedule-template"
+
+	template := ScheduleTemplate{SlotMinutes: 30, Weekly: []WeeklyRule{{Weekday: "monday", Start: "09:00", End: "12:00"}}}
+	if rec := f.do(t, http.MethodPut, path, grey, template); rec.Code != http.StatusOK {
+		t.Fatalf("put status = %d, want 200", rec.Code)
+	}
+	if rec := f.do(t, http.MethodDelete, path, User{Username: "house", Role: RoleDoctor, ProfileID: "d2"}, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("another doctor's status = %d, want 403", rec.Code)
+	}
+	if rec := f.do(t, http.MethodDelete, path, grey, nil); rec.Code != http.StatusOK {
+		t.Fatalf("delete status = %d, want 200", rec.Code)
+	}
+	doctor, err := f.store.Doctors.Get(context.Background(), "d1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doctor.Template != nil {
+		t.Errorf("template = %+v after delete, want none", doctor.Template)
+	}
+	slots, err := doctorSlots(doctor, f.first, f.second.Add(slotDuration))
+	if err != nil || len(slots) != 2 {
+		t.Errorf("slots = %v, %v; want the flat schedule's two", slots, err)
+	}
+
+	admin := User{Username: "root", Role: RoleAdmin}
+	if rec := f.do(t, http.MethodDelete, "/api/doctors/missing/schedule-template", admin, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown doctor status = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// namespaceNotFound is the error code Mongo returns for a collection that
+// doesn't exist.
+const namespaceNotFound = 26
+
+type indexSpec struct {
+	Name   string
+	Keys   bson.D
+	Unique bool
+	// TTL makes Mongo delete documents once the indexed date has passed.
+	TTL bool
+}
+
+type collectionSpec struct {
+	Name      string
+	Indexes   []indexSpec
+	Validator bson.D
+}
+
+// appointmentValidator is shared by the live and archived appointments.
+var appointmentValidator = jsonSchema(bson.A{"id", "patientId", "doctorId", "startTime", "endTime", "status"}, bson.D{
+	{Key: "id", Value: bson.D{{Key: "bsonType", Value: "string"}, {Key: "minLength", Value: 1}}},
+	{Key: "patientId", Value: bson.D{{Key: "bsonType", Value: "string"}, {Key: "minLength", Value: 1}}},
+	{Key: "doctorId", Value: bson.D{{Key: "bsonType", Value: "string"}, {Key: "minLength", Value: 1}}},
+	{Key: "startTime", Value: bson.D{{Key: "bsonType", Value: "date"}}},
+	{Key: "endTime", Value: bson.D{{Key: "bsonType", Value: "date"}}},
+	{Key: "status", Value: bson.D{{Key: "enum", Value: bson.A{
+		AppointmentScheduled, AppointmentCompleted, AppointmentCancelled, AppointmentNoShow,
+	}}}},
+	{Key: "notes", Value: bson.D{{Key: "bsonType", Value: "string"}}},
+	{Key: "reminderSentAt", Value: bson.D{{Key: "bsonType", Value: "date"}}},
+})
+
+// Many fields are Go slices, which the driver stores as null when empty, so
+// array properties accept null as well.
+var expectedSchema = []collectionSpec{
+	{
+		Name: "users",
+		Indexes: []indexSpec{
+			{Name: "username_unique", Keys: bson.D{{Key: "username", Value: 1}}, Unique: true},
+		},
+		Validator: jsonSchema(bson.A{"username", "password", "role"}, bson.D{
+			{Key: "username", Value: bson.D{{Key: "bsonType", Value: "string"}, {Key: "minLength", Value: 1}}},
+			{Key: "password", Value: bson.D{{Key: "bsonType", Value: "string"}}},
+			{Key: "email", Value: bson.D{{Key: "bsonType", Value: "string"}}},
+			{Key: "role", Value: bson.D{{Key: "enum", Value: bson.A{RolePatient, RoleDoctor, RoleAdmin}}}},
+			{Key: "profileid", Value: bson.D{{Key: "bsonType", Value: "string"}}},
+			{Key: "createdat", Value: bson.D{{Key: "bsonType", Value: "date"}}},
+		}),
+	},
+	{
+		Name: "doctor",
+		Indexes: []indexSpec{
+			{Name: "id_unique", Keys: bson.D{{Key: "id", Value: 1}}, Unique: true},
+			// Listings sort by name with _id breaking ties
+			{Name: "dname_id", Keys: bson.D{{Key: "dname", Value: 1}, {Key: "_id", Value: 1}}},
+			{Name: "specialization_dname_id", Keys: bson.D{{Key: "specialization", Value: 1}, {Key: "dname", Value: 1}, {Key: "_id", Value: 1}}},
+		},
+		Validator: jsonSchema(bson.A{"id", "dname"}, bson.D{
+			{Key: "id", Value: bson.D{{Key: "bsonType", Value: "string"}, {Key: "minLength", Value: 1}}},
+			{Key: "dname", Value: bson.D{{Key: "bsonType", Value: "string"}}},
+			{Key: "specialization", Value: bson.D{{Key: "bsonType", Value: "string"}}},
+			{Key: "schedule", Value: stringArray()},
+			{Key: "scheduleTemplate", Value: bson.D{
+				{Key: "bsonType", Value: "object"},
+				{Key: "required", Value: bson.A{"slotMinutes"}},
+				{Key: "properties", Value: bson.D{
+					{Key: "slotMinutes", Value: bson.D{{Key: "bsonType", Value: bson.A{"int", "long"}}, {Key: "minimum", Value: minSlotMinutes}}},
+					{Key: "weekly", Value: bson.D{
+						{Key: "bsonType", Value: bson.A{"array", "null"}},
+						{Key: "items", Value: bson.D{
+							{Key: "bsonType", Value: "object"},
+							{Key: "properties", Value: bson.D{
+								{Key: "slotMinutes", Value: bson.D{{Key: "bsonType", Value: bson.A{"int", "long"}}, {Key: "minimum", Value: minSlotMinutes}}},
+							}},
+						}},
+					}},
+				}},
+			}},
+		}),
+	},
+	{
+		Name: "patients",
+		Indexes: []indexSpec{
+			{Name: "id_unique", Keys: bson.D{{Key: "id", Value: 1}}, Unique: true},
+			{Name: "tags", Keys: bson.D{{Key: "tags", Value: 1}}},
+			{Name: "pname_id", Keys: bson.D{{Key: "pname", Value: 1}, {Key: "_id", Value: 1}}},
+		},
+		Validator: jsonSchema(bson.A{"id"}, bson.D{
+			{Key: "id", Value: bson.D{{Key: "bsonType", Value: "string"}, {Key: "minLength", Value: 1}}},
+			{Key: "pname", Value: bson.D{{Key: "bsonType", Value: "string"}}},
+			{Key: "tags", Value: stringArray()},
+			{Key: "notes", Value: bson.D{
+				{Key: "bsonType", Value: bson.A{"array", "null"}},
+				{Key: "items", Value: bson.D{
+					{Key: "bsonType", Value: "object"},
+					{Key: "required", Value: bson.A{"text"}},
+				}},
+			}},
+		}),
+	},
+	{
+		Name: "appointments",
+		Indexes: []indexSpec{
+			{Name: "id_unique", Keys: bson.D{{Key: "id", Value: 1}}, Unique: true},
+			{Name: "patient_start", Keys: bson.D{{Key: "patientId", Value: 1}, {Key: "startTime", Value: 1}}},
+			{Name: "doctor_start", Keys: bson.D{{Key: "doctorId", Value: 1}, {Key: "startTime", Value: 1}}},
+			{Name: "status_start", Keys: bson.D{{Key: "status", Value: 1}, {Key: "startTime", Value: 1}}},
+			{Name: "start", Keys: bson.D{{Key: "startTime", Value: 1}}},
+			{Name: "partner_start", Keys: bson.D{{Key: "partnerId", Value: 1}, {Key: "startTime", Value: 1}}},
+		},
+		Validator: appointmentValidator,
+	},
+	{
+		// Appointments moved out by the archival job; only read by history
+		// listings and reports, so just the lookup indexes they use
+		Name: "appointments_archive",
+		Indexes: []indexSpec{
+			{Name: "id_unique", Keys: bson.D{{Key: "id", Value: 1}}, Unique: true},
+			{Name: "patient_start", Keys: bson.D{{Key: "patientId", Value: 1}, {Key: "startTime", Value: 1}}},
+			{Name: "doctor_start", Keys: bson.D{{Key: "doctorId", Value: 1}, {Key: "startTime", Value: 1}}},
+			{Name: "start", Keys: bson.D{{Key: "startTime", Value: 1}}},
+		},
+		Validator: appointmentValidator,
+	},
+	{
+		Name: "slot_claims",
+		Indexes: []indexSpec{
+			{Name: "doctor_start", Keys: bson.D{{Key: "doctorId", Value: 1}, {Key: "startTime", Value: 1}}},
+		},
+		Validator: jsonSchema(bson.A{"doctorId", "startTime", "appointmentId"}, bson.D{
+			{Key: "doctorId", Value: bson.D{{Key: "bsonType", Value: "string"}}},
+			{Key: "startTime", Value: bson.D{{Key: "bsonType", Value: "date"}}},
+			{Key: "appointmentId", Value: bson.D{{Key: "bsonType", Value: "string"}}},
+		}),
+	},
+	{
+		Name: "availability",
+		Indexes: []indexSpec{
+			{Name: "doctor_date", Keys: bson.D{{Key: "doctorId", Value: 1}, {Key: "date", Value: 1}}},
+			{Name: "date_doctor", Keys: bson.D{{Key: "date", Value: 1}, {Key: "doctorId", Value: 1}}},
+		},
+		Validator: jsonSchema(bson.A{"doctorId", "date", "computedAt"}, bson.D{
+			{Key: "doctorId", Value: bson.D{{Key: "bsonType", Value: "string"}}},
+			{Key: "date", Value: bson.D{{Key: "bsonType", Value: "string"}}},
+			{Key: "slots", Value: bson.D{{Key: "bsonType", Value: bson.A{"array", "null"}}}},
+			{Key: "computedAt", Value: bson.D{{Key: "bsonType", Value: "date"}}},
+		}),
+	},
+	{
+		Name: "delegations",
+		Indexes: []indexSpec{
+			{Name: "patientId", Keys: bson.D{{Key: "patientId", Value: 1}}},
+			{Name: "delegate", Keys: bson.D{{Key: "delegate", Value: 1}}},
+		},
+		Validator: jsonSchema(bson.A{"patientId", "delegate", "scopes", "grantedAt"}, bson.D{
+			{Key: "patientId", Value: bson.D{{Key: "bsonType", Value: "string"}, {Key: "minLength", Value: 1}}},
+			{Key: "delegate", Value: bson.D{{Key: "bsonType", Value: "string"}, {Key: "minLength", Value: 1}}},
+			{Key: "scopes", Value: stringArray()},
+			{Key: "grantedAt", Value: bson.D{{Key: "bsonType", Value: "date"}}},
+		}),
+	},
+	{
+		Name: "clinical_templates",
+		Indexes: []indexSpec{
+			{Name: "specialization_name", Keys: bson.D{{Key: "specialization", Value: 1}, {Key: "name", Value: 1}}},
+		},
+		Validator: jsonSchema(bson.A{"specialization", "name", "version", "fields"}, bson.D{
+			{Key: "specialization", Value: bson.D{{Key: "bsonType", Value: "string"}}},
+			{Key: "name", Value: bson.D{{Key: "bsonType", Value: "string"}}},
+			{Key: "version", Value: bson.D{{Key: "bsonType", Value: bson.A{"int", "long"}}}},
+			{Key: "fields", Value: bson.D{{Key: "bsonType", Value: "array"}}},
+		}),
+	},
+	{
+		Name: "visit_notes",
+		Indexes: []indexSpec{
+			{Name: "patientId", Keys: bson.D{{Key: "patientId", Value: 1}}},
+			{Name: "specialization_templateId", Keys: bson.D{{Key: "specialization", Value: 1}, {Key: "templateId", Value: 1}}},
+		},
+		Validator: jsonSchema(bson.A{"patientId", "doctorId", "templateId", "values"}, bson.D{
+			{Key: "patientId", Value: bson.D{{Key: "bsonType", Value: "string"}}},
+			{Key: "doctorId", Value: bson.D{{Key: "bsonType", Value: "string"}}},
+			{Key: "templateId", Value: bson.D{{Key: "bsonType", Value: "string"}}},
+			{Key: "values", Value: bson.D{{Key: "bsonType", Value: "object"}}},
+		}),
+	},
+	{
+		Name: "quality_measures",
+		Indexes: []indexSpec{
+			{Name: "measure_date", Keys: bson.D{{Key: "measure", Value: 1}, {Key: "date", Value: 1}}},
+		},
+	},
+	{
+		Name: "inventory_items",
+		Indexes: []indexSpec{
+			{Name: "name", Keys: bson.D{{Key: "name", Value: 1}}},
+		},
+		Validator: jsonSchema(bson.A{"name", "stock", "reorderLevel"}, bson.D{
+			{Key: "name", Value: bson.D{{Key: "bsonType", Value: "string"}, {Key: "minLength", Value: 1}}},
+			// Consume never takes stock below zero; this catches any other writer
+			{Key: "stock", Value: bson.D{{Key: "bsonType", Value: bson.A{"int", "long"}}, {Key: "minimum", Value: 0}}},
+			{Key: "reorderLevel", Value: bson.D{{Key: "bsonType", Value: bson.A{"int", "long"}}, {Key: "minimum", Value: 0}}},
+		}),
+	},
+	{
+		Name: "inventory_usage",
+		Indexes: []indexSpec{
+			{Name: "appointmentId", Keys: bson.D{{Key: "appointmentId", Value: 1}}},
+			{Name: "itemId_recordedAt", Keys: bson.D{{Key: "itemId", Value: 1}, {Key: "recordedAt", Value: 1}}},
+		},
+	},
+	{
+		Name: "segments",
+		Indexes: []indexSpec{
+			{Name: "name", Keys: bson.D{{Key: "name", Value: 1}}},
+		},
+	},
+	{
+		Name: "cancellation_reasons",
+		Validator: jsonSchema(bson.A{"label", "initiatedBy"}, bson.D{
+			{Key: "label", Value: bson.D{{Key: "bsonType", Value: "string"}, {Key: "minLength", Value: 1}}},
+			{Key: "initiatedBy", Value: bson.D{{Key: "enum", Value: bson.A{"patient", "clinic", "external"}}}},
+		}),
+	},
+	{
+		Name: "reporting_tokens",
+	},
+	{
+		Name: "account_closures",
+		Indexes: []indexSpec{
+			{Name: "closesAt", Keys: bson.D{{Key: "closesAt", Value: 1}}},
+			{Name: "closedAt", Keys: bson.D{{Key: "closedAt", Value: 1}}},
+		},
+	},
+	{
+		Name: "sessions",
+		Indexes: []indexSpec{
+			{Name: "username", Keys: bson.D{{Key: "username", Value: 1}, {Key: "createdAt", Value: 1}}},
+			{Name: "expires", Keys: bson.D{{Key: "expiresAt", Value: 1}}, TTL: true},
+		},
+	},
+	{
+		Name: "partners",
+	},
+	{
+		Name: "triage_rules",
+	},
+	{
+		Name: "triage_assessments",
+		Indexes: []indexSpec{
+			{Name: "patient_created", Keys: bson.D{{Key: "patientId", Value: 1}, {Key: "createdAt", Value: -1}}},
+			{Name: "expires", Keys: bson.D{{Key: "expiresAt", Value: 1}}, TTL: true},
+		},
+	},
+	{
+		Name: "hooks",
+	},
+	{
+		Name: "attendance",
+		Indexes: []indexSpec{
+			{Name: "day_username", Keys: bson.D{{Key: "day", Value: 1}, {Key: "username", Value: 1}}},
+		},
+	},
+	{
+		Name: "captures",
+	},
+	{
+		// Recorded exchanges are removed by Mongo once kept long enough
+		Name: "captured_exchanges",
+		Indexes: []indexSpec{
+			{Name: "captureId_at", Keys: bson.D{{Key: "captureId", Value: 1}, {Key: "at", Value: 1}}},
+			{Name: "expires", Keys: bson.D{{Key: "deleteAt", Value: 1}}, TTL: true},
+		},
+	},
+	{
+		Name: "audit_log",
+		Indexes: []indexSpec{
+			{Name: "at", Keys: bson.D{{Key: "at", Value: 1}}},
+			{Name: "patientId_at", Keys: bson.D{{Key: "patientId", Value: 1}, {Key: "at", Value: 1}}},
+		},
+		Validator: jsonSchema(bson.A{"at", "actor", "action"}, bson.D{
+			{Key: "at", Value: bson.D{{Key: "bsonType", Value: "date"}}},
+			{Key: "actor", Value: bson.D{{Key: "bsonType", Value: "string"}}},
+			{Key: "action", Value: bson.D{{Key: "bsonType", Value: "string"}, {Key: "minLength", Value: 1}}},
+		}),
+	},
+	{
+		// Unused sign-in links are removed by Mongo once they expire
+		Name: "magic_links",
+		Indexes: []indexSpec{
+			{Name: "expires", Keys: bson.D{{Key: "expiresAt", Value: 1}}, TTL: true},
+		},
+		Validator: jsonSchema(bson.A{"username", "deviceHash", "expiresAt"}, bson.D{
+			{Key: "username", Value: bson.D{{Key: "bsonType", Value: "string"}}},
+			{Key: "deviceHash", Value: bson.D{{Key: "bsonType", Value: "string"}}},
+			{Key: "expiresAt", Value: bson.D{{Key: "bsonType", Value: "date"}}},
+		}),
+	},
+}
+
+func jsonSchema(required bson.A, properties bson.D) bson.D {
+	return bson.D{{Key: "$jsonSchema", Value: bson.D{
+		{Key: "bsonType", Value: "object"},
+		{Key: "required", Value: required},
+		{Key: "properties", Value: properties},
+	}}}
+}
+
+func stringArray() bson.D {
+	return bson.D{
+		{Key: "bsonType", Value: bson.A{"array", "null"}},
+		{Key: "items", Value: bson.D{{Key: "bsonType", Value: "string"}}},
+	}
+}
+
+// SchemaDrift is one difference between the database and expectedSchema.
+type SchemaDrift struct {
+	Collection string
+	Kind       string // "missing-index", "index-mismatch", "unexpected-index", "validator"
+	Detail     string
+	// Fixable drifts can be corrected without dropping anything.
+	Fixable bool
+
+	index *indexSpec
+}
+
+func (d SchemaDrift) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Collection, d.Kind, d.Detail)
+}
+
+// checkSchema compares indexes and validators in db against expectedSchema.
+func checkSchema(ctx context.Context, db *mongo.Database) ([]SchemaDrift, error) {
+	validators, err := currentValidators(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var drifts []SchemaDrift
+	for _, spec := range expectedSchema {
+		indexDrifts, err := checkIndexes(ctx, db.Collection(spec.Name), spec)
+		if err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, indexDrifts...)
+
+		if canonicalJSON(validators[spec.Name]) != canonicalJSON(spec.Validator) {
+			drifts = append(drifts, SchemaDrift{
+				Collection: spec.Name,
+				Kind:       "validator",
+				Detail:     "JSON schema validator is missing or out of date",
+				Fixable:    true,
+			})
+		}
+	}
+	return drifts, nil
+}
+
+func checkIndexes(ctx context.Context, coll *mongo.Collection, spec collectionSpec) ([]SchemaDrift, error) {
+	existing, err := coll.Indexes().ListSpecifications(ctx)
+	if collectionMissing(err) {
+		// The collection doesn't exist yet, so every index is missing
+		existing, err = nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listing %s indexes: %w", spec.Name, err)
+	}
+	return indexDrifts(spec, existing), nil
+}
+
+// collectionMissing reports whether err is Mongo saying the collection
+// doesn't exist.
+func collectionMissing(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == namespaceNotFound
+}
+
+// indexDrifts compares the existing indexes of a collection with spec.
+func indexDrifts(spec collectionSpec, existing []*mongo.IndexSpecification) []SchemaDrift {
+	byKeys := make(map[string]*mongo.IndexSpecification, len(existing))
+	for _, idx := range existing {
+		byKeys[canonicalJSON(idx.KeysDocument)] = idx
+	}
+
+	var drifts []SchemaDrift
+	expectedKeys := map[string]bool{canonicalJSON(bson.D{{Key: "_id", Value: 1}}): true}
+	for i := range spec.Indexes {
+		want := &spec.Indexes[i]
+		keys := canonicalJSON(want.Keys)
+		expectedKeys[keys] = true
+
+		have, ok := byKeys[keys]
+		switch {
+		case !ok:
+			drifts = append(drifts, SchemaDrift{
+				Collection: spec.Name,
+				Kind:       "missing-index",
+				Detail:     fmt.Sprintf("%s on %s", want.Name, keys),
+				Fixable:    true,
+				index:      want,
+			})
+		case (have.Unique != nil && *have.Unique) != want.Unique:
+			drifts = append(drifts, SchemaDrift{
+				Collection: spec.Name,
+				Kind:       "index-mismatch",
+				Detail:     fmt.Sprintf("%s on %s should have unique=%t", have.Name, keys, want.Unique),
+			})
+		case (have.ExpireAfterSeconds != nil) != want.TTL:
+			drifts = append(drifts, SchemaDrift{
+				Collection: spec.Name,
+				Kind:       "index-mismatch",
+				Detail:     fmt.Sprintf("%s on %s should have ttl=%t", have.Name, keys, want.TTL),
+			})
+		}
+	}
+
+	for keys, idx := range byKeys {
+		if !expectedKeys[keys] {
+			drifts = append(drifts, SchemaDrift{
+				Collection: spec.Name,
+				Kind:       "unexpected-index",
+				Detail:     fmt.Sprintf("%s on %s", idx.Name, keys),
+			})
+		}
+	}
+	return drifts
+}
+
+// currentValidators returns the validator of every existing collection.
+func currentValidators(ctx context.Context, db *mongo.Database) (map[string]bson.Raw, error) {
+	specs, err := db.ListCollectionSpecifications(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+
+	validators := make(map[string]bson.Raw, len(specs))
+	for _, spec := range specs {
+		if validator, ok := spec.Options.Lookup("validator").DocumentOK(); ok {
+			validators[spec.Name] = validator
+		}
+	}
+	return validators, nil
+}
+
+// fixSchema applies the fixable drifts. Mismatched and unexpected indexes
+// are only reported, since fixing them means dropping an index.
+func fixSchema(ctx context.Context, db *mongo.Database, drifts []SchemaDrift) error {
+	existing, err := db.ListCollectionNames(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	exists := make(map[string]bool, len(existing))
+	for _, name := range existing {
+		exists[name] = true
+	}
+
+	for _, drift := range drifts {
+		if !drift.Fixable {
+			continue
+		}
+
+		switch drift.Kind {
+		case "missing-index":
+			model := mongo.IndexModel{
+				Keys:    drift.index.Keys,
+				Options: options.Index().SetName(drift.index.Name).SetUnique(drift.index.Unique),
+			}
+			if drift.index.TTL {
+				model.Options.SetExpireAfterSeconds(0)
+			}
+			if _, err := db.Collection(drift.Collection).Indexes().CreateOne(ctx, model); err != nil {
+				return fmt.Errorf("%s: creating index %s: %w", drift.Collection, drift.index.Name, err)
+			}
+			exists[drift.Collection] = true
+
+		case "validator":
+			validator := validatorFor(drift.Collection)
+			if !exists[drift.Collection] {
+				opts := options.CreateCollection().SetValidator(validator)
+				if err := db.CreateCollection(ctx, drift.Collection, opts); err != nil {
+					return fmt.Errorf("%s: creating collection: %w", drift.Collection, err)
+				}
+				exists[drift.Collection] = true
+				continue
+			}
+			cmd := bson.D{{Key: "collMod", Value: drift.Collection}, {Key: "validator", Value: validator}}
+			if err := db.RunCommand(ctx, cmd).Err(); err != nil {
+				return fmt.Errorf("%s: installing validator: %w", drift.Collection, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validatorFor(collection string) bson.D {
+	for _, spec := range expectedSchema {
+		if spec.Name == collection {
+			return spec.Validator
+		}
+	}
+	return nil
+}
+
+// ensureSchema reports schema drift on boot and creates missing indexes,
+// which listings rely on and which only add to the database. The other
+// fixes, like installing validators, are only applied when autoFix is set.
+// Drift never stops the server from starting.
+func ensureSchema(ctx context.Context, db *mongo.Database, autoFix bool) {
+	drifts, err := checkSchema(ctx, db)
+	if err != nil {
+		log.Println("Schema check failed: ", err)
+		return
+	}
+	var fixes []SchemaDrift
+	for _, drift := range drifts {
+		log.Println("Schema drift: ", drift)
+		if autoFix || drift.Kind == "missing-index" {
+			fixes = append(fixes, drift)
+		}
+	}
+
+	if len(fixes) > 0 {
+		if err := fixSchema(ctx, db, fixes); err != nil {
+			log.Println("Schema fix failed: ", err)
+			return
+		}
+		log.Printf("Schema fix applied to %d of %d drifts", len(fixes), len(drifts))
+	}
+}
+
+// runSchemaCommand implements `main schema [-fix]`. It prints the drift
+// report and returns the process exit code: 1 while drift remains.
+func runSchemaCommand(ctx context.Context, db *mongo.Database, args []string) int {
+	fix := len(args) > 0 && args[0] == "-fix"
+
+	drifts, err := checkSchema(ctx, db)
+	if err != nil {
+		fmt.Println("Schema check failed:", err)
+		return 1
+	}
+	if len(drifts) == 0 {
+		fmt.Println("Schema is up to date")
+		return 0
+	}
+	for _, drift := range drifts {
+		fmt.Println(drift)
+	}
+	if !fix {
+		return 1
+	}
+
+	if err := fixSchema(ctx, db, drifts); err != nil {
+		fmt.Println("Schema fix failed:", err)
+		return 1
+	}
+	remaining, err := checkSchema(ctx, db)
+	if err != nil {
+		fmt.Println("Schema check failed:", err)
+		return 1
+	}
+	fmt.Printf("Fixed %d of %d issues\n", len(drifts)-len(remaining), len(drifts))
+	for _, drift := range remaining {
+		fmt.Println(drift)
+	}
+	if len(remaining) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// canonicalJSON renders a document as relaxed extended JSON so documents
+// that differ only in numeric types (int32 vs int64) compare equal.
+func canonicalJSON(doc interface{}) string {
+	if doc == nil {
+		return ""
+	}
+	if raw, ok := doc.(bson.Raw); ok {
+		var d bson.D
+		if err := bson.Unmarshal(raw, &d); err != nil {
+			return ""
+		}
+		doc = d
+	}
+	out, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
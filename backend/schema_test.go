@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	raw := func(doc bson.D) bson.Raw {
+		t.Helper()
+		out, err := bson.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	// Keys read back from Mongo match the spec whatever their integer type
+	keys := canonicalJSON(bson.D{{Key: "doctorId", Value: 1}, {Key: "start", Value: -1}})
+	for _, stored := range []interface{}{
+		raw(bson.D{{Key: "doctorId", Value: int32(1)}, {Key: "start", Value: int32(-1)}}),
+		bson.D{{Key: "doctorId", Value: int64(1)}, {Key: "start", Value: int64(-1)}},
+	} {
+		if got := canonicalJSON(stored); got != keys {
+			t.Errorf("canonical keys = %s, want %s", got, keys)
+		}
+	}
+	if got := canonicalJSON(raw(bson.D{{Key: "start", Value: -1}, {Key: "doctorId", Value: 1}})); got == keys {
+		t.Error("keys in another order compare equal")
+	}
+
+	// An installed validator compares equal, a stale or missing one doesn't
+	for _, spec := range expectedSchema {
+		if spec.Validator == nil {
+			continue
+		}
+		if canonicalJSON(raw(spec.Validator)) != canonicalJSON(spec.Validator) {
+			t.Errorf("%s: installed validator drifts from its spec", spec.Name)
+		}
+	}
+	stale := jsonSchema(bson.A{"username", "password"}, bson.D{
+		{Key: "username", Value: bson.D{{Key: "bsonType", Value: "string"}}},
+	})
+	var missing bson.Raw
+	want := canonicalJSON(validatorFor("users"))
+	if canonicalJSON(raw(stale)) == want || canonicalJSON(missing) == want {
+		t.Error("a stale or missing validator compares equal to the spec")
+	}
+}
+
+func TestIndexDrifts(t *testing.T) {
+	index := func(name string, keys bson.D, unique, ttl bool) *mongo.IndexSpecification {
+		t.Helper()
+		doc, err := bson.Marshal(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		idx := &mongo.IndexSpecification{Name: name, KeysDocument: doc}
+		if unique {
+			idx.Unique = &unique
+		}
+		if ttl {
+			var seconds int32
+			idx.ExpireAfterSeconds = &seconds
+		}
+		return idx
+	}
+	spec := collectionSpec{
+		Name: "appointments",
+		Indexes: []indexSpec{
+			{Name: "id_unique", Keys: bson.D{{Key: "id", Value: 1}}, Unique: true},
+			{Name: "patient_start", Keys: bson.D{{Key: "patientId", Value: 1}, {Key: "startTime", Value: 1}}},
+			{Name: "reminder", Keys: bson.D{{Key: "remindAt", Value: 1}}, TTL: true},
+			{Name: "doctor_start", Keys: bson.D{{Key: "doctorId", Value: 1}, {Key: "startTime", Value: 1}}},
+		},
+	}
+	id := bson.D{{Key: "_id", Value: int32(1)}}
+
+	for _, tc := range []struct {
+		name     string
+		existing []*mongo.IndexSpecification
+		want     []string
+	}{
+		{
+			name: "up to date",
+			existing: []*mongo.IndexSpecification{
+				index("_id_", id, false, false),
+				index("id_unique", bson.D{{Key: "id", Value: int32(1)}}, true, false),
+				index("patient_start", bson.D{{Key: "patientId", Value: int32(1)}, {Key: "startTime", Value: int32(1)}}, false, false),
+				index("reminder", bson.D{{Key: "remindAt", Value: int32(1)}}, false, true),
+				index("doctor_start", bson.D{{Key: "doctorId", Value: int32(1)}, {Key: "startTime", Value: int32(1)}}, false, false),
+			},
+		},
+		{
+			// Matched by keys, so a renamed index isn't reported
+			name: "drifted",
+			existing: []*mongo.IndexSpecification{
+				index("_id_", id, false, false),
+				index("id_1", bson.D{{Key: "id", Value: int32(1)}}, false, false),
+				index("patient_start", bson.D{{Key: "patientId", Value: int32(1)}, {Key: "startTime", Value: int32(1)}}, true, false),
+				index("reminder", bson.D{{Key: "remindAt", Value: int32(1)}}, false, false),
+				index("status_1", bson.D{{Key: "status", Value: int32(1)}}, false, false),
+			},
+			want: []string{
+				`appointments: index-mismatch: id_1 on {"id":1} should have unique=true`,
+				`appointments: index-mismatch: patient_start on {"patientId":1,"startTime":1} should have unique=false`,
+				`appointments: index-mismatch: reminder on {"remindAt":1} should have ttl=true`,
+				`appointments: missing-index: doctor_start on {"doctorId":1,"startTime":1}`,
+				`appointments: unexpected-index: status_1 on {"status":1}`,
+			},
+		},
+		{
+			name: "new collection",
+			want: []string{
+				`appointments: missing-index: id_unique on {"id":1}`,
+				`appointments: missing-index: patient_start on {"patientId":1,"startTime":1}`,
+				`appointments: missing-index: reminder on {"remindAt":1}`,
+				`appointments: missing-index: doctor_start on {"doctorId":1,"startTime":1}`,
+			},
+		},
+	} {
+		drifts := indexDrifts(spec, tc.existing)
+		got := make([]string, len(drifts))
+		for i, drift := range drifts {
+			got[i] = drift.String()
+			// Only missing indexes are fixed, since the rest mean dropping one
+			if fixable := drift.Kind == "missing-index"; drift.Fixable != fixable || (drift.index != nil) != fixable {
+				t.Errorf("%s: %s: fixable = %t, want %t", tc.name, drift, drift.Fixable, fixable)
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: drifts = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCollectionMissing(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{mongo.CommandError{Code: namespaceNotFound, Name: "NamespaceNotFound"}, true},
+		{fmt.Errorf("listing indexes: %w", mongo.CommandError{Code: namespaceNotFound}), true},
+		{mongo.CommandError{Code: 13, Name: "Unauthorized"}, false},
+		{context.DeadlineExceeded, false},
+	} {
+		if got := collectionMissing(tc.err); got != tc.want {
+			t.Errorf("collectionMissing(%v) = %t, want %t", tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type (
+	Segment       = store.Segment
+	SegmentFilter = store.SegmentFilter
+)
+
+// segmentPatient is one patient of an evaluated segment.
+type segmentPatient struct {
+	ID          string   `json:"id" bson:"id"`
+	PName       string   `json:"pname" bson:"pname"`
+	Tags        []string `json:"tags" bson:"tags"`
+	DateOfBirth string   `json:"dateOfBirth,omitempty" bson:"dateOfBirth"`
+}
+
+func validateSegmentFilter(f SegmentFilter) []fieldError {
+	var errs []fieldError
+	if f.MinAge != nil && f.MaxAge != nil && *f.MinAge > *f.MaxAge {
+		errs = append(errs, fieldError{Field: "filter.maxAge", Message: "must not be less than minAge"})
+	}
+	if f.VisitedWithinDays > 0 && f.NotVisitedWithinDays >= f.VisitedWithinDays {
+		errs = append(errs, fieldError{Field: "filter.notVisitedWithinDays", Message: "must be less than visitedWithinDays"})
+	}
+	return errs
+}
+
+// visitLookup adds field as the patient's first completed appointment since
+// since, in a list that is empty when there is none.
+func visitLookup(field string, f SegmentFilter, since time.Time) bson.D {
+	visits := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"$expr":     bson.M{"$eq": bson.A{"$patientId", "$$patient"}},
+			"status":    AppointmentCompleted,
+			"startTime": bson.M{"$gte": since},
+		}}},
+	}
+	if f.Specialization != "" {
+		visits = append(visits,
+			bson.D{{Key: "$lookup", Value: bson.M{"from": "doctor", "localField": "doctorId", "foreignField": "id", "as": "doctor"}}},
+			bson.D{{Key: "$match", Value: bson.M{"doctor.specialization": f.Specialization}}},
+		)
+	}
+	visits = append(visits, bson.D{{Key: "$limit", Value: 1}}, bson.D{{Key: "$project", Value: bson.M{"_id": 1}}})
+	return bson.D{{Key: "$lookup", Value: bson.M{
+		"from":     "appointments",
+		"let":      bson.M{"patient": "$id"},
+		"pipeline": visits,
+		"as":       field,
+	}}}
+}
+
+// segmentPipeline finds the patients matching f at now over the patients
+// collection, ordered by name.
+func segmentPipeline(f SegmentFilter, now time.Time) mongo.Pipeline {
+	match := bson.M{}
+	if len(f.Tags) > 0 {
+		match["tags"] = bson.M{"$all": f.Tags}
+	}
+	// Dates of birth are YYYY-MM-DD strings, which sort like the dates
+	born := bson.M{}
+	if f.MinAge != nil {
+		born["$lte"] = now.AddDate(-*f.MinAge, 0, 0).Format(dateLayout)
+	}
+	if f.MaxAge != nil {
+		born["$gt"] = now.AddDate(-*f.MaxAge-1, 0, 0).Format(dateLayout)
+	}
+	if len(born) > 0 {
+		match["dateOfBirth"] = born
+	}
+
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: match}}}
+	if f.VisitedWithinDays > 0 {
+		pipeline = append(pipeline,
+			visitLookup("recentVisit", f, now.AddDate(0, 0, -f.VisitedWithinDays)),
+			bson.D{{Key: "$match", Value: bson.M{"recentVisit": bson.M{"$ne": bson.A{}}}}},
+		)
+	}
+	if f.NotVisitedWithinDays > 0 {
+		pipeline = append(pipeline,
+			visitLookup("latestVisit", f, now.AddDate(0, 0, -f.NotVisitedWithinDays)),
+			bson.D{{Key: "$match", Value: bson.M{"latestVisit": bson.A{}}}},
+		)
+	}
+	return append(pipeline,
+		bson.D{{Key: "$project", Value: bson.M{"_id": 0, "id": 1, "pname": 1, "tags": bson.M{"$ifNull": bson.A{"$tags", bson.A{}}}, "dateOfBirth": 1}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "pname", Value: 1}, {Key: "id", Value: 1}}}},
+	)
+}
+
+func (s *Server) GetSegments(c *gin.Context) {
+	segments, err := s.store.Segments.List(c.Request.Context())
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving segments")
+		return
+	}
+	c.JSON(http.StatusOK, segments)
+}
+
+// PutSegment creates or replaces a saved segment.
+func (s *Server) PutSegment(c *gin.Context) {
+	var segment Segment
+	if !bindJSON(c, &segment) {
+		return
+	}
+	if errs := validateSegmentFilter(segment.Filter); len(errs) > 0 {
+		abortWithDetails(c, errs...)
+		return
+	}
+	user, _ := currentUser(c)
+	segment.ID = c.Param("id")
+	segment.UpdatedBy = user.Username
+	segment.UpdatedAt = time.Now().UTC()
+
+	if err := s.store.Segments.Put(c.Request.Context(), segment); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error saving segment")
+		return
+	}
+	c.JSON(http.StatusOK, segment)
+}
+
+func (s *Server) DeleteSegment(c *gin.Context) {
+	err := s.store.Segments.Delete(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, "Segment not found")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error deleting segment")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Segment deleted"})
+}
+
+// GetSegmentPatients evaluates a saved segment now and returns one page of
+// its patients. Like the reports it needs MongoDB.
+func (s *Server) GetSegmentPatients(c *gin.Context) {
+	ctx := c.Request.Context()
+	q, err := parseListQuery(c, "name")
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	segment, err := s.store.Segments.Get(ctx, c.Param("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, "Segment not found")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving segment")
+		return
+	}
+
+	page := q.page()
+	pipeline := append(segmentPipeline(segment.Filter, time.Now().UTC()), bson.D{{Key: "$facet", Value: bson.M{
+		"rows":  mongo.Pipeline{{{Key: "$skip", Value: page.Skip}}, {{Key: "$limit", Value: page.Limit}}},
+		"total": mongo.Pipeline{{{Key: "$count", Value: "count"}}},
+	}}})
+	results, err := aggregateReport[struct {
+		Rows  []segmentPatient `bson:"rows"`
+		Total []struct {
+			Count int64 `bson:"count"`
+		} `bson:"total"`
+	}](ctx, s.db.Collection("patients"), pipeline)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error evaluating segment")
+		return
+	}
+
+	patients, total := []segmentPatient{}, int64(0)
+	if len(results) > 0 {
+		if results[0].Rows != nil {
+			patients = results[0].Rows
+		}
+		if len(results[0].Total) > 0 {
+			total = results[0].Total[0].Count
+		}
+	}
+	setTotalCount(c, total)
+	c.JSON(http.StatusOK, gin.H{"segment": segment, "patients": patients})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSavedSegments(t *testing.T) {
+	ts := newTestServer(t)
+	doctor := User{Username: "grey", Role: RoleDoctor, ProfileID: "d1"}
+	patient := User{Username: "alice", Role: RolePatient, ProfileID: "p-alice"}
+	recall := gin.H{"name": "Diabetic recall", "filter": gin.H{"tags": []string{"diabetic"}, "minAge": 40, "visitedWithinDays": 730, "notVisitedWithinDays": 365}}
+
+	decode[apiError](t, ts.do(t, http.MethodPut, "/api/segments/recall", patient, recall), http.StatusForbidden)
+	bad := decode[apiError](t, ts.do(t, http.MethodPut, "/api/segments/recall", doctor, gin.H{"name": "Empty", "filter": gin.H{"minAge": 60, "maxAge": 18}}), http.StatusBadRequest)
+	if len(bad.Details) != 1 || bad.Details[0].Field != "filter.maxAge" {
+		t.Errorf("details = %+v, want a maxAge error", bad.Details)
+	}
+
+	saved := decode[Segment](t, ts.do(t, http.MethodPut, "/api/segments/recall", doctor, recall), http.StatusOK)
+	if saved.UpdatedBy != "grey" || *saved.Filter.MinAge != 40 {
+		t.Errorf("saved = %+v, want grey's segment from age 40", saved)
+	}
+	if segments := decode[[]Segment](t, ts.do(t, http.MethodGet, "/api/segments", doctor, nil), http.StatusOK); len(segments) != 1 {
+		t.Errorf("segments = %+v, want the recall segment", segments)
+	}
+	decode[struct{}](t, ts.do(t, http.MethodDelete, "/api/segments/recall", doctor, nil), http.StatusOK)
+	decode[apiError](t, ts.do(t, http.MethodDelete, "/api/segments/recall", doctor, nil), http.StatusNotFound)
+}
+
+func TestSegmentPipelineAges(t *testing.T) {
+	minAge, maxAge := 18, 30
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC)
+	match := segmentPipeline(SegmentFilter{MinAge: &minAge, MaxAge: &maxAge}, now)[0][0].Value.(bson.M)
+	born := match["dateOfBirth"].(bson.M)
+	// Turning 18 today is in, turning 31 today is out
+	if born["$lte"] != "2008-03-15" || born["$gt"] != "1995-03-15" {
+		t.Errorf("dateOfBirth bounds = %v, want (1995-03-15, 2008-03-15]", born)
+	}
+}
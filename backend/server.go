@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"containerized-go-app/notification"
+	"containerized-go-app/store"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Server holds the dependencies of the HTTP handlers and background jobs.
+type Server struct {
+	store    *store.Store
+	notifier *notification.Notifier
+	// Sign-in link requests are throttled per username and per client IP
+	magicLinkUserLimit *rateLimiter
+	magicLinkIPLimit   *rateLimiter
+	// The public availability summary has its own limit and cache
+	publicLimit        *rateLimiter
+	publicAvailability *publicAvailability
+	// db runs the aggregation reports, which only exist for MongoDB; it is
+	// nil when the server runs on another store.
+	db *mongo.Database
+	// frontend is the built frontend served for unmatched paths, or nil
+	// when it is deployed separately.
+	frontend fs.FS
+	captures *captureCache
+	// patientIndex serves the reception typeahead
+	patientIndex *patientIndex
+	// auditSinks copies audit entries to external sinks; nil when none is
+	// configured.
+	auditSinks *auditSinks
+}
+
+func NewServer(st *store.Store, notifier *notification.Notifier, db *mongo.Database) *Server {
+	return &Server{
+		store:              st,
+		notifier:           notifier,
+		db:                 db,
+		magicLinkUserLimit: newRateLimiter(3, magicLinkWindow),
+		magicLinkIPLimit:   newRateLimiter(10, magicLinkWindow),
+		publicLimit:        newRateLimiter(publicRequestsPerMinute, time.Minute),
+		publicAvailability: &publicAvailability{},
+		frontend:           frontendFiles,
+		captures:           &captureCache{},
+		patientIndex:       &patientIndex{},
+	}
+}
+
+// Router builds every route on one engine, for a single listener; prof
+// records their timings.
+func (s *Server) Router(prof *profiler) *gin.Engine {
+	routes := s.engine(prof, true)
+	s.publicRoutes(routes)
+	s.adminRoutes(routes, prof)
+	return routes
+}
+
+// PublicRouter builds the patient and staff routes without the admin
+// surface, for when that has a listener of its own.
+func (s *Server) PublicRouter(prof *profiler) *gin.Engine {
+	routes := s.engine(prof, true)
+	s.publicRoutes(routes)
+	return routes
+}
+
+// AdminRouter builds the admin routes, diagnostics and reports. The admin
+// listener is meant for the clinic's internal network and scripts, so
+// there is no CORS and no frontend.
+func (s *Server) AdminRouter(prof *profiler) *gin.Engine {
+	routes := s.engine(prof, false)
+	routes.GET("/healthz", s.Healthz)
+	s.adminRoutes(routes, prof)
+	return routes
+}
+
+// trustedProxies are the reverse proxies in front of the server, as IPs
+// or CIDRs from TRUSTED_PROXIES. Only their X-Forwarded-For is believed, so
+// without any the client IP the rate limits see is the connection's.
+var trustedProxies []string
+
+// parseTrustedProxies reads TRUSTED_PROXIES, a comma-separated list of IPs
+// and CIDRs.
+func parseTrustedProxies(value string) ([]string, error) {
+	var proxies []string
+	for _, proxy := range strings.Split(value, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return nil, fmt.Errorf("%q is neither an IP nor a CIDR", proxy)
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies, nil
+}
+
+// engine sets up the middleware every listener shares; browser adds CORS
+// for the frontend and serves it for unmatched paths.
+func (s *Server) engine(prof *profiler, browser bool) *gin.Engine {
+	routes := gin.Default()
+	if err := routes.SetTrustedProxies(trustedProxies); err != nil {
+		// parseTrustedProxies has checked them, but never trust every proxy
+		log.Printf("Ignoring trusted proxies: %v", err)
+		routes.SetTrustedProxies(nil)
+	}
+
+	// Configure CORS
+	if browser {
+		config := cors.DefaultConfig()
+		config.AllowOrigins = []string{"http://localhost:3000"}
+		config.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+		config.AllowHeaders = append(config.AllowHeaders, "Authorization")
+		config.ExposeHeaders = []string{totalCountHeader}
+		routes.Use(cors.New(config))
+	}
+	routes.Use(prof.middleware())
+	routes.Use(DeadlineBudget(requestBudgetFromEnv(), map[string]time.Duration{"/api/admin/reports": reportBudget}))
+	routes.Use(s.CaptureTraffic())
+	routes.Use(ErrorEnvelope())
+	if browser && hasFrontend(s.frontend) {
+		routes.NoRoute(serveFrontend(s.frontend))
+	} else {
+		routes.NoRoute(func(c *gin.Context) {
+			abortWithError(c, http.StatusNotFound, "Not found")
+		})
+	}
+	return routes
+}
+
+func (s *Server) publicRoutes(routes *gin.Engine) {
+	// Set up routes
+	routes.GET("/healthz", s.Healthz)
+	routes.POST("/api/signup", s.OptionalAuth(), s.SignUp)
+	routes.POST("/api/login", s.Login)
+	routes.POST("/api/login/magic-link", s.RequestMagicLink)
+	routes.POST("/api/login/magic-link/verify", s.VerifyMagicLink)
+	routes.GET("/api/doctors", s.GetDoctors)
+	routes.GET("/api/doctors/:id", s.GetDoctorByID)
+	routes.GET("/api/doctors/:id/availability", s.GetDoctorAvailability)
+	routes.GET("/api/doctors/:id/slots", s.GetDoctorSlots)
+	routes.GET("/api/public/next-available", s.GetPublicNextAvailable)
+
+	authed := routes.Group("/api", s.AuthRequired())
+	authed.POST("/doctors", RequireRole(RoleDoctor, RoleAdmin), s.CreateDoctor)
+	authed.PUT("/doctors/:id/schedule", RequireSelf(RoleDoctor, RoleAdmin), s.SetDoctorSchedule)
+	authed.PUT("/doctors/:id/schedule-template", RequireSelf(RoleDoctor, RoleAdmin), s.SetDoctorScheduleTemplate)
+	authed.DELETE("/doctors/:id/schedule-template", RequireSelf(RoleDoctor, RoleAdmin), s.DeleteDoctorScheduleTemplate)
+	authed.PUT("/doctors/:id/follow-up-slots", RequireSelf(RoleDoctor, RoleAdmin), s.SetDoctorFollowUpSlots)
+
+	draft := authed.Group("/doctors/:id/schedule-draft", RequireSelf(RoleDoctor, RoleAdmin))
+	draft.GET("", s.GetDoctorScheduleDraft)
+	draft.PUT("", s.PutDoctorScheduleDraft)
+	draft.DELETE("", s.DeleteDoctorScheduleDraft)
+	draft.GET("/diff", s.GetDoctorScheduleDraftDiff)
+	draft.POST("/publish", s.PublishDoctorScheduleDraft)
+	authed.POST("/doctors/:id/schedule-impact", RequireSelf(RoleDoctor, RoleAdmin), s.PostDoctorScheduleImpact)
+
+	staff := authed.Group("", RequireRole(RoleDoctor, RoleAdmin))
+	staff.GET("/patients", s.GetPatients)
+	staff.GET("/patients/typeahead", s.GetPatientTypeahead)
+	staff.POST("/clock-in", s.ClockIn)
+	staff.POST("/clock-out", s.ClockOut)
+	staff.GET("/attendance", s.GetAttendance)
+	staff.PUT("/patients/:id/tags", s.SetPatientTags)
+	staff.POST("/patients/:id/notes", s.AddPatientNote)
+	staff.GET("/clinical-templates", s.GetClinicalTemplates)
+	staff.GET("/inventory", s.GetInventory)
+	staff.GET("/segments", s.GetSegments)
+	staff.PUT("/segments/:id", s.PutSegment)
+	staff.DELETE("/segments/:id", s.DeleteSegment)
+	if s.db != nil {
+		staff.GET("/segments/:id/patients", s.GetSegmentPatients)
+	}
+
+	authed.GET("/doctors/:id/appointments", RequireSelf(RoleDoctor, RoleAdmin), s.GetDoctorAppointments)
+
+	// Patients' own resources; delegates only reach the appointment routes
+	ownPatient := authed.Group("/patients/:id", RequireSelf(RolePatient, RoleDoctor, RoleAdmin))
+	ownPatient.PATCH("/profile", s.UpdatePatientProfile)
+	ownPatient.PUT("/emergency-contacts", s.SetEmergencyContacts)
+	ownPatient.POST("/appointments/:appointmentID/emergency-contacts/notify", RequireRole(RoleDoctor), s.NotifyEmergencyContacts)
+	ownPatient.GET("/appointments/:appointmentID/visit-note", RequireRole(RoleDoctor, RoleAdmin), s.GetVisitNote)
+	ownPatient.PUT("/appointments/:appointmentID/visit-note", RequireRole(RoleDoctor), s.PutVisitNote)
+	ownPatient.GET("/appointments/:appointmentID/supplies", RequireRole(RoleDoctor, RoleAdmin), s.GetAppointmentSupplies)
+	ownPatient.POST("/appointments/:appointmentID/supplies", RequireRole(RoleDoctor), s.RecordAppointmentSupplies)
+	ownPatient.GET("/consents", s.GetPatientConsents)
+	ownPatient.PUT("/consents/:purpose", s.SetPatientConsent)
+	ownPatient.GET("/closure", s.GetAccountClosure)
+	ownPatient.POST("/closure", RequireRole(RolePatient), s.RequestAccountClosure)
+	ownPatient.POST("/closure/confirm", RequireRole(RolePatient), s.ConfirmAccountClosure)
+	ownPatient.DELETE("/closure", RequireRole(RolePatient), s.UndoAccountClosure)
+
+	// Only the patient, or an admin, decides who else reaches their
+	// appointments; a doctor treating them doesn't
+	delegations := authed.Group("/patients/:id/delegations", RequireSelf(RolePatient, RoleAdmin))
+	delegations.GET("", s.GetPatientDelegations)
+	delegations.POST("", s.CreatePatientDelegation)
+	delegations.DELETE("/:delegationID", s.RevokePatientDelegation)
+
+	patient := authed.Group("/patients/:id")
+	canView, canBook := s.RequirePatientAccess(store.DelegationView), s.RequirePatientAccess(store.DelegationBook)
+	patient.GET("/profile/missing-fields", canView, s.GetMissingProfileFields)
+	patient.GET("/appointments", canView, s.GetPatientAppointments)
+	patient.POST("/appointments", canBook, s.BookAppointment)
+	patient.POST("/appointments/next-available", canBook, s.BookFromQueue)
+	patient.POST("/triage", canBook, s.PostTriage)
+	patient.PUT("/appointments/:appointmentID", canBook, s.UpdateAppointment)
+	patient.DELETE("/appointments/:appointmentID", canBook, s.CancelAppointment)
+
+	authed.GET("/bootstrap", s.GetBootstrap)
+	authed.GET("/delegations", s.GetMyDelegations)
+	authed.POST("/logout", s.Logout)
+	authed.GET("/sessions", s.GetSessions)
+	authed.DELETE("/sessions", s.RevokeOtherSessions)
+	authed.DELETE("/sessions/:sessionID", s.RevokeSession)
+	authed.GET("/cancellation-reasons", s.GetCancellationReasons)
+	authed.GET("/triage/questionnaire", s.GetTriageQuestionnaire)
+
+	// Referring clinics book with their partner token, which opens nothing
+	// else
+	partner := routes.Group("/api/partner", s.RequirePartner())
+	partner.GET("/doctors", s.GetPartnerDoctors)
+	partner.GET("/doctors/:id/slots", RequirePartnerDoctor, s.GetDoctorSlots)
+	partner.GET("/referrals", s.GetReferrals)
+	partner.POST("/referrals", s.CreateReferral)
+
+	// Legacy SOAP adapter for the regional health authority; the integrator
+	// authenticates with an admin service account token
+	routes.GET("/soap/appointments", GetAppointmentsWSDL)
+	routes.POST("/soap/appointments", s.AuthRequired(), RequireRole(RoleAdmin), s.HandleAppointmentsSOAP)
+}
+
+func (s *Server) adminRoutes(routes *gin.Engine, prof *profiler) {
+	admin := routes.Group("/api/admin", s.AuthRequired(), RequireRole(RoleAdmin))
+	admin.GET("/diagnostics", prof.GetDiagnostics)
+	admin.GET("/audit", s.GetAuditLog)
+	admin.PUT("/clinical-templates/:id", s.PutClinicalTemplate)
+	admin.GET("/quality", s.GetQualityMeasures)
+	admin.GET("/quality/:measure", s.GetQualityTrend)
+	admin.PUT("/inventory/:id", s.PutInventoryItem)
+	admin.PUT("/cancellation-reasons/:code", s.PutCancellationReason)
+	admin.GET("/triage-rules", s.GetTriageRules)
+	admin.PUT("/triage-rules/:ruleID", s.PutTriageRule)
+	admin.GET("/hooks", s.GetHooks)
+	admin.PUT("/hooks/:hookID", s.PutHook)
+	admin.DELETE("/hooks/:hookID", s.DeleteHook)
+	admin.GET("/runbook", s.GetRunbook)
+	admin.POST("/runbook/:action", s.RequestRunbookAction)
+	admin.POST("/runbook/:action/confirm", s.ConfirmRunbookAction)
+	admin.DELETE("/users/:username/sessions", s.RevokeUserSessions)
+	admin.GET("/reporting-tokens", s.GetReportingTokens)
+	admin.POST("/reporting-tokens", s.CreateReportingToken)
+	admin.DELETE("/reporting-tokens/:tokenID", s.RevokeReportingToken)
+	admin.GET("/partners", s.GetPartners)
+	admin.POST("/partners", s.CreatePartner)
+	admin.DELETE("/partners/:partnerID", s.RevokePartner)
+	admin.GET("/attendance", s.GetStaffAttendance)
+	admin.GET("/captures", s.GetCaptures)
+	admin.POST("/captures", s.StartCapture)
+	admin.DELETE("/captures/:captureID", s.StopCapture)
+	admin.GET("/captures/:captureID/exchanges", s.GetCapturedExchanges)
+
+	// Reports take admin tokens and the read-only reporting tokens, which
+	// open nothing else
+	if s.db != nil {
+		reports := routes.Group("/api/admin/reports", s.RequireReportAccess())
+		reports.GET("/doctor-volume", s.GetDoctorVolumeReport)
+		reports.GET("/attendance", s.GetAttendanceReport)
+		reports.GET("/busiest-slots", s.GetBusiestSlotsReport)
+		reports.GET("/signups", s.GetSignupsReport)
+		reports.GET("/cancellations", s.GetCancellationsReport)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+
+	"containerized-go-app/notification"
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	jwtSecret = []byte("test-secret")
+	registerValidators()
+	os.Exit(m.Run())
+}
+
+// testServer is a Server on an in-memory store together with its router.
+type testServer struct {
+	*Server
+	handler http.Handler
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	srv := NewServer(store.NewMemory(), notification.New(1), nil)
+	return &testServer{Server: srv, handler: srv.Router(newProfiler(profilerWindow))}
+}
+
+// do sends a request with body encoded as JSON, authenticated as user when
+// user has a username.
+func (ts *testServer) do(t *testing.T, method, path string, user User, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	if user.Username != "" {
+		token, _, err := ts.startSession(context.Background(), user, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	ts.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// decode unmarshals a response body, failing the test on a wrong status.
+func decode[T any](t *testing.T, rec *httptest.ResponseRecorder, wantStatus int) T {
+	t.Helper()
+	if rec.Code != wantStatus {
+		t.Fatalf("status = %d, want %d; body %s", rec.Code, wantStatus, rec.Body)
+	}
+	var v T
+	if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+		t.Fatalf("decoding %s: %v", rec.Body, err)
+	}
+	return v
+}
+
+func TestSignUpAndLogin(t *testing.T) {
+	ts := newTestServer(t)
+	creds := gin.H{"username": "alice", "password": "secret123"}
+
+	signup := decode[struct {
+		ProfileID string `json:"profileId"`
+	}](t, ts.do(t, http.MethodPost, "/api/signup", User{}, creds), http.StatusOK)
+	if signup.ProfileID == "" {
+		t.Fatal("signup returned no profileId")
+	}
+	if _, err := ts.store.Patients.Get(context.Background(), signup.ProfileID); err != nil {
+		t.Fatalf("patient record for new account: %v", err)
+	}
+
+	dup := decode[apiError](t, ts.do(t, http.MethodPost, "/api/signup", User{}, creds), http.StatusConflict)
+	if dup.Code != "username_taken" {
+		t.Errorf("duplicate signup code = %q, want username_taken", dup.Code)
+	}
+
+	bad := ts.do(t, http.MethodPost, "/api/login", User{}, gin.H{"username": "alice", "password": "wrong-pass1"})
+	if bad.Code != http.StatusUnauthorized {
+		t.Errorf("login with wrong password: status = %d, want 401", bad.Code)
+	}
+
+	login := decode[struct {
+		Token     string `json:"token"`
+		Role      string `json:"role"`
+		ProfileID string `json:"profileId"`
+	}](t, ts.do(t, http.MethodPost, "/api/login", User{}, creds), http.StatusOK)
+	if login.Token == "" || login.Role != RolePatient || login.ProfileID != signup.ProfileID {
+		t.Errorf("login = %+v, want a patient token for profile %s", login, signup.ProfileID)
+	}
+}
+
+// racingUsers reports every username as free, like a signup racing
+// another for the same name.
+type racingUsers struct{ store.UserRepository }
+
+func (racingUsers) Exists(context.Context, string) (bool, error) { return false, nil }
+
+func TestSignUpTakenUsernameLeavesNoPatient(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+	creds := gin.H{"username": "alice", "password": "secret123"}
+	decode[struct{}](t, ts.do(t, http.MethodPost, "/api/signup", User{}, creds), http.StatusOK)
+
+	ts.store.Users = racingUsers{ts.store.Users}
+	if got := decode[apiError](t, ts.do(t, http.MethodPost, "/api/signup", User{}, creds), http.StatusConflict); got.Code != "username_taken" {
+		t.Errorf("code = %q, want username_taken", got.Code)
+	}
+	if _, total, err := ts.store.Patients.List(ctx, store.PatientFilter{}, store.Page{}); err != nil || total != 1 {
+		t.Errorf("patients = %d, %v; want only the first signup's", total, err)
+	}
+}
+
+func TestClientIPTrustsOnlyConfiguredProxies(t *testing.T) {
+	clientIP := func() string {
+		t.Helper()
+		ts := newTestServer(t)
+		routes := ts.engine(newProfiler(profilerWindow), false)
+		routes.GET("/ip", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+		req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	// httptest requests come from 192.0.2.1
+	if ip := clientIP(); ip != "192.0.2.1" {
+		t.Errorf("client IP without trusted proxies = %s, want the connection's 192.0.2.1", ip)
+	}
+	defer func(saved []string) { trustedProxies = saved }(trustedProxies)
+	var err error
+	if trustedProxies, err = parseTrustedProxies("10.0.0.1, 192.0.2.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	if ip := clientIP(); ip != "203.0.113.7" {
+		t.Errorf("client IP behind a trusted proxy = %s, want the forwarded 203.0.113.7", ip)
+	}
+	if _, err := parseTrustedProxies("10.0.0.1,proxy.internal"); err == nil {
+		t.Error("parseTrustedProxies accepted a hostname")
+	}
+}
+
+func TestSignUpValidation(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := decode[apiError](t, ts.do(t, http.MethodPost, "/api/signup", User{}, gin.H{"username": "bob", "password": "short"}), http.StatusBadRequest)
+	if len(resp.Details) != 1 || resp.Details[0].Field != "password" {
+		t.Errorf("details = %+v, want one password error", resp.Details)
+	}
+
+	// Only admins may create staff accounts
+	staff := gin.H{"username": "drwho", "password": "secret123", "role": RoleDoctor, "profileId": "d1"}
+	if rec := ts.do(t, http.MethodPost, "/api/signup", User{}, staff); rec.Code != http.StatusForbidden {
+		t.Errorf("anonymous doctor signup: status = %d, want 403", rec.Code)
+	}
+	admin := User{Username: "root", Role: RoleAdmin}
+	if rec := ts.do(t, http.MethodPost, "/api/signup", admin, staff); rec.Code != http.StatusOK {
+		t.Errorf("admin doctor signup: status = %d, want 200; body %s", rec.Code, rec.Body)
+	}
+}
+
+func TestDoctorPagesWithRepeatedNames(t *testing.T) {
+	ts := newTestServer(t)
+	for _, id := range []string{"d3", "d1", "d4", "d2"} {
+		if err := ts.store.Doctors.Create(context.Background(), Doctor{ID: id, DName: "Dr. Smith"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var seen []string
+	for page := 1; page <= 4; page++ {
+		path := fmt.Sprintf("/api/doctors?sort=name&limit=1&page=%d", page)
+		doctors := decode[[]Doctor](t, ts.do(t, http.MethodGet, path, User{}, nil), http.StatusOK)
+		if len(doctors) != 1 {
+			t.Fatalf("page %d = %+v, want one doctor", page, doctors)
+		}
+		seen = append(seen, doctors[0].ID)
+	}
+	if !slices.Equal(seen, []string{"d1", "d2", "d3", "d4"}) {
+		t.Errorf("pages = %v, want every doctor once, ties in ID order", seen)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Session = store.Session
+
+type sessionView struct {
+	Session
+	Current bool `json:"current"`
+}
+
+// GetSessions lists the current user's active sessions, oldest first.
+func (s *Server) GetSessions(c *gin.Context) {
+	user, _ := currentUser(c)
+	sessions, err := s.store.Sessions.Active(c.Request.Context(), user.Username, time.Now())
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving sessions")
+		return
+	}
+	views := make([]sessionView, len(sessions))
+	for i, session := range sessions {
+		views[i] = sessionView{Session: session, Current: session.ID == user.SessionID}
+	}
+	c.JSON(http.StatusOK, views)
+}
+
+// RevokeOtherSessions signs the current user out everywhere else, e.g.
+// after losing a phone.
+func (s *Server) RevokeOtherSessions(c *gin.Context) {
+	user, _ := currentUser(c)
+	revoked, err := s.store.Sessions.RevokeAll(c.Request.Context(), user.Username, user.SessionID, time.Now().UTC())
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error revoking sessions")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"revoked": revoked})
+}
+
+// RevokeSession signs out one of the current user's sessions, which may be
+// the current one.
+func (s *Server) RevokeSession(c *gin.Context) {
+	ctx := c.Request.Context()
+	user, _ := currentUser(c)
+	session, err := s.store.Sessions.Get(ctx, c.Param("sessionID"))
+	if errors.Is(err, store.ErrNotFound) || err == nil && session.Username != user.Username {
+		abortWithError(c, http.StatusNotFound, "Session not found")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving session")
+		return
+	}
+	if err := s.store.Sessions.Revoke(ctx, session.ID, time.Now().UTC()); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error revoking session")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Session signed out"})
+}
+
+// Logout ends the session of the token it is called with.
+func (s *Server) Logout(c *gin.Context) {
+	user, _ := currentUser(c)
+	if err := s.store.Sessions.Revoke(c.Request.Context(), user.SessionID, time.Now().UTC()); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error signing out")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Signed out"})
+}
+
+// RevokeUserSessions signs a user out everywhere.
+func (s *Server) RevokeUserSessions(c *gin.Context) {
+	revoked, err := s.store.Sessions.RevokeAll(c.Request.Context(), c.Param("username"), "", time.Now().UTC())
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error revoking sessions")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"revoked": revoked})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSessionLimitsAndRevocation(t *testing.T) {
+	ts := newTestServer(t)
+	alice := User{Username: "alice", Role: RolePatient, ProfileID: "p-alice"}
+	admin := User{Username: "root", Role: RoleAdmin}
+	defer func(max int) { maxSessionsPerUser = max }(maxSessionsPerUser)
+	maxSessionsPerUser = 2
+
+	signIn := func() string {
+		t.Helper()
+		token, _, err := ts.startSession(context.Background(), alice, "192.0.2.1", "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	send := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		ts.handler.ServeHTTP(rec, req)
+		return rec
+	}
+	signedOut := func(token string) bool {
+		t.Helper()
+		rec := send(http.MethodGet, "/api/delegations", token)
+		return rec.Code == http.StatusUnauthorized
+	}
+
+	// A third sign-in signs out the oldest session
+	first, second, third := signIn(), signIn(), signIn()
+	if !signedOut(first) || signedOut(second) || signedOut(third) {
+		t.Fatal("want only the first of three sessions signed out")
+	}
+	if resp := decode[apiError](t, send(http.MethodGet, "/api/delegations", first), http.StatusUnauthorized); resp.Code != "session_ended" {
+		t.Errorf("code = %q, want session_ended", resp.Code)
+	}
+	sessions := decode[[]sessionView](t, send(http.MethodGet, "/api/sessions", third), http.StatusOK)
+	if len(sessions) != 2 || sessions[0].Current || !sessions[1].Current {
+		t.Errorf("sessions = %+v, want two with the newest current", sessions)
+	}
+
+	// Signing out everywhere else keeps the current session
+	decode[struct{}](t, send(http.MethodDelete, "/api/sessions", third), http.StatusOK)
+	if !signedOut(second) || signedOut(third) {
+		t.Error("want only the other session signed out")
+	}
+
+	// Admins can sign a user out everywhere, and logging out ends the
+	// session it is called with
+	decode[struct{}](t, ts.do(t, http.MethodDelete, "/api/admin/users/alice/sessions", admin, nil), http.StatusOK)
+	if !signedOut(third) {
+		t.Error("session still active after an admin revoked them all")
+	}
+	fourth := signIn()
+	decode[struct{}](t, send(http.MethodPost, "/api/logout", fourth), http.StatusOK)
+	if !signedOut(fourth) {
+		t.Error("session still active after logging out")
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Doctors without a schedule template list the RFC3339 start time of every
+// bookable slot in their flat schedule; those slots last slotDuration.
+const slotDuration = 30 * time.Minute
+
+var (
+	errSlotUnavailable = errors.New("slot is not in the doctor's schedule")
+	errSlotTaken       = errors.New("slot is already booked")
+	errFollowUpOnly    = errors.New("slot is for follow-up visits only")
+)
+
+type Slot = store.Slot
+
+type slotList struct {
+	XMLName xml.Name `xml:"slots"`
+	Slots   []Slot   `xml:"slot"`
+}
+
+// parseSchedule parses a doctor's schedule into slot start times.
+func parseSchedule(schedule []string) ([]time.Time, error) {
+	starts := make([]time.Time, 0, len(schedule))
+	for _, entry := range schedule {
+		start, err := time.Parse(time.RFC3339, entry)
+		if err != nil {
+			return nil, err
+		}
+		starts = append(starts, start.UTC())
+	}
+	return starts, nil
+}
+
+// doctorSlots returns the doctor's slots starting in [from, to). They are
+// generated from the schedule template when the doctor has one and taken
+// from the flat schedule otherwise.
+func doctorSlots(doctor Doctor, from, to time.Time) ([]Slot, error) {
+	if doctor.Template != nil {
+		return generateSlots(*doctor.Template, from, to), nil
+	}
+
+	starts, err := parseSchedule(doctor.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	followUps, err := parseSchedule(doctor.FollowUpSlots)
+	if err != nil {
+		return nil, err
+	}
+	slots := []Slot{}
+	for _, start := range starts {
+		if !start.Before(from) && start.Before(to) {
+			followUp := slices.ContainsFunc(followUps, start.Equal)
+			slots = append(slots, Slot{StartTime: start, EndTime: start.Add(slotDuration), FollowUpOnly: followUp})
+		}
+	}
+	return slots, nil
+}
+
+// findSlot returns the doctor's slot starting exactly at start.
+func findSlot(doctor Doctor, start time.Time) (Slot, bool) {
+	slots, err := doctorSlots(doctor, start, start.Add(time.Nanosecond))
+	if err != nil || len(slots) == 0 {
+		return Slot{}, false
+	}
+	return slots[0], true
+}
+
+// claimSlot atomically reserves a doctor's slot for an appointment and
+// returns errSlotTaken when another booking got there first.
+func (s *Server) claimSlot(ctx context.Context, doctorID string, start time.Time, appointmentID string) error {
+	claim := store.SlotClaim{
+		ID:            store.SlotClaimID(doctorID, start),
+		DoctorID:      doctorID,
+		StartTime:     start.UTC(),
+		AppointmentID: appointmentID,
+	}
+	err := s.store.Slots.Claim(ctx, claim)
+	if errors.Is(err, store.ErrDuplicate) {
+		return errSlotTaken
+	}
+	if err == nil {
+		go s.refreshDayAvailability(doctorID, start)
+	}
+	return err
+}
+
+// releaseSlot frees a slot previously claimed by appointmentID.
+func (s *Server) releaseSlot(ctx context.Context, doctorID string, start time.Time, appointmentID string) error {
+	released, err := s.store.Slots.Release(ctx, store.SlotClaimID(doctorID, start), appointmentID)
+	if released {
+		go s.refreshDayAvailability(doctorID, start)
+	}
+	return err
+}
+
+// freeSlots returns the doctor's unclaimed slots starting in [from, to).
+func (s *Server) freeSlots(ctx context.Context, doctor Doctor, from, to time.Time) ([]Slot, error) {
+	slots, err := doctorSlots(doctor, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := s.store.Slots.Claimed(ctx, doctor.ID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	taken := make(map[string]bool, len(claims))
+	for _, claim := range claims {
+		taken[claim.ID] = true
+	}
+
+	free := []Slot{}
+	for _, slot := range slots {
+		if !taken[store.SlotClaimID(doctor.ID, slot.StartTime)] {
+			free = append(free, slot)
+		}
+	}
+	return free, nil
+}
+
+func (s *Server) GetDoctorAvailability(c *gin.Context) {
+	ctx := c.Request.Context()
+	doctorID := c.Param("id")
+
+	day, err := time.Parse("2006-01-02", c.Query("date"))
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, "date must be formatted as YYYY-MM-DD")
+		return
+	}
+
+	// The next two weeks are precomputed; later days, and days the cache
+	// can't answer within its sub-budget, are computed on demand
+	cacheCtx, cancel := withSubBudget(ctx, cacheBudget)
+	slots, cached, err := s.cachedDayAvailability(cacheCtx, doctorID, day)
+	cancel()
+	if err != nil {
+		log.Printf("Availability cache lookup for doctor %s failed: %v", doctorID, err)
+	}
+	if !cached || err != nil {
+		doctor, err := s.findDoctor(ctx, doctorID)
+		if err == errDoctorNotFound {
+			abortWithError(c, http.StatusNotFound, "Doctor not found")
+			return
+		} else if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "Error fetching doctor data")
+			return
+		}
+
+		slots, err = s.freeSlots(ctx, doctor, day, day.AddDate(0, 0, 1))
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "Error computing availability")
+			return
+		}
+	}
+
+	addSlotDates(slots)
+	render(c, http.StatusOK, slots, slotList{Slots: slots})
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The regional health authority's legacy system only speaks SOAP 1.1, so
+// appointment lookup and booking are exposed as a document/literal service
+// that translates into the same booking logic the REST handlers use.
+
+const (
+	soapEnvelopeNS   = "http://schemas.xmlsoap.org/soap/envelope/"
+	soapContentType  = "text/xml; charset=utf-8"
+	maxSOAPBodyBytes = 1 << 20
+)
+
+type soapRequest struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Body    struct {
+		GetAppointments *soapGetAppointmentsRequest `xml:"GetAppointmentsRequest"`
+		BookAppointment *soapBookAppointmentRequest `xml:"BookAppointmentRequest"`
+	} `xml:"Body"`
+}
+
+type soapGetAppointmentsRequest struct {
+	PatientID string `xml:"PatientID"`
+}
+
+type soapBookAppointmentRequest struct {
+	PatientID string    `xml:"PatientID"`
+	DoctorID  string    `xml:"DoctorID"`
+	StartTime time.Time `xml:"StartTime"`
+	EndTime   time.Time `xml:"EndTime"`
+	Notes     string    `xml:"Notes"`
+}
+
+type soapAppointment struct {
+	ID        string    `xml:"ID"`
+	DoctorID  string    `xml:"DoctorID"`
+	StartTime time.Time `xml:"StartTime"`
+	EndTime   time.Time `xml:"EndTime"`
+	Status    string    `xml:"Status"`
+	Notes     string    `xml:"Notes"`
+}
+
+type soapGetAppointmentsResponse struct {
+	XMLName      xml.Name          `xml:"urn:clinic:appointments GetAppointmentsResponse"`
+	Appointments []soapAppointment `xml:"Appointments>Appointment"`
+}
+
+type soapBookAppointmentResponse struct {
+	XMLName       xml.Name `xml:"urn:clinic:appointments BookAppointmentResponse"`
+	AppointmentID string   `xml:"AppointmentID"`
+	Message       string   `xml:"Message"`
+}
+
+type soapFault struct {
+	XMLName     xml.Name `xml:"soap:Fault"`
+	FaultCode   string   `xml:"faultcode"`
+	FaultString string   `xml:"faultstring"`
+}
+
+func GetAppointmentsWSDL(c *gin.Context) {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	location := fmt.Sprintf("%s://%s%s", scheme, c.Request.Host, c.Request.URL.Path)
+
+	c.Data(http.StatusOK, soapContentType, []byte(fmt.Sprintf(appointmentsWSDL, location)))
+}
+
+func (s *Server) HandleAppointmentsSOAP(c *gin.Context) {
+	ctx := c.Request.Context()
+	payload, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSOAPBodyBytes))
+	if err != nil {
+		writeSOAPFault(c, "soap:Client", "Error reading request")
+		return
+	}
+
+	var req soapRequest
+	if err := xml.Unmarshal(payload, &req); err != nil || req.XMLName.Space != soapEnvelopeNS {
+		writeSOAPFault(c, "soap:Client", "Invalid SOAP envelope")
+		return
+	}
+
+	switch {
+	case req.Body.GetAppointments != nil:
+		patientID := req.Body.GetAppointments.PatientID
+		if patientID == "" {
+			writeSOAPFault(c, "soap:Client", "PatientID is required")
+			return
+		}
+
+		if _, err := s.findPatient(ctx, patientID); err != nil {
+			writeSOAPFault(c, "soap:Client", "Patient not found")
+			return
+		}
+		appointments, err := s.appointmentHistory(ctx, store.AppointmentFilter{PatientID: patientID})
+		if err != nil {
+			writeSOAPFault(c, "soap:Server", "Error fetching appointments")
+			return
+		}
+
+		resp := soapGetAppointmentsResponse{Appointments: make([]soapAppointment, 0, len(appointments))}
+		for _, a := range appointments {
+			resp.Appointments = append(resp.Appointments, soapAppointment{
+				ID:        a.ID,
+				DoctorID:  a.DoctorID,
+				StartTime: a.StartTime,
+				EndTime:   a.EndTime,
+				Status:    a.Status,
+				Notes:     a.Notes,
+			})
+		}
+		writeSOAP(c, http.StatusOK, resp)
+
+	case req.Body.BookAppointment != nil:
+		booking := req.Body.BookAppointment
+		if booking.PatientID == "" || booking.DoctorID == "" || booking.StartTime.IsZero() {
+			writeSOAPFault(c, "soap:Client", "PatientID, DoctorID and StartTime are required")
+			return
+		}
+
+		appointment := Appointment{
+			PatientID: booking.PatientID,
+			DoctorID:  booking.DoctorID,
+			StartTime: booking.StartTime,
+			EndTime:   booking.EndTime,
+			Notes:     booking.Notes,
+		}
+		if _, err := s.createAppointment(ctx, &appointment); err != nil {
+			switch {
+			case errors.Is(err, errPatientNotFound):
+				writeSOAPFault(c, "soap:Client", "Patient not found")
+			case errors.Is(err, errDoctorNotFound):
+				writeSOAPFault(c, "soap:Client", "Doctor not found")
+			case errors.Is(err, errInvalidTimeRange):
+				writeSOAPFault(c, "soap:Client", "EndTime must be after StartTime")
+			case errors.Is(err, errSlotUnavailable):
+				writeSOAPFault(c, "soap:Client", "The doctor has no slot at that time")
+			case errors.Is(err, errSlotTaken):
+				writeSOAPFault(c, "soap:Client", "That slot has already been booked")
+			default:
+				writeSOAPFault(c, "soap:Server", "Error booking appointment")
+			}
+			return
+		}
+
+		writeSOAP(c, http.StatusOK, soapBookAppointmentResponse{
+			AppointmentID: appointment.ID,
+			Message:       "Appointment booked successfully",
+		})
+
+	default:
+		writeSOAPFault(c, "soap:Client", "Unsupported operation")
+	}
+}
+
+// writeSOAP wraps content in a SOAP 1.1 envelope and writes it.
+func writeSOAP(c *gin.Context, code int, content interface{}) {
+	body, err := xml.Marshal(content)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	envelope := xml.Header +
+		`<soap:Envelope xmlns:soap="` + soapEnvelopeNS + `"><soap:Body>` +
+		string(body) +
+		`</soap:Body></soap:Envelope>`
+	c.Data(code, soapContentType, []byte(envelope))
+}
+
+// writeSOAPFault writes a SOAP fault. The SOAP 1.1 HTTP binding sends every
+// fault with a 500 status; faultCode tells the caller whose fault it was.
+func writeSOAPFault(c *gin.Context, faultCode, message string) {
+	writeSOAP(c, http.StatusInternalServerError, soapFault{FaultCode: faultCode, FaultString: message})
+}
+
+// appointmentsWSDL describes the SOAP service; %s is the endpoint location.
+const appointmentsWSDL = xml.Header + `<definitions name="Appointments"
+    targetNamespace="urn:clinic:appointments"
+    xmlns="http://schemas.xmlsoap.org/wsdl/"
+    xmlns:soap="http://schemas.xmlsoap.org/wsdl/soap/"
+    xmlns:tns="urn:clinic:appointments"
+    xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <types>
+    <xsd:schema targetNamespace="urn:clinic:appointments" elementFormDefault="qualified">
+      <xsd:element name="GetAppointmentsRequest">
+        <xsd:complexType>
+          <xsd:sequence>
+            <xsd:element name="PatientID" type="xsd:string"/>
+          </xsd:sequence>
+        </xsd:complexType>
+      </xsd:element>
+      <xsd:element name="GetAppointmentsResponse">
+        <xsd:complexType>
+          <xsd:sequence>
+            <xsd:element name="Appointments">
+              <xsd:complexType>
+                <xsd:sequence>
+                  <xsd:element name="Appointment" type="tns:Appointment" minOccurs="0" maxOccurs="unbounded"/>
+                </xsd:sequence>
+              </xsd:complexType>
+            </xsd:element>
+          </xsd:sequence>
+        </xsd:complexType>
+      </xsd:element>
+      <xsd:complexType name="Appointment">
+        <xsd:sequence>
+          <xsd:element name="ID" type="xsd:string"/>
+          <xsd:element name="DoctorID" type="xsd:string"/>
+          <xsd:element name="StartTime" type="xsd:dateTime"/>
+          <xsd:element name="EndTime" type="xsd:dateTime"/>
+          <xsd:element name="Status" type="xsd:string"/>
+          <xsd:element name="Notes" type="xsd:string"/>
+        </xsd:sequence>
+      </xsd:complexType>
+      <xsd:element name="BookAppointmentRequest">
+        <xsd:complexType>
+          <xsd:sequence>
+            <xsd:element name="PatientID" type="xsd:string"/>
+            <xsd:element name="DoctorID" type="xsd:string"/>
+            <xsd:element name="StartTime" type="xsd:dateTime"/>
+            <xsd:element name="EndTime" type="xsd:dateTime" minOccurs="0"/>
+            <xsd:element name="Notes" type="xsd:string" minOccurs="0"/>
+          </xsd:sequence>
+        </xsd:complexType>
+      </xsd:element>
+      <xsd:element name="BookAppointmentResponse">
+        <xsd:complexType>
+          <xsd:sequence>
+            <xsd:element name="AppointmentID" type="xsd:string"/>
+            <xsd:element name="Message" type="xsd:string"/>
+          </xsd:sequence>
+        </xsd:complexType>
+      </xsd:element>
+    </xsd:schema>
+  </types>
+  <message name="GetAppointmentsInput">
+    <part name="parameters" element="tns:GetAppointmentsRequest"/>
+  </message>
+  <message name="GetAppointmentsOutput">
+    <part name="parameters" element="tns:GetAppointmentsResponse"/>
+  </message>
+  <message name="BookAppointmentInput">
+    <part name="parameters" element="tns:BookAppointmentRequest"/>
+  </message>
+  <message name="BookAppointmentOutput">
+    <part name="parameters" element="tns:BookAppointmentResponse"/>
+  </message>
+  <portType name="AppointmentsPortType">
+    <operation name="GetAppointments">
+      <input message="tns:GetAppointmentsInput"/>
+      <output message="tns:GetAppointmentsOutput"/>
+    </operation>
+    <operation name="BookAppointment">
+      <input message="tns:BookAppointmentInput"/>
+      <output message="tns:BookAppointmentOutput"/>
+    </operation>
+  </portType>
+  <binding name="AppointmentsBinding" type="tns:AppointmentsPortType">
+    <soap:binding style="document" transport="http://schemas.xmlsoap.org/soap/http"/>
+    <operation name="GetAppointments">
+      <soap:operation soapAction="urn:clinic:appointments#GetAppointments"/>
+      <input><soap:body use="literal"/></input>
+      <output><soap:body use="literal"/></output>
+    </operation>
+    <operation name="BookAppointment">
+      <soap:operation soapAction="urn:clinic:appointments#BookAppointment"/>
+      <input><soap:body use="literal"/></input>
+      <output><soap:body use="literal"/></output>
+    </operation>
+  </binding>
+  <service name="AppointmentsService">
+    <port name="AppointmentsPort" binding="tns:AppointmentsBinding">
+      <soap:address location="%s"/>
+    </port>
+  </service>
+</definitions>
+`
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type soapResponse struct {
+	Body struct {
+		GetAppointments struct {
+			Appointments []soapAppointment `xml:"Appointments>Appointment"`
+		} `xml:"GetAppointmentsResponse"`
+		BookAppointment soapBookAppointmentResponse `xml:"BookAppointmentResponse"`
+		Fault           struct {
+			FaultCode   string `xml:"faultcode"`
+			FaultString string `xml:"faultstring"`
+		} `xml:"Fault"`
+	} `xml:"Body"`
+}
+
+// soapCall posts operation wrapped in a SOAP envelope as user.
+func (ts *testServer) soapCall(t *testing.T, user User, operation string) (*httptest.ResponseRecorder, soapResponse) {
+	t.Helper()
+	envelope := `<soap:Envelope xmlns:soap="` + soapEnvelopeNS + `"><soap:Body>` + operation + `</soap:Body></soap:Envelope>`
+	req := httptest.NewRequest(http.MethodPost, "/soap/appointments", strings.NewReader(envelope))
+	req.Header.Set("Content-Type", soapContentType)
+	if user.Username != "" {
+		token, _, err := ts.startSession(req.Context(), user, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	ts.handler.ServeHTTP(rec, req)
+
+	var resp soapResponse
+	if strings.HasPrefix(rec.Header().Get("Content-Type"), "text/xml") {
+		if err := xml.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decoding %s: %v", rec.Body, err)
+		}
+	}
+	return rec, resp
+}
+
+func TestSOAPBookAndLookUpAppointments(t *testing.T) {
+	f := newBookingFixture(t)
+	admin := User{Username: "root", Role: RoleAdmin}
+
+	book := `<BookAppointmentRequest xmlns="urn:clinic:appointments"><PatientID>p-alice</PatientID><DoctorID>d1</DoctorID><StartTime>` +
+		f.first.Format(time.RFC3339) + `</StartTime><Notes>Referred by the authority</Notes></BookAppointmentRequest>`
+	rec, resp := f.soapCall(t, admin, book)
+	if rec.Code != http.StatusOK || resp.Body.BookAppointment.AppointmentID == "" {
+		t.Fatalf("book = %d %s, want an appointment ID", rec.Code, rec.Body)
+	}
+	booked := resp.Body.BookAppointment.AppointmentID
+
+	rec, resp = f.soapCall(t, admin, `<GetAppointmentsRequest xmlns="urn:clinic:appointments"><PatientID>p-alice</PatientID></GetAppointmentsRequest>`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("lookup = %d %s", rec.Code, rec.Body)
+	}
+	got := resp.Body.GetAppointments.Appointments
+	if len(got) != 1 || got[0].ID != booked || got[0].DoctorID != "d1" || !got[0].StartTime.Equal(f.first) || got[0].Notes != "Referred by the authority" {
+		t.Errorf("appointments = %+v, want the one just booked", got)
+	}
+
+	// The slot is now taken for REST and SOAP callers alike
+	if rec := f.book(t, f.bob, f.first); rec.Code != http.StatusConflict {
+		t.Errorf("REST booking of the taken slot = %d, want 409", rec.Code)
+	}
+	rec, resp = f.soapCall(t, admin, strings.Replace(book, "p-alice", "p-bob", 1))
+	if rec.Code != http.StatusInternalServerError || resp.Body.Fault.FaultCode != "soap:Client" || !strings.Contains(resp.Body.Fault.FaultString, "already been booked") {
+		t.Errorf("second booking = %d %+v, want a client fault for the taken slot", rec.Code, resp.Body.Fault)
+	}
+}
+
+func TestSOAPFaults(t *testing.T) {
+	f := newBookingFixture(t)
+	admin := User{Username: "root", Role: RoleAdmin}
+
+	for name, tc := range map[string]struct {
+		operation string
+		fault     string
+	}{
+		"missing patient":     {`<GetAppointmentsRequest xmlns="urn:clinic:appointments"/>`, "PatientID is required"},
+		"unknown patient":     {`<GetAppointmentsRequest xmlns="urn:clinic:appointments"><PatientID>nobody</PatientID></GetAppointmentsRequest>`, "Patient not found"},
+		"unknown doctor":      {`<BookAppointmentRequest xmlns="urn:clinic:appointments"><PatientID>p-alice</PatientID><DoctorID>d9</DoctorID><StartTime>` + f.first.Format(time.RFC3339) + `</StartTime></BookAppointmentRequest>`, "Doctor not found"},
+		"off the schedule":    {`<BookAppointmentRequest xmlns="urn:clinic:appointments"><PatientID>p-alice</PatientID><DoctorID>d1</DoctorID><StartTime>` + f.first.Add(time.Hour).Format(time.RFC3339) + `</StartTime></BookAppointmentRequest>`, "no slot at that time"},
+		"missing start":       {`<BookAppointmentRequest xmlns="urn:clinic:appointments"><PatientID>p-alice</PatientID><DoctorID>d1</DoctorID></BookAppointmentRequest>`, "are required"},
+		"unsupported request": {`<CancelAppointmentRequest xmlns="urn:clinic:appointments"/>`, "Unsupported operation"},
+	} {
+		rec, resp := f.soapCall(t, admin, tc.operation)
+		if rec.Code != http.StatusInternalServerError || resp.Body.Fault.FaultCode != "soap:Client" || !strings.Contains(resp.Body.Fault.FaultString, tc.fault) {
+			t.Errorf("%s: %d %+v, want a client fault containing %q", name, rec.Code, resp.Body.Fault, tc.fault)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/soap/appointments", strings.NewReader(`<Envelope><Body/></Envelope>`))
+	token, _, err := f.startSession(req.Context(), admin, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	f.handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "Invalid SOAP envelope") {
+		t.Errorf("envelope without the SOAP namespace = %d %s, want an invalid envelope fault", rec.Code, rec.Body)
+	}
+}
+
+func TestSOAPRequiresAdmin(t *testing.T) {
+	f := newBookingFixture(t)
+	lookup := `<GetAppointmentsRequest xmlns="urn:clinic:appointments"><PatientID>p-alice</PatientID></GetAppointmentsRequest>`
+	if rec, _ := f.soapCall(t, User{}, lookup); rec.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous status = %d, want 401", rec.Code)
+	}
+	if rec, _ := f.soapCall(t, f.alice, lookup); rec.Code != http.StatusForbidden {
+		t.Errorf("patient status = %d, want 403", rec.Code)
+	}
+}
+
+func TestAppointmentsWSDL(t *testing.T) {
+	ts := newTestServer(t)
+	rec := ts.do(t, http.MethodGet, "/soap/appointments", User{}, nil)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/xml") {
+		t.Fatalf("WSDL = %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var wsdl struct {
+		Service struct {
+			Port struct {
+				Address struct {
+					Location string `xml:"location,attr"`
+				} `xml:"address"`
+			} `xml:"port"`
+		} `xml:"service"`
+	}
+	if err := xml.Unmarshal(rec.Body.Bytes(), &wsdl); err != nil {
+		t.Fatal(err)
+	}
+	if want := "http://example.com/soap/appointments"; wsdl.Service.Port.Address.Location != want {
+		t.Errorf("endpoint location = %q, want %q", wsdl.Service.Port.Address.Location, want)
+	}
+}
@@ -0,0 +1,1395 @@
+package store
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewMemory returns an empty Store kept in memory, for tests. It is safe
+// for concurrent use and hands out copies, so callers can't change stored
+// records by accident.
+func NewMemory() *Store {
+	m := &memory{
+		users:        map[string]User{},
+		doctors:      map[string]Doctor{},
+		patients:     map[string]Patient{},
+		appointments: map[string]Appointment{},
+		archived:     map[string]Appointment{},
+		claims:       map[string]SlotClaim{},
+		availability: map[string]CachedAvailability{},
+		magicLinks:   map[string]MagicLink{},
+		templates:    map[string]ClinicalTemplate{},
+		visitNotes:   map[string]VisitNote{},
+		quality:      map[string]QualitySnapshot{},
+		inventory:    map[string]InventoryItem{},
+		segments:     map[string]Segment{},
+		reasons:      map[string]CancellationReason{},
+		reporting:    map[string]ReportingToken{},
+		closures:     map[string]AccountClosure{},
+		sessions:     map[string]Session{},
+		captures:     map[string]Capture{},
+		partners:     map[string]Partner{},
+		referrals:    map[string]int64{},
+		attendance:   map[string]Attendance{},
+		triageRules:  map[string]TriageRule{},
+		triage:       map[string]TriageAssessment{},
+		hooks:        map[string]Hook{},
+	}
+	return &Store{
+		Users:        memoryUsers{m},
+		Doctors:      memoryDoctors{m},
+		Patients:     memoryPatients{m},
+		Appointments: memoryAppointments{m},
+		Slots:        memorySlots{m},
+		Availability: memoryAvailability{m},
+		MagicLinks:   memoryMagicLinks{m},
+		Audit:        memoryAudit{m},
+		Delegations:  memoryDelegations{m},
+		Templates:    memoryTemplates{m},
+		VisitNotes:   memoryVisitNotes{m},
+		Quality:      memoryQuality{m},
+		Inventory:    memoryInventory{m},
+		Segments:     memorySegments{m},
+		Reasons:      memoryReasons{m},
+		Reporting:    memoryReporting{m},
+		Closures:     memoryClosures{m},
+		Sessions:     memorySessions{m},
+		Captures:     memoryCaptures{m},
+		Partners:     memoryPartners{m},
+		Attendance:   memoryAttendance{m},
+		TriageRules:  memoryTriageRules{m},
+		Triage:       memoryTriage{m},
+		Hooks:        memoryHooks{m},
+	}
+}
+
+// memory holds every record behind one lock; the repositories are views
+// onto it.
+type memory struct {
+	mu           sync.Mutex
+	users        map[string]User
+	doctors      map[string]Doctor
+	patients     map[string]Patient
+	appointments map[string]Appointment
+	archived     map[string]Appointment
+	claims       map[string]SlotClaim
+	availability map[string]CachedAvailability
+	magicLinks   map[string]MagicLink
+	audit        []AuditEntry
+	delegations  []Delegation
+	templates    map[string]ClinicalTemplate
+	visitNotes   map[string]VisitNote
+	quality      map[string]QualitySnapshot
+	inventory    map[string]InventoryItem
+	usage        []InventoryUsage
+	segments     map[string]Segment
+	reasons      map[string]CancellationReason
+	reporting    map[string]ReportingToken
+	closures     map[string]AccountClosure
+	sessions     map[string]Session
+	captures     map[string]Capture
+	exchanges    []CapturedExchange
+	partners     map[string]Partner
+	referrals    map[string]int64
+	attendance   map[string]Attendance
+	triageRules  map[string]TriageRule
+	triage       map[string]TriageAssessment
+	hooks        map[string]Hook
+}
+
+// paginate sorts matches with less, then by id when less ties, and returns
+// page of them along with their count. Matches collected from a map need
+// id, since their order changes from call to call; nil keeps ties in the
+// order of matches.
+func paginate[T any](matches []T, page Page, less func(a, b T) bool, id func(T) string) ([]T, int64) {
+	if less != nil || id != nil {
+		sort.SliceStable(matches, func(i, j int) bool {
+			a, b := matches[i], matches[j]
+			if page.Desc {
+				a, b = b, a
+			}
+			if less != nil && less(a, b) != less(b, a) {
+				return less(a, b)
+			}
+			return id != nil && id(a) < id(b)
+		})
+	}
+
+	total := int64(len(matches))
+	start := min(page.Skip, total)
+	end := total
+	if page.Limit > 0 {
+		end = min(start+page.Limit, total)
+	}
+	result := make([]T, 0, end-start)
+	return append(result, matches[start:end]...), total
+}
+
+func copyDoctor(d Doctor) Doctor {
+	d.Schedule = slices.Clone(d.Schedule)
+	d.FollowUpSlots = slices.Clone(d.FollowUpSlots)
+	if d.Template != nil {
+		template := *d.Template
+		template.Weekly = slices.Clone(template.Weekly)
+		template.Exceptions = slices.Clone(template.Exceptions)
+		d.Template = &template
+	}
+	if d.Draft != nil {
+		draft := *d.Draft
+		copied := copyDoctor(Doctor{Schedule: draft.Schedule, FollowUpSlots: draft.FollowUpSlots, Template: draft.Template})
+		draft.Schedule, draft.FollowUpSlots, draft.Template = copied.Schedule, copied.FollowUpSlots, copied.Template
+		d.Draft = &draft
+	}
+	return d
+}
+
+func copyPatient(p Patient) Patient {
+	p.Tags = slices.Clone(p.Tags)
+	p.Notes = slices.Clone(p.Notes)
+	p.Consents = slices.Clone(p.Consents)
+	p.EmergencyContacts = slices.Clone(p.EmergencyContacts)
+	return p
+}
+
+type memoryUsers struct{ m *memory }
+
+func (r memoryUsers) Create(_ context.Context, user User) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.users[user.Username]; ok {
+		return ErrDuplicate
+	}
+	r.m.users[user.Username] = user
+	return nil
+}
+
+func (r memoryUsers) FindByUsername(_ context.Context, username string) (User, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	user, ok := r.m.users[username]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (r memoryUsers) Exists(_ context.Context, username string) (bool, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	_, ok := r.m.users[username]
+	return ok, nil
+}
+
+func (r memoryUsers) FindByProfile(_ context.Context, role, profileID string) (User, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for _, user := range r.m.users {
+		if user.Role == role && user.ProfileID == profileID {
+			return user, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (r memoryUsers) Delete(_ context.Context, username string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.users[username]; !ok {
+		return ErrNotFound
+	}
+	delete(r.m.users, username)
+	return nil
+}
+
+type memoryDoctors struct{ m *memory }
+
+func (r memoryDoctors) Create(_ context.Context, doctor Doctor) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.doctors[doctor.ID]; ok {
+		return ErrDuplicate
+	}
+	r.m.doctors[doctor.ID] = copyDoctor(doctor)
+	return nil
+}
+
+func (r memoryDoctors) Get(_ context.Context, id string) (Doctor, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	doctor, ok := r.m.doctors[id]
+	if !ok {
+		return Doctor{}, ErrNotFound
+	}
+	return copyDoctor(doctor), nil
+}
+
+func (r memoryDoctors) Exists(_ context.Context, id string) (bool, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	_, ok := r.m.doctors[id]
+	return ok, nil
+}
+
+func (r memoryDoctors) List(_ context.Context, f DoctorFilter, page Page) ([]Doctor, int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	name := strings.ToLower(f.NameContains)
+	matches := []Doctor{}
+	for _, doctor := range r.m.doctors {
+		if !strings.Contains(strings.ToLower(doctor.DName), name) ||
+			(f.Specialization != "" && doctor.Specialization != f.Specialization) ||
+			(f.IDs != nil && !slices.Contains(f.IDs, doctor.ID)) {
+			continue
+		}
+		matches = append(matches, copyDoctor(doctor))
+	}
+
+	var less func(a, b Doctor) bool
+	switch page.Sort {
+	case "name":
+		less = func(a, b Doctor) bool { return a.DName < b.DName }
+	case "specialization":
+		less = func(a, b Doctor) bool { return a.Specialization < b.Specialization }
+	}
+	doctors, total := paginate(matches, page, less, func(d Doctor) string { return d.ID })
+	return doctors, total, nil
+}
+
+func (r memoryDoctors) All(_ context.Context) ([]Doctor, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	doctors := make([]Doctor, 0, len(r.m.doctors))
+	for _, doctor := range r.m.doctors {
+		doctors = append(doctors, copyDoctor(doctor))
+	}
+	return doctors, nil
+}
+
+func (r memoryDoctors) SetSchedule(_ context.Context, id string, schedule []string) error {
+	return r.update(id, func(d *Doctor) { d.Schedule = slices.Clone(schedule) })
+}
+
+func (r memoryDoctors) SetTemplate(_ context.Context, id string, template *ScheduleTemplate) error {
+	return r.update(id, func(d *Doctor) { d.Template = copyDoctor(Doctor{Template: template}).Template })
+}
+
+func (r memoryDoctors) SetFollowUpSlots(_ context.Context, id string, starts []string) error {
+	return r.update(id, func(d *Doctor) { d.FollowUpSlots = slices.Clone(starts) })
+}
+
+func (r memoryDoctors) SetDraft(_ context.Context, id string, draft *ScheduleDraft) error {
+	return r.update(id, func(d *Doctor) { d.Draft = copyDoctor(Doctor{Draft: draft}).Draft })
+}
+
+func (r memoryDoctors) PublishDraft(_ context.Context, id string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	doctor, ok := r.m.doctors[id]
+	if !ok || doctor.Draft == nil {
+		return ErrNotFound
+	}
+	r.m.doctors[id] = doctor.Published(*doctor.Draft)
+	return nil
+}
+
+func (r memoryDoctors) update(id string, change func(*Doctor)) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	doctor, ok := r.m.doctors[id]
+	if !ok {
+		return ErrNotFound
+	}
+	change(&doctor)
+	r.m.doctors[id] = doctor
+	return nil
+}
+
+type memoryPatients struct{ m *memory }
+
+func (r memoryPatients) Create(_ context.Context, patient Patient) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.patients[patient.ID]; ok {
+		return ErrDuplicate
+	}
+	r.m.patients[patient.ID] = copyPatient(patient)
+	return nil
+}
+
+func (r memoryPatients) Get(_ context.Context, id string) (Patient, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	patient, ok := r.m.patients[id]
+	if !ok {
+		return Patient{}, ErrNotFound
+	}
+	return copyPatient(patient), nil
+}
+
+func (r memoryPatients) List(_ context.Context, f PatientFilter, page Page) ([]Patient, int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	matches := []Patient{}
+	for _, patient := range r.m.patients {
+		hasAll := true
+		for _, tag := range f.Tags {
+			hasAll = hasAll && slices.Contains(patient.Tags, tag)
+		}
+		if hasAll {
+			matches = append(matches, copyPatient(patient))
+		}
+	}
+
+	var less func(a, b Patient) bool
+	if page.Sort == "name" {
+		less = func(a, b Patient) bool { return a.PName < b.PName }
+	}
+	patients, total := paginate(matches, page, less, func(p Patient) string { return p.ID })
+	return patients, total, nil
+}
+
+func (r memoryPatients) SetTags(_ context.Context, id string, tags []string) error {
+	return r.update(id, func(p *Patient) { p.Tags = slices.Clone(tags) })
+}
+
+func (r memoryPatients) AddNote(_ context.Context, id string, note PatientNote) error {
+	return r.update(id, func(p *Patient) { p.Notes = append(slices.Clone(p.Notes), note) })
+}
+
+func (r memoryPatients) SetProfile(_ context.Context, id string, profile PatientProfile) error {
+	return r.update(id, func(p *Patient) { p.PatientProfile = profile })
+}
+
+func (r memoryPatients) SetConsent(_ context.Context, id string, consent Consent) error {
+	return r.update(id, func(p *Patient) {
+		consents := slices.DeleteFunc(slices.Clone(p.Consents), func(c Consent) bool { return c.Purpose == consent.Purpose })
+		p.Consents = append(consents, consent)
+	})
+}
+
+func (r memoryPatients) SetEmergencyContacts(_ context.Context, id string, contacts []EmergencyContact) error {
+	return r.update(id, func(p *Patient) { p.EmergencyContacts = slices.Clone(contacts) })
+}
+
+func (r memoryPatients) Anonymize(_ context.Context, id string) error {
+	return r.update(id, func(p *Patient) {
+		p.PName, p.Notes, p.Consents, p.EmergencyContacts = "", []PatientNote{}, nil, nil
+		p.PatientProfile = PatientProfile{}
+	})
+}
+
+func (r memoryPatients) Delete(_ context.Context, id string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.patients[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.m.patients, id)
+	return nil
+}
+
+func (r memoryPatients) update(id string, change func(*Patient)) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	patient, ok := r.m.patients[id]
+	if !ok {
+		return ErrNotFound
+	}
+	change(&patient)
+	r.m.patients[id] = patient
+	return nil
+}
+
+type memoryAppointments struct{ m *memory }
+
+func (r memoryAppointments) Create(_ context.Context, appointment Appointment) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.appointments[appointment.ID]; ok {
+		return ErrDuplicate
+	}
+	r.m.appointments[appointment.ID] = appointment
+	return nil
+}
+
+func (r memoryAppointments) Get(_ context.Context, patientID, id string) (Appointment, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	appointment, ok := r.m.appointments[id]
+	if !ok || appointment.PatientID != patientID {
+		return Appointment{}, ErrNotFound
+	}
+	return appointment, nil
+}
+
+func (r memoryAppointments) Update(_ context.Context, a Appointment, resetReminder bool) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	existing, ok := r.m.appointments[a.ID]
+	if !ok || existing.PatientID != a.PatientID || existing.Status != AppointmentScheduled {
+		return ErrNotFound
+	}
+	existing.DoctorID = a.DoctorID
+	existing.StartTime = a.StartTime
+	existing.EndTime = a.EndTime
+	existing.Notes = a.Notes
+	existing.Status = a.Status
+	if a.CancellationReason != "" {
+		existing.CancellationReason = a.CancellationReason
+	}
+	if resetReminder {
+		existing.ReminderSentAt = nil
+	}
+	r.m.appointments[a.ID] = existing
+	return nil
+}
+
+func (r memoryAppointments) Cancel(_ context.Context, patientID, id, reason string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	appointment, ok := r.m.appointments[id]
+	if !ok || appointment.PatientID != patientID || appointment.Status != AppointmentScheduled {
+		return ErrNotFound
+	}
+	appointment.Status = AppointmentCancelled
+	appointment.CancellationReason = reason
+	r.m.appointments[id] = appointment
+	return nil
+}
+
+func (r memoryAppointments) List(_ context.Context, f AppointmentFilter, page Page) ([]Appointment, int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	matches := []Appointment{}
+	collect := func(appointments map[string]Appointment) {
+		for _, a := range appointments {
+			if (f.PatientID == "" || a.PatientID == f.PatientID) &&
+				(f.DoctorID == "" || a.DoctorID == f.DoctorID) &&
+				(f.PartnerID == "" || a.PartnerID == f.PartnerID) &&
+				(len(f.Statuses) == 0 || slices.Contains(f.Statuses, a.Status)) &&
+				(f.From.IsZero() || !a.StartTime.Before(f.From)) &&
+				(f.To.IsZero() || a.StartTime.Before(f.To)) {
+				matches = append(matches, a)
+			}
+		}
+	}
+	collect(r.m.appointments)
+	if f.IncludeArchived {
+		collect(r.m.archived)
+	}
+
+	appointments, total := paginate(matches, page, func(a, b Appointment) bool {
+		return a.StartTime.Before(b.StartTime)
+	}, func(a Appointment) string { return a.ID })
+	return appointments, total, nil
+}
+
+func (r memoryAppointments) DueReminders(_ context.Context, from, to time.Time) ([]Appointment, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	due := []Appointment{}
+	for _, a := range r.m.appointments {
+		if a.Status == AppointmentScheduled && a.StartTime.After(from) && !a.StartTime.After(to) && a.ReminderSentAt == nil {
+			due = append(due, a)
+		}
+	}
+	return due, nil
+}
+
+func (r memoryAppointments) MarkReminderSent(_ context.Context, id string, at time.Time) (bool, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	appointment, ok := r.m.appointments[id]
+	if !ok || appointment.ReminderSentAt != nil {
+		return false, nil
+	}
+	appointment.ReminderSentAt = &at
+	r.m.appointments[id] = appointment
+	return true, nil
+}
+
+func (r memoryAppointments) Archive(_ context.Context, cutoff time.Time) (int, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	moved := 0
+	for id, a := range r.m.appointments {
+		if a.EndTime.Before(cutoff) {
+			r.m.archived[id] = a
+			delete(r.m.appointments, id)
+			moved++
+		}
+	}
+	return moved, nil
+}
+
+type memorySlots struct{ m *memory }
+
+func (r memorySlots) Claim(_ context.Context, claim SlotClaim) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.claims[claim.ID]; ok {
+		return ErrDuplicate
+	}
+	r.m.claims[claim.ID] = claim
+	return nil
+}
+
+func (r memorySlots) Release(_ context.Context, id, appointmentID string) (bool, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	claim, ok := r.m.claims[id]
+	if !ok || claim.AppointmentID != appointmentID {
+		return false, nil
+	}
+	delete(r.m.claims, id)
+	return true, nil
+}
+
+func (r memorySlots) Claimed(_ context.Context, doctorID string, from, to time.Time) ([]SlotClaim, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	claims := []SlotClaim{}
+	for _, claim := range r.m.claims {
+		if claim.DoctorID == doctorID && !claim.StartTime.Before(from) && claim.StartTime.Before(to) {
+			claims = append(claims, claim)
+		}
+	}
+	return claims, nil
+}
+
+func (r memorySlots) DeleteBefore(_ context.Context, cutoff time.Time) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for id, claim := range r.m.claims {
+		if claim.StartTime.Before(cutoff) {
+			delete(r.m.claims, id)
+		}
+	}
+	return nil
+}
+
+type memoryAvailability struct{ m *memory }
+
+func (r memoryAvailability) Put(_ context.Context, day CachedAvailability) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if existing, ok := r.m.availability[day.ID]; ok && !existing.ComputedAt.Before(day.ComputedAt) {
+		return nil
+	}
+	day.Slots = slices.Clone(day.Slots)
+	r.m.availability[day.ID] = day
+	return nil
+}
+
+func (r memoryAvailability) Get(_ context.Context, doctorID, date string) (CachedAvailability, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	day, ok := r.m.availability[doctorID+"|"+date]
+	if !ok {
+		return CachedAvailability{}, ErrNotFound
+	}
+	day.Slots = slices.Clone(day.Slots)
+	return day, nil
+}
+
+func (r memoryAvailability) DeleteBefore(_ context.Context, doctorID, date string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for id, day := range r.m.availability {
+		if day.DoctorID == doctorID && day.Date < date {
+			delete(r.m.availability, id)
+		}
+	}
+	return nil
+}
+
+func (r memoryAvailability) DoctorsWithSlots(_ context.Context, date string) ([]string, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	ids := []string{}
+	for _, day := range r.m.availability {
+		if day.Date == date && len(day.Slots) > 0 {
+			ids = append(ids, day.DoctorID)
+		}
+	}
+	return ids, nil
+}
+
+type memoryMagicLinks struct{ m *memory }
+
+func (r memoryMagicLinks) Create(_ context.Context, link MagicLink) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.magicLinks[link.ID]; ok {
+		return ErrDuplicate
+	}
+	r.m.magicLinks[link.ID] = link
+	return nil
+}
+
+func (r memoryMagicLinks) Consume(_ context.Context, id string) (MagicLink, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	link, ok := r.m.magicLinks[id]
+	if !ok {
+		return MagicLink{}, ErrNotFound
+	}
+	delete(r.m.magicLinks, id)
+	return link, nil
+}
+
+type memoryAudit struct{ m *memory }
+
+func (r memoryAudit) Append(_ context.Context, entry AuditEntry) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	r.m.audit = append(r.m.audit, entry)
+	return nil
+}
+
+func (r memoryAudit) List(_ context.Context, f AuditFilter, page Page) ([]AuditEntry, int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	matches := []AuditEntry{}
+	for _, entry := range r.m.audit {
+		if (f.PatientID == "" || entry.PatientID == f.PatientID) && (f.Action == "" || entry.Action == f.Action) {
+			matches = append(matches, entry)
+		}
+	}
+
+	var less func(a, b AuditEntry) bool
+	if page.Sort == "at" {
+		less = func(a, b AuditEntry) bool { return a.At.Before(b.At) }
+	}
+	entries, total := paginate(matches, page, less, nil)
+	return entries, total, nil
+}
+
+type memoryDelegations struct{ m *memory }
+
+func (r memoryDelegations) Create(_ context.Context, delegation Delegation) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	delegation.Scopes = slices.Clone(delegation.Scopes)
+	r.m.delegations = append(r.m.delegations, delegation)
+	return nil
+}
+
+func (r memoryDelegations) ForPatient(_ context.Context, patientID string) ([]Delegation, error) {
+	return r.matching(func(d Delegation) bool { return d.PatientID == patientID }), nil
+}
+
+func (r memoryDelegations) ForDelegate(_ context.Context, username string) ([]Delegation, error) {
+	return r.matching(func(d Delegation) bool { return d.Delegate == username }), nil
+}
+
+func (r memoryDelegations) matching(match func(Delegation) bool) []Delegation {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	delegations := []Delegation{}
+	for _, d := range r.m.delegations {
+		if match(d) {
+			d.Scopes = slices.Clone(d.Scopes)
+			delegations = append(delegations, d)
+		}
+	}
+	return delegations
+}
+
+func (r memoryDelegations) Revoke(_ context.Context, patientID, id string, at time.Time) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for i, d := range r.m.delegations {
+		if d.ID == id && d.PatientID == patientID {
+			r.m.delegations[i].RevokedAt = &at
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func copyTemplate(t ClinicalTemplate) ClinicalTemplate {
+	t.Fields = slices.Clone(t.Fields)
+	for i := range t.Fields {
+		t.Fields[i].Options = slices.Clone(t.Fields[i].Options)
+	}
+	return t
+}
+
+type memoryTemplates struct{ m *memory }
+
+func (r memoryTemplates) Put(_ context.Context, template ClinicalTemplate) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	r.m.templates[template.ID] = copyTemplate(template)
+	return nil
+}
+
+func (r memoryTemplates) Get(_ context.Context, id string) (ClinicalTemplate, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	template, ok := r.m.templates[id]
+	if !ok {
+		return ClinicalTemplate{}, ErrNotFound
+	}
+	return copyTemplate(template), nil
+}
+
+func (r memoryTemplates) List(_ context.Context, specialization string) ([]ClinicalTemplate, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	templates := []ClinicalTemplate{}
+	for _, template := range r.m.templates {
+		if specialization == "" || template.Specialization == specialization {
+			templates = append(templates, copyTemplate(template))
+		}
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates, nil
+}
+
+type memoryVisitNotes struct{ m *memory }
+
+func (r memoryVisitNotes) Put(_ context.Context, note VisitNote) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	note.Values = maps.Clone(note.Values)
+	r.m.visitNotes[note.AppointmentID] = note
+	return nil
+}
+
+func (r memoryVisitNotes) Get(_ context.Context, appointmentID string) (VisitNote, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	note, ok := r.m.visitNotes[appointmentID]
+	if !ok {
+		return VisitNote{}, ErrNotFound
+	}
+	note.Values = maps.Clone(note.Values)
+	return note, nil
+}
+
+type memoryQuality struct{ m *memory }
+
+func (r memoryQuality) Put(_ context.Context, snapshot QualitySnapshot) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	snapshot.ID = snapshot.Measure + "|" + snapshot.Date
+	r.m.quality[snapshot.ID] = snapshot
+	return nil
+}
+
+func (r memoryQuality) List(_ context.Context, measure, from, to string) ([]QualitySnapshot, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	snapshots := []QualitySnapshot{}
+	for _, snapshot := range r.m.quality {
+		if snapshot.Measure == measure && snapshot.Date >= from && snapshot.Date <= to {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Date < snapshots[j].Date })
+	return snapshots, nil
+}
+
+type memoryInventory struct{ m *memory }
+
+func (r memoryInventory) Put(_ context.Context, item InventoryItem) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	r.m.inventory[item.ID] = item
+	return nil
+}
+
+func (r memoryInventory) Get(_ context.Context, id string) (InventoryItem, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	item, ok := r.m.inventory[id]
+	if !ok {
+		return InventoryItem{}, ErrNotFound
+	}
+	return item, nil
+}
+
+func (r memoryInventory) List(_ context.Context) ([]InventoryItem, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	items := make([]InventoryItem, 0, len(r.m.inventory))
+	for _, item := range r.m.inventory {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return items, nil
+}
+
+func (r memoryInventory) Consume(_ context.Context, usage InventoryUsage) (InventoryItem, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	item, ok := r.m.inventory[usage.ItemID]
+	if !ok {
+		return InventoryItem{}, ErrNotFound
+	}
+	if item.Stock < usage.Quantity {
+		return InventoryItem{}, ErrInsufficientStock
+	}
+	item.Stock -= usage.Quantity
+	item.UpdatedAt = usage.RecordedAt
+	r.m.inventory[item.ID] = item
+	r.m.usage = append(r.m.usage, usage)
+	return item, nil
+}
+
+func (r memoryInventory) Usage(_ context.Context, appointmentID string) ([]InventoryUsage, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	usage := []InventoryUsage{}
+	for _, u := range r.m.usage {
+		if u.AppointmentID == appointmentID {
+			usage = append(usage, u)
+		}
+	}
+	return usage, nil
+}
+
+func copySegment(s Segment) Segment {
+	s.Filter.Tags = slices.Clone(s.Filter.Tags)
+	return s
+}
+
+type memorySegments struct{ m *memory }
+
+func (r memorySegments) Put(_ context.Context, segment Segment) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	r.m.segments[segment.ID] = copySegment(segment)
+	return nil
+}
+
+func (r memorySegments) Get(_ context.Context, id string) (Segment, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	segment, ok := r.m.segments[id]
+	if !ok {
+		return Segment{}, ErrNotFound
+	}
+	return copySegment(segment), nil
+}
+
+func (r memorySegments) List(_ context.Context) ([]Segment, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	segments := make([]Segment, 0, len(r.m.segments))
+	for _, segment := range r.m.segments {
+		segments = append(segments, copySegment(segment))
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Name < segments[j].Name })
+	return segments, nil
+}
+
+func (r memorySegments) Delete(_ context.Context, id string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.segments[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.m.segments, id)
+	return nil
+}
+
+type memoryReasons struct{ m *memory }
+
+func (r memoryReasons) Put(_ context.Context, reason CancellationReason) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	r.m.reasons[reason.Code] = reason
+	return nil
+}
+
+func (r memoryReasons) List(_ context.Context) ([]CancellationReason, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	reasons := make([]CancellationReason, 0, len(r.m.reasons))
+	for _, reason := range r.m.reasons {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool { return reasons[i].Code < reasons[j].Code })
+	return reasons, nil
+}
+
+func copyReportingToken(t ReportingToken) ReportingToken {
+	t.DoctorIDs = slices.Clone(t.DoctorIDs)
+	return t
+}
+
+type memoryReporting struct{ m *memory }
+
+func (r memoryReporting) Create(_ context.Context, token ReportingToken) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.reporting[token.ID]; ok {
+		return ErrDuplicate
+	}
+	r.m.reporting[token.ID] = copyReportingToken(token)
+	return nil
+}
+
+func (r memoryReporting) Get(_ context.Context, id string) (ReportingToken, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	token, ok := r.m.reporting[id]
+	if !ok {
+		return ReportingToken{}, ErrNotFound
+	}
+	return copyReportingToken(token), nil
+}
+
+func (r memoryReporting) List(_ context.Context) ([]ReportingToken, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	tokens := make([]ReportingToken, 0, len(r.m.reporting))
+	for _, token := range r.m.reporting {
+		tokens = append(tokens, copyReportingToken(token))
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.After(tokens[j].CreatedAt) })
+	return tokens, nil
+}
+
+func (r memoryReporting) Revoke(_ context.Context, id string, at time.Time) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	token, ok := r.m.reporting[id]
+	if !ok {
+		return ErrNotFound
+	}
+	token.RevokedAt = &at
+	r.m.reporting[id] = token
+	return nil
+}
+
+func copyClosure(c AccountClosure) AccountClosure {
+	c.CancelledAppointments = slices.Clone(c.CancelledAppointments)
+	return c
+}
+
+type memoryClosures struct{ m *memory }
+
+func (r memoryClosures) Put(_ context.Context, closure AccountClosure) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	r.m.closures[closure.PatientID] = copyClosure(closure)
+	return nil
+}
+
+func (r memoryClosures) Get(_ context.Context, patientID string) (AccountClosure, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	closure, ok := r.m.closures[patientID]
+	if !ok {
+		return AccountClosure{}, ErrNotFound
+	}
+	return copyClosure(closure), nil
+}
+
+func (r memoryClosures) Delete(_ context.Context, patientID string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.closures[patientID]; !ok {
+		return ErrNotFound
+	}
+	delete(r.m.closures, patientID)
+	return nil
+}
+
+func (r memoryClosures) Closing(_ context.Context, t time.Time) ([]AccountClosure, error) {
+	return r.matching(func(c AccountClosure) bool { return c.ClosesAt != nil && c.ClosesAt.Before(t) && c.ClosedAt == nil }), nil
+}
+
+func (r memoryClosures) Closed(_ context.Context, t time.Time) ([]AccountClosure, error) {
+	return r.matching(func(c AccountClosure) bool { return c.ClosedAt != nil && c.ClosedAt.Before(t) && c.AnonymizedAt == nil }), nil
+}
+
+func (r memoryClosures) matching(match func(AccountClosure) bool) []AccountClosure {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	closures := []AccountClosure{}
+	for _, closure := range r.m.closures {
+		if match(closure) {
+			closures = append(closures, copyClosure(closure))
+		}
+	}
+	return closures
+}
+
+type memorySessions struct{ m *memory }
+
+func (r memorySessions) Create(_ context.Context, session Session) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.sessions[session.ID]; ok {
+		return ErrDuplicate
+	}
+	r.m.sessions[session.ID] = session
+	return nil
+}
+
+func (r memorySessions) Get(_ context.Context, id string) (Session, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	session, ok := r.m.sessions[id]
+	if !ok {
+		return Session{}, ErrNotFound
+	}
+	return session, nil
+}
+
+func (r memorySessions) Active(_ context.Context, username string, now time.Time) ([]Session, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	sessions := []Session{}
+	for _, session := range r.m.sessions {
+		if session.Username == username && session.Active(now) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.Before(sessions[j].CreatedAt) })
+	return sessions, nil
+}
+
+func (r memorySessions) Revoke(_ context.Context, id string, at time.Time) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	session, ok := r.m.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	session.RevokedAt = &at
+	r.m.sessions[id] = session
+	return nil
+}
+
+func (r memorySessions) RevokeAll(_ context.Context, username, exceptID string, at time.Time) (int, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	revoked := 0
+	for id, session := range r.m.sessions {
+		if session.Username != username || id == exceptID || !session.Active(at) {
+			continue
+		}
+		session.RevokedAt = &at
+		r.m.sessions[id] = session
+		revoked++
+	}
+	return revoked, nil
+}
+
+type memoryCaptures struct{ m *memory }
+
+func (r memoryCaptures) Create(_ context.Context, capture Capture) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.captures[capture.ID]; ok {
+		return ErrDuplicate
+	}
+	r.m.captures[capture.ID] = capture
+	return nil
+}
+
+func (r memoryCaptures) List(_ context.Context) ([]Capture, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	captures := make([]Capture, 0, len(r.m.captures))
+	for _, capture := range r.m.captures {
+		captures = append(captures, capture)
+	}
+	sort.Slice(captures, func(i, j int) bool { return captures[i].CreatedAt.After(captures[j].CreatedAt) })
+	return captures, nil
+}
+
+func (r memoryCaptures) Active(_ context.Context, now time.Time) ([]Capture, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	captures := []Capture{}
+	for _, capture := range r.m.captures {
+		if capture.Active(now) {
+			captures = append(captures, capture)
+		}
+	}
+	return captures, nil
+}
+
+func (r memoryCaptures) Stop(_ context.Context, id string, at time.Time) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	capture, ok := r.m.captures[id]
+	if !ok {
+		return ErrNotFound
+	}
+	capture.StoppedAt = &at
+	r.m.captures[id] = capture
+	return nil
+}
+
+func (r memoryCaptures) Record(_ context.Context, exchange CapturedExchange) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	exchange.Sealed = slices.Clone(exchange.Sealed)
+	r.m.exchanges = append(r.m.exchanges, exchange)
+	return nil
+}
+
+func (r memoryCaptures) Exchanges(_ context.Context, captureID string) ([]CapturedExchange, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	exchanges := []CapturedExchange{}
+	for _, exchange := range r.m.exchanges {
+		if exchange.CaptureID == captureID {
+			exchange.Sealed = slices.Clone(exchange.Sealed)
+			exchanges = append(exchanges, exchange)
+		}
+	}
+	return exchanges, nil
+}
+
+func copyPartner(p Partner) Partner {
+	p.DoctorIDs = slices.Clone(p.DoctorIDs)
+	return p
+}
+
+type memoryPartners struct{ m *memory }
+
+func (r memoryPartners) Create(_ context.Context, partner Partner) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.partners[partner.ID]; ok {
+		return ErrDuplicate
+	}
+	r.m.partners[partner.ID] = copyPartner(partner)
+	return nil
+}
+
+func (r memoryPartners) Get(_ context.Context, id string) (Partner, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	partner, ok := r.m.partners[id]
+	if !ok {
+		return Partner{}, ErrNotFound
+	}
+	return copyPartner(partner), nil
+}
+
+func (r memoryPartners) List(_ context.Context) ([]Partner, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	partners := make([]Partner, 0, len(r.m.partners))
+	for _, partner := range r.m.partners {
+		partners = append(partners, copyPartner(partner))
+	}
+	sort.Slice(partners, func(i, j int) bool { return partners[i].CreatedAt.After(partners[j].CreatedAt) })
+	return partners, nil
+}
+
+func (r memoryPartners) Revoke(_ context.Context, id string, at time.Time) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	partner, ok := r.m.partners[id]
+	if !ok {
+		return ErrNotFound
+	}
+	partner.RevokedAt = &at
+	r.m.partners[id] = partner
+	return nil
+}
+
+func (r memoryPartners) CountReferral(_ context.Context, partnerID, month string, quota int, booked int64) (bool, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	key := partnerID + ":" + month
+	count, ok := r.m.referrals[key]
+	if !ok {
+		count = booked
+	}
+	if count >= int64(quota) {
+		r.m.referrals[key] = count
+		return false, nil
+	}
+	r.m.referrals[key] = count + 1
+	return true, nil
+}
+
+func (r memoryPartners) UncountReferral(_ context.Context, partnerID, month string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	key := partnerID + ":" + month
+	if r.m.referrals[key] > 0 {
+		r.m.referrals[key]--
+	}
+	return nil
+}
+
+func copyAttendance(a Attendance) Attendance {
+	a.PushedAppointments = slices.Clone(a.PushedAppointments)
+	return a
+}
+
+type memoryAttendance struct{ m *memory }
+
+func (r memoryAttendance) Create(_ context.Context, attendance Attendance) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.attendance[attendance.ID]; ok {
+		return ErrDuplicate
+	}
+	r.m.attendance[attendance.ID] = copyAttendance(attendance)
+	return nil
+}
+
+func (r memoryAttendance) Get(_ context.Context, id string) (Attendance, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	attendance, ok := r.m.attendance[id]
+	if !ok {
+		return Attendance{}, ErrNotFound
+	}
+	return copyAttendance(attendance), nil
+}
+
+func (r memoryAttendance) ClockOut(_ context.Context, id string, at time.Time) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	attendance, ok := r.m.attendance[id]
+	if !ok {
+		return ErrNotFound
+	}
+	attendance.ClockedOutAt = &at
+	r.m.attendance[id] = attendance
+	return nil
+}
+
+func (r memoryAttendance) SetPushed(_ context.Context, id string, appointmentIDs []string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	attendance, ok := r.m.attendance[id]
+	if !ok {
+		return ErrNotFound
+	}
+	attendance.PushedAppointments = slices.Clone(appointmentIDs)
+	r.m.attendance[id] = attendance
+	return nil
+}
+
+func (r memoryAttendance) List(_ context.Context, username, fromDay, toDay string) ([]Attendance, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	days := []Attendance{}
+	for _, attendance := range r.m.attendance {
+		if (username == "" || attendance.Username == username) && attendance.Day >= fromDay && attendance.Day <= toDay {
+			days = append(days, copyAttendance(attendance))
+		}
+	}
+	sort.Slice(days, func(i, j int) bool {
+		if days[i].Day != days[j].Day {
+			return days[i].Day < days[j].Day
+		}
+		return days[i].Username < days[j].Username
+	})
+	return days, nil
+}
+
+func copyTriageRule(rule TriageRule) TriageRule {
+	rule.Symptoms = slices.Clone(rule.Symptoms)
+	return rule
+}
+
+type memoryTriageRules struct{ m *memory }
+
+func (r memoryTriageRules) Put(_ context.Context, rule TriageRule) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	r.m.triageRules[rule.ID] = copyTriageRule(rule)
+	return nil
+}
+
+func (r memoryTriageRules) List(_ context.Context) ([]TriageRule, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	rules := make([]TriageRule, 0, len(r.m.triageRules))
+	for _, rule := range r.m.triageRules {
+		rules = append(rules, copyTriageRule(rule))
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	return rules, nil
+}
+
+type memoryTriage struct{ m *memory }
+
+func (r memoryTriage) Create(_ context.Context, assessment TriageAssessment) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.triage[assessment.ID]; ok {
+		return ErrDuplicate
+	}
+	assessment.Symptoms = slices.Clone(assessment.Symptoms)
+	r.m.triage[assessment.ID] = assessment
+	return nil
+}
+
+func (r memoryTriage) Latest(_ context.Context, patientID string, now time.Time) (TriageAssessment, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	var latest TriageAssessment
+	found := false
+	for _, assessment := range r.m.triage {
+		if assessment.PatientID == patientID && assessment.ExpiresAt.After(now) && (!found || assessment.CreatedAt.After(latest.CreatedAt)) {
+			latest, found = assessment, true
+		}
+	}
+	if !found {
+		return TriageAssessment{}, ErrNotFound
+	}
+	latest.Symptoms = slices.Clone(latest.Symptoms)
+	return latest, nil
+}
+
+func copyHook(hook Hook) Hook {
+	hook.Actions = slices.Clone(hook.Actions)
+	return hook
+}
+
+type memoryHooks struct{ m *memory }
+
+func (r memoryHooks) Put(_ context.Context, hook Hook) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	r.m.hooks[hook.ID] = copyHook(hook)
+	return nil
+}
+
+func (r memoryHooks) Delete(_ context.Context, id string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.hooks[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.m.hooks, id)
+	return nil
+}
+
+func (r memoryHooks) List(_ context.Context) ([]Hook, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	hooks := make([]Hook, 0, len(r.m.hooks))
+	for _, hook := range r.m.hooks {
+		hooks = append(hooks, copyHook(hook))
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].ID < hooks[j].ID })
+	return hooks, nil
+}
@@ -0,0 +1,622 @@
+package store
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// User is both the stored account and the signup body; the binding tags
+// validate signups.
+type User struct {
+	Username string `json:"username" binding:"required,min=3,max=64,username"`
+	Password string `json:"password" binding:"required,password"`
+	Email    string `json:"email" binding:"omitempty,email"`
+	Role     string `json:"role" binding:"omitempty,oneof=patient doctor admin"`
+	// ProfileID is the patient or doctor record this account belongs to.
+	ProfileID string    `json:"profileId"`
+	CreatedAt time.Time `json:"-" bson:"createdat,omitempty"`
+}
+
+type Doctor struct {
+	XMLName xml.Name `json:"-" bson:"-" xml:"doctor"`
+	ID      string   `json:"id" bson:"id" xml:"id" binding:"required,notblank"`
+	DName   string   `json:"dname" bson:"dname" xml:"dname" binding:"required,notblank,max=200"`
+	// Specialization is free text, e.g. "cardiology".
+	Specialization string   `json:"specialization,omitempty" bson:"specialization,omitempty" xml:"specialization,omitempty"`
+	Schedule       []string `json:"schedule" bson:"schedule" xml:"schedule>slot" binding:"dive,rfc3339"`
+	// FollowUpSlots are the RFC3339 start times of flat schedule slots only
+	// the doctor's existing patients may book.
+	FollowUpSlots []string `json:"followUpSlots,omitempty" bson:"followUpSlots,omitempty" xml:"-" binding:"dive,rfc3339"`
+	// Template, when set, replaces Schedule as the source of slots.
+	Template *ScheduleTemplate `json:"scheduleTemplate,omitempty" bson:"scheduleTemplate,omitempty" xml:"-"`
+	// Draft holds schedule changes that aren't published yet; availability
+	// ignores it.
+	Draft *ScheduleDraft `json:"-" bson:"scheduleDraft,omitempty" xml:"-"`
+}
+
+// ScheduleDraft is an unpublished replacement for a doctor's slots.
+// Publishing it replaces Schedule, FollowUpSlots and Template.
+type ScheduleDraft struct {
+	Schedule      []string          `json:"schedule" bson:"schedule" binding:"dive,rfc3339"`
+	FollowUpSlots []string          `json:"followUpSlots,omitempty" bson:"followUpSlots,omitempty" binding:"dive,rfc3339"`
+	Template      *ScheduleTemplate `json:"scheduleTemplate,omitempty" bson:"scheduleTemplate,omitempty"`
+	UpdatedBy     string            `json:"updatedBy" bson:"updatedBy"`
+	UpdatedAt     time.Time         `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Published returns the doctor as they will be once the draft is published.
+func (d Doctor) Published(draft ScheduleDraft) Doctor {
+	d.Schedule = draft.Schedule
+	d.FollowUpSlots = draft.FollowUpSlots
+	d.Template = draft.Template
+	d.Draft = nil
+	return d
+}
+
+// ScheduleTemplate describes a doctor's recurring weekly availability.
+// Bookable slots are generated from it on demand.
+type ScheduleTemplate struct {
+	Weekly      []WeeklyRule `json:"weekly" bson:"weekly"`
+	SlotMinutes int          `json:"slotMinutes" bson:"slotMinutes"`
+	// Exceptions are YYYY-MM-DD dates, e.g. holidays, with no slots.
+	Exceptions []string `json:"exceptions" bson:"exceptions"`
+	// TimeZone is the IANA zone rule times are in; empty means UTC.
+	TimeZone string `json:"timeZone" bson:"timeZone"`
+}
+
+// WeeklyRule is a working window on one weekday, e.g. monday 09:00-17:00.
+type WeeklyRule struct {
+	Weekday string `json:"weekday" bson:"weekday"`
+	Start   string `json:"start" bson:"start"`
+	End     string `json:"end" bson:"end"`
+	// FollowUpOnly reserves the window's slots for the doctor's existing
+	// patients.
+	FollowUpOnly bool `json:"followUpOnly,omitempty" bson:"followUpOnly,omitempty"`
+	// SlotMinutes, when set, replaces the template's slot length in this
+	// window, e.g. for a weekly clinic of short reviews.
+	SlotMinutes int `json:"slotMinutes,omitempty" bson:"slotMinutes,omitempty"`
+}
+
+type Patient struct {
+	XMLName xml.Name      `json:"-" bson:"-" xml:"patient"`
+	ID      string        `json:"id" bson:"id" xml:"id"`
+	PName   string        `json:"pname" bson:"pname" xml:"pname"`
+	Tags    []string      `json:"tags" bson:"tags" xml:"tags>tag"`
+	Notes   []PatientNote `json:"notes" bson:"notes" xml:"notes>note"`
+	// Consents holds the latest decision for each purpose.
+	Consents          []Consent          `json:"consents" bson:"consents,omitempty" xml:"-"`
+	EmergencyContacts []EmergencyContact `json:"emergencyContacts" bson:"emergencyContacts,omitempty" xml:"-"`
+	PatientProfile    `bson:",inline"`
+}
+
+// ActiveConsents returns the purposes patient has consented to at t.
+func (p Patient) ActiveConsents(t time.Time) []string {
+	purposes := []string{}
+	for _, consent := range p.Consents {
+		if consent.ActiveAt(t) {
+			purposes = append(purposes, consent.Purpose)
+		}
+	}
+	return purposes
+}
+
+// EmergencyContact is someone to reach when something happens to a patient
+// during a visit.
+type EmergencyContact struct {
+	Name         string `json:"name" bson:"name" binding:"required,notblank,max=200"`
+	Relationship string `json:"relationship,omitempty" bson:"relationship,omitempty" binding:"max=100"`
+	Phone        string `json:"phone" bson:"phone" binding:"required,e164"`
+}
+
+// Purposes patient data may be used or shared for with consent.
+const (
+	ConsentResearch  = "research"
+	ConsentMarketing = "marketing"
+	ConsentInsurer   = "insurer"
+	// ConsentDiagnostics lets support record the patient's requests while
+	// debugging a problem they reported.
+	ConsentDiagnostics = "diagnostics"
+)
+
+// Consent is a patient's decision about one purpose. Revoking keeps the
+// record so it shows when consent was given and withdrawn.
+type Consent struct {
+	Purpose   string     `json:"purpose" bson:"purpose"`
+	GrantedAt time.Time  `json:"grantedAt" bson:"grantedAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+	// RecordedBy is the account that recorded the latest decision.
+	RecordedBy string `json:"recordedBy" bson:"recordedBy"`
+}
+
+// ActiveAt reports whether c was granted, and neither revoked nor expired,
+// at t.
+func (c Consent) ActiveAt(t time.Time) bool {
+	return c.RevokedAt == nil && !c.GrantedAt.After(t) && (c.ExpiresAt == nil || c.ExpiresAt.After(t))
+}
+
+// PatientProfile is the part of a patient record patients fill in over
+// time. Every field is optional, so patients can book before completing it.
+type PatientProfile struct {
+	// DateOfBirth is a YYYY-MM-DD date.
+	DateOfBirth     string `json:"dateOfBirth,omitempty" bson:"dateOfBirth,omitempty" xml:"dateOfBirth,omitempty"`
+	Phone           string `json:"phone,omitempty" bson:"phone,omitempty" xml:"phone,omitempty"`
+	Address         string `json:"address,omitempty" bson:"address,omitempty" xml:"address,omitempty"`
+	InsuranceNumber string `json:"insuranceNumber,omitempty" bson:"insuranceNumber,omitempty" xml:"insuranceNumber,omitempty"`
+}
+
+// PatientNote is an internal, staff-only note attached to a patient.
+type PatientNote struct {
+	Text      string    `json:"text" bson:"text" xml:"text" binding:"required,notblank,max=2000"`
+	Author    string    `json:"author" bson:"author" xml:"author"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt" xml:"createdAt"`
+}
+
+const (
+	AppointmentScheduled = "scheduled"
+	AppointmentCompleted = "completed"
+	AppointmentCancelled = "cancelled"
+	AppointmentNoShow    = "no-show"
+)
+
+type Appointment struct {
+	XMLName   xml.Name  `json:"-" bson:"-" xml:"appointment"`
+	ID        string    `json:"id" bson:"id" xml:"id"`
+	PatientID string    `json:"patientId" bson:"patientId" xml:"patientId"`
+	DoctorID  string    `json:"doctorId" bson:"doctorId" xml:"doctorId"`
+	StartTime time.Time `json:"startTime" bson:"startTime" xml:"startTime"`
+	EndTime   time.Time `json:"endTime" bson:"endTime" xml:"endTime"`
+	Status    string    `json:"status" bson:"status" xml:"status"`
+	Notes     string    `json:"notes" bson:"notes" xml:"notes"`
+	// CancellationReason is the code of the CancellationReason recorded
+	// when the appointment was cancelled.
+	CancellationReason string `json:"cancellationReason,omitempty" bson:"cancellationReason,omitempty" xml:"cancellationReason,omitempty"`
+	// Queue is the specialization whose queue assigned the doctor, for
+	// appointments booked without choosing one.
+	Queue string `json:"queue,omitempty" bson:"queue,omitempty" xml:"queue,omitempty"`
+	// PartnerID is the referral partner that booked the appointment.
+	PartnerID string `json:"partnerId,omitempty" bson:"partnerId,omitempty" xml:"partnerId,omitempty"`
+	// ReminderSentAt is set once the reminder for StartTime has been sent.
+	ReminderSentAt *time.Time `json:"-" bson:"reminderSentAt,omitempty" xml:"-"`
+	// SecondaryDate is the StartTime day in the clinic's secondary calendar.
+	SecondaryDate *CalendarDate `json:"secondaryDate,omitempty" bson:"-" xml:"secondaryDate,omitempty"`
+}
+
+type Slot struct {
+	XMLName   xml.Name  `json:"-" bson:"-" xml:"slot"`
+	StartTime time.Time `json:"startTime" bson:"startTime" xml:"startTime"`
+	EndTime   time.Time `json:"endTime" bson:"endTime" xml:"endTime"`
+	// FollowUpOnly slots can only be booked by patients the doctor has
+	// already seen.
+	FollowUpOnly bool `json:"followUpOnly,omitempty" bson:"followUpOnly,omitempty" xml:"followUpOnly,omitempty"`
+	// SecondaryDate is the StartTime day in the clinic's secondary calendar.
+	SecondaryDate *CalendarDate `json:"secondaryDate,omitempty" bson:"-" xml:"secondaryDate,omitempty"`
+}
+
+// CalendarDate is a day in a calendar other than the Gregorian one. It is
+// only computed for responses, never stored.
+type CalendarDate struct {
+	Calendar string `json:"calendar" xml:"calendar"`
+	// Date is YYYY-MM-DD in Calendar.
+	Date    string `json:"date" xml:"date"`
+	Display string `json:"display" xml:"display"`
+}
+
+// SlotClaim marks a doctor's slot as taken. Its ID is derived from the
+// doctor and start time, so only one of several concurrent bookings for
+// the same slot can store it.
+type SlotClaim struct {
+	ID            string    `bson:"_id"`
+	DoctorID      string    `bson:"doctorId"`
+	StartTime     time.Time `bson:"startTime"`
+	AppointmentID string    `bson:"appointmentId"`
+}
+
+// SlotClaimID is the ID of the claim on the doctor's slot starting at start.
+func SlotClaimID(doctorID string, start time.Time) string {
+	return doctorID + "|" + start.UTC().Format(time.RFC3339)
+}
+
+// CachedAvailability is one doctor's free slots for one UTC day.
+type CachedAvailability struct {
+	ID         string    `bson:"_id"`
+	DoctorID   string    `bson:"doctorId"`
+	Date       string    `bson:"date"`
+	Slots      []Slot    `bson:"slots"`
+	ComputedAt time.Time `bson:"computedAt"`
+}
+
+// MagicLink is an outstanding password-less sign-in link. It is deleted when
+// used, so each link works once.
+type MagicLink struct {
+	ID       string `bson:"_id"`
+	Username string `bson:"username"`
+	// DeviceHash is the hash of the device the link was requested from.
+	DeviceHash string    `bson:"deviceHash"`
+	ExpiresAt  time.Time `bson:"expiresAt"`
+}
+
+// AuditEntry records a sensitive action and who took it.
+type AuditEntry struct {
+	ID        string    `json:"id" bson:"_id"`
+	At        time.Time `json:"at" bson:"at"`
+	Actor     string    `json:"actor" bson:"actor"`
+	ActorRole string    `json:"actorRole" bson:"actorRole"`
+	// Action is a dotted name, e.g. "emergency_contact.notified".
+	Action    string `json:"action" bson:"action"`
+	PatientID string `json:"patientId,omitempty" bson:"patientId,omitempty"`
+	Detail    string `json:"detail,omitempty" bson:"detail,omitempty"`
+}
+
+// Scopes a patient can delegate to another account. Booking includes
+// viewing.
+const (
+	DelegationView = "view"
+	DelegationBook = "book"
+)
+
+// Delegation lets another account, e.g. a guardian, act for a patient
+// within Scopes until it expires or is revoked.
+type Delegation struct {
+	ID        string `json:"id" bson:"_id"`
+	PatientID string `json:"patientId" bson:"patientId"`
+	// Delegate is the username of the account given access.
+	Delegate  string     `json:"delegate" bson:"delegate"`
+	Scopes    []string   `json:"scopes" bson:"scopes"`
+	GrantedAt time.Time  `json:"grantedAt" bson:"grantedAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+	// Guardian marks the delegate as the patient's parent or guardian; while
+	// the patient is a minor, their notifications go to the guardian.
+	Guardian bool `json:"guardian,omitempty" bson:"guardian,omitempty"`
+}
+
+// ActiveAt reports whether d is neither revoked nor expired at t.
+func (d Delegation) ActiveAt(t time.Time) bool {
+	return d.RevokedAt == nil && (d.ExpiresAt == nil || d.ExpiresAt.After(t))
+}
+
+// Allows reports whether d grants scope at t.
+func (d Delegation) Allows(scope string, t time.Time) bool {
+	if !d.ActiveAt(t) {
+		return false
+	}
+	for _, granted := range d.Scopes {
+		if granted == scope || (granted == DelegationBook && scope == DelegationView) {
+			return true
+		}
+	}
+	return false
+}
+
+// Field types of a ClinicalTemplate.
+const (
+	FieldText    = "text"
+	FieldNumber  = "number"
+	FieldBoolean = "boolean"
+	FieldChoice  = "choice"
+)
+
+// ClinicalTemplate is the structure of the visit notes of one specialty.
+type ClinicalTemplate struct {
+	ID             string `json:"id" bson:"_id"`
+	Specialization string `json:"specialization" bson:"specialization" binding:"required,notblank"`
+	Name           string `json:"name" bson:"name" binding:"required,notblank,max=200"`
+	// Version goes up on every change, so notes can tell which fields they
+	// were written against.
+	Version int             `json:"version" bson:"version"`
+	Fields  []TemplateField `json:"fields" bson:"fields" binding:"required,min=1,max=100,dive"`
+}
+
+type TemplateField struct {
+	// Key names the field in VisitNote.Values.
+	Key      string `json:"key" bson:"key" binding:"required,notblank,max=64"`
+	Label    string `json:"label" bson:"label" binding:"required,notblank,max=200"`
+	Type     string `json:"type" bson:"type" binding:"required,oneof=text number boolean choice"`
+	Required bool   `json:"required" bson:"required"`
+	// Options are the allowed values of a choice field.
+	Options []string `json:"options,omitempty" bson:"options,omitempty"`
+}
+
+// VisitNote is the documentation of one appointment, filled in from a
+// ClinicalTemplate.
+type VisitNote struct {
+	AppointmentID   string `json:"appointmentId" bson:"_id"`
+	PatientID       string `json:"patientId" bson:"patientId"`
+	DoctorID        string `json:"doctorId" bson:"doctorId"`
+	TemplateID      string `json:"templateId" bson:"templateId"`
+	TemplateVersion int    `json:"templateVersion" bson:"templateVersion"`
+	Specialization  string `json:"specialization" bson:"specialization"`
+	// Values holds a string, number or boolean per template field key.
+	Values    map[string]interface{} `json:"values" bson:"values"`
+	Text      string                 `json:"text" bson:"text"`
+	UpdatedAt time.Time              `json:"updatedAt" bson:"updatedAt"`
+}
+
+// QualitySnapshot is the value of one quality measure on one day.
+type QualitySnapshot struct {
+	ID      string `json:"-" bson:"_id"`
+	Measure string `json:"measure" bson:"measure"`
+	// Date is the YYYY-MM-DD day the snapshot was taken.
+	Date        string    `json:"date" bson:"date"`
+	Numerator   int       `json:"numerator" bson:"numerator"`
+	Denominator int       `json:"denominator" bson:"denominator"`
+	Rate        float64   `json:"rate" bson:"rate"`
+	ComputedAt  time.Time `json:"computedAt" bson:"computedAt"`
+}
+
+// InventoryItem is a consumable the clinic keeps in stock.
+type InventoryItem struct {
+	ID   string `json:"id" bson:"_id"`
+	Name string `json:"name" bson:"name" binding:"required,notblank,max=200"`
+	// Unit is what Stock counts, e.g. "box" or "pair".
+	Unit  string `json:"unit" bson:"unit" binding:"max=32"`
+	Stock int    `json:"stock" bson:"stock" binding:"min=0"`
+	// ReorderLevel is the stock at or below which staff are alerted.
+	ReorderLevel int       `json:"reorderLevel" bson:"reorderLevel" binding:"min=0"`
+	UpdatedAt    time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Low reports whether the item is at or below its reorder level.
+func (i InventoryItem) Low() bool {
+	return i.Stock <= i.ReorderLevel
+}
+
+// InventoryUsage records an item consumed during an appointment.
+type InventoryUsage struct {
+	ID            string    `json:"id" bson:"_id"`
+	ItemID        string    `json:"itemId" bson:"itemId"`
+	AppointmentID string    `json:"appointmentId" bson:"appointmentId"`
+	Quantity      int       `json:"quantity" bson:"quantity"`
+	RecordedBy    string    `json:"recordedBy" bson:"recordedBy"`
+	RecordedAt    time.Time `json:"recordedAt" bson:"recordedAt"`
+}
+
+// Segment is a saved patient cohort. Only its filter is stored; the
+// matching patients are found whenever it is used.
+type Segment struct {
+	ID        string        `json:"id" bson:"_id"`
+	Name      string        `json:"name" bson:"name" binding:"required,notblank,max=200"`
+	Filter    SegmentFilter `json:"filter" bson:"filter"`
+	UpdatedBy string        `json:"updatedBy" bson:"updatedBy"`
+	UpdatedAt time.Time     `json:"updatedAt" bson:"updatedAt"`
+}
+
+// SegmentFilter narrows the patients of a Segment; zero fields match
+// everyone.
+type SegmentFilter struct {
+	// Tags matches patients carrying all of them.
+	Tags []string `json:"tags,omitempty" bson:"tags,omitempty" binding:"max=20"`
+	// MinAge and MaxAge are inclusive ages in years; patients without a
+	// date of birth never match them.
+	MinAge *int `json:"minAge,omitempty" bson:"minAge,omitempty" binding:"omitempty,min=0,max=150"`
+	MaxAge *int `json:"maxAge,omitempty" bson:"maxAge,omitempty" binding:"omitempty,min=0,max=150"`
+	// VisitedWithinDays matches patients with a completed appointment in
+	// the last that many days, and NotVisitedWithinDays those without one.
+	VisitedWithinDays    int `json:"visitedWithinDays,omitempty" bson:"visitedWithinDays,omitempty" binding:"min=0,max=3650"`
+	NotVisitedWithinDays int `json:"notVisitedWithinDays,omitempty" bson:"notVisitedWithinDays,omitempty" binding:"min=0,max=3650"`
+	// Specialization, when set, limits visit history to doctors of that
+	// specialization.
+	Specialization string `json:"specialization,omitempty" bson:"specialization,omitempty"`
+}
+
+// Who a cancellation was initiated by, for analytics.
+const (
+	InitiatedByPatient  = "patient"
+	InitiatedByClinic   = "clinic"
+	InitiatedByExternal = "external"
+)
+
+// CancellationReason is an entry of the catalog cancellations are coded
+// against.
+type CancellationReason struct {
+	Code        string `json:"code" bson:"_id"`
+	Label       string `json:"label" bson:"label" binding:"required,notblank,max=200"`
+	InitiatedBy string `json:"initiatedBy" bson:"initiatedBy" binding:"required,oneof=patient clinic external"`
+	// Retired reasons stay on past appointments but can't be chosen.
+	Retired bool `json:"retired" bson:"retired"`
+}
+
+// Triage urgencies, from least to most urgent. Emergency outcomes turn the
+// patient away from online booking.
+const (
+	UrgencyRoutine   = "routine"
+	UrgencySoon      = "soon"
+	UrgencyUrgent    = "urgent"
+	UrgencyEmergency = "emergency"
+)
+
+// TriageRule routes the patients whose pre-screening answers match it.
+// Rules are tried by Priority, lowest first, and the first match decides.
+type TriageRule struct {
+	ID       string `json:"id" bson:"_id"`
+	Priority int    `json:"priority" bson:"priority" binding:"min=0,max=10000"`
+	// Symptoms matches answers reporting any of them; empty matches all.
+	Symptoms []string `json:"symptoms" bson:"symptoms" binding:"max=50,dive,notblank,max=100"`
+	// MinSeverity matches answers at least this severe on the 0-10 scale.
+	MinSeverity    int    `json:"minSeverity" bson:"minSeverity" binding:"min=0,max=10"`
+	Urgency        string `json:"urgency" bson:"urgency" binding:"required,oneof=routine soon urgent emergency"`
+	Specialization string `json:"specialization,omitempty" bson:"specialization,omitempty" binding:"max=200"`
+	// Message is shown to the patient, e.g. where to get emergency care.
+	Message string `json:"message,omitempty" bson:"message,omitempty" binding:"max=2000"`
+	// Disabled rules are skipped, which is how the defaults are turned off.
+	Disabled  bool      `json:"disabled" bson:"disabled"`
+	UpdatedBy string    `json:"updatedBy,omitempty" bson:"updatedBy,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty" bson:"updatedAt,omitempty"`
+}
+
+// TriageAssessment is the outcome of one pre-screening, kept until it
+// expires so booking can honour it.
+type TriageAssessment struct {
+	ID        string   `json:"id" bson:"_id"`
+	PatientID string   `json:"patientId" bson:"patientId"`
+	Symptoms  []string `json:"symptoms" bson:"symptoms"`
+	Severity  int      `json:"severity" bson:"severity"`
+	// RuleID is the rule that matched, empty when none did.
+	RuleID         string    `json:"ruleId,omitempty" bson:"ruleId,omitempty"`
+	Urgency        string    `json:"urgency" bson:"urgency"`
+	Specialization string    `json:"specialization,omitempty" bson:"specialization,omitempty"`
+	Message        string    `json:"message,omitempty" bson:"message,omitempty"`
+	CreatedBy      string    `json:"createdBy" bson:"createdBy"`
+	CreatedAt      time.Time `json:"createdAt" bson:"createdAt"`
+	ExpiresAt      time.Time `json:"expiresAt" bson:"expiresAt"`
+}
+
+// Hook is a small clinic-specific automation: when Event happens and
+// Condition holds, the Actions run.
+type Hook struct {
+	ID    string `json:"id" bson:"_id"`
+	Event string `json:"event" bson:"event" binding:"required,oneof=appointment.created"`
+	// Condition is an expression over the event, e.g.
+	// `patient.visits >= 10 && !contains(patient.tags, "vip")`; empty
+	// always holds.
+	Condition string       `json:"condition" bson:"condition" binding:"max=1000"`
+	Actions   []HookAction `json:"actions" bson:"actions" binding:"required,min=1,max=10,dive"`
+	Disabled  bool         `json:"disabled" bson:"disabled"`
+	UpdatedBy string       `json:"updatedBy,omitempty" bson:"updatedBy,omitempty"`
+	UpdatedAt time.Time    `json:"updatedAt,omitempty" bson:"updatedAt,omitempty"`
+}
+
+// HookAction is one thing a Hook does to the event's patient: add a tag,
+// or add a note for staff to follow up on.
+type HookAction struct {
+	Type  string `json:"type" bson:"type" binding:"required,oneof=tag note"`
+	Value string `json:"value" bson:"value" binding:"required,notblank,max=2000"`
+}
+
+// ReportingToken records an analytics token issued to an external tool. The
+// token itself is only shown once; this is what it may pull.
+type ReportingToken struct {
+	ID   string `json:"id" bson:"_id"`
+	Name string `json:"name" bson:"name"`
+	// DoctorIDs limits reports to these doctors' appointments; empty means
+	// the whole clinic.
+	DoctorIDs []string   `json:"doctorIds" bson:"doctorIds"`
+	CreatedBy string     `json:"createdBy" bson:"createdBy"`
+	CreatedAt time.Time  `json:"createdAt" bson:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt" bson:"expiresAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+}
+
+// Active reports whether the token can still be used at now.
+func (t ReportingToken) Active(now time.Time) bool {
+	return t.RevokedAt == nil && now.Before(t.ExpiresAt)
+}
+
+// AccountClosure tracks a patient closing their account. Once confirmed,
+// the login stays usable until ClosesAt so the patient can change their
+// mind; the patient record is anonymized after the retention window.
+type AccountClosure struct {
+	PatientID   string    `json:"patientId" bson:"_id"`
+	Username    string    `json:"username" bson:"username"`
+	RequestedAt time.Time `json:"requestedAt" bson:"requestedAt"`
+	// ConfirmHash is the SHA-256 of the emailed confirmation token, which
+	// works until ConfirmBy.
+	ConfirmHash string     `json:"-" bson:"confirmHash"`
+	ConfirmBy   time.Time  `json:"confirmBy" bson:"confirmBy"`
+	ConfirmedAt *time.Time `json:"confirmedAt,omitempty" bson:"confirmedAt,omitempty"`
+	ClosesAt    *time.Time `json:"closesAt,omitempty" bson:"closesAt,omitempty"`
+	ClosedAt    *time.Time `json:"closedAt,omitempty" bson:"closedAt,omitempty"`
+	// CancelledAppointments are the future appointments cancelled on
+	// confirmation.
+	CancelledAppointments []string   `json:"cancelledAppointments,omitempty" bson:"cancelledAppointments,omitempty"`
+	AnonymizedAt          *time.Time `json:"anonymizedAt,omitempty" bson:"anonymizedAt,omitempty"`
+}
+
+// Session is one sign-in. Bearer tokens name their session, so revoking it
+// signs the token out before it expires.
+type Session struct {
+	ID        string     `json:"id" bson:"_id"`
+	Username  string     `json:"-" bson:"username"`
+	CreatedAt time.Time  `json:"createdAt" bson:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt" bson:"expiresAt"`
+	ClientIP  string     `json:"clientIp,omitempty" bson:"clientIp,omitempty"`
+	UserAgent string     `json:"userAgent,omitempty" bson:"userAgent,omitempty"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+}
+
+// Active reports whether the session can still be used at now.
+func (s Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// Capture records the traffic of one user or one route for a limited time,
+// to debug failures that can't be reproduced otherwise.
+type Capture struct {
+	ID string `json:"id" bson:"_id"`
+	// Username and Route (a pattern such as
+	// /api/patients/:id/appointments) narrow what is recorded; a capture
+	// sets at least one.
+	Username  string     `json:"username,omitempty" bson:"username,omitempty"`
+	Route     string     `json:"route,omitempty" bson:"route,omitempty"`
+	Reason    string     `json:"reason" bson:"reason"`
+	CreatedBy string     `json:"createdBy" bson:"createdBy"`
+	CreatedAt time.Time  `json:"createdAt" bson:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt" bson:"expiresAt"`
+	StoppedAt *time.Time `json:"stoppedAt,omitempty" bson:"stoppedAt,omitempty"`
+}
+
+// Active reports whether the capture is recording at now.
+func (c Capture) Active(now time.Time) bool {
+	return c.StoppedAt == nil && now.Before(c.ExpiresAt)
+}
+
+// CapturedExchange is one recorded request and response. Sealed holds them
+// encrypted; the other fields are enough to find the exchange.
+type CapturedExchange struct {
+	ID        string    `json:"id" bson:"_id"`
+	CaptureID string    `json:"captureId" bson:"captureId"`
+	At        time.Time `json:"at" bson:"at"`
+	Method    string    `json:"method" bson:"method"`
+	Route     string    `json:"route" bson:"route"`
+	Status    int       `json:"status" bson:"status"`
+	Sealed    []byte    `json:"-" bson:"sealed"`
+	// DeleteAt is when the exchange is purged.
+	DeleteAt time.Time `json:"deleteAt" bson:"deleteAt"`
+}
+
+// Partner is a referring clinic booking through the partner API with its
+// own token.
+type Partner struct {
+	ID   string `json:"id" bson:"_id"`
+	Name string `json:"name" bson:"name"`
+	// DoctorIDs are the doctors the partner may book; empty means any.
+	DoctorIDs []string `json:"doctorIds" bson:"doctorIds"`
+	// MonthlyQuota caps the partner's appointments starting in one
+	// calendar month; 0 means no cap.
+	MonthlyQuota int        `json:"monthlyQuota" bson:"monthlyQuota"`
+	CreatedBy    string     `json:"createdBy" bson:"createdBy"`
+	CreatedAt    time.Time  `json:"createdAt" bson:"createdAt"`
+	ExpiresAt    time.Time  `json:"expiresAt" bson:"expiresAt"`
+	RevokedAt    *time.Time `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+}
+
+// Active reports whether the partner's token can still be used at now.
+func (p Partner) Active(now time.Time) bool {
+	return p.RevokedAt == nil && now.Before(p.ExpiresAt)
+}
+
+// Attendance is one person's day on the time clock. Doctors' days are tied
+// to their first slot, which decides whether they started late.
+type Attendance struct {
+	// ID is the username and the clinic-local day, see AttendanceID.
+	ID       string `json:"id" bson:"_id"`
+	Username string `json:"username" bson:"username"`
+	DoctorID string `json:"doctorId,omitempty" bson:"doctorId,omitempty"`
+	// Day is the clinic-local YYYY-MM-DD date.
+	Day          string     `json:"day" bson:"day"`
+	ShiftStart   *time.Time `json:"shiftStart,omitempty" bson:"shiftStart,omitempty"`
+	ClockedInAt  time.Time  `json:"clockedInAt" bson:"clockedInAt"`
+	ClockedOutAt *time.Time `json:"clockedOutAt,omitempty" bson:"clockedOutAt,omitempty"`
+	// LateMinutes is how long after ShiftStart the clock-in was, once past
+	// the grace period.
+	LateMinutes int `json:"lateMinutes,omitempty" bson:"lateMinutes,omitempty"`
+	// PushedAppointments were moved to later slots because of the late
+	// start.
+	PushedAppointments []string `json:"pushedAppointments,omitempty" bson:"pushedAppointments,omitempty"`
+}
+
+// AttendanceID is the ID of username's attendance on day.
+func AttendanceID(username, day string) string {
+	return username + ":" + day
+}
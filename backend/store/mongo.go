@@ -0,0 +1,918 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const archiveBatchSize = 500
+
+// NewMongo returns a Store backed by the collections of db.
+func NewMongo(db *mongo.Database) *Store {
+	appointments := &mongoAppointments{
+		live:    db.Collection("appointments"),
+		archive: db.Collection("appointments_archive"),
+	}
+	return &Store{
+		Users:        mongoUsers{db.Collection("users")},
+		Doctors:      mongoDoctors{db.Collection("doctor")},
+		Patients:     mongoPatients{db.Collection("patients")},
+		Appointments: appointments,
+		Slots:        mongoSlots{db.Collection("slot_claims")},
+		Availability: mongoAvailability{db.Collection("availability")},
+		MagicLinks:   mongoMagicLinks{db.Collection("magic_links")},
+		Audit:        mongoAudit{db.Collection("audit_log")},
+		Delegations:  mongoDelegations{db.Collection("delegations")},
+		Templates:    mongoTemplates{db.Collection("clinical_templates")},
+		VisitNotes:   mongoVisitNotes{db.Collection("visit_notes")},
+		Quality:      mongoQuality{db.Collection("quality_measures")},
+		Segments:     mongoSegments{db.Collection("segments")},
+		Reasons:      mongoReasons{db.Collection("cancellation_reasons")},
+		Reporting:    mongoReporting{db.Collection("reporting_tokens")},
+		Closures:     mongoClosures{db.Collection("account_closures")},
+		Sessions:     mongoSessions{db.Collection("sessions")},
+		Partners:     mongoPartners{coll: db.Collection("partners"), referrals: db.Collection("partner_referrals")},
+		Attendance:   mongoAttendance{db.Collection("attendance")},
+		TriageRules:  mongoTriageRules{db.Collection("triage_rules")},
+		Triage:       mongoTriage{db.Collection("triage_assessments")},
+		Hooks:        mongoHooks{db.Collection("hooks")},
+		Captures:     mongoCaptures{captures: db.Collection("captures"), exchanges: db.Collection("captured_exchanges")},
+		Inventory:    mongoInventory{items: db.Collection("inventory_items"), usage: db.Collection("inventory_usage")},
+		ping: func(ctx context.Context) error {
+			return db.Client().Ping(ctx, nil)
+		},
+	}
+}
+
+func findOne[T any](ctx context.Context, coll *mongo.Collection, filter bson.M) (T, error) {
+	var doc T
+	err := coll.FindOne(ctx, filter).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return doc, ErrNotFound
+	}
+	return doc, err
+}
+
+func findAll[T any](ctx context.Context, coll *mongo.Collection, filter interface{}, opts ...*options.FindOptions) ([]T, error) {
+	cur, err := coll.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	docs := []T{}
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// findPage counts the matches of filter and returns the requested page,
+// with sortFields mapping page.Sort to the document field.
+func findPage[T any](ctx context.Context, coll *mongo.Collection, filter bson.M, page Page, sortFields map[string]string) ([]T, int64, error) {
+	total, err := coll.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	docs, err := findAll[T](ctx, coll, filter, pageOptions(page, sortFields))
+	return docs, total, err
+}
+
+func pageOptions(page Page, sortFields map[string]string) *options.FindOptions {
+	opts := options.Find().SetSkip(page.Skip)
+	if page.Limit > 0 {
+		opts.SetLimit(page.Limit)
+	}
+	// _id breaks ties, so pages don't overlap or skip documents when the
+	// sort field repeats
+	direction := 1
+	if page.Desc {
+		direction = -1
+	}
+	if field, ok := sortFields[page.Sort]; ok {
+		opts.SetSort(bson.D{{Key: field, Value: direction}, {Key: "_id", Value: direction}})
+	} else {
+		opts.SetSort(bson.D{{Key: "_id", Value: direction}})
+	}
+	return opts
+}
+
+// updateMatched runs an update and maps an unmatched filter to ErrNotFound.
+func updateMatched(ctx context.Context, coll *mongo.Collection, filter bson.M, update interface{}) error {
+	result, err := coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func insert(ctx context.Context, coll *mongo.Collection, doc interface{}) error {
+	_, err := coll.InsertOne(ctx, doc)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+type mongoUsers struct{ coll *mongo.Collection }
+
+func (r mongoUsers) Create(ctx context.Context, user User) error {
+	return insert(ctx, r.coll, user)
+}
+
+func (r mongoUsers) FindByUsername(ctx context.Context, username string) (User, error) {
+	return findOne[User](ctx, r.coll, bson.M{"username": username})
+}
+
+func (r mongoUsers) Exists(ctx context.Context, username string) (bool, error) {
+	count, err := r.coll.CountDocuments(ctx, bson.M{"username": username})
+	return count > 0, err
+}
+
+func (r mongoUsers) FindByProfile(ctx context.Context, role, profileID string) (User, error) {
+	return findOne[User](ctx, r.coll, bson.M{"profileid": profileID, "role": role})
+}
+
+func (r mongoUsers) Delete(ctx context.Context, username string) error {
+	result, err := r.coll.DeleteOne(ctx, bson.M{"username": username})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+var doctorSortFields = map[string]string{"name": "dname", "specialization": "specialization"}
+
+type mongoDoctors struct{ coll *mongo.Collection }
+
+func (r mongoDoctors) Create(ctx context.Context, doctor Doctor) error {
+	return insert(ctx, r.coll, doctor)
+}
+
+func (r mongoDoctors) Get(ctx context.Context, id string) (Doctor, error) {
+	return findOne[Doctor](ctx, r.coll, bson.M{"id": id})
+}
+
+func (r mongoDoctors) Exists(ctx context.Context, id string) (bool, error) {
+	count, err := r.coll.CountDocuments(ctx, bson.M{"id": id})
+	return count > 0, err
+}
+
+func (r mongoDoctors) List(ctx context.Context, f DoctorFilter, page Page) ([]Doctor, int64, error) {
+	filter := bson.M{}
+	if f.NameContains != "" {
+		filter["dname"] = primitive.Regex{Pattern: regexp.QuoteMeta(f.NameContains), Options: "i"}
+	}
+	if f.Specialization != "" {
+		filter["specialization"] = f.Specialization
+	}
+	if f.IDs != nil {
+		filter["id"] = bson.M{"$in": f.IDs}
+	}
+	return findPage[Doctor](ctx, r.coll, filter, page, doctorSortFields)
+}
+
+func (r mongoDoctors) All(ctx context.Context) ([]Doctor, error) {
+	return findAll[Doctor](ctx, r.coll, bson.D{})
+}
+
+func (r mongoDoctors) SetSchedule(ctx context.Context, id string, schedule []string) error {
+	return updateMatched(ctx, r.coll, bson.M{"id": id}, bson.M{"$set": bson.M{"schedule": schedule}})
+}
+
+func (r mongoDoctors) SetTemplate(ctx context.Context, id string, template *ScheduleTemplate) error {
+	if template == nil {
+		return updateMatched(ctx, r.coll, bson.M{"id": id}, bson.M{"$unset": bson.M{"scheduleTemplate": ""}})
+	}
+	return updateMatched(ctx, r.coll, bson.M{"id": id}, bson.M{"$set": bson.M{"scheduleTemplate": template}})
+}
+
+func (r mongoDoctors) SetDraft(ctx context.Context, id string, draft *ScheduleDraft) error {
+	if draft == nil {
+		return updateMatched(ctx, r.coll, bson.M{"id": id}, bson.M{"$unset": bson.M{"scheduleDraft": ""}})
+	}
+	return updateMatched(ctx, r.coll, bson.M{"id": id}, bson.M{"$set": bson.M{"scheduleDraft": draft}})
+}
+
+func (r mongoDoctors) PublishDraft(ctx context.Context, id string) error {
+	// Copying from the stored draft in one pipeline update means an edit
+	// racing with the publish is either published whole or not at all
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"schedule":         bson.M{"$ifNull": bson.A{"$scheduleDraft.schedule", bson.A{}}},
+			"followUpSlots":    bson.M{"$ifNull": bson.A{"$scheduleDraft.followUpSlots", "$$REMOVE"}},
+			"scheduleTemplate": bson.M{"$ifNull": bson.A{"$scheduleDraft.scheduleTemplate", "$$REMOVE"}},
+		}}},
+		{{Key: "$unset", Value: "scheduleDraft"}},
+	}
+	return updateMatched(ctx, r.coll, bson.M{"id": id, "scheduleDraft": bson.M{"$exists": true}}, update)
+}
+
+func (r mongoDoctors) SetFollowUpSlots(ctx context.Context, id string, starts []string) error {
+	return updateMatched(ctx, r.coll, bson.M{"id": id}, bson.M{"$set": bson.M{"followUpSlots": starts}})
+}
+
+var patientSortFields = map[string]string{"name": "pname"}
+
+type mongoPatients struct{ coll *mongo.Collection }
+
+func (r mongoPatients) Create(ctx context.Context, patient Patient) error {
+	return insert(ctx, r.coll, patient)
+}
+
+func (r mongoPatients) Get(ctx context.Context, id string) (Patient, error) {
+	return findOne[Patient](ctx, r.coll, bson.M{"id": id})
+}
+
+func (r mongoPatients) List(ctx context.Context, f PatientFilter, page Page) ([]Patient, int64, error) {
+	filter := bson.M{}
+	if len(f.Tags) > 0 {
+		filter["tags"] = bson.M{"$all": f.Tags}
+	}
+	return findPage[Patient](ctx, r.coll, filter, page, patientSortFields)
+}
+
+func (r mongoPatients) SetTags(ctx context.Context, id string, tags []string) error {
+	return updateMatched(ctx, r.coll, bson.M{"id": id}, bson.M{"$set": bson.M{"tags": tags}})
+}
+
+func (r mongoPatients) AddNote(ctx context.Context, id string, note PatientNote) error {
+	return updateMatched(ctx, r.coll, bson.M{"id": id}, bson.M{"$push": bson.M{"notes": note}})
+}
+
+func (r mongoPatients) SetProfile(ctx context.Context, id string, profile PatientProfile) error {
+	update := bson.M{"$set": bson.M{
+		"dateOfBirth":     profile.DateOfBirth,
+		"phone":           profile.Phone,
+		"address":         profile.Address,
+		"insuranceNumber": profile.InsuranceNumber,
+	}}
+	return updateMatched(ctx, r.coll, bson.M{"id": id}, update)
+}
+
+func (r mongoPatients) SetConsent(ctx context.Context, id string, consent Consent) error {
+	// Swapping the entry in one pipeline update keeps concurrent decisions
+	// on other purposes intact
+	others := bson.M{"$filter": bson.M{
+		"input": bson.M{"$ifNull": bson.A{"$consents", bson.A{}}},
+		"cond":  bson.M{"$ne": bson.A{"$$this.purpose", consent.Purpose}},
+	}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"consents": bson.M{"$concatArrays": bson.A{others, bson.A{bson.M{"$literal": consent}}}},
+	}}}}
+	return updateMatched(ctx, r.coll, bson.M{"id": id}, update)
+}
+
+func (r mongoPatients) SetEmergencyContacts(ctx context.Context, id string, contacts []EmergencyContact) error {
+	return updateMatched(ctx, r.coll, bson.M{"id": id}, bson.M{"$set": bson.M{"emergencyContacts": contacts}})
+}
+
+func (r mongoPatients) Anonymize(ctx context.Context, id string) error {
+	return updateMatched(ctx, r.coll, bson.M{"id": id}, bson.M{
+		"$set":   bson.M{"pname": "", "notes": bson.A{}},
+		"$unset": bson.M{"consents": "", "emergencyContacts": "", "dateOfBirth": "", "phone": "", "address": "", "insuranceNumber": ""},
+	})
+}
+
+func (r mongoPatients) Delete(ctx context.Context, id string) error {
+	result, err := r.coll.DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+var appointmentSortFields = map[string]string{"startTime": "startTime"}
+
+type mongoAppointments struct {
+	live    *mongo.Collection
+	archive *mongo.Collection
+}
+
+func (r *mongoAppointments) Create(ctx context.Context, appointment Appointment) error {
+	return insert(ctx, r.live, appointment)
+}
+
+func (r *mongoAppointments) Get(ctx context.Context, patientID, id string) (Appointment, error) {
+	return findOne[Appointment](ctx, r.live, bson.M{"id": id, "patientId": patientID})
+}
+
+func (r *mongoAppointments) Update(ctx context.Context, a Appointment, resetReminder bool) error {
+	set := bson.M{
+		"doctorId":  a.DoctorID,
+		"startTime": a.StartTime,
+		"endTime":   a.EndTime,
+		"notes":     a.Notes,
+		"status":    a.Status,
+	}
+	if a.CancellationReason != "" {
+		set["cancellationReason"] = a.CancellationReason
+	}
+	update := bson.M{"$set": set}
+	if resetReminder {
+		update["$unset"] = bson.M{"reminderSentAt": ""}
+	}
+	return updateMatched(ctx, r.live, bson.M{"id": a.ID, "patientId": a.PatientID, "status": AppointmentScheduled}, update)
+}
+
+func (r *mongoAppointments) Cancel(ctx context.Context, patientID, id, reason string) error {
+	filter := bson.M{"id": id, "patientId": patientID, "status": AppointmentScheduled}
+	return updateMatched(ctx, r.live, filter, bson.M{"$set": bson.M{"status": AppointmentCancelled, "cancellationReason": reason}})
+}
+
+// List pages across the archive and the live collection in turn, which
+// works because only startTime orderings are supported.
+func (r *mongoAppointments) List(ctx context.Context, f AppointmentFilter, page Page) ([]Appointment, int64, error) {
+	filter := appointmentFilter(f)
+	page.Sort = "startTime"
+	if !f.IncludeArchived {
+		return findPage[Appointment](ctx, r.live, filter, page, appointmentSortFields)
+	}
+
+	first, second := r.archive, r.live
+	if page.Desc {
+		first, second = second, first
+	}
+	firstTotal, err := first.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	secondTotal, err := second.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	appointments := []Appointment{}
+	if page.Skip < firstTotal {
+		found, err := findAll[Appointment](ctx, first, filter, pageOptions(page, appointmentSortFields))
+		if err != nil {
+			return nil, 0, err
+		}
+		appointments = append(appointments, found...)
+	}
+	if remaining := page.Limit - int64(len(appointments)); page.Limit == 0 || remaining > 0 {
+		rest := page
+		rest.Skip = page.Skip - firstTotal
+		if rest.Skip < 0 {
+			rest.Skip = 0
+		}
+		if page.Limit > 0 {
+			rest.Limit = remaining
+		}
+		found, err := findAll[Appointment](ctx, second, filter, pageOptions(rest, appointmentSortFields))
+		if err != nil {
+			return nil, 0, err
+		}
+		appointments = append(appointments, found...)
+	}
+	return appointments, firstTotal + secondTotal, nil
+}
+
+func appointmentFilter(f AppointmentFilter) bson.M {
+	filter := bson.M{}
+	if f.PatientID != "" {
+		filter["patientId"] = f.PatientID
+	}
+	if f.DoctorID != "" {
+		filter["doctorId"] = f.DoctorID
+	}
+	if f.PartnerID != "" {
+		filter["partnerId"] = f.PartnerID
+	}
+	if len(f.Statuses) > 0 {
+		filter["status"] = bson.M{"$in": f.Statuses}
+	}
+	startTime := bson.M{}
+	if !f.From.IsZero() {
+		startTime["$gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		startTime["$lt"] = f.To
+	}
+	if len(startTime) > 0 {
+		filter["startTime"] = startTime
+	}
+	return filter
+}
+
+func (r *mongoAppointments) DueReminders(ctx context.Context, from, to time.Time) ([]Appointment, error) {
+	filter := bson.M{
+		"status":         AppointmentScheduled,
+		"startTime":      bson.M{"$gt": from, "$lte": to},
+		"reminderSentAt": bson.M{"$exists": false},
+	}
+	return findAll[Appointment](ctx, r.live, filter)
+}
+
+func (r *mongoAppointments) MarkReminderSent(ctx context.Context, id string, at time.Time) (bool, error) {
+	filter := bson.M{"id": id, "reminderSentAt": bson.M{"$exists": false}}
+	result, err := r.live.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"reminderSentAt": at}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// Archive copies each appointment before deleting it, and the copy is an
+// upsert, so an interrupted run is safely picked up by the next one.
+func (r *mongoAppointments) Archive(ctx context.Context, cutoff time.Time) (int, error) {
+	moved := 0
+	for {
+		opts := options.Find().SetLimit(archiveBatchSize)
+		batch, err := findAll[bson.M](ctx, r.live, bson.M{"endTime": bson.M{"$lt": cutoff}}, opts)
+		if err != nil {
+			return moved, err
+		}
+		if len(batch) == 0 {
+			return moved, nil
+		}
+
+		for _, doc := range batch {
+			filter := bson.M{"id": doc["id"]}
+			delete(doc, "_id")
+			if _, err := r.archive.ReplaceOne(ctx, filter, doc, options.Replace().SetUpsert(true)); err != nil {
+				return moved, err
+			}
+			if _, err := r.live.DeleteOne(ctx, filter); err != nil {
+				return moved, err
+			}
+			moved++
+		}
+	}
+}
+
+type mongoSlots struct{ coll *mongo.Collection }
+
+func (r mongoSlots) Claim(ctx context.Context, claim SlotClaim) error {
+	return insert(ctx, r.coll, claim)
+}
+
+func (r mongoSlots) Release(ctx context.Context, id, appointmentID string) (bool, error) {
+	result, err := r.coll.DeleteOne(ctx, bson.M{"_id": id, "appointmentId": appointmentID})
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount > 0, nil
+}
+
+func (r mongoSlots) Claimed(ctx context.Context, doctorID string, from, to time.Time) ([]SlotClaim, error) {
+	filter := bson.M{"doctorId": doctorID, "startTime": bson.M{"$gte": from, "$lt": to}}
+	return findAll[SlotClaim](ctx, r.coll, filter)
+}
+
+func (r mongoSlots) DeleteBefore(ctx context.Context, cutoff time.Time) error {
+	_, err := r.coll.DeleteMany(ctx, bson.M{"startTime": bson.M{"$lt": cutoff}})
+	return err
+}
+
+type mongoAvailability struct{ coll *mongo.Collection }
+
+// Put orders writes by computation time, so a slower, older refresh can't
+// overwrite a newer one.
+func (r mongoAvailability) Put(ctx context.Context, day CachedAvailability) error {
+	filter := bson.M{"_id": day.ID, "computedAt": bson.M{"$lt": day.ComputedAt}}
+	_, err := r.coll.ReplaceOne(ctx, filter, day, options.Replace().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// A newer computation already stored this day
+		return nil
+	}
+	return err
+}
+
+func (r mongoAvailability) Get(ctx context.Context, doctorID, date string) (CachedAvailability, error) {
+	return findOne[CachedAvailability](ctx, r.coll, bson.M{"_id": doctorID + "|" + date})
+}
+
+func (r mongoAvailability) DeleteBefore(ctx context.Context, doctorID, date string) error {
+	_, err := r.coll.DeleteMany(ctx, bson.M{"doctorId": doctorID, "date": bson.M{"$lt": date}})
+	return err
+}
+
+func (r mongoAvailability) DoctorsWithSlots(ctx context.Context, date string) ([]string, error) {
+	filter := bson.M{"date": date, "slots.0": bson.M{"$exists": true}}
+	values, err := r.coll.Distinct(ctx, "doctorId", filter)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(values))
+	for _, value := range values {
+		if id, ok := value.(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+type mongoMagicLinks struct{ coll *mongo.Collection }
+
+func (r mongoMagicLinks) Create(ctx context.Context, link MagicLink) error {
+	return insert(ctx, r.coll, link)
+}
+
+func (r mongoMagicLinks) Consume(ctx context.Context, id string) (MagicLink, error) {
+	var link MagicLink
+	err := r.coll.FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&link)
+	if err == mongo.ErrNoDocuments {
+		return link, ErrNotFound
+	}
+	return link, err
+}
+
+var auditSortFields = map[string]string{"at": "at"}
+
+type mongoAudit struct{ coll *mongo.Collection }
+
+func (r mongoAudit) Append(ctx context.Context, entry AuditEntry) error {
+	return insert(ctx, r.coll, entry)
+}
+
+func (r mongoAudit) List(ctx context.Context, f AuditFilter, page Page) ([]AuditEntry, int64, error) {
+	filter := bson.M{}
+	if f.PatientID != "" {
+		filter["patientId"] = f.PatientID
+	}
+	if f.Action != "" {
+		filter["action"] = f.Action
+	}
+	return findPage[AuditEntry](ctx, r.coll, filter, page, auditSortFields)
+}
+
+type mongoDelegations struct{ coll *mongo.Collection }
+
+func (r mongoDelegations) Create(ctx context.Context, delegation Delegation) error {
+	return insert(ctx, r.coll, delegation)
+}
+
+func (r mongoDelegations) ForPatient(ctx context.Context, patientID string) ([]Delegation, error) {
+	return findAll[Delegation](ctx, r.coll, bson.M{"patientId": patientID}, options.Find().SetSort(bson.M{"grantedAt": 1}))
+}
+
+func (r mongoDelegations) ForDelegate(ctx context.Context, username string) ([]Delegation, error) {
+	return findAll[Delegation](ctx, r.coll, bson.M{"delegate": username}, options.Find().SetSort(bson.M{"grantedAt": 1}))
+}
+
+func (r mongoDelegations) Revoke(ctx context.Context, patientID, id string, at time.Time) error {
+	return updateMatched(ctx, r.coll, bson.M{"_id": id, "patientId": patientID}, bson.M{"$set": bson.M{"revokedAt": at}})
+}
+
+type mongoTemplates struct{ coll *mongo.Collection }
+
+func (r mongoTemplates) Put(ctx context.Context, template ClinicalTemplate) error {
+	_, err := r.coll.ReplaceOne(ctx, bson.M{"_id": template.ID}, template, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (r mongoTemplates) Get(ctx context.Context, id string) (ClinicalTemplate, error) {
+	return findOne[ClinicalTemplate](ctx, r.coll, bson.M{"_id": id})
+}
+
+func (r mongoTemplates) List(ctx context.Context, specialization string) ([]ClinicalTemplate, error) {
+	filter := bson.M{}
+	if specialization != "" {
+		filter["specialization"] = specialization
+	}
+	return findAll[ClinicalTemplate](ctx, r.coll, filter, options.Find().SetSort(bson.M{"name": 1}))
+}
+
+type mongoVisitNotes struct{ coll *mongo.Collection }
+
+func (r mongoVisitNotes) Put(ctx context.Context, note VisitNote) error {
+	_, err := r.coll.ReplaceOne(ctx, bson.M{"_id": note.AppointmentID}, note, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (r mongoVisitNotes) Get(ctx context.Context, appointmentID string) (VisitNote, error) {
+	return findOne[VisitNote](ctx, r.coll, bson.M{"_id": appointmentID})
+}
+
+type mongoQuality struct{ coll *mongo.Collection }
+
+func (r mongoQuality) Put(ctx context.Context, snapshot QualitySnapshot) error {
+	snapshot.ID = snapshot.Measure + "|" + snapshot.Date
+	_, err := r.coll.ReplaceOne(ctx, bson.M{"_id": snapshot.ID}, snapshot, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (r mongoQuality) List(ctx context.Context, measure, from, to string) ([]QualitySnapshot, error) {
+	filter := bson.M{"measure": measure, "date": bson.M{"$gte": from, "$lte": to}}
+	return findAll[QualitySnapshot](ctx, r.coll, filter, options.Find().SetSort(bson.M{"date": 1}))
+}
+
+type mongoInventory struct{ items, usage *mongo.Collection }
+
+func (r mongoInventory) Put(ctx context.Context, item InventoryItem) error {
+	_, err := r.items.ReplaceOne(ctx, bson.M{"_id": item.ID}, item, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (r mongoInventory) Get(ctx context.Context, id string) (InventoryItem, error) {
+	return findOne[InventoryItem](ctx, r.items, bson.M{"_id": id})
+}
+
+func (r mongoInventory) List(ctx context.Context) ([]InventoryItem, error) {
+	return findAll[InventoryItem](ctx, r.items, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+}
+
+func (r mongoInventory) Consume(ctx context.Context, usage InventoryUsage) (InventoryItem, error) {
+	// Matching on the stock makes the check and the decrement one atomic
+	// step, so concurrent visits can't take the stock below zero
+	filter := bson.M{"_id": usage.ItemID, "stock": bson.M{"$gte": usage.Quantity}}
+	update := bson.M{"$inc": bson.M{"stock": -usage.Quantity}, "$set": bson.M{"updatedAt": usage.RecordedAt}}
+	var item InventoryItem
+	err := r.items.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&item)
+	if err == mongo.ErrNoDocuments {
+		if _, err := r.Get(ctx, usage.ItemID); err != nil {
+			return InventoryItem{}, err
+		}
+		return InventoryItem{}, ErrInsufficientStock
+	} else if err != nil {
+		return InventoryItem{}, err
+	}
+	if err := insert(ctx, r.usage, usage); err != nil {
+		// Put the stock back so none goes missing without a usage record
+		restore := bson.M{"$inc": bson.M{"stock": usage.Quantity}}
+		if _, restoreErr := r.items.UpdateOne(context.WithoutCancel(ctx), bson.M{"_id": usage.ItemID}, restore); restoreErr != nil {
+			return InventoryItem{}, errors.Join(err, restoreErr)
+		}
+		return InventoryItem{}, err
+	}
+	return item, nil
+}
+
+func (r mongoInventory) Usage(ctx context.Context, appointmentID string) ([]InventoryUsage, error) {
+	return findAll[InventoryUsage](ctx, r.usage, bson.M{"appointmentId": appointmentID}, options.Find().SetSort(bson.M{"recordedAt": 1}))
+}
+
+type mongoSegments struct{ coll *mongo.Collection }
+
+func (r mongoSegments) Put(ctx context.Context, segment Segment) error {
+	_, err := r.coll.ReplaceOne(ctx, bson.M{"_id": segment.ID}, segment, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (r mongoSegments) Get(ctx context.Context, id string) (Segment, error) {
+	return findOne[Segment](ctx, r.coll, bson.M{"_id": id})
+}
+
+func (r mongoSegments) List(ctx context.Context) ([]Segment, error) {
+	return findAll[Segment](ctx, r.coll, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+}
+
+func (r mongoSegments) Delete(ctx context.Context, id string) error {
+	result, err := r.coll.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type mongoReasons struct{ coll *mongo.Collection }
+
+func (r mongoReasons) Put(ctx context.Context, reason CancellationReason) error {
+	_, err := r.coll.ReplaceOne(ctx, bson.M{"_id": reason.Code}, reason, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (r mongoReasons) List(ctx context.Context) ([]CancellationReason, error) {
+	return findAll[CancellationReason](ctx, r.coll, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+}
+
+type mongoReporting struct{ coll *mongo.Collection }
+
+func (r mongoReporting) Create(ctx context.Context, token ReportingToken) error {
+	return insert(ctx, r.coll, token)
+}
+
+func (r mongoReporting) Get(ctx context.Context, id string) (ReportingToken, error) {
+	return findOne[ReportingToken](ctx, r.coll, bson.M{"_id": id})
+}
+
+func (r mongoReporting) List(ctx context.Context) ([]ReportingToken, error) {
+	return findAll[ReportingToken](ctx, r.coll, bson.M{}, options.Find().SetSort(bson.M{"createdAt": -1}))
+}
+
+func (r mongoReporting) Revoke(ctx context.Context, id string, at time.Time) error {
+	return updateMatched(ctx, r.coll, bson.M{"_id": id}, bson.M{"$set": bson.M{"revokedAt": at}})
+}
+
+type mongoClosures struct{ coll *mongo.Collection }
+
+func (r mongoClosures) Put(ctx context.Context, closure AccountClosure) error {
+	_, err := r.coll.ReplaceOne(ctx, bson.M{"_id": closure.PatientID}, closure, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (r mongoClosures) Get(ctx context.Context, patientID string) (AccountClosure, error) {
+	return findOne[AccountClosure](ctx, r.coll, bson.M{"_id": patientID})
+}
+
+func (r mongoClosures) Delete(ctx context.Context, patientID string) error {
+	result, err := r.coll.DeleteOne(ctx, bson.M{"_id": patientID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r mongoClosures) Closing(ctx context.Context, t time.Time) ([]AccountClosure, error) {
+	return findAll[AccountClosure](ctx, r.coll, bson.M{"closesAt": bson.M{"$lt": t}, "closedAt": nil})
+}
+
+func (r mongoClosures) Closed(ctx context.Context, t time.Time) ([]AccountClosure, error) {
+	return findAll[AccountClosure](ctx, r.coll, bson.M{"closedAt": bson.M{"$lt": t}, "anonymizedAt": nil})
+}
+
+type mongoSessions struct{ coll *mongo.Collection }
+
+func (r mongoSessions) Create(ctx context.Context, session Session) error {
+	return insert(ctx, r.coll, session)
+}
+
+func (r mongoSessions) Get(ctx context.Context, id string) (Session, error) {
+	return findOne[Session](ctx, r.coll, bson.M{"_id": id})
+}
+
+func (r mongoSessions) Active(ctx context.Context, username string, now time.Time) ([]Session, error) {
+	filter := bson.M{"username": username, "expiresAt": bson.M{"$gt": now}, "revokedAt": nil}
+	return findAll[Session](ctx, r.coll, filter, options.Find().SetSort(bson.M{"createdAt": 1}))
+}
+
+func (r mongoSessions) Revoke(ctx context.Context, id string, at time.Time) error {
+	return updateMatched(ctx, r.coll, bson.M{"_id": id}, bson.M{"$set": bson.M{"revokedAt": at}})
+}
+
+func (r mongoSessions) RevokeAll(ctx context.Context, username, exceptID string, at time.Time) (int, error) {
+	filter := bson.M{"username": username, "_id": bson.M{"$ne": exceptID}, "expiresAt": bson.M{"$gt": at}, "revokedAt": nil}
+	result, err := r.coll.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revokedAt": at}})
+	if err != nil {
+		return 0, err
+	}
+	return int(result.ModifiedCount), nil
+}
+
+type mongoCaptures struct {
+	captures  *mongo.Collection
+	exchanges *mongo.Collection
+}
+
+func (r mongoCaptures) Create(ctx context.Context, capture Capture) error {
+	return insert(ctx, r.captures, capture)
+}
+
+func (r mongoCaptures) List(ctx context.Context) ([]Capture, error) {
+	return findAll[Capture](ctx, r.captures, bson.M{}, options.Find().SetSort(bson.M{"createdAt": -1}))
+}
+
+func (r mongoCaptures) Active(ctx context.Context, now time.Time) ([]Capture, error) {
+	return findAll[Capture](ctx, r.captures, bson.M{"expiresAt": bson.M{"$gt": now}, "stoppedAt": nil})
+}
+
+func (r mongoCaptures) Stop(ctx context.Context, id string, at time.Time) error {
+	return updateMatched(ctx, r.captures, bson.M{"_id": id}, bson.M{"$set": bson.M{"stoppedAt": at}})
+}
+
+func (r mongoCaptures) Record(ctx context.Context, exchange CapturedExchange) error {
+	return insert(ctx, r.exchanges, exchange)
+}
+
+func (r mongoCaptures) Exchanges(ctx context.Context, captureID string) ([]CapturedExchange, error) {
+	return findAll[CapturedExchange](ctx, r.exchanges, bson.M{"captureId": captureID}, options.Find().SetSort(bson.M{"at": 1}))
+}
+
+type mongoPartners struct {
+	coll *mongo.Collection
+	// referrals holds a count per partner and month, so the quota check
+	// and the count are one guarded $inc like a slot claim
+	referrals *mongo.Collection
+}
+
+func (r mongoPartners) Create(ctx context.Context, partner Partner) error {
+	return insert(ctx, r.coll, partner)
+}
+
+func (r mongoPartners) Get(ctx context.Context, id string) (Partner, error) {
+	return findOne[Partner](ctx, r.coll, bson.M{"_id": id})
+}
+
+func (r mongoPartners) List(ctx context.Context) ([]Partner, error) {
+	return findAll[Partner](ctx, r.coll, bson.M{}, options.Find().SetSort(bson.M{"createdAt": -1}))
+}
+
+func (r mongoPartners) Revoke(ctx context.Context, id string, at time.Time) error {
+	return updateMatched(ctx, r.coll, bson.M{"_id": id}, bson.M{"$set": bson.M{"revokedAt": at}})
+}
+
+func (r mongoPartners) CountReferral(ctx context.Context, partnerID, month string, quota int, booked int64) (bool, error) {
+	id := partnerID + ":" + month
+	// Only the first insert for the month lands
+	if err := insert(ctx, r.referrals, bson.M{"_id": id, "count": booked}); err != nil && !errors.Is(err, ErrDuplicate) {
+		return false, err
+	}
+	result, err := r.referrals.UpdateOne(ctx, bson.M{"_id": id, "count": bson.M{"$lt": quota}}, bson.M{"$inc": bson.M{"count": 1}})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func (r mongoPartners) UncountReferral(ctx context.Context, partnerID, month string) error {
+	_, err := r.referrals.UpdateOne(ctx, bson.M{"_id": partnerID + ":" + month, "count": bson.M{"$gt": 0}}, bson.M{"$inc": bson.M{"count": -1}})
+	return err
+}
+
+type mongoAttendance struct{ coll *mongo.Collection }
+
+func (r mongoAttendance) Create(ctx context.Context, attendance Attendance) error {
+	return insert(ctx, r.coll, attendance)
+}
+
+func (r mongoAttendance) Get(ctx context.Context, id string) (Attendance, error) {
+	return findOne[Attendance](ctx, r.coll, bson.M{"_id": id})
+}
+
+func (r mongoAttendance) ClockOut(ctx context.Context, id string, at time.Time) error {
+	return updateMatched(ctx, r.coll, bson.M{"_id": id}, bson.M{"$set": bson.M{"clockedOutAt": at}})
+}
+
+func (r mongoAttendance) SetPushed(ctx context.Context, id string, appointmentIDs []string) error {
+	return updateMatched(ctx, r.coll, bson.M{"_id": id}, bson.M{"$set": bson.M{"pushedAppointments": appointmentIDs}})
+}
+
+func (r mongoAttendance) List(ctx context.Context, username, fromDay, toDay string) ([]Attendance, error) {
+	filter := bson.M{"day": bson.M{"$gte": fromDay, "$lte": toDay}}
+	if username != "" {
+		filter["username"] = username
+	}
+	return findAll[Attendance](ctx, r.coll, filter, options.Find().SetSort(bson.D{{Key: "day", Value: 1}, {Key: "username", Value: 1}}))
+}
+
+type mongoTriageRules struct{ coll *mongo.Collection }
+
+func (r mongoTriageRules) Put(ctx context.Context, rule TriageRule) error {
+	_, err := r.coll.ReplaceOne(ctx, bson.M{"_id": rule.ID}, rule, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (r mongoTriageRules) List(ctx context.Context) ([]TriageRule, error) {
+	return findAll[TriageRule](ctx, r.coll, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+}
+
+type mongoTriage struct{ coll *mongo.Collection }
+
+func (r mongoTriage) Create(ctx context.Context, assessment TriageAssessment) error {
+	return insert(ctx, r.coll, assessment)
+}
+
+func (r mongoTriage) Latest(ctx context.Context, patientID string, now time.Time) (TriageAssessment, error) {
+	var assessment TriageAssessment
+	filter := bson.M{"patientId": patientID, "expiresAt": bson.M{"$gt": now}}
+	err := r.coll.FindOne(ctx, filter, options.FindOne().SetSort(bson.M{"createdAt": -1})).Decode(&assessment)
+	if err == mongo.ErrNoDocuments {
+		return assessment, ErrNotFound
+	}
+	return assessment, err
+}
+
+type mongoHooks struct{ coll *mongo.Collection }
+
+func (r mongoHooks) Put(ctx context.Context, hook Hook) error {
+	_, err := r.coll.ReplaceOne(ctx, bson.M{"_id": hook.ID}, hook, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (r mongoHooks) Delete(ctx context.Context, id string) error {
+	result, err := r.coll.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r mongoHooks) List(ctx context.Context) ([]Hook, error) {
+	return findAll[Hook](ctx, r.coll, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+}
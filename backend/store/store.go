@@ -0,0 +1,379 @@
+// Package store holds the clinic's persistent data behind repository
+// interfaces, with a MongoDB implementation for production and an
+// in-memory one for tests.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when the requested record doesn't exist.
+	ErrNotFound = errors.New("not found")
+	// ErrDuplicate is returned when a record with the same key exists.
+	ErrDuplicate = errors.New("duplicate key")
+	// ErrInsufficientStock is returned when consuming more of an inventory
+	// item than is in stock.
+	ErrInsufficientStock = errors.New("insufficient stock")
+)
+
+// Page selects part of a listing. Sort is a field name the listing
+// documents as sortable, with ties in ID order; without one the listing is
+// in ID order. Limit 0 returns every match.
+type Page struct {
+	Skip  int64
+	Limit int64
+	Sort  string
+	Desc  bool
+}
+
+// DoctorFilter narrows a doctor listing; zero fields match everything.
+type DoctorFilter struct {
+	// NameContains matches a case-insensitive substring of the name.
+	NameContains   string
+	Specialization string
+	// IDs, when non-nil, restricts the listing to these doctors.
+	IDs []string
+}
+
+// PatientFilter narrows a patient listing; zero fields match everything.
+type PatientFilter struct {
+	// Tags matches patients carrying all of them.
+	Tags []string
+}
+
+// AppointmentFilter narrows an appointment listing; zero fields match
+// everything. From and To bound the start time and are inclusive and
+// exclusive respectively.
+type AppointmentFilter struct {
+	PatientID string
+	DoctorID  string
+	PartnerID string
+	Statuses  []string
+	From      time.Time
+	To        time.Time
+	// IncludeArchived adds archived appointments, which all ended before
+	// the live ones.
+	IncludeArchived bool
+}
+
+type UserRepository interface {
+	// Create returns ErrDuplicate when the username is taken.
+	Create(ctx context.Context, user User) error
+	FindByUsername(ctx context.Context, username string) (User, error)
+	Exists(ctx context.Context, username string) (bool, error)
+	// FindByProfile returns the account with role owning a profile.
+	FindByProfile(ctx context.Context, role, profileID string) (User, error)
+	// Delete returns ErrNotFound when there is no such account.
+	Delete(ctx context.Context, username string) error
+}
+
+// DoctorRepository sorts listings by "name" or "specialization".
+type DoctorRepository interface {
+	Create(ctx context.Context, doctor Doctor) error
+	Get(ctx context.Context, id string) (Doctor, error)
+	Exists(ctx context.Context, id string) (bool, error)
+	// List returns one page of matches and the number of matches overall.
+	List(ctx context.Context, filter DoctorFilter, page Page) ([]Doctor, int64, error)
+	All(ctx context.Context) ([]Doctor, error)
+	SetSchedule(ctx context.Context, id string, schedule []string) error
+	// SetTemplate stores the doctor's schedule template, or removes it when
+	// template is nil.
+	SetTemplate(ctx context.Context, id string, template *ScheduleTemplate) error
+	SetFollowUpSlots(ctx context.Context, id string, starts []string) error
+	// SetDraft stores the doctor's schedule draft, or discards it when
+	// draft is nil.
+	SetDraft(ctx context.Context, id string, draft *ScheduleDraft) error
+	// PublishDraft makes the stored draft the doctor's schedule in one
+	// step. It returns ErrNotFound when the doctor has no draft.
+	PublishDraft(ctx context.Context, id string) error
+}
+
+// PatientRepository sorts listings by "name".
+type PatientRepository interface {
+	Create(ctx context.Context, patient Patient) error
+	Get(ctx context.Context, id string) (Patient, error)
+	List(ctx context.Context, filter PatientFilter, page Page) ([]Patient, int64, error)
+	SetTags(ctx context.Context, id string, tags []string) error
+	AddNote(ctx context.Context, id string, note PatientNote) error
+	SetProfile(ctx context.Context, id string, profile PatientProfile) error
+	// SetConsent replaces the patient's consent for consent.Purpose.
+	SetConsent(ctx context.Context, id string, consent Consent) error
+	SetEmergencyContacts(ctx context.Context, id string, contacts []EmergencyContact) error
+	// Anonymize strips everything identifying from the patient, keeping
+	// the record so their appointments still count in aggregates.
+	Anonymize(ctx context.Context, id string) error
+	// Delete removes a patient record nothing refers to yet. It returns
+	// ErrNotFound when there is no such patient.
+	Delete(ctx context.Context, id string) error
+}
+
+// AppointmentRepository sorts listings by "startTime". Archived
+// appointments are read-only and only returned by List.
+type AppointmentRepository interface {
+	Create(ctx context.Context, appointment Appointment) error
+	Get(ctx context.Context, patientID, id string) (Appointment, error)
+	// Update saves the doctor, times, notes, status and cancellation
+	// reason of a scheduled appointment. resetReminder
+	// clears the reminder record so the new time gets its own reminder. It
+	// returns ErrNotFound unless the appointment exists and is still
+	// scheduled.
+	Update(ctx context.Context, appointment Appointment, resetReminder bool) error
+	// Cancel sets the status of a scheduled appointment to cancelled and
+	// records reason. It returns ErrNotFound unless the appointment exists
+	// and is still scheduled.
+	Cancel(ctx context.Context, patientID, id, reason string) error
+	List(ctx context.Context, filter AppointmentFilter, page Page) ([]Appointment, int64, error)
+	// DueReminders returns scheduled appointments starting in (from, to]
+	// that haven't had a reminder yet.
+	DueReminders(ctx context.Context, from, to time.Time) ([]Appointment, error)
+	// MarkReminderSent records a reminder unless one was already recorded,
+	// and reports whether it did.
+	MarkReminderSent(ctx context.Context, id string, at time.Time) (bool, error)
+	// Archive moves appointments that ended before cutoff to the archive
+	// and returns how many were moved.
+	Archive(ctx context.Context, cutoff time.Time) (int, error)
+}
+
+type SlotRepository interface {
+	// Claim returns ErrDuplicate when the slot is already claimed.
+	Claim(ctx context.Context, claim SlotClaim) error
+	// Release deletes the claim with id if appointmentID holds it, and
+	// reports whether it did.
+	Release(ctx context.Context, id, appointmentID string) (bool, error)
+	// Claimed returns the doctor's claims on slots starting in [from, to).
+	Claimed(ctx context.Context, doctorID string, from, to time.Time) ([]SlotClaim, error)
+	DeleteBefore(ctx context.Context, cutoff time.Time) error
+}
+
+type AvailabilityRepository interface {
+	// Put stores a day unless a later computation of it is already stored.
+	Put(ctx context.Context, day CachedAvailability) error
+	Get(ctx context.Context, doctorID, date string) (CachedAvailability, error)
+	// DeleteBefore drops a doctor's days before date.
+	DeleteBefore(ctx context.Context, doctorID, date string) error
+	// DoctorsWithSlots returns the doctors with a free slot on date.
+	DoctorsWithSlots(ctx context.Context, date string) ([]string, error)
+}
+
+type MagicLinkRepository interface {
+	Create(ctx context.Context, link MagicLink) error
+	// Consume deletes and returns a link, or returns ErrNotFound when it
+	// doesn't exist or was already used.
+	Consume(ctx context.Context, id string) (MagicLink, error)
+}
+
+// AuditFilter narrows an audit log listing; zero fields match everything.
+type AuditFilter struct {
+	PatientID string
+	Action    string
+}
+
+// AuditRepository is append-only and sorts listings by "at".
+type AuditRepository interface {
+	Append(ctx context.Context, entry AuditEntry) error
+	List(ctx context.Context, filter AuditFilter, page Page) ([]AuditEntry, int64, error)
+}
+
+type DelegationRepository interface {
+	Create(ctx context.Context, delegation Delegation) error
+	// ForPatient lists the delegations patientID granted, including revoked
+	// and expired ones.
+	ForPatient(ctx context.Context, patientID string) ([]Delegation, error)
+	// ForDelegate lists the delegations granted to username, including
+	// revoked and expired ones.
+	ForDelegate(ctx context.Context, username string) ([]Delegation, error)
+	// Revoke marks one of patientID's delegations as revoked at at.
+	Revoke(ctx context.Context, patientID, id string, at time.Time) error
+}
+
+type ClinicalTemplateRepository interface {
+	// Put creates or replaces a template.
+	Put(ctx context.Context, template ClinicalTemplate) error
+	Get(ctx context.Context, id string) (ClinicalTemplate, error)
+	// List returns the templates of specialization, or all of them when it
+	// is empty.
+	List(ctx context.Context, specialization string) ([]ClinicalTemplate, error)
+}
+
+type VisitNoteRepository interface {
+	// Put creates or replaces the note of note.AppointmentID.
+	Put(ctx context.Context, note VisitNote) error
+	Get(ctx context.Context, appointmentID string) (VisitNote, error)
+}
+
+type QualityRepository interface {
+	// Put stores a snapshot, replacing an earlier one of the same measure
+	// and day.
+	Put(ctx context.Context, snapshot QualitySnapshot) error
+	// List returns the snapshots of measure with dates in [from, to],
+	// oldest first.
+	List(ctx context.Context, measure, from, to string) ([]QualitySnapshot, error)
+}
+
+type InventoryRepository interface {
+	// Put creates or replaces an item.
+	Put(ctx context.Context, item InventoryItem) error
+	Get(ctx context.Context, id string) (InventoryItem, error)
+	// List returns every item, by name.
+	List(ctx context.Context) ([]InventoryItem, error)
+	// Consume takes usage.Quantity out of usage.ItemID's stock and records
+	// the usage, returning the item as it is afterwards. It leaves the
+	// stock alone when it fails, returning ErrInsufficientStock when there
+	// isn't enough.
+	Consume(ctx context.Context, usage InventoryUsage) (InventoryItem, error)
+	// Usage lists what was consumed during an appointment, oldest first.
+	Usage(ctx context.Context, appointmentID string) ([]InventoryUsage, error)
+}
+
+type SegmentRepository interface {
+	// Put creates or replaces a segment.
+	Put(ctx context.Context, segment Segment) error
+	Get(ctx context.Context, id string) (Segment, error)
+	// List returns every segment, by name.
+	List(ctx context.Context) ([]Segment, error)
+	Delete(ctx context.Context, id string) error
+}
+
+type CancellationReasonRepository interface {
+	// Put creates or replaces a reason.
+	Put(ctx context.Context, reason CancellationReason) error
+	List(ctx context.Context) ([]CancellationReason, error)
+}
+
+type ReportingTokenRepository interface {
+	Create(ctx context.Context, token ReportingToken) error
+	Get(ctx context.Context, id string) (ReportingToken, error)
+	// List returns every token, newest first.
+	List(ctx context.Context) ([]ReportingToken, error)
+	// Revoke returns ErrNotFound when the token doesn't exist.
+	Revoke(ctx context.Context, id string, at time.Time) error
+}
+
+type AccountClosureRepository interface {
+	// Put creates or replaces the closure of closure.PatientID.
+	Put(ctx context.Context, closure AccountClosure) error
+	Get(ctx context.Context, patientID string) (AccountClosure, error)
+	Delete(ctx context.Context, patientID string) error
+	// Closing returns the confirmed closures whose grace period ended
+	// before t and whose account is still open.
+	Closing(ctx context.Context, t time.Time) ([]AccountClosure, error)
+	// Closed returns the closures whose account was closed before t and
+	// whose patient isn't anonymized yet.
+	Closed(ctx context.Context, t time.Time) ([]AccountClosure, error)
+}
+
+type SessionRepository interface {
+	Create(ctx context.Context, session Session) error
+	Get(ctx context.Context, id string) (Session, error)
+	// Active lists username's sessions usable at now, oldest first.
+	Active(ctx context.Context, username string, now time.Time) ([]Session, error)
+	// Revoke returns ErrNotFound when the session doesn't exist.
+	Revoke(ctx context.Context, id string, at time.Time) error
+	// RevokeAll revokes username's sessions but exceptID and returns how
+	// many were still active.
+	RevokeAll(ctx context.Context, username, exceptID string, at time.Time) (int, error)
+}
+
+type CaptureRepository interface {
+	Create(ctx context.Context, capture Capture) error
+	// List returns every capture, newest first.
+	List(ctx context.Context) ([]Capture, error)
+	// Active returns the captures recording at now.
+	Active(ctx context.Context, now time.Time) ([]Capture, error)
+	// Stop returns ErrNotFound when the capture doesn't exist.
+	Stop(ctx context.Context, id string, at time.Time) error
+	Record(ctx context.Context, exchange CapturedExchange) error
+	// Exchanges returns the capture's exchanges, oldest first.
+	Exchanges(ctx context.Context, captureID string) ([]CapturedExchange, error)
+}
+
+type PartnerRepository interface {
+	Create(ctx context.Context, partner Partner) error
+	Get(ctx context.Context, id string) (Partner, error)
+	// List returns every partner, newest first.
+	List(ctx context.Context) ([]Partner, error)
+	// Revoke returns ErrNotFound when the partner doesn't exist.
+	Revoke(ctx context.Context, id string, at time.Time) error
+	// CountReferral atomically counts one more referral for the partner in
+	// month (YYYY-MM) unless quota are counted already, and reports whether
+	// it did. The month's first count starts from booked, the referrals
+	// booked before it was counted.
+	CountReferral(ctx context.Context, partnerID, month string, quota int, booked int64) (bool, error)
+	// UncountReferral gives back one referral counted in month.
+	UncountReferral(ctx context.Context, partnerID, month string) error
+}
+
+type TriageRuleRepository interface {
+	// Put creates or replaces the rule with the same ID.
+	Put(ctx context.Context, rule TriageRule) error
+	List(ctx context.Context) ([]TriageRule, error)
+}
+
+type TriageAssessmentRepository interface {
+	Create(ctx context.Context, assessment TriageAssessment) error
+	// Latest returns the patient's newest assessment that hasn't expired at
+	// now, or ErrNotFound.
+	Latest(ctx context.Context, patientID string, now time.Time) (TriageAssessment, error)
+}
+
+type HookRepository interface {
+	// Put creates or replaces the hook with the same ID.
+	Put(ctx context.Context, hook Hook) error
+	// Delete returns ErrNotFound when there is no such hook.
+	Delete(ctx context.Context, id string) error
+	List(ctx context.Context) ([]Hook, error)
+}
+
+type AttendanceRepository interface {
+	// Create returns ErrDuplicate when the day is already on the clock.
+	Create(ctx context.Context, attendance Attendance) error
+	Get(ctx context.Context, id string) (Attendance, error)
+	// ClockOut returns ErrNotFound when the day isn't on the clock.
+	ClockOut(ctx context.Context, id string, at time.Time) error
+	// SetPushed records the appointments moved by a late start.
+	SetPushed(ctx context.Context, id string, appointmentIDs []string) error
+	// List returns the days from fromDay to toDay inclusive, earliest
+	// first, of username or everyone when it is empty.
+	List(ctx context.Context, username, fromDay, toDay string) ([]Attendance, error)
+}
+
+// Store groups the repositories of one backend.
+type Store struct {
+	Users        UserRepository
+	Doctors      DoctorRepository
+	Patients     PatientRepository
+	Appointments AppointmentRepository
+	Slots        SlotRepository
+	Availability AvailabilityRepository
+	MagicLinks   MagicLinkRepository
+	Audit        AuditRepository
+	Delegations  DelegationRepository
+	Templates    ClinicalTemplateRepository
+	VisitNotes   VisitNoteRepository
+	Quality      QualityRepository
+	Inventory    InventoryRepository
+	Segments     SegmentRepository
+	Reasons      CancellationReasonRepository
+	Reporting    ReportingTokenRepository
+	Closures     AccountClosureRepository
+	Sessions     SessionRepository
+	Captures     CaptureRepository
+	Partners     PartnerRepository
+	Attendance   AttendanceRepository
+	TriageRules  TriageRuleRepository
+	Triage       TriageAssessmentRepository
+	Hooks        HookRepository
+
+	ping func(ctx context.Context) error
+}
+
+// Ping reports whether the backend is reachable.
+func (s *Store) Ping(ctx context.Context) error {
+	if s.ping == nil {
+		return nil
+	}
+	return s.ping(ctx)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type (
+	TriageRule       = store.TriageRule
+	TriageAssessment = store.TriageAssessment
+)
+
+// triageValidFor is how long a pre-screening outcome applies to booking,
+// after which the patient answers again.
+const triageValidFor = 24 * time.Hour
+
+const emergencyMessage = "These symptoms need emergency care. Call your local emergency number or go to the nearest emergency department now; appointments can't be booked online for them."
+
+// defaultTriageRules are always in the rule set. Admins can change or
+// disable them and add their own.
+var defaultTriageRules = []TriageRule{
+	{
+		ID:       "emergency-symptoms",
+		Priority: 10,
+		Symptoms: []string{"chest_pain", "difficulty_breathing", "stroke_signs", "severe_bleeding", "loss_of_consciousness"},
+		Urgency:  store.UrgencyEmergency,
+		Message:  emergencyMessage,
+	},
+	{
+		ID:          "severe-pain",
+		Priority:    100,
+		MinSeverity: 8,
+		Urgency:     store.UrgencyUrgent,
+		Message:     "Please book the earliest appointment available.",
+	},
+}
+
+// urgencyWindows is how far ahead the next-available queue looks for a
+// patient whose pre-screening came out at each urgency.
+var urgencyWindows = map[string]time.Duration{
+	store.UrgencyUrgent:  48 * time.Hour,
+	store.UrgencySoon:    7 * 24 * time.Hour,
+	store.UrgencyRoutine: queueHorizon,
+}
+
+// triageRequest holds the questionnaire answers.
+type triageRequest struct {
+	Symptoms []string `json:"symptoms" binding:"max=50,dive,notblank,max=100"`
+	Severity *int     `json:"severity" binding:"required,min=0,max=10"`
+}
+
+// triageRules returns the rule set by priority: the defaults overridden by
+// and merged with the stored rules, by ID.
+func (s *Server) triageRules(ctx context.Context) ([]TriageRule, error) {
+	stored, err := s.store.TriageRules.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]TriageRule, len(defaultTriageRules)+len(stored))
+	for _, rule := range defaultTriageRules {
+		byID[rule.ID] = rule
+	}
+	for _, rule := range stored {
+		byID[rule.ID] = rule
+	}
+	rules := make([]TriageRule, 0, len(byID))
+	for _, rule := range byID {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Priority != rules[j].Priority {
+			return rules[i].Priority < rules[j].Priority
+		}
+		return rules[i].ID < rules[j].ID
+	})
+	return rules, nil
+}
+
+// matchTriage returns the first enabled rule the answers match.
+func matchTriage(rules []TriageRule, symptoms []string, severity int) (TriageRule, bool) {
+	for _, rule := range rules {
+		if rule.Disabled || severity < rule.MinSeverity {
+			continue
+		}
+		if len(rule.Symptoms) == 0 || slices.ContainsFunc(rule.Symptoms, func(symptom string) bool { return slices.Contains(symptoms, symptom) }) {
+			return rule, true
+		}
+	}
+	return TriageRule{}, false
+}
+
+// latestTriage returns the patient's pre-screening that still applies, if
+// any.
+func (s *Server) latestTriage(ctx context.Context, patientID string) (TriageAssessment, bool, error) {
+	assessment, err := s.store.Triage.Latest(ctx, patientID, time.Now().UTC())
+	if errors.Is(err, store.ErrNotFound) {
+		return TriageAssessment{}, false, nil
+	}
+	return assessment, err == nil, err
+}
+
+// checkTriageOrAbort turns patients and their delegates away from online
+// booking while their pre-screening directs them to emergency care. Staff
+// can still book for them, e.g. after speaking to them.
+func (s *Server) checkTriageOrAbort(c *gin.Context, patientID string) bool {
+	if user, _ := currentUser(c); hasRole(user, []string{RoleDoctor, RoleAdmin}) {
+		return true
+	}
+	assessment, found, err := s.latestTriage(c.Request.Context(), patientID)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error checking pre-screening")
+		return false
+	}
+	if found && assessment.Urgency == store.UrgencyEmergency {
+		message := assessment.Message
+		if message == "" {
+			message = emergencyMessage
+		}
+		abortWithCode(c, http.StatusForbidden, "emergency_care", message)
+		return false
+	}
+	return true
+}
+
+// GetTriageQuestionnaire lists the symptoms the rules know about, for the
+// questionnaire to offer, and the severity scale.
+func (s *Server) GetTriageQuestionnaire(c *gin.Context) {
+	rules, err := s.triageRules(c.Request.Context())
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving triage rules")
+		return
+	}
+	symptoms := []string{}
+	for _, rule := range rules {
+		for _, symptom := range rule.Symptoms {
+			if !rule.Disabled && !slices.Contains(symptoms, symptom) {
+				symptoms = append(symptoms, symptom)
+			}
+		}
+	}
+	sort.Strings(symptoms)
+	c.JSON(http.StatusOK, gin.H{"symptoms": symptoms, "severity": gin.H{"min": 0, "max": 10}})
+}
+
+// PostTriage runs the patient's answers through the rules and records the
+// outcome, which booking honours until it expires. Answers no rule matches
+// are routine.
+func (s *Server) PostTriage(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req triageRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	rules, err := s.triageRules(ctx)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving triage rules")
+		return
+	}
+
+	user, _ := currentUser(c)
+	now := time.Now().UTC()
+	assessment := TriageAssessment{
+		ID:        primitive.NewObjectID().Hex(),
+		PatientID: c.Param("id"),
+		Symptoms:  req.Symptoms,
+		Severity:  *req.Severity,
+		Urgency:   store.UrgencyRoutine,
+		CreatedBy: user.Username,
+		CreatedAt: now,
+		ExpiresAt: now.Add(triageValidFor),
+	}
+	if assessment.Symptoms == nil {
+		assessment.Symptoms = []string{}
+	}
+	if rule, ok := matchTriage(rules, assessment.Symptoms, assessment.Severity); ok {
+		assessment.RuleID = rule.ID
+		assessment.Urgency = rule.Urgency
+		assessment.Specialization = rule.Specialization
+		assessment.Message = rule.Message
+	}
+	if err := s.store.Triage.Create(ctx, assessment); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error saving pre-screening")
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"assessment":    assessment,
+		"onlineBooking": assessment.Urgency != store.UrgencyEmergency,
+	})
+}
+
+// GetTriageRules lists the rule set in the order it is tried.
+func (s *Server) GetTriageRules(c *gin.Context) {
+	rules, err := s.triageRules(c.Request.Context())
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error retrieving triage rules")
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+// PutTriageRule adds a rule or replaces one, including the defaults.
+func (s *Server) PutTriageRule(c *gin.Context) {
+	var rule TriageRule
+	if !bindJSON(c, &rule) {
+		return
+	}
+	user, _ := currentUser(c)
+	rule.ID = c.Param("ruleID")
+	rule.UpdatedBy = user.Username
+	rule.UpdatedAt = time.Now().UTC()
+	if rule.Symptoms == nil {
+		rule.Symptoms = []string{}
+	}
+	if err := s.store.TriageRules.Put(c.Request.Context(), rule); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Error saving triage rule")
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type triageResponse struct {
+	Assessment    TriageAssessment `json:"assessment"`
+	OnlineBooking bool             `json:"onlineBooking"`
+}
+
+func TestEmergencyTriageBlocksOnlineBooking(t *testing.T) {
+	f := newBookingFixture(t)
+
+	resp := decode[triageResponse](t, f.do(t, http.MethodPost, "/api/patients/p-alice/triage", f.alice, gin.H{"symptoms": []string{"chest_pain"}, "severity": 4}), http.StatusCreated)
+	if resp.Assessment.Urgency != "emergency" || resp.OnlineBooking || resp.Assessment.Message == "" {
+		t.Fatalf("triage = %+v, want an emergency outcome with a message", resp)
+	}
+
+	if got := decode[apiError](t, f.book(t, f.alice, f.first), http.StatusForbidden); got.Code != "emergency_care" {
+		t.Errorf("booking code = %q, want emergency_care", got.Code)
+	}
+	if got := decode[apiError](t, f.do(t, http.MethodPost, "/api/patients/p-alice/appointments/next-available", f.alice, gin.H{"specialization": "cardiology"}), http.StatusForbidden); got.Code != "emergency_care" {
+		t.Errorf("queue booking code = %q, want emergency_care", got.Code)
+	}
+
+	// Reception can still book once they've spoken to the patient
+	admin := User{Username: "root", Role: RoleAdmin}
+	path := "/api/patients/p-alice/appointments"
+	decode[bookingResponse](t, f.do(t, http.MethodPost, path, admin, gin.H{"doctorId": f.doctor.ID, "startTime": f.first}), http.StatusOK)
+
+	// Other patients are unaffected
+	decode[bookingResponse](t, f.book(t, f.bob, f.second), http.StatusOK)
+}
+
+func TestTriageRoutesToSpecialization(t *testing.T) {
+	f := newBookingFixture(t)
+	admin := User{Username: "root", Role: RoleAdmin}
+	derm := Doctor{ID: "d2", DName: "Dr. Shepherd", Specialization: "dermatology", Schedule: []string{f.first.Format(time.RFC3339)}}
+	if err := f.store.Doctors.Create(context.Background(), derm); err != nil {
+		t.Fatal(err)
+	}
+
+	rule := gin.H{"priority": 50, "symptoms": []string{"rash"}, "urgency": "soon", "specialization": "dermatology"}
+	decode[TriageRule](t, f.do(t, http.MethodPut, "/api/admin/triage-rules/rash", admin, rule), http.StatusOK)
+	questionnaire := decode[struct {
+		Symptoms []string `json:"symptoms"`
+	}](t, f.do(t, http.MethodGet, "/api/triage/questionnaire", f.alice, nil), http.StatusOK)
+	if !slices.Contains(questionnaire.Symptoms, "rash") {
+		t.Errorf("questionnaire symptoms = %v, want rash offered", questionnaire.Symptoms)
+	}
+
+	resp := decode[triageResponse](t, f.do(t, http.MethodPost, "/api/patients/p-alice/triage", f.alice, gin.H{"symptoms": []string{"rash"}, "severity": 2}), http.StatusCreated)
+	if resp.Assessment.RuleID != "rash" || resp.Assessment.Specialization != "dermatology" || !resp.OnlineBooking {
+		t.Fatalf("triage = %+v, want the rash rule's dermatology routing", resp)
+	}
+
+	booked := decode[bookingResponse](t, f.do(t, http.MethodPost, "/api/patients/p-alice/appointments/next-available", f.alice, gin.H{}), http.StatusOK)
+	if booked.Appointment.DoctorID != derm.ID || booked.Appointment.Queue != "dermatology" {
+		t.Errorf("appointment = %+v, want the dermatology queue's %s", booked.Appointment, derm.ID)
+	}
+
+	// Without a pre-screening the specialization has to be given
+	if rec := f.do(t, http.MethodPost, "/api/patients/p-bob/appointments/next-available", f.bob, gin.H{}); rec.Code != http.StatusBadRequest {
+		t.Errorf("untriaged queue booking status = %d, want 400", rec.Code)
+	}
+}
+
+func TestMatchTriageByPriority(t *testing.T) {
+	rules := []TriageRule{
+		{ID: "off", Priority: 1, Urgency: "emergency", Disabled: true},
+		{ID: "severe", Priority: 5, MinSeverity: 8, Urgency: "urgent"},
+		{ID: "cough", Priority: 10, Symptoms: []string{"cough"}, Urgency: "soon"},
+	}
+	if rule, ok := matchTriage(rules, []string{"cough"}, 9); !ok || rule.ID != "severe" {
+		t.Errorf("severe cough matched %q, want severe", rule.ID)
+	}
+	if rule, ok := matchTriage(rules, []string{"cough"}, 3); !ok || rule.ID != "cough" {
+		t.Errorf("mild cough matched %q, want cough", rule.ID)
+	}
+	if rule, ok := matchTriage(rules, []string{"headache"}, 3); ok {
+		t.Errorf("mild headache matched %q, want no rule", rule.ID)
+	}
+}
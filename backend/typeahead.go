@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// typeaheadRefresh is how often the index is rebuilt from the store, to
+	// pick up patients added through other instances
+	typeaheadRefresh  = 5 * time.Minute
+	typeaheadMinQuery = 2
+	typeaheadLimit    = 10
+	typeaheadMaxLimit = 25
+)
+
+// Fields a typeahead match can be found by.
+const (
+	matchedName      = "name"
+	matchedPhone     = "phone"
+	matchedInsurance = "insuranceNumber"
+	matchedID        = "id"
+)
+
+// typeaheadMatch is what reception needs to pick the patient out.
+type typeaheadMatch struct {
+	ID          string `json:"id"`
+	Name        string `json:"pname"`
+	Phone       string `json:"phone,omitempty"`
+	DateOfBirth string `json:"dateOfBirth,omitempty"`
+	// MatchedOn is the field the query matched.
+	MatchedOn string `json:"matchedOn"`
+}
+
+type typeaheadKey struct {
+	key       string
+	patientID string
+	field     string
+	// word marks keys for the later words of a name, which rank below
+	// matches on the start of the name.
+	word bool
+}
+
+// patientIndex answers prefix lookups from memory: keys stays sorted, so a
+// lookup is a binary search rather than a scan of the patients.
+type patientIndex struct {
+	mu       sync.RWMutex
+	builtAt  time.Time
+	keys     []typeaheadKey
+	patients map[string]typeaheadMatch
+}
+
+// indexKeys returns the normalized keys patient is found by: the whole
+// name and each word of it, the phone's digits in international and
+// national form, the insurance number and the record ID.
+func indexKeys(patient Patient) []typeaheadKey {
+	var keys []typeaheadKey
+	add := func(key, field string) {
+		if key != "" {
+			keys = append(keys, typeaheadKey{key: key, patientID: patient.ID, field: field})
+		}
+	}
+	name := normalizeQuery(patient.PName)
+	add(name, matchedName)
+	if words := strings.Fields(name); len(words) > 1 {
+		for _, word := range words[1:] {
+			keys = append(keys, typeaheadKey{key: word, patientID: patient.ID, field: matchedName, word: true})
+		}
+	}
+	phone := phoneDigits(patient.Phone)
+	add(phone, matchedPhone)
+	if strings.HasPrefix(patient.Phone, "+") {
+		// Reception types the national form, with a trunk 0 in place of
+		// the country code, which is one to three digits long
+		for cc := 1; cc <= 3 && cc < len(phone); cc++ {
+			add("0"+phone[cc:], matchedPhone)
+		}
+	}
+	add(normalizeQuery(patient.InsuranceNumber), matchedInsurance)
+	add(strings.ToLower(patient.ID), matchedID)
+	return keys
+}
+
+func normalizeQuery(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+// phoneDigits drops the spaces, dashes, brackets and plus signs people type
+// phone numbers with.
+func phoneDigits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// looksLikePhone reports whether q is only digits and phone punctuation.
+func looksLikePhone(q string) bool {
+	return q != "" && strings.Trim(q, "0123456789 -+()") == "" && phoneDigits(q) != ""
+}
+
+// rebuild replaces the index with patients.
+func (ix *patientIndex) rebuild(patients []Patient, at time.Time) {
+	keys := []typeaheadKey{}
+	matches := make(map[string]typeaheadMatch, len(patients))
+	for _, patient := range patients {
+		if patient.PName == "" {
+			continue // anonymized
+		}
+		keys = append(keys, indexKeys(patient)...)
+		matches[patient.ID] = typeaheadMatch{ID: patient.ID, Name: patient.PName, Phone: patient.Phone, DateOfBirth: patient.DateOfBirth}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].key < keys[j].key })
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.keys, ix.patients, ix.builtAt = keys, matches, at
+}
+
+// put adds or replaces one patient without waiting for the next rebuild.
+// Anonymized patients are removed.
+func (ix *patientIndex) put(patient Patient) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if ix.builtAt.IsZero() {
+		return // the first lookup builds everything
+	}
+	ix.removeLocked(patient.ID)
+	if patient.PName == "" {
+		return
+	}
+	for _, key := range indexKeys(patient) {
+		i := sort.Search(len(ix.keys), func(i int) bool { return ix.keys[i].key >= key.key })
+		ix.keys = append(ix.keys, typeaheadKey{})
+		copy(ix.keys[i+1:], ix.keys[i:])
+		ix.keys[i] = key
+	}
+	ix.patients[patient.ID] = typeaheadMatch{ID: patient.ID, Name: patient.PName, Phone: patient.Phone, DateOfBirth: patient.DateOfBirth}
+}
+
+func (ix *patientIndex) removeLocked(patientID string) {
+	if _, ok := ix.patients[patientID]; !ok {
+		return
+	}
+	kept := ix.keys[:0]
+	for _, key := range ix.keys {
+		if key.patientID != patientID {
+			kept = append(kept, key)
+		}
+	}
+	ix.keys = kept
+	delete(ix.patients, patientID)
+}
+
+// lookup returns up to limit patients with a key starting with q. Exact
+// matches come first, then matches on the start of a field, then on a
+// later word of the name, each by name.
+func (ix *patientIndex) lookup(q string, limit int) []typeaheadMatch {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	type hit struct {
+		match typeaheadMatch
+		rank  int
+	}
+	var hits []hit
+	// seen is the index of each patient's hit, which keeps its best rank
+	seen := map[string]int{}
+	collect := func(prefix string, fields ...string) {
+		start := sort.Search(len(ix.keys), func(i int) bool { return ix.keys[i].key >= prefix })
+		for i := start; i < len(ix.keys) && strings.HasPrefix(ix.keys[i].key, prefix); i++ {
+			key := ix.keys[i]
+			if !slices.Contains(fields, key.field) {
+				continue
+			}
+			rank := 1
+			if key.key == prefix {
+				rank = 0
+			} else if key.word {
+				rank = 2
+			}
+			if j, ok := seen[key.patientID]; ok {
+				if rank < hits[j].rank {
+					hits[j].rank, hits[j].match.MatchedOn = rank, key.field
+				}
+				continue
+			}
+			seen[key.patientID] = len(hits)
+			match := ix.patients[key.patientID]
+			match.MatchedOn = key.field
+			hits = append(hits, hit{match, rank})
+		}
+	}
+	if looksLikePhone(q) {
+		collect(phoneDigits(q), matchedPhone, matchedInsurance, matchedID)
+	}
+	collect(normalizeQuery(q), matchedName, matchedInsurance, matchedID)
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].rank != hits[j].rank {
+			return hits[i].rank < hits[j].rank
+		}
+		return hits[i].match.Name < hits[j].match.Name
+	})
+	matches := make([]typeaheadMatch, 0, min(len(hits), limit))
+	for _, hit := range hits[:min(len(hits), limit)] {
+		matches = append(matches, hit.match)
+	}
+	return matches
+}
+
+func (s *Server) refreshPatientIndex(ctx context.Context) error {
+	patients, _, err := s.store.Patients.List(ctx, store.PatientFilter{}, store.Page{})
+	if err != nil {
+		return err
+	}
+	s.patientIndex.rebuild(patients, time.Now())
+	return nil
+}
+
+// runPatientIndex rebuilds the typeahead index on start and then every
+// typeaheadRefresh.
+func (s *Server) runPatientIndex(ctx context.Context) {
+	ticker := time.NewTicker(typeaheadRefresh)
+	defer ticker.Stop()
+
+	for {
+		if err := s.refreshPatientIndex(ctx); err != nil {
+			log.Println("Building the patient typeahead index failed: ", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// GetPatientTypeahead finds patients by the start of their name, any word
+// of their name, their phone number, insurance number or record ID, for
+// reception to pick someone out while typing.
+func (s *Server) GetPatientTypeahead(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if len([]rune(q)) < typeaheadMinQuery {
+		abortWithError(c, http.StatusBadRequest, "q must be at least 2 characters")
+		return
+	}
+	limit := typeaheadLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > typeaheadMaxLimit {
+			abortWithError(c, http.StatusBadRequest, "limit must be between 1 and 25")
+			return
+		}
+		limit = parsed
+	}
+
+	s.patientIndex.mu.RLock()
+	built := !s.patientIndex.builtAt.IsZero()
+	s.patientIndex.mu.RUnlock()
+	if !built {
+		if err := s.refreshPatientIndex(c.Request.Context()); err != nil {
+			abortWithError(c, http.StatusInternalServerError, "Error searching patients")
+			return
+		}
+	}
+	c.JSON(http.StatusOK, s.patientIndex.lookup(q, limit))
+}
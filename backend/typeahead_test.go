@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"containerized-go-app/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPatientTypeahead(t *testing.T) {
+	f := newBookingFixture(t)
+	ctx := context.Background()
+	for _, patient := range []Patient{
+		{ID: "p-omar", PName: "Omar Hassan", PatientProfile: store.PatientProfile{Phone: "+201001234567", InsuranceNumber: "INS-778"}},
+		{ID: "p-hala", PName: "Hala Omran"},
+	} {
+		if err := f.store.Patients.Create(ctx, patient); err != nil {
+			t.Fatal(err)
+		}
+	}
+	doctor := User{Username: "grey", Role: RoleDoctor, ProfileID: f.doctor.ID}
+	search := func(q string) []typeaheadMatch {
+		t.Helper()
+		return decode[[]typeaheadMatch](t, f.do(t, http.MethodGet, "/api/patients/typeahead?q="+url.QueryEscape(q), doctor, nil), http.StatusOK)
+	}
+	ids := func(matches []typeaheadMatch) string {
+		var ids []string
+		for _, match := range matches {
+			ids = append(ids, match.ID)
+		}
+		return fmt.Sprint(ids)
+	}
+
+	for _, tc := range []struct{ q, want string }{
+		{"om", "[p-omar p-hala]"},
+		{"OMAR h", "[p-omar]"},
+		{"hassan", "[p-omar]"},
+		{"0100 123", "[p-omar]"},
+		{"ins-7", "[p-omar]"},
+		{"p-al", "[p-alice]"},
+		{"zz", "[]"},
+	} {
+		if got := ids(search(tc.q)); got != tc.want {
+			t.Errorf("q=%q matched %s, want %s", tc.q, got, tc.want)
+		}
+	}
+	if matches := search("0100"); len(matches) != 1 || matches[0].MatchedOn != matchedPhone {
+		t.Errorf("phone matches = %+v", matches)
+	}
+
+	// Profile changes show up without waiting for the rebuild
+	f.do(t, http.MethodPatch, "/api/patients/p-alice/profile", f.alice, gin.H{"phone": "+201119998888"})
+	if got := ids(search("01119")); got != "[p-alice]" {
+		t.Errorf("after profile update matched %s, want [p-alice]", got)
+	}
+
+	decode[[]typeaheadMatch](t, f.do(t, http.MethodGet, "/api/patients/typeahead?q=om&limit=1", doctor, nil), http.StatusOK)
+	decode[apiError](t, f.do(t, http.MethodGet, "/api/patients/typeahead?q=o", doctor, nil), http.StatusBadRequest)
+	decode[apiError](t, f.do(t, http.MethodGet, "/api/patients/typeahead?q=om&limit=100", doctor, nil), http.StatusBadRequest)
+	decode[apiError](t, f.do(t, http.MethodGet, "/api/patients/typeahead?q=om", f.alice, nil), http.StatusForbidden)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+const minPasswordLength = 8
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// registerValidators adds the custom validation tags used in binding tags
+// and reports fields by their JSON names.
+func registerValidators() {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	v.RegisterValidation("password", func(fl validator.FieldLevel) bool {
+		return isStrongPassword(fl.Field().String())
+	})
+	v.RegisterValidation("username", func(fl validator.FieldLevel) bool {
+		return usernamePattern.MatchString(fl.Field().String())
+	})
+	v.RegisterValidation("notblank", func(fl validator.FieldLevel) bool {
+		return strings.TrimSpace(fl.Field().String()) != ""
+	})
+	v.RegisterValidation("rfc3339", func(fl validator.FieldLevel) bool {
+		_, err := time.Parse(time.RFC3339, fl.Field().String())
+		return err == nil
+	})
+}
+
+// checkVar validates value against binding tags outside a struct, returning
+// the message for the first rule it breaks or "" when it is valid.
+func checkVar(value interface{}, tags string) string {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return ""
+	}
+	var errs validator.ValidationErrors
+	if err := v.Var(value, tags); errors.As(err, &errs) && len(errs) > 0 {
+		return validationMessage(errs[0])
+	}
+	return ""
+}
+
+// isStrongPassword requires minPasswordLength characters including at least
+// one letter and one digit.
+func isStrongPassword(password string) bool {
+	if len(password) < minPasswordLength {
+		return false
+	}
+	var letter, digit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			letter = true
+		case unicode.IsDigit(r):
+			digit = true
+		}
+	}
+	return letter && digit
+}
+
+// fieldPath is the JSON path of the failing field, without the top-level
+// struct name, e.g. "schedule[2]".
+func fieldPath(fe validator.FieldError) string {
+	_, path, found := strings.Cut(fe.Namespace(), ".")
+	if !found {
+		return fe.Field()
+	}
+	return path
+}
+
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "notblank":
+		return "must not be blank"
+	case "email":
+		return "must be a valid email address"
+	case "password":
+		return "must be at least 8 characters and contain a letter and a digit"
+	case "rfc3339":
+		return "must be an RFC3339 time, e.g. 2024-01-02T09:00:00Z"
+	case "datetime":
+		return "must be a date, e.g. 2006-01-02"
+	case "e164":
+		return "must be an international phone number, e.g. +14155550123"
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "username":
+		return "must contain only letters, digits, '.', '_' and '-'"
+	case "min", "max":
+		unit := " characters"
+		if fe.Kind() == reflect.Slice {
+			unit = " items"
+		}
+		if fe.Tag() == "min" {
+			return "must be at least " + fe.Param() + unit
+		}
+		return "must be at most " + fe.Param() + unit
+	case "gtfield":
+		// The parameter is the Go field name; clients know the JSON one
+		param := fe.Param()
+		return "must be after " + strings.ToLower(param[:1]) + param[1:]
+	default:
+		return "is invalid"
+	}
+}